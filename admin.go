@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var adminToken string
+
+// setupAdmin registers the admin API. The endpoints are only available when
+// ADMIN_TOKEN or LDAP_URL is set and require the token as a bearer token or
+// the credentials of a directory admin.
+func setupAdmin() {
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" && ldapConfig.URL == "" {
+		log.Println("Neither ADMIN_TOKEN nor LDAP_URL set, admin API disabled")
+		return
+	}
+
+	mux.HandleFunc("/admin/quarantine", requireAdmin(quarantineListHandler))
+	mux.HandleFunc("/admin/quarantine/release", requireAdmin(quarantineReleaseHandler))
+	mux.HandleFunc("/admin/quarantine/purge", requireAdmin(quarantinePurgeHandler))
+	mux.HandleFunc("/admin/pending", requireAdmin(pendingListHandler))
+	mux.HandleFunc("/admin/pending/approve", requireAdmin(pendingApproveHandler))
+	mux.HandleFunc("/admin/pending/reject", requireAdmin(pendingRejectHandler))
+	mux.HandleFunc("/admin/shares", requireAdmin(shareCreateHandler))
+	mux.HandleFunc("POST /admin/upload-links", requireAdmin(uploadLinkCreateHandler))
+	mux.HandleFunc("GET /admin/files", requireAdmin(fileListHandler))
+	mux.HandleFunc("DELETE /admin/files/{path...}", requireAdmin(fileDeleteHandler))
+	mux.HandleFunc("GET /admin/stats", requireAdmin(statsHandler))
+	mux.HandleFunc("DELETE /admin/sessions/{id...}", requireAdmin(sessionDeleteHandler))
+	mux.HandleFunc("DELETE /admin/uploaders/{id}", requireAdmin(uploaderDeleteHandler))
+	mux.HandleFunc("GET /admin/versions", requireAdmin(versionListHandler))
+	mux.HandleFunc("POST /admin/versions/restore", requireAdmin(versionRestoreHandler))
+	mux.HandleFunc("GET /admin/trash", requireAdmin(trashListHandler))
+	mux.HandleFunc("POST /admin/trash/restore", requireAdmin(trashRestoreHandler))
+	mux.HandleFunc("POST /admin/trash/purge", requireAdmin(trashPurgeHandler))
+	mux.HandleFunc("GET /admin/integrity", requireAdmin(integrityReportHandler))
+	mux.HandleFunc("GET /admin/maintenance", requireAdmin(maintenanceStatusHandler))
+	mux.HandleFunc("PUT /admin/maintenance", requireAdmin(maintenanceUpdateHandler))
+}
+
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireClientCert(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			if ldapConfig.URL != "" {
+				w.Header().Add("WWW-Authenticate", `Basic realm="go-uploader admin"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// adminAuthorized accepts the admin token, or with LDAP the Basic
+// credentials of a member of the admin group.
+func adminAuthorized(r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok || ldapConfig.URL == "" {
+		return false
+	}
+	if err := checkLDAPLogin(r.Context(), username, password); err != nil {
+		requestLogf(r.Context(), "Admin login of %q from %s failed: %v", username, logIP(clientIP(r)), err)
+		return false
+	}
+	return true
+}
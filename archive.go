@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+const archivePrefix = "archive/"
+
+// archiveAfter is the time since the last upload to a session after which
+// it moves to the archive tier. Zero disables archiving.
+var archiveAfter time.Duration
+
+// archiveTier moves old sessions out of hot storage. It is nil unless
+// archiving is enabled.
+var archiveTier *archivedBackend
+
+const archiveSweepInterval = time.Hour
+
+// setupArchive wraps the backend with the archive tier, either archive/ in
+// the same backend or ARCHIVE_BACKEND.
+func setupArchive() error {
+	archiveAfter, archiveTier = 0, nil
+	value := os.Getenv("ARCHIVE_AFTER")
+	if value == "" {
+		return nil
+	}
+	after, err := time.ParseDuration(value)
+	if err != nil || after <= 0 {
+		return fmt.Errorf("ARCHIVE_AFTER must be a positive duration, got %q", value)
+	}
+	tier := &archivedBackend{Backend: storage, archive: storage, prefix: archivePrefix}
+	location := archivePrefix
+	if name := os.Getenv("ARCHIVE_BACKEND"); name != "" {
+		if name == backendName {
+			return fmt.Errorf("ARCHIVE_BACKEND must differ from BACKEND %q", backendName)
+		}
+		b, err := newBackend(name)
+		if err != nil {
+			return fmt.Errorf("ARCHIVE_BACKEND: %w", err)
+		}
+		tier.archive, tier.prefix = guardBackend(name, instrumentBackend(name, b)), ""
+		location = "the " + name + " backend"
+	}
+	archiveAfter, archiveTier = after, tier
+	storage = tier
+	log.Printf("Moving sessions to %s %s after their last upload", location, archiveAfter)
+	if checkingConfig {
+		return nil
+	}
+
+	go func() {
+		for {
+			// The first sweep waits until the rest of the storage is set up
+			time.Sleep(archiveSweepInterval)
+			archiveOldSessions(time.Now())
+		}
+	}()
+	return nil
+}
+
+// archivedBackend keeps recent files in the hot backend and old sessions in
+// the archive tier, e.g. a prefix an S3 lifecycle rule moves to a cheaper
+// storage class. Reads fall back to the archive and listings merge both, so
+// archived files keep their names.
+type archivedBackend struct {
+	store.Backend
+	archive store.Backend
+	// prefix is prepended to the names in the archive, which is the hot
+	// backend itself if it is set
+	prefix string
+}
+
+// SaveFile stores the file in the hot backend, so a file saved again, e.g.
+// an approved pending upload, leaves the archive.
+func (b *archivedBackend) SaveFile(name string, data io.Reader) (store.SaveResult, error) {
+	result, err := b.Backend.SaveFile(name, data)
+	if err != nil {
+		return result, err
+	}
+	if err := b.archive.DeleteFile(b.prefix + name); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return result, err
+	}
+	return result, nil
+}
+
+func (b *archivedBackend) OpenFile(name string) (io.ReadCloser, error) {
+	f, err := b.Backend.OpenFile(name)
+	if errors.Is(err, store.ErrNotFound) {
+		return b.archive.OpenFile(b.prefix + name)
+	}
+	return f, err
+}
+
+// DeleteFile removes the file from whichever tier holds it.
+func (b *archivedBackend) DeleteFile(name string) error {
+	hotErr := b.Backend.DeleteFile(name)
+	if hotErr != nil && !errors.Is(hotErr, store.ErrNotFound) {
+		return hotErr
+	}
+	archiveErr := b.archive.DeleteFile(b.prefix + name)
+	if archiveErr != nil && !errors.Is(archiveErr, store.ErrNotFound) {
+		return archiveErr
+	}
+	if hotErr != nil && archiveErr != nil {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// ListFiles merges both tiers.
+func (b *archivedBackend) ListFiles(prefix string) ([]string, error) {
+	names, err := b.hotFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := b.archive.ListFiles(b.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range archived {
+		names = append(names, strings.TrimPrefix(name, b.prefix))
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// hotFiles lists the files below prefix that haven't been archived.
+func (b *archivedBackend) hotFiles(prefix string) ([]string, error) {
+	names, err := b.Backend.ListFiles(prefix)
+	if err != nil || b.prefix == "" {
+		return names, err
+	}
+	return slices.DeleteFunc(names, func(name string) bool { return strings.HasPrefix(name, b.prefix) }), nil
+}
+
+// moveToArchive moves a file from the hot backend to the archive.
+func (b *archivedBackend) moveToArchive(name string) error {
+	src, err := b.Backend.OpenFile(name)
+	if err != nil {
+		return err
+	}
+	_, err = b.archive.SaveFile(b.prefix+name, src)
+	src.Close()
+	if err != nil {
+		return fmt.Errorf("copying file: %w", err)
+	}
+	return b.Backend.DeleteFile(name)
+}
+
+// archiveOldSessions moves the sessions whose last upload is older than
+// archiveAfter to the archive tier. Sessions are found through the upload
+// times in their metadata; the sidecars themselves stay hot for listings.
+func archiveOldSessions(now time.Time) {
+	names, err := storage.ListFiles(metaPrefix)
+	if err != nil {
+		log.Printf("Error listing metadata for archiving: %v", err)
+		return
+	}
+	sessions := make(map[string][]*fileMetadata)
+	lastUpload := make(map[string]time.Time)
+	for _, name := range names {
+		file, ok := strings.CutSuffix(strings.TrimPrefix(name, metaPrefix), ".json")
+		if !ok {
+			continue
+		}
+		meta, err := loadMetadata(file)
+		if err != nil {
+			log.Printf("Error loading metadata of %s for archiving: %v", logName(file), err)
+			continue
+		}
+		// Files uploaded before archiving was enabled have no upload time
+		if meta.Session == "" || meta.Uploaded.IsZero() {
+			continue
+		}
+		sessions[meta.Session] = append(sessions[meta.Session], meta)
+		if meta.Uploaded.After(lastUpload[meta.Session]) {
+			lastUpload[meta.Session] = meta.Uploaded
+		}
+	}
+
+	archived := 0
+	for session, files := range sessions {
+		if now.Sub(lastUpload[session]) < archiveAfter {
+			continue
+		}
+		if !slices.ContainsFunc(files, func(meta *fileMetadata) bool { return meta.Archived.IsZero() }) {
+			continue
+		}
+		moved, err := archiveSession(session, files, now)
+		archived += moved
+		if err != nil {
+			log.Printf("Error archiving session %s: %v", session, err)
+		}
+	}
+	if archived > 0 {
+		log.Printf("Moved %d file(s) of old sessions to the archive", archived)
+	}
+}
+
+// archiveSession moves the files of a session to the archive and records
+// the time in their metadata. It returns how many files were moved.
+func archiveSession(session string, files []*fileMetadata, now time.Time) (int, error) {
+	moved := 0
+	for _, prefix := range sessionPrefixes(session) {
+		if prefix == metaPrefix+session+"/" {
+			continue
+		}
+		names, err := archiveTier.hotFiles(prefix)
+		if err != nil {
+			return moved, err
+		}
+		for _, name := range names {
+			if err := archiveTier.moveToArchive(name); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return moved, err
+			}
+			moved++
+		}
+	}
+	archivedFiles.Add(float64(moved))
+	for _, meta := range files {
+		if !meta.Archived.IsZero() {
+			continue
+		}
+		meta.Archived = now.UTC()
+		if err := saveMetadata(meta); err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveOldSessions(t *testing.T) {
+	mockStorage := &MockStorage{files: map[string][]byte{
+		"old/photo.jpg":            []byte("a"),
+		"thumbnails/old/photo.jpg": []byte("t"),
+		"new/photo.jpg":            []byte("b"),
+	}}
+	originalStorage := storage
+	archiveTier = &archivedBackend{Backend: mockStorage, archive: mockStorage, prefix: archivePrefix}
+	storage = archiveTier
+	archiveAfter = 24 * time.Hour
+	defer func() {
+		storage = originalStorage
+		archiveAfter, archiveTier = 0, nil
+	}()
+
+	now := time.Now()
+	saveMetadata(&fileMetadata{Path: "old/photo.jpg", Session: "old", Uploaded: now.Add(-48 * time.Hour)})
+	saveMetadata(&fileMetadata{Path: "new/photo.jpg", Session: "new", Uploaded: now.Add(-time.Hour)})
+	archiveOldSessions(now)
+
+	for _, name := range []string{"archive/old/photo.jpg", "archive/thumbnails/old/photo.jpg", "new/photo.jpg"} {
+		if _, ok := mockStorage.files[name]; !ok {
+			t.Errorf("expected %s to be stored, got %v", name, mockStorage.files)
+		}
+	}
+	if _, ok := mockStorage.files["old/photo.jpg"]; ok {
+		t.Error("expected the old session to leave hot storage")
+	}
+	if meta, err := loadMetadata("old/photo.jpg"); err != nil || meta.Archived.IsZero() {
+		t.Errorf("expected the archive time in the metadata, got %+v, %v", meta, err)
+	}
+
+	names, _ := storage.ListFiles("")
+	if !slices.Contains(names, "old/photo.jpg") || slices.Contains(names, "archive/old/photo.jpg") {
+		t.Errorf("expected archived files under their own names, got %v", names)
+	}
+	f, err := storage.OpenFile("old/photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(f)
+	f.Close()
+	if string(content) != "a" {
+		t.Errorf("expected the archived content, got %q", content)
+	}
+
+	// Saving the file again brings it back to hot storage
+	storage.SaveFile("old/photo.jpg", strings.NewReader("c"))
+	if _, ok := mockStorage.files["archive/old/photo.jpg"]; ok {
+		t.Error("expected the archived copy to be replaced")
+	}
+	if err := storage.DeleteFile("thumbnails/old/photo.jpg"); err != nil || len(mockStorage.files["archive/thumbnails/old/photo.jpg"]) != 0 {
+		t.Errorf("expected the archived thumbnail to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const auditPrefix = "audit/"
+
+// auditEvent is stored as an individual JSON object under the audit/ prefix,
+// since object stores can't append to a shared log.
+type auditEvent struct {
+	Time    time.Time      `json:"time"`
+	Action  string         `json:"action"`
+	Subject string         `json:"subject"`
+	Client  string         `json:"client,omitempty"`
+	Request string         `json:"request_id,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// recordAudit persists an audit event. Failures are logged but don't fail the
+// audited operation, which has already happened at this point.
+func recordAudit(r *http.Request, action, subject string, details map[string]any) {
+	event := auditEvent{
+		Time:    time.Now().UTC(),
+		Action:  action,
+		Subject: subject,
+		Details: details,
+	}
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+		event.Client = logIP(clientIP(r))
+		event.Request = requestID(ctx)
+	}
+	requestLogf(ctx, "Audit: %s %s", action, logName(subject))
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		requestLogf(ctx, "Error encoding audit event: %v", err)
+		return
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := auditPrefix + event.Time.Format("2006-01-02/15-04-05.000000") + "-" + hex.EncodeToString(suffix) + ".json"
+	if _, err := storage.SaveFile(name, bytes.NewReader(data)); err != nil {
+		requestLogf(ctx, "Error saving audit event: %v", err)
+	}
+}
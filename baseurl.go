@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// publicBaseURL is where guests reach the uploader, e.g.
+// "https://example.com/photos" when a reverse proxy serves it below /photos.
+// Without it, generated links are paths on the host the request came to.
+var publicBaseURL string
+
+// publicBasePath is the path of publicBaseURL, for cookies.
+var publicBasePath string
+
+func setupBaseURL() error {
+	publicBaseURL, publicBasePath = "", ""
+	value := os.Getenv("BASE_URL")
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("BASE_URL must be an absolute http or https URL, got %q", value)
+	}
+	publicBaseURL = strings.TrimSuffix(value, "/")
+	publicBasePath = strings.TrimSuffix(u.EscapedPath(), "/")
+	log.Printf("Generating links below %s", publicBaseURL)
+	return nil
+}
+
+// publicURL returns the link to p, a path on this server starting with "/".
+func publicURL(p string) string {
+	return publicBaseURL + p
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+// errStorageUnavailable is returned by backends while their circuit breaker
+// is open.
+var errStorageUnavailable = errors.New("storage backend unavailable")
+
+// breakerFailures is the number of consecutive failed operations that opens
+// the circuit breaker of a backend; 0 disables the breakers.
+var breakerFailures = 5
+
+// breakerCooldown is the time an open breaker fails operations right away
+// before letting a single operation through to test the backend again.
+var breakerCooldown = 30 * time.Second
+
+// storageBreakers are the breakers of the configured backends by name.
+// Backends of the same name share one, as they reach the same service.
+var storageBreakers = map[string]*circuitBreaker{}
+
+// setupStorageBreaker configures the circuit breakers, which stop guests
+// from waiting for the full timeout of a backend that is down, e.g. an
+// unreachable S3 endpoint.
+func setupStorageBreaker() error {
+	if value := os.Getenv("STORAGE_BREAKER_FAILURES"); value != "" {
+		failures, err := strconv.Atoi(value)
+		if err != nil || failures < 0 {
+			return fmt.Errorf("STORAGE_BREAKER_FAILURES must be a number, got %q", value)
+		}
+		breakerFailures = failures
+	}
+	if value := os.Getenv("STORAGE_BREAKER_COOLDOWN"); value != "" {
+		cooldown, err := time.ParseDuration(value)
+		if err != nil || cooldown <= 0 {
+			return fmt.Errorf("STORAGE_BREAKER_COOLDOWN must be a positive duration, got %q", value)
+		}
+		breakerCooldown = cooldown
+	}
+	if breakerFailures == 0 {
+		log.Println("Storage circuit breakers disabled")
+	}
+	return nil
+}
+
+// guardBackend wraps a backend in the circuit breaker for its name.
+func guardBackend(name string, b store.Backend) store.Backend {
+	if breakerFailures == 0 {
+		return b
+	}
+	breaker := storageBreakers[name]
+	if breaker == nil {
+		breaker = &circuitBreaker{name: name, failures: breakerFailures, cooldown: breakerCooldown}
+		storageBreakers[name] = breaker
+	}
+	return &breakerBackend{Backend: b, breaker: breaker}
+}
+
+// circuitBreaker counts consecutive failures of a backend. Once open, it
+// rejects operations until the cooldown has passed, then lets one through:
+// the breaker closes if it succeeds and opens for another cooldown if not.
+type circuitBreaker struct {
+	name     string
+	failures int
+	cooldown time.Duration
+
+	mu     sync.Mutex
+	failed int
+	// openUntil is the end of the cooldown while the breaker is open
+	openUntil time.Time
+	// probing is set while the operation testing the backend runs
+	probing bool
+}
+
+// allow reports whether an operation may run, or else how long the breaker
+// stays open.
+func (c *circuitBreaker) allow() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed < c.failures {
+		return 0, true
+	}
+	if wait := time.Until(c.openUntil); wait > 0 || c.probing {
+		return max(wait, 0), false
+	}
+	c.probing = true
+	return 0, true
+}
+
+// wait returns how long the breaker stays open, or zero if it is closed.
+func (c *circuitBreaker) wait() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed < c.failures {
+		return 0
+	}
+	wait := time.Until(c.openUntil)
+	if wait <= 0 && !c.probing {
+		// The next operation tests the backend
+		return 0
+	}
+	return max(wait, time.Second)
+}
+
+// skip ends an operation allowed by the breaker that says nothing about the
+// backend.
+func (c *circuitBreaker) skip() {
+	c.mu.Lock()
+	c.probing = false
+	c.mu.Unlock()
+}
+
+// record counts the result of an operation allowed by the breaker.
+func (c *circuitBreaker) record(failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+	if !failed {
+		if c.failed >= c.failures {
+			log.Printf("Storage backend %s recovered, closing the circuit breaker", c.name)
+			storageBreakerOpen.WithLabelValues(c.name).Set(0)
+		}
+		c.failed = 0
+		return
+	}
+	c.failed++
+	if c.failed == c.failures {
+		log.Printf("Storage backend %s failed %d times in a row, failing fast for %s", c.name, c.failed, c.cooldown)
+		storageBreakerTrips.WithLabelValues(c.name).Inc()
+		storageBreakerOpen.WithLabelValues(c.name).Set(1)
+	}
+	if c.failed >= c.failures {
+		c.openUntil = time.Now().Add(c.cooldown)
+	}
+}
+
+// breakerBackend fails operations with errStorageUnavailable while the
+// breaker of its backend is open. Missing files and failures to read the
+// data being saved, e.g. a dropped upload, don't count as failures.
+type breakerBackend struct {
+	store.Backend
+	breaker *circuitBreaker
+}
+
+func (b *breakerBackend) unavailable(wait time.Duration) error {
+	return fmt.Errorf("%w: %s, retrying in %s", errStorageUnavailable, b.breaker.name, wait.Round(time.Second))
+}
+
+func (b *breakerBackend) SaveFile(name string, data io.Reader) (store.SaveResult, error) {
+	wait, ok := b.breaker.allow()
+	if !ok {
+		return store.SaveResult{}, b.unavailable(wait)
+	}
+	reader := &errorReader{r: data}
+	result, err := b.Backend.SaveFile(name, reader)
+	if reader.err != nil {
+		b.breaker.skip()
+	} else {
+		b.breaker.record(err != nil)
+	}
+	return result, err
+}
+
+func (b *breakerBackend) OpenFile(name string) (io.ReadCloser, error) {
+	wait, ok := b.breaker.allow()
+	if !ok {
+		return nil, b.unavailable(wait)
+	}
+	f, err := b.Backend.OpenFile(name)
+	b.breaker.record(err != nil && !errors.Is(err, store.ErrNotFound))
+	return f, err
+}
+
+func (b *breakerBackend) DeleteFile(name string) error {
+	wait, ok := b.breaker.allow()
+	if !ok {
+		return b.unavailable(wait)
+	}
+	err := b.Backend.DeleteFile(name)
+	b.breaker.record(err != nil && !errors.Is(err, store.ErrNotFound))
+	return err
+}
+
+func (b *breakerBackend) ListFiles(prefix string) ([]string, error) {
+	wait, ok := b.breaker.allow()
+	if !ok {
+		return nil, b.unavailable(wait)
+	}
+	names, err := b.Backend.ListFiles(prefix)
+	b.breaker.record(err != nil)
+	return names, err
+}
+
+// errorReader remembers the error of the underlying reader, so a failed
+// save can be told apart from a failed upload.
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
+
+func writeStorageUnavailable(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", retryAfter(wait))
+	writeProblem(w, r, http.StatusServiceUnavailable, problemStorageUnavailable, "Uploads are temporarily unavailable, please try again in a few minutes")
+}
+
+// uploadBreakerWait returns how long uploads of a request would fail because
+// the breaker of their backend is open, or zero. Uploads through the spool
+// don't wait for the backend and are always accepted.
+func uploadBreakerWait(ctx context.Context) time.Duration {
+	name := backendName
+	if d := requestDrop(ctx); d != nil && d.Backend != "" {
+		name = d.Backend
+	} else if uploadsSpooled {
+		return 0
+	}
+	breaker := storageBreakers[name]
+	if breaker == nil {
+		return 0
+	}
+	return breaker.wait()
+}
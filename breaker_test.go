@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+func TestBreakerBackend(t *testing.T) {
+	backend := &flakyStorage{fails: 100}
+	breaker := &circuitBreaker{name: "flaky", failures: 3, cooldown: 20 * time.Millisecond}
+	guarded := &breakerBackend{Backend: backend, breaker: breaker}
+
+	// Missing files and failed uploads say nothing about the backend
+	if _, err := guarded.OpenFile("missing.jpg"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("OpenFile = %v", err)
+	}
+	backend.fails = 0
+	if _, err := guarded.SaveFile("dropped.jpg", &failingReader{}); err == nil {
+		t.Fatal("expected the save of a dropped upload to fail")
+	}
+	backend.fails = 100
+	for i := 0; i < 3; i++ {
+		if _, err := guarded.SaveFile("photo.jpg", strings.NewReader("a")); err == nil || errors.Is(err, errStorageUnavailable) {
+			t.Fatalf("save %d = %v, want the backend error", i, err)
+		}
+	}
+
+	// Open, the backend isn't asked anymore
+	if _, err := guarded.SaveFile("photo.jpg", strings.NewReader("a")); !errors.Is(err, errStorageUnavailable) {
+		t.Fatalf("save with an open breaker = %v", err)
+	}
+	if _, err := guarded.ListFiles(""); !errors.Is(err, errStorageUnavailable) {
+		t.Fatalf("list with an open breaker = %v", err)
+	}
+	if backend.fails != 97 {
+		t.Errorf("backend was called %d times, want 3", 100-backend.fails)
+	}
+	if wait := breaker.wait(); wait <= 0 {
+		t.Errorf("wait = %s with an open breaker", wait)
+	}
+
+	// After the cooldown a single save tests the backend again
+	time.Sleep(25 * time.Millisecond)
+	if wait := breaker.wait(); wait != 0 {
+		t.Errorf("wait = %s after the cooldown", wait)
+	}
+	if _, err := guarded.SaveFile("photo.jpg", strings.NewReader("a")); err == nil || errors.Is(err, errStorageUnavailable) {
+		t.Fatalf("probe = %v, want the backend error", err)
+	}
+	if _, err := guarded.SaveFile("photo.jpg", strings.NewReader("a")); !errors.Is(err, errStorageUnavailable) {
+		t.Fatalf("save after a failed probe = %v", err)
+	}
+	time.Sleep(25 * time.Millisecond)
+	backend.fails = 0
+	if _, err := guarded.SaveFile("photo.jpg", strings.NewReader("a")); err != nil {
+		t.Fatalf("probe = %v", err)
+	}
+	if _, err := guarded.OpenFile("photo.jpg"); err != nil {
+		t.Errorf("open after recovery = %v", err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestUploadHandlerStorageUnavailable(t *testing.T) {
+	originalStorage, originalName := storage, backendName
+	backendName = "flaky"
+	breaker := &circuitBreaker{name: "flaky", failures: 1, cooldown: time.Minute}
+	storage = &breakerBackend{Backend: &flakyStorage{fails: 1}, breaker: breaker}
+	storageBreakers["flaky"] = breaker
+	defer func() {
+		storage, backendName = originalStorage, originalName
+		delete(storageBreakers, "flaky")
+	}()
+	fakeTurnstile(t)
+
+	upload := func() *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "photo.jpg")
+		part.Write([]byte("photo"))
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Turnstile-Token", "token")
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w
+	}
+
+	// The failed save opens the breaker
+	if w := upload(); w.Code != http.StatusBadRequest {
+		t.Fatalf("first upload = %d: %s", w.Code, w.Body)
+	}
+	w := upload()
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "temporarily unavailable") {
+		t.Fatalf("upload with an open breaker = %d: %s", w.Code, w.Body)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "60" {
+		t.Errorf("Retry-After = %q", retry)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/meyskens/go-turnstile"
+)
+
+var turnstileURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+// captchaProvider selects the bot defense: "turnstile" or "pow" for the
+// self-hosted proof-of-work challenge.
+var captchaProvider = "turnstile"
+
+// Expected values for the action and hostname reported by Turnstile. Empty
+// values disable the respective check.
+var turnstileAction string
+var turnstileHostnames []string
+
+func setupCaptcha() error {
+	if provider := os.Getenv("CAPTCHA_PROVIDER"); provider != "" {
+		captchaProvider = provider
+	}
+
+	switch captchaProvider {
+	case "turnstile":
+		turnstileSecret = os.Getenv("TURNSTILE_SECRET")
+		if turnstileSecret == "" {
+			return fmt.Errorf("TURNSTILE_SECRET environment variable is not set")
+		}
+		setupTurnstile()
+		return nil
+	case "pow":
+		return setupPoW()
+	case "none":
+		// Client certificates keep bots out instead
+		if os.Getenv("TLS_CLIENT_CA") == "" && !devMode {
+			return fmt.Errorf("CAPTCHA_PROVIDER none requires TLS_CLIENT_CA")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown CAPTCHA_PROVIDER %q", captchaProvider)
+	}
+}
+
+// captchaBypassNetworks skip the CAPTCHA, e.g. the venue Wi-Fi or an office
+// VPN.
+var captchaBypassNetworks []*net.IPNet
+
+func setupCaptchaBypass() error {
+	var err error
+	captchaBypassNetworks, err = parseNetworks("CAPTCHA_BYPASS_NETWORKS")
+	if err != nil {
+		return err
+	}
+	for _, network := range captchaBypassNetworks {
+		log.Printf("Skipping the CAPTCHA for uploads from %s", network)
+	}
+	return nil
+}
+
+// captchaBypassNetwork returns the allowlisted network the client of r is
+// in, or nil.
+func captchaBypassNetwork(r *http.Request) *net.IPNet {
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return nil
+	}
+	for _, network := range captchaBypassNetworks {
+		if network.Contains(ip) {
+			return network
+		}
+	}
+	return nil
+}
+
+// requestCaptchaProvider returns the CAPTCHA provider for an upload
+// request, which drops can override.
+func requestCaptchaProvider(r *http.Request) string {
+	if d := requestDrop(r.Context()); d != nil && d.Captcha != "" {
+		return d.Captcha
+	}
+	return captchaProvider
+}
+
+// verifyCaptcha checks the bot defense solution sent with an upload request.
+func verifyCaptcha(r *http.Request) error {
+	switch requestCaptchaProvider(r) {
+	case "none":
+		return nil
+	case "pow":
+		return verifyPoW(r.Header.Get("X-PoW-Solution"))
+	}
+	return verifyTurnstile(r.Header.Get("X-Turnstile-Token"), clientIP(r))
+}
+
+func setupTurnstile() {
+	turnstileAction = os.Getenv("TURNSTILE_ACTION")
+	if turnstileAction != "" {
+		log.Printf("Requiring Turnstile action: %s", turnstileAction)
+	}
+	turnstileHostnames = splitList(os.Getenv("TURNSTILE_HOSTNAMES"))
+	if len(turnstileHostnames) > 0 {
+		log.Printf("Requiring Turnstile hostname in: %s", strings.Join(turnstileHostnames, ", "))
+	}
+}
+
+// verifyTurnstile checks the token with Cloudflare and makes sure it was
+// solved for our widget, so tokens harvested on other sites are rejected.
+func verifyTurnstile(token, remoteIP string) error {
+	ts := turnstile.New(turnstileSecret)
+	ts.TurnstileURL = turnstileURL
+	resp, err := ts.Verify(token, remoteIP)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("verification failed: %v", resp.ErrorCodes)
+	}
+
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if turnstileAction != "" && resp.Action != turnstileAction {
+		return fmt.Errorf("unexpected action %q", resp.Action)
+	}
+	if len(turnstileHostnames) > 0 && !slices.Contains(turnstileHostnames, strings.ToLower(resp.Hostname)) {
+		return fmt.Errorf("unexpected hostname %q", resp.Hostname)
+	}
+	return nil
+}
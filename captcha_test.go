@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerifyTurnstile_ActionAndHostname(t *testing.T) {
+	var reply string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(reply))
+	}))
+	defer server.Close()
+
+	originalURL := turnstileURL
+	turnstileURL = server.URL
+	defer func() { turnstileURL = originalURL }()
+
+	turnstileAction = "upload"
+	turnstileHostnames = []string{"photos.example.com"}
+	defer func() {
+		turnstileAction = ""
+		turnstileHostnames = nil
+	}()
+
+	tests := []struct {
+		name    string
+		reply   string
+		wantErr bool
+	}{
+		{"Valid", `{"success":true,"action":"upload","hostname":"photos.example.com"}`, false},
+		{"Failed", `{"success":false,"error-codes":["invalid-input-response"]}`, true},
+		{"WrongAction", `{"success":true,"action":"login","hostname":"photos.example.com"}`, true},
+		{"WrongHostname", `{"success":true,"action":"upload","hostname":"evil.example.net"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply = tt.reply
+			err := verifyTurnstile("token", "127.0.0.1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyTurnstile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeTurnstile points verification at a local server accepting every token.
+func fakeTurnstile(t *testing.T) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success":true}`))
+	}))
+	originalURL := turnstileURL
+	turnstileURL = server.URL
+	t.Cleanup(func() {
+		turnstileURL = originalURL
+		server.Close()
+	})
+}
+
+func TestCaptchaBypass(t *testing.T) {
+	t.Setenv("CAPTCHA_BYPASS_NETWORKS", "10.20.0.0/16, 2001:db8::1")
+	if err := setupCaptchaBypass(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { captchaBypassNetworks = nil }()
+
+	for _, test := range []struct {
+		remoteAddr string
+		bypassed   bool
+	}{
+		{"10.20.3.4:1234", true},
+		{"[2001:db8::1]:1234", true},
+		{"10.21.0.1:1234", false},
+		{"[2001:db8::2]:1234", false},
+	} {
+		req := httptest.NewRequest("POST", "/upload", nil)
+		req.RemoteAddr = test.remoteAddr
+		if bypassed := captchaBypassNetwork(req) != nil; bypassed != test.bypassed {
+			t.Errorf("%s: got bypassed %v", test.remoteAddr, bypassed)
+		}
+	}
+
+	t.Setenv("CAPTCHA_BYPASS_NETWORKS", "10.20.0.0/33")
+	if err := setupCaptchaBypass(); err == nil {
+		t.Error("expected an invalid network to be rejected")
+	}
+}
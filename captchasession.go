@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const captchaSessionPurpose = "captcha-session"
+
+// captchaSessionHeader returns a token with uploads that solved the CAPTCHA,
+// which the client sends back with its next uploads instead of solving one
+// again. Turnstile tokens and proof-of-work solutions are single-use, so
+// clients uploading in many batches would otherwise face a challenge each.
+const captchaSessionHeader = "X-Captcha-Session"
+
+// captchaSessionTTL is how long a solved CAPTCHA is accepted; zero disables
+// the tokens.
+var captchaSessionTTL = 15 * time.Minute
+
+// captchaSessionClaims is the signed payload of a CAPTCHA session. It is
+// bound to the client IP, only stored as a keyed hash, so a solved CAPTCHA
+// can't be handed to other clients.
+type captchaSessionClaims struct {
+	Drop    string `json:"d,omitempty"`
+	IPHash  string `json:"ip"`
+	Expires int64  `json:"e"`
+}
+
+func setupCaptchaSessions() error {
+	value := os.Getenv("CAPTCHA_SESSION_TTL")
+	if value == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return fmt.Errorf("CAPTCHA_SESSION_TTL must be a duration, got %q", value)
+	}
+	captchaSessionTTL = ttl
+	if ttl == 0 {
+		log.Println("CAPTCHA sessions disabled, every upload needs a CAPTCHA")
+	}
+	return nil
+}
+
+func captchaSessionIPHash(r *http.Request) string {
+	return base64.RawURLEncoding.EncodeToString(tokenMAC("captcha-session-ip", clientIP(r))[:12])
+}
+
+// issueCaptchaSession returns a CAPTCHA session with the response to an
+// upload that solved the CAPTCHA.
+func issueCaptchaSession(w http.ResponseWriter, r *http.Request) {
+	if captchaSessionTTL == 0 || requestCaptchaProvider(r) == "none" {
+		return
+	}
+	token, err := signToken(captchaSessionPurpose, captchaSessionClaims{
+		Drop:    dropName(r.Context()),
+		IPHash:  captchaSessionIPHash(r),
+		Expires: time.Now().Add(captchaSessionTTL).Unix(),
+	})
+	if err != nil {
+		requestLogf(r.Context(), "Error signing CAPTCHA session: %v", err)
+		return
+	}
+	w.Header().Set(captchaSessionHeader, token)
+}
+
+// validCaptchaSession reports whether r carries a CAPTCHA session issued to
+// the same client for the same drop that has not expired yet.
+func validCaptchaSession(r *http.Request) bool {
+	token := r.Header.Get(captchaSessionHeader)
+	if token == "" || captchaSessionTTL == 0 {
+		return false
+	}
+	var claims captchaSessionClaims
+	if err := parseToken(captchaSessionPurpose, token, &claims); err != nil {
+		return false
+	}
+	return time.Now().Unix() <= claims.Expires && claims.Drop == dropName(r.Context()) && claims.IPHash == captchaSessionIPHash(r)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCaptchaSession(t *testing.T) {
+	var verified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("response") != "solved" {
+			w.Write([]byte(`{"success":false}`))
+			return
+		}
+		verified.Add(1)
+		w.Write([]byte(`{"success":true}`))
+	}))
+	defer server.Close()
+	originalURL, originalStorage := turnstileURL, storage
+	turnstileURL, storage = server.URL, &MockStorage{}
+	defer func() { turnstileURL, storage = originalURL, originalStorage }()
+
+	upload := func(header, value, remoteAddr string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "photo.jpg")
+		part.Write([]byte("data"))
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set(header, value)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w
+	}
+
+	w := upload("X-Turnstile-Token", "solved", "192.0.2.1:1234")
+	token := w.Header().Get(captchaSessionHeader)
+	if w.Code != http.StatusCreated || token == "" {
+		t.Fatalf("expected a CAPTCHA session with the upload, got %d and %q", w.Code, token)
+	}
+	w = upload(captchaSessionHeader, token, "192.0.2.1:5678")
+	if w.Code != http.StatusCreated || verified.Load() != 1 {
+		t.Errorf("expected the session to replace the CAPTCHA, got %d after %d verifications", w.Code, verified.Load())
+	}
+	if w.Header().Get(captchaSessionHeader) != "" {
+		t.Error("expected the session not to be renewed")
+	}
+	if w := upload(captchaSessionHeader, token, "198.51.100.7:1234"); w.Code != http.StatusForbidden {
+		t.Errorf("expected the session to be bound to the client, got %d", w.Code)
+	}
+
+	expired, _ := signToken(captchaSessionPurpose, captchaSessionClaims{IPHash: captchaSessionIPHash(httptest.NewRequest("POST", "/upload", nil)), Expires: time.Now().Add(-time.Minute).Unix()})
+	if w := upload(captchaSessionHeader, expired, "192.0.2.1:1234"); w.Code != http.StatusForbidden {
+		t.Errorf("expected an expired session to be rejected, got %d", w.Code)
+	}
+}
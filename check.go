@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/meyskens/go-turnstile"
+
+	store "go-uploader/storage"
+)
+
+// errCheckFailed is returned by the check command if a check failed, so it
+// exits with status 1.
+var errCheckFailed = errors.New("configuration check failed")
+
+// errCheckWarning marks a check result as a warning rather than a failure.
+var errCheckWarning = errors.New("warning")
+
+// configCheck is one check of the check command. Run returns a short
+// detail for the report, or an error wrapping errCheckWarning for problems
+// that don't stop the server.
+type configCheck struct {
+	Name string
+	Run  func() (string, error)
+}
+
+// checkingConfig is set by the check command. The setups then validate
+// their settings without starting background work or listeners, which would
+// compete with the running server.
+var checkingConfig bool
+
+// configChecks run the validation of every setting that can be checked
+// without starting the server, then probe the external services. They run in
+// the order of the setup on startup, as later settings depend on earlier
+// ones, e.g. drops on the CAPTCHA provider.
+var configChecks = []configCheck{
+	{"signing secret", checkSigningSecret},
+	{"admin API", checkAdminAPI},
+	{"captcha", checkCaptcha},
+	{"captcha sessions", detailless(setupCaptchaSessions)},
+	{"captcha bypass", detailless(setupCaptchaBypass)},
+	{"privacy", detailless(setupPrivacy)},
+	{"trusted proxies", detailless(setupTrustedProxies)},
+	{"base URL", detailless(setupBaseURL)},
+	{"origins", detailless(setupOriginCheck)},
+	{"GeoIP", detailless(setupGeoIP)},
+	{"storage breaker", detailless(setupStorageBreaker)},
+	{"backend", detailless(setupStorage)},
+	{"size routing", detailless(setupSizeRouting)},
+	{"archive", detailless(setupArchive)},
+	{"spool", detailless(setupSpool)},
+	{"disk space", detailless(setupDiskSpace)},
+	{"type routing", detailless(setupTypeRouting)},
+	{"hash blocklist", detailless(setupHashBlocklist)},
+	{"file names", detailless(setupFilenameLength)},
+	{"session folders", detailless(setupSessionFolders)},
+	{"resizing", detailless(setupResizing)},
+	{"content moderation", detailless(setupContentModeration)},
+	{"throttling", detailless(setupThrottling)},
+	{"concurrency limit", detailless(setupConcurrencyLimit)},
+	{"file count limit", detailless(setupFileCountLimit)},
+	{"upload size limit", detailless(setupUploadSizeLimit)},
+	{"rate limiting", detailless(setupRateLimiting)},
+	{"idempotency", detailless(setupIdempotency)},
+	{"hooks", detailless(setupHooks)},
+	{"pipeline", detailless(setupPipeline)},
+	{"events", detailless(setupEvents)},
+	{"integrity check", detailless(setupIntegrityCheck)},
+	{"trash", detailless(setupTrash)},
+	{"share links", detailless(setupShares)},
+	{"thumbnails", detailless(setupThumbnails)},
+	{"image service", detailless(setupImageService)},
+	{"URL import", detailless(setupImport)},
+	{"email", detailless(setupEmail)},
+	{"live feed", detailless(setupLive)},
+	{"drops", detailless(setupDrops)},
+	{"Telegram", detailless(setupTelegram)},
+	{"listing cache", detailless(setupListCache)},
+	{"pprof", detailless(setupPprof)},
+	{"LDAP", detailless(setupLDAP)},
+	{"TLS", detailless(func() error { return setupProtocols(&http.Server{}) })},
+	{"listener", checkListener},
+	{"templates", checkTemplates},
+	{"storage", checkStorage},
+}
+
+func detailless(setup func() error) func() (string, error) {
+	return func() (string, error) { return "", setup() }
+}
+
+const checkUsage = `Usage: go-uploader check [flags]
+
+Validates the configuration from the environment and .env, probes the
+storage backends and the CAPTCHA provider and parses the templates. Exits
+with status 1 if a check failed, and with -strict also on warnings.
+
+Flags:
+`
+
+// runCheckCommand reports on every check, so a deploy pipeline sees all
+// problems at once.
+func runCheckCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), checkUsage)
+		flags.PrintDefaults()
+	}
+	strict := flags.Bool("strict", false, "fail on warnings too")
+	verbose := flags.Bool("v", false, "show the log output of the setup")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	// Developer mode fills in defaults the other checks rely on
+	setupDevMode()
+	checkingConfig = true
+	return runChecks(configChecks, *strict, stdout)
+}
+
+func runChecks(checks []configCheck, strict bool, stdout io.Writer) error {
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	failed, warnings := 0, 0
+	for _, check := range checks {
+		detail, err := check.Run()
+		status := "OK"
+		switch {
+		case errors.Is(err, errCheckWarning):
+			status = "WARN"
+			warnings++
+			detail = strings.TrimSuffix(err.Error(), ": "+errCheckWarning.Error())
+		case err != nil:
+			status = "FAIL"
+			failed++
+			detail = err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", status, check.Name, detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "\n%d check(s), %d failed, %d warning(s)\n", len(checks), failed, warnings)
+	if failed > 0 || (strict && warnings > 0) {
+		return errCheckFailed
+	}
+	return nil
+}
+
+func checkWarning(format string, args ...any) error {
+	return fmt.Errorf(format+": %w", append(args, errCheckWarning)...)
+}
+
+func checkSigningSecret() (string, error) {
+	secret := os.Getenv("SIGNING_SECRET")
+	if secret == "" {
+		return "", checkWarning("SIGNING_SECRET is not set, signed links won't survive restarts")
+	}
+	if len(secret) < 32 {
+		return "", checkWarning("SIGNING_SECRET is shorter than 32 characters")
+	}
+	setupSigning()
+	return "", nil
+}
+
+func checkAdminAPI() (string, error) {
+	if os.Getenv("ADMIN_TOKEN") == "" && os.Getenv("LDAP_URL") == "" {
+		return "", checkWarning("neither ADMIN_TOKEN nor LDAP_URL is set, the admin API is disabled")
+	}
+	return "", nil
+}
+
+// checkCaptcha validates the provider and, for Turnstile, verifies a dummy
+// token to find out whether Cloudflare accepts the secret.
+func checkCaptcha() (string, error) {
+	if err := setupCaptcha(); err != nil {
+		return "", err
+	}
+	if captchaProvider != "turnstile" {
+		return captchaProvider, nil
+	}
+	ts := turnstile.New(turnstileSecret)
+	ts.TurnstileURL = turnstileURL
+	resp, err := ts.Verify("go-uploader-check", "")
+	if err != nil {
+		return "", fmt.Errorf("reaching Turnstile: %w", err)
+	}
+	for _, code := range resp.ErrorCodes {
+		if code == "invalid-input-secret" || code == "missing-input-secret" {
+			return "", errors.New("Turnstile rejected TURNSTILE_SECRET")
+		}
+	}
+	return "turnstile, secret accepted", nil
+}
+
+// checkListener validates the address the server listens on without taking
+// it from the running server.
+func checkListener() (string, error) {
+	if os.Getenv("LISTEN_PID") != "" {
+		return "systemd socket", nil
+	}
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if _, err := unixSocketMode(); err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(filepath.Dir(path)); err != nil {
+			return "", fmt.Errorf("socket directory: %w", err)
+		}
+		return addr, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", fmt.Errorf("invalid LISTEN_ADDR %q: %w", addr, err)
+	}
+	return addr, nil
+}
+
+// checkTemplates parses the template overrides and renders the upload page.
+// The embedded templates are parsed on startup already.
+func checkTemplates() (string, error) {
+	if err := setupReceiptPage(); err != nil {
+		return "", err
+	}
+	if os.Getenv("DEV_MODE") == "true" {
+		if _, err := os.Stat("templates"); err == nil {
+			if _, err := template.ParseFS(os.DirFS("."), "templates/*.html"); err != nil {
+				return "", err
+			}
+		}
+	}
+	if _, _, err := buildIndexPage(); err != nil {
+		return "", fmt.Errorf("rendering the upload page: %w", err)
+	}
+	if receiptTemplate != nil {
+		return "custom receipt page", nil
+	}
+	return "", nil
+}
+
+// checkStorage writes, reads back and deletes a probe file in every
+// configured backend.
+func checkStorage() (string, error) {
+	var names []string
+	backend := os.Getenv("BACKEND")
+	if backend == "" {
+		backend = "local"
+	}
+	for _, name := range []string{backend, os.Getenv("LARGE_FILE_BACKEND"), os.Getenv("ARCHIVE_BACKEND")} {
+		if name == "" || slices.Contains(names, name) {
+			continue
+		}
+		b, err := newBackend(name)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		if err := probeBackend(b); err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ") + " readable and writable", nil
+}
+
+// checkProbeFile is written to probe a backend. It is in the metadata area
+// so listings never show it.
+const checkProbeFile = metaPrefix + ".check"
+
+func probeBackend(b store.Backend) error {
+	content := []byte("go-uploader check " + newRequestID())
+	if _, err := b.SaveFile(checkProbeFile, bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("writing: %w", err)
+	}
+	defer b.DeleteFile(checkProbeFile)
+	f, err := b.OpenFile(checkProbeFile)
+	if err != nil {
+		return fmt.Errorf("reading: %w", err)
+	}
+	read, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading: %w", err)
+	}
+	if !bytes.Equal(read, content) {
+		return errors.New("read back different content")
+	}
+	if err := b.DeleteFile(checkProbeFile); err != nil {
+		return fmt.Errorf("deleting: %w", err)
+	}
+	return nil
+}
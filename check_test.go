@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunChecks(t *testing.T) {
+	checks := []configCheck{
+		{"good", func() (string, error) { return "fine", nil }},
+		{"soft", func() (string, error) { return "", checkWarning("%s is not set", "SOMETHING") }},
+	}
+	var out strings.Builder
+	if err := runChecks(checks, false, &out); err != nil {
+		t.Fatalf("runChecks: %v", err)
+	}
+	if !strings.Contains(out.String(), "OK    good  fine\n") || !strings.Contains(out.String(), "WARN  soft  SOMETHING is not set\n") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+	if err := runChecks(checks, true, &out); !errors.Is(err, errCheckFailed) {
+		t.Errorf("strict run with a warning = %v", err)
+	}
+
+	checks = append(checks, configCheck{"bad", func() (string, error) { return "", errors.New("broken") }})
+	out.Reset()
+	if err := runChecks(checks, false, &out); !errors.Is(err, errCheckFailed) {
+		t.Errorf("run with a failure = %v", err)
+	}
+	if !strings.Contains(out.String(), "FAIL  bad   broken\n") || !strings.Contains(out.String(), "3 check(s), 1 failed, 1 warning(s)") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
+
+func TestCheckStorage(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("BACKEND", "local")
+	t.Setenv("LOCAL_PATH", dir)
+	detail, err := checkStorage()
+	if err != nil || detail != "local readable and writable" {
+		t.Fatalf("checkStorage = %q, %v", detail, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, checkProbeFile)); !os.IsNotExist(err) {
+		t.Errorf("probe file was left behind: %v", err)
+	}
+
+	t.Setenv("LOCAL_FILE_MODE", "999")
+	if _, err := checkStorage(); err == nil {
+		t.Error("expected an invalid file mode to fail")
+	}
+}
+
+func TestCheckCaptcha(t *testing.T) {
+	var response string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+	originalURL, originalSecret, originalProvider := turnstileURL, turnstileSecret, captchaProvider
+	turnstileURL = server.URL
+	defer func() {
+		turnstileURL, turnstileSecret, captchaProvider = originalURL, originalSecret, originalProvider
+	}()
+	t.Setenv("CAPTCHA_PROVIDER", "turnstile")
+	t.Setenv("TURNSTILE_SECRET", "secret")
+
+	response = `{"success":false,"error-codes":["invalid-input-response"]}`
+	if _, err := checkCaptcha(); err != nil {
+		t.Errorf("a valid secret failed: %v", err)
+	}
+	response = `{"success":false,"error-codes":["invalid-input-secret"]}`
+	if _, err := checkCaptcha(); err == nil {
+		t.Error("expected a rejected secret to fail")
+	}
+}
+
+func TestConfigChecks_Settings(t *testing.T) {
+	checkingConfig = true
+	defer func() {
+		checkingConfig = false
+		trashRetention = 0
+		tlsCertFile, tlsKeyFile = "", ""
+	}()
+	run := func(name string) error {
+		for _, check := range configChecks {
+			if check.Name == name {
+				_, err := check.Run()
+				return err
+			}
+		}
+		t.Fatalf("no %s check", name)
+		return nil
+	}
+
+	t.Setenv("DROPS", "Bad Name")
+	t.Setenv("TLS_CERT_FILE", filepath.Join(t.TempDir(), "missing.pem"))
+	t.Setenv("TLS_KEY_FILE", filepath.Join(t.TempDir(), "missing.key"))
+	t.Setenv("TRASH_RETENTION", "a week")
+	t.Setenv("LISTEN_ADDR", "8080")
+	for _, name := range []string{"drops", "TLS", "trash", "listener"} {
+		if err := run(name); err == nil {
+			t.Errorf("expected the %s check to fail", name)
+		}
+	}
+
+	t.Setenv("TRASH_RETENTION", "168h")
+	if err := run("trash"); err != nil {
+		t.Errorf("trash check = %v", err)
+	}
+}
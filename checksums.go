@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	store "go-uploader/storage"
+)
+
+const checksumsFile = "SHA256SUMS"
+
+// sessionChecksums enables writing a SHA256SUMS manifest into every session
+// folder.
+var sessionChecksums bool
+
+func setupSessionChecksums() {
+	sessionChecksums = os.Getenv("SESSION_CHECKSUMS") == "true"
+	if sessionChecksums {
+		log.Printf("Writing %s manifests for upload sessions", checksumsFile)
+	}
+}
+
+// checksumsMu serializes the updates of manifests, which requests sharing a
+// session folder merge their files into.
+var checksumsMu sync.Mutex
+
+// writeSessionChecksums stores a manifest in the format of sha256sum, so the
+// files of a session can be verified with "sha256sum -c SHA256SUMS" from
+// within the session folder. Only files published right away are listed;
+// pending and quarantined files are not part of the session folder yet.
+// Files sorted into capture date folders get a manifest in each folder, and
+// the files of earlier requests in a shared folder are kept in it.
+func writeSessionChecksums(ctx context.Context, files []*pipelineFile) {
+	if !sessionChecksums {
+		return
+	}
+
+	sums := make(map[string]map[string]string)
+	var folders []string
+	for _, file := range files {
+		if file.Status != "stored" {
+			continue
+		}
+		folder, ok := sums[file.Session]
+		if !ok {
+			folder = make(map[string]string)
+			sums[file.Session] = folder
+			folders = append(folders, file.Session)
+		}
+		folder[strings.TrimPrefix(filepath.ToSlash(file.Name), file.Session+"/")] = file.SHA256
+	}
+
+	checksumsMu.Lock()
+	defer checksumsMu.Unlock()
+	for _, folder := range folders {
+		name := path.Join(folder, checksumsFile)
+		manifest, err := mergeChecksums(name, sums[folder])
+		if err != nil {
+			requestLogf(ctx, "Error reading checksums for session %s: %v", folder, err)
+			continue
+		}
+		if _, err := storage.SaveFile(name, strings.NewReader(manifest)); err != nil {
+			requestLogf(ctx, "Error saving checksums for session %s: %v", folder, err)
+		}
+	}
+}
+
+// mergeChecksums returns the manifest at name with the sums of files added,
+// replacing the lines of files stored again.
+func mergeChecksums(name string, files map[string]string) (string, error) {
+	manifest := &strings.Builder{}
+	f, err := storage.OpenFile(name)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return "", err
+	}
+	if err == nil {
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if _, file, ok := strings.Cut(line, "  "); line == "" || ok && files[file] != "" {
+				continue
+			}
+			manifest.WriteString(line + "\n")
+		}
+	}
+	for _, file := range slices.Sorted(maps.Keys(files)) {
+		fmt.Fprintf(manifest, "%s  %s\n", files[file], file)
+	}
+	return manifest.String(), nil
+}
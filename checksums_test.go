@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadHandler_SessionChecksums(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	sessionChecksums = true
+	defer func() {
+		storage = originalStorage
+		sessionChecksums = false
+	}()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		part, _ := writer.CreateFormFile("file", name)
+		part.Write([]byte("content of " + name))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	uploadHandler(httptest.NewRecorder(), req)
+
+	var manifest string
+	for name, content := range mockStorage.files {
+		if strings.HasSuffix(name, "/SHA256SUMS") {
+			manifest = string(content)
+		}
+	}
+	var want strings.Builder
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		sum := sha256.Sum256([]byte("content of " + name))
+		want.WriteString(hex.EncodeToString(sum[:]) + "  " + name + "\n")
+	}
+	if manifest != want.String() {
+		t.Errorf("unexpected manifest:\n%s\nwant:\n%s", manifest, want.String())
+	}
+}
+
+func TestWriteSessionChecksums_SharedFolder(t *testing.T) {
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	sessionChecksums = true
+	defer func() {
+		storage = originalStorage
+		sessionChecksums = false
+	}()
+
+	write := func(files ...string) {
+		var stored []*pipelineFile
+		for _, name := range files {
+			stored = append(stored, &pipelineFile{Name: "2024-06-01/" + name, Session: "2024-06-01", Status: "stored", SHA256: "sum of " + name})
+		}
+		writeSessionChecksums(context.Background(), stored)
+	}
+	write("b.jpg", "a.jpg")
+	write("c.jpg")
+	write("a.jpg")
+
+	want := "sum of b.jpg  b.jpg\nsum of c.jpg  c.jpg\nsum of a.jpg  a.jpg\n"
+	if manifest := string(mockStorage.files["2024-06-01/SHA256SUMS"]); manifest != want {
+		t.Errorf("unexpected manifest:\n%s\nwant:\n%s", manifest, want)
+	}
+}
+
+func TestUploadHandler_ReservedChecksumsName(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	sessionChecksums = true
+	defer func() {
+		storage = originalStorage
+		sessionChecksums = false
+	}()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "SHA256SUMS")
+	part.Write([]byte("guest content"))
+	writer.Close()
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	uploadHandler(httptest.NewRecorder(), req)
+
+	var manifest, guest string
+	for name, content := range mockStorage.files {
+		switch {
+		case strings.HasSuffix(name, "/SHA256SUMS"):
+			manifest = string(content)
+		case strings.Contains(name, "/SHA256SUMS-") && !strings.HasPrefix(name, metaPrefix):
+			guest = name
+		}
+	}
+	if guest == "" || string(mockStorage.files[guest]) != "guest content" {
+		t.Fatalf("expected the guest file under another name, got %v", mockStorage.files)
+	}
+	if !strings.HasSuffix(manifest, "  "+strings.SplitN(guest, "/", 2)[1]+"\n") {
+		t.Errorf("expected the manifest to list the renamed file, got %q", manifest)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// commands run instead of the server when named as the first argument.
+var commands = map[string]func(args []string, stdout io.Writer) error{
+	"admin":   runAdminCommand,
+	"check":   runCheckCommand,
+	"migrate": runMigrateCommand,
+}
+
+// runCommand runs a subcommand and returns the process exit code.
+func runCommand(name string, args []string) int {
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		return 2
+	}
+	godotenv.Load()
+	if err := command(args, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		}
+		return 1
+	}
+	return 0
+}
+
+const adminUsage = `Usage: go-uploader admin [flags] <command>
+
+Commands:
+  ls [prefix]           List published files, e.g. of a session
+  rm <session|path>...  Delete sessions or single files
+  stats                 Show file counts per storage area
+
+Flags:
+`
+
+// runAdminCommand manages a running instance through its admin API.
+func runAdminCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), adminUsage)
+		flags.PrintDefaults()
+	}
+	baseURL := os.Getenv("UPLOADER_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	flags.StringVar(&baseURL, "url", baseURL, "URL of the instance (UPLOADER_URL)")
+	token := flags.String("token", os.Getenv("ADMIN_TOKEN"), "admin token (ADMIN_TOKEN)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *token == "" {
+		return errors.New("no admin token, set ADMIN_TOKEN or pass -token")
+	}
+
+	client := &adminClient{
+		baseURL: baseURL,
+		token:   *token,
+		client:  &http.Client{Timeout: time.Minute},
+	}
+	switch flags.Arg(0) {
+	case "ls":
+		var files []string
+		if err := client.do(http.MethodGet, url.Values{"prefix": {flags.Arg(1)}}, &files, "admin", "files"); err != nil {
+			return err
+		}
+		for _, name := range files {
+			fmt.Fprintln(stdout, name)
+		}
+	case "rm":
+		if flags.NArg() < 2 {
+			return errors.New("rm needs at least one session or path")
+		}
+		for _, name := range flags.Args()[1:] {
+			name = strings.Trim(name, "/")
+			// Anything that isn't a single file is deleted as a session
+			err := client.do(http.MethodDelete, nil, nil, "admin", "files", name)
+			var status *statusError
+			if errors.As(err, &status) && status.code == http.StatusNotFound {
+				err = client.do(http.MethodDelete, nil, nil, "admin", "sessions", name)
+			}
+			if err != nil {
+				return fmt.Errorf("deleting %s: %w", name, err)
+			}
+			fmt.Fprintf(stdout, "Deleted %s\n", name)
+		}
+	case "stats":
+		var stats storageStats
+		if err := client.do(http.MethodGet, nil, &stats, "admin", "stats"); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Sessions\t%d\n", stats.Sessions)
+		fmt.Fprintf(tw, "Files\t%d\n", stats.Files)
+		fmt.Fprintf(tw, "Pending\t%d\n", stats.Pending)
+		fmt.Fprintf(tw, "Quarantined\t%d\n", stats.Quarantined)
+		fmt.Fprintf(tw, "Versions\t%d\n", stats.Versions)
+		fmt.Fprintf(tw, "Trash\t%d\n", stats.Trash)
+		return tw.Flush()
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown admin command %q", flags.Arg(0))
+	}
+	return nil
+}
+
+// statusError is an error response of the admin API.
+type statusError struct {
+	code    int
+	message string
+}
+
+func (e *statusError) Error() string { return e.message }
+
+// adminClient calls the admin API of a running instance.
+type adminClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// do sends a request to the path made of elems and decodes a JSON response
+// into out, if given.
+func (c *adminClient) do(method string, query url.Values, out any, elems ...string) error {
+	u, err := url.JoinPath(c.baseURL, elems...)
+	if err != nil {
+		return err
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &statusError{code: resp.StatusCode, message: resp.Status + ": " + strings.TrimSpace(string(message))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
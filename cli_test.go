@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminCommand(t *testing.T) {
+	mockStorage := &MockStorage{files: map[string][]byte{
+		"session1/photo.jpg":           []byte("a"),
+		"session1/videos/clip.mp4":     []byte("b"),
+		"session2/my photo.jpg":        []byte("c"),
+		"pending/session3/d.jpg":       []byte("d"),
+		"meta/session1/photo.jpg.json": []byte(`{"path":"session1/photo.jpg","image":{"width":4000,"height":3000}}`),
+	}}
+	originalStorage := storage
+	storage = mockStorage
+	adminToken = "secret"
+	defer func() {
+		storage = originalStorage
+		adminToken = ""
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/files", requireAdmin(fileListHandler))
+	mux.HandleFunc("DELETE /admin/files/{path...}", requireAdmin(fileDeleteHandler))
+	mux.HandleFunc("GET /admin/stats", requireAdmin(statsHandler))
+	mux.HandleFunc("DELETE /admin/sessions/{id...}", requireAdmin(sessionDeleteHandler))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	run := func(args ...string) (string, error) {
+		var out strings.Builder
+		err := runAdminCommand(append([]string{"-url", server.URL, "-token", "secret"}, args...), &out)
+		return out.String(), err
+	}
+
+	out, err := run("ls")
+	if err != nil || out != "session1/photo.jpg\nsession1/videos/clip.mp4\nsession2/my photo.jpg\n" {
+		t.Errorf("ls = %q, %v", out, err)
+	}
+	out, err = run("ls", "session1")
+	if err != nil || out != "session1/photo.jpg\nsession1/videos/clip.mp4\n" {
+		t.Errorf("ls session1 = %q, %v", out, err)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/admin/files?prefix=session1&details=true", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var details []fileDetails
+	json.NewDecoder(resp.Body).Decode(&details)
+	resp.Body.Close()
+	if len(details) != 2 || details[0].Image == nil || details[0].Image.Width != 4000 || details[1].Image != nil {
+		t.Errorf("unexpected file details %+v", details)
+	}
+
+	out, err = run("stats")
+	if err != nil || !strings.Contains(out, "Sessions     2") || !strings.Contains(out, "Pending      1") {
+		t.Errorf("stats = %q, %v", out, err)
+	}
+
+	if _, err := run("rm", "session2/my photo.jpg", "session1"); err != nil {
+		t.Fatalf("rm: %v", err)
+	}
+	for _, name := range []string{"session2/my photo.jpg", "session1/photo.jpg", "meta/session1/photo.jpg.json"} {
+		if _, ok := mockStorage.files[name]; ok {
+			t.Errorf("expected %s to be deleted", name)
+		}
+	}
+
+	if _, err := run("rm", "session2/missing.jpg"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	err = runAdminCommand([]string{"-url", server.URL, "-token", "wrong", "ls"}, &strings.Builder{})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// trustedProxies lists the networks whose X-Forwarded-For header is believed.
+var trustedProxies []*net.IPNet
+
+func setupTrustedProxies() error {
+	var err error
+	trustedProxies, err = parseNetworks("TRUSTED_PROXIES")
+	if err != nil {
+		return err
+	}
+	if len(trustedProxies) > 0 {
+		log.Printf("Trusting X-Forwarded-For from %d proxy network(s)", len(trustedProxies))
+	}
+	return nil
+}
+
+// parseNetworks reads a comma-separated list of IPs and CIDRs from the
+// environment variable name.
+func parseNetworks(name string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range splitList(os.Getenv(name)) {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %w", name, entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// clientIP returns the address of the client that sent the request. When the
+// connection comes from a trusted proxy, X-Forwarded-For is followed from the
+// right until the first address that isn't a trusted proxy itself.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// isTrustedProxy reports whether addr belongs to a trusted proxy. Connections
+// over a Unix socket are local and always trusted.
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr == "" || addr == "@"
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,192.0.2.1")
+	defer os.Unsetenv("TRUSTED_PROXIES")
+	if err := setupTrustedProxies(); err != nil {
+		t.Fatalf("setupTrustedProxies() failed: %v", err)
+	}
+	defer func() { trustedProxies = nil }()
+
+	tests := []struct {
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"203.0.113.5:1234", "198.51.100.7", "203.0.113.5"},                   // untrusted peer, header ignored
+		{"192.0.2.1:1234", "198.51.100.7", "198.51.100.7"},                    // trusted proxy
+		{"192.0.2.1:1234", "6.6.6.6, 198.51.100.7, 10.1.2.3", "198.51.100.7"}, // spoofed entry left of the real client
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"@", "198.51.100.7", "198.51.100.7"}, // Unix socket
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/upload", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tt.remote, tt.forwarded, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"512", 512, false},
+		{"256KB", 256 << 10, false},
+		{"10 MB", 10 << 20, false},
+		{"1gb", 1 << 30, false},
+		{"12B", 12, false},
+		{"", 0, true},
+		{"-1MB", 0, true},
+		{"lots", 0, true},
+	}
+
+	for _, test := range tests {
+		result, err := parseSize(test.input)
+		if (err != nil) != test.wantErr || result != test.expected {
+			t.Errorf("parseSize(%q) = %d, %v; want %d, error %v", test.input, result, err, test.expected, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// termsURL links the terms guests have to accept before uploading. Consent
+// is not required when it is empty.
+var termsURL string
+
+// termsVersion is recorded with each consent, so it is clear which terms
+// were accepted after they change.
+var termsVersion string
+
+const consentField = "consent"
+
+func setupConsent() {
+	termsURL = os.Getenv("TERMS_URL")
+	termsVersion = os.Getenv("TERMS_VERSION")
+	if termsURL != "" {
+		log.Printf("Requiring consent to the terms at %s", termsURL)
+	}
+}
+
+// consentRecord proves that the guest of an upload session accepted the
+// terms. The client IP is only stored as a keyed hash.
+type consentRecord struct {
+	Session      string    `json:"session"`
+	Time         time.Time `json:"time"`
+	IPHash       string    `json:"ip_hash"`
+	RequestID    string    `json:"request_id,omitempty"`
+	TermsURL     string    `json:"terms_url"`
+	TermsVersion string    `json:"terms_version,omitempty"`
+}
+
+// consentKey has no .json suffix, so it can't collide with the metadata of
+// an uploaded file in the session. Every request gets its own record, as
+// several guests may upload into a shared session folder.
+func consentKey(session string, t time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return metaPrefix + session + "/consent/" + t.Format(versionIDFormat) + "-" + hex.EncodeToString(suffix)
+}
+
+// readConsent reports whether a consent form field was checked.
+func readConsent(part io.Reader) bool {
+	value, _ := io.ReadAll(io.LimitReader(part, 16))
+	switch string(value) {
+	case "yes", "true", "on":
+		return true
+	}
+	return false
+}
+
+// saveConsent stores the consent record next to the metadata of a session.
+func saveConsent(r *http.Request, session string) error {
+	now := time.Now().UTC()
+	data, err := json.Marshal(consentRecord{
+		Session:      session,
+		Time:         now,
+		IPHash:       hex.EncodeToString(tokenMAC("consent-ip", clientIP(r))),
+		RequestID:    requestID(r.Context()),
+		TermsURL:     termsURL,
+		TermsVersion: termsVersion,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = storage.SaveFile(consentKey(session, now), bytes.NewReader(data))
+	return err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadHandler_Consent(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	termsURL, termsVersion = "https://example.com/terms", "2024-06"
+	defer func() {
+		storage = originalStorage
+		termsURL, termsVersion = "", ""
+	}()
+
+	upload := func(consent string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		if consent != "" {
+			writer.WriteField("consent", consent)
+		}
+		part, _ := writer.CreateFormFile("file", "a.jpg")
+		part.Write([]byte("image"))
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w
+	}
+
+	for _, consent := range []string{"", "no"} {
+		if w := upload(consent); w.Code != http.StatusForbidden {
+			t.Errorf("consent %q: expected status %d, got %d", consent, http.StatusForbidden, w.Code)
+		}
+	}
+	if len(mockStorage.files) != 0 {
+		t.Fatalf("expected nothing stored without consent, got %v", mockStorage.files)
+	}
+
+	if w := upload("yes"); w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var record consentRecord
+	for name, content := range mockStorage.files {
+		if strings.HasPrefix(name, metaPrefix) && strings.Contains(name, "/consent/") {
+			json.Unmarshal(content, &record)
+		}
+	}
+	if record.Session == "" || record.IPHash == "" || record.TermsVersion != "2024-06" {
+		t.Errorf("unexpected consent record: %+v", record)
+	}
+	if strings.Contains(record.IPHash, "192.0.2.1") {
+		t.Errorf("consent record must not contain the client IP: %+v", record)
+	}
+
+	// Guests uploading into a shared folder each leave their own record
+	sessionFolder, sharedSessionFolders = "{{date}}", true
+	defer func() { sessionFolder, sharedSessionFolders = defaultSessionFolder, false }()
+	for range 2 {
+		if w := upload("yes"); w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+	}
+	records := 0
+	for name := range mockStorage.files {
+		if strings.HasPrefix(name, metaPrefix) && strings.Contains(name, "/consent/") {
+			records++
+		}
+	}
+	if records != 3 {
+		t.Errorf("expected a consent record per request, got %d", records)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition"
+	rktypes "github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+// moderationResult records what the external moderation service reported for
+// a file. Score ranges from 0 (harmless) to 1 (certainly inappropriate).
+type moderationResult struct {
+	Provider    string    `json:"provider"`
+	Score       float64   `json:"score"`
+	Labels      []string  `json:"labels,omitempty"`
+	Quarantined bool      `json:"quarantined"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// contentModerator classifies image bytes with an external service.
+type contentModerator interface {
+	Name() string
+	Moderate(ctx context.Context, contentType string, data []byte) (score float64, labels []string, err error)
+}
+
+var moderator contentModerator
+var moderationThreshold = 0.8
+
+// moderationMaxSize is the largest image sent for moderation, which is also
+// the amount buffered in memory per upload while moderation is enabled.
+var moderationMaxSize int64 = 5 * 1024 * 1024
+
+func setupContentModeration() error {
+	provider := os.Getenv("MODERATION_PROVIDER")
+	switch provider {
+	case "":
+		return nil
+	case "http":
+		endpoint := os.Getenv("MODERATION_URL")
+		if endpoint == "" {
+			return fmt.Errorf("MODERATION_URL is required for the http moderation provider")
+		}
+		moderator = &httpModerator{URL: endpoint, APIKey: os.Getenv("MODERATION_API_KEY")}
+	case "rekognition":
+		cfg, err := config.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			return fmt.Errorf("loading AWS config: %w", err)
+		}
+		moderator = &rekognitionModerator{Client: rekognition.NewFromConfig(cfg)}
+	default:
+		return fmt.Errorf("unknown MODERATION_PROVIDER %q", provider)
+	}
+
+	if value := os.Getenv("MODERATION_THRESHOLD"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			return fmt.Errorf("MODERATION_THRESHOLD must be between 0 and 1, got %q", value)
+		}
+		moderationThreshold = threshold
+	}
+	if value := os.Getenv("MODERATION_MAX_SIZE"); value != "" {
+		size, err := parseSize(value)
+		if err != nil {
+			return fmt.Errorf("parsing MODERATION_MAX_SIZE: %w", err)
+		}
+		moderationMaxSize = size
+	}
+	log.Printf("Moderating images with %s, quarantining above score %.2f", provider, moderationThreshold)
+	return nil
+}
+
+// moderateUpload buffers small images and sends them to the moderator before
+// they are stored. It returns the reader to store from, the moderation
+// result (nil if the file wasn't moderated) and a quarantine reason if the
+// score is above the threshold.
+func moderateUpload(ctx context.Context, name, contentType string, data io.Reader) (io.Reader, *moderationResult, string) {
+	if moderator == nil {
+		return data, nil, ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType = mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return data, nil, ""
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(data, moderationMaxSize+1))
+	reader := io.MultiReader(bytes.NewReader(buf), data)
+	if err != nil {
+		return reader, nil, ""
+	}
+	if int64(len(buf)) > moderationMaxSize {
+		requestLogf(ctx, "Skipping moderation of %s: larger than %d bytes", logName(name), moderationMaxSize)
+		return reader, nil, ""
+	}
+
+	result := &moderationResult{Provider: moderator.Name(), Time: time.Now()}
+	result.Score, result.Labels, err = moderator.Moderate(ctx, mediaType, buf)
+	if err != nil {
+		requestLogf(ctx, "Moderation of %s failed: %v", logName(name), err)
+		result.Error = err.Error()
+		return reader, result, ""
+	}
+	if result.Score < moderationThreshold {
+		return reader, result, ""
+	}
+	result.Quarantined = true
+	return reader, result, fmt.Sprintf("moderation score %.2f (%s)", result.Score, strings.Join(result.Labels, ", "))
+}
+
+// httpModerator posts the image bytes to a generic moderation API that
+// responds with {"score": 0.93, "labels": ["nudity"]}.
+type httpModerator struct {
+	URL    string
+	APIKey string
+}
+
+func (h *httpModerator) Name() string { return "http" }
+
+func (h *httpModerator) Moderate(ctx context.Context, contentType string, data []byte) (float64, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if h.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, nil, fmt.Errorf("moderation API returned %s", resp.Status)
+	}
+
+	var result struct {
+		Score  float64  `json:"score"`
+		Labels []string `json:"labels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, nil, fmt.Errorf("decoding moderation response: %w", err)
+	}
+	return result.Score, result.Labels, nil
+}
+
+// rekognitionModerator uses AWS Rekognition moderation labels. The score is
+// the highest label confidence.
+type rekognitionModerator struct {
+	Client *rekognition.Client
+}
+
+func (r *rekognitionModerator) Name() string { return "rekognition" }
+
+func (r *rekognitionModerator) Moderate(ctx context.Context, contentType string, data []byte) (float64, []string, error) {
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		return 0, nil, fmt.Errorf("rekognition doesn't support %s", contentType)
+	}
+
+	out, err := r.Client.DetectModerationLabels(ctx, &rekognition.DetectModerationLabelsInput{
+		Image:         &rktypes.Image{Bytes: data},
+		MinConfidence: aws.Float32(50),
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var score float64
+	var labels []string
+	for _, label := range out.ModerationLabels {
+		labels = append(labels, aws.ToString(label.Name))
+		score = max(score, float64(aws.ToFloat32(label.Confidence))/100)
+	}
+	return score, labels, nil
+}
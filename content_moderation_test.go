@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContentModeration(t *testing.T) {
+	fakeTurnstile(t)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if bytes.Contains(data, []byte("explicit")) {
+			w.Write([]byte(`{"score":0.97,"labels":["explicit nudity"]}`))
+			return
+		}
+		w.Write([]byte(`{"score":0.01}`))
+	}))
+	defer api.Close()
+
+	moderator = &httpModerator{URL: api.URL}
+	defer func() { moderator = nil }()
+
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, content := range map[string]string{"safe.jpg": "harmless", "bad.jpg": "explicit"} {
+		part, _ := writer.CreateFormFile("file", name)
+		part.Write([]byte("\xff\xd8\xff\xe0 " + content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var safe, bad string
+	names, _ := mockStorage.ListFiles("")
+	for _, name := range names {
+		switch {
+		case strings.HasPrefix(name, metaPrefix):
+		case strings.HasSuffix(name, "safe.jpg"):
+			safe = name
+		case strings.HasSuffix(name, "bad.jpg"):
+			bad = name
+		}
+	}
+	if safe == "" || strings.HasPrefix(safe, quarantinePrefix) {
+		t.Errorf("Expected safe.jpg to be stored publicly, got %v", names)
+	}
+	if !strings.HasPrefix(bad, quarantinePrefix) {
+		t.Fatalf("Expected bad.jpg to be quarantined, got %v", names)
+	}
+
+	meta, err := loadMetadata(strings.TrimPrefix(bad, quarantinePrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Moderation.Quarantined || meta.Moderation.Score != 0.97 {
+		t.Errorf("Expected quarantined moderation result, got %+v", meta.Moderation)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Form posts of the upload page carry a CSRF token bound to a cookie
+// (double submit): another site can make a browser post a form, with its
+// cookies and client certificate, but can't read the cookie to get the
+// token right.
+const (
+	csrfCookie  = "uploader_csrf"
+	csrfField   = "csrf_token"
+	csrfPurpose = "csrf"
+)
+
+// csrfTokenPlaceholder is rendered into the upload page in place of the
+// token, which differs per browser.
+const csrfTokenPlaceholder = "CSRF_TOKEN_PLACEHOLDER"
+
+// csrfExemptHeaders are sent by the page's script and by API clients.
+// Browsers only send them cross-site after a CORS preflight, which the
+// uploader never allows, so requests carrying one can't be forged.
+var csrfExemptHeaders = []string{"Authorization", "X-Turnstile-Token", "X-PoW-Solution", captchaSessionHeader, uploadSessionHeader, uploadTokenHeader, "Idempotency-Key"}
+
+// csrfToken returns the token of the browser with the CSRF cookie set on r,
+// setting a new cookie if there is none.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	value := ""
+	if cookie, err := r.Cookie(csrfCookie); err == nil && validCSRFCookie(cookie.Value) {
+		value = cookie.Value
+	} else {
+		b := make([]byte, 16)
+		rand.Read(b)
+		value = hex.EncodeToString(b)
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookie,
+			Value:    value,
+			Path:     publicBasePath + "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenMAC(csrfPurpose, value))
+}
+
+func validCSRFCookie(value string) bool {
+	_, err := hex.DecodeString(value)
+	return len(value) == 32 && err == nil
+}
+
+// requiresCSRFToken reports whether r looks like a form posted by a
+// browser: it has an Origin or Referer, but none of the headers only
+// scripts can send. Clients like curl send neither and pass.
+func requiresCSRFToken(r *http.Request) bool {
+	if r.Header.Get("Origin") == "" && r.Header.Get("Referer") == "" {
+		return false
+	}
+	for _, name := range csrfExemptHeaders {
+		if r.Header.Get(name) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// readCSRFToken reads the token form field and reports whether it matches
+// the cookie sent with r.
+func readCSRFToken(r *http.Request, part io.Reader) bool {
+	value, _ := io.ReadAll(io.LimitReader(part, 64))
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || !validCSRFCookie(cookie.Value) {
+		return false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(string(value)))
+	return err == nil && hmac.Equal(mac, tokenMAC(csrfPurpose, cookie.Value))
+}
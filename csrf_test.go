@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestUploadHandler_CSRF(t *testing.T) {
+	fakeTurnstile(t)
+	originalStorage := storage
+	storage = &MockStorage{}
+	defer func() { storage = originalStorage }()
+
+	w := httptest.NewRecorder()
+	serveIndexPage(w, httptest.NewRequest("GET", "/", nil), `<input name="csrf_token" value="`+csrfTokenPlaceholder+`">`)
+	cookies := w.Result().Cookies()
+	match := regexp.MustCompile(`value="([^"]+)"`).FindStringSubmatch(w.Body.String())
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie || match == nil || match[1] == csrfTokenPlaceholder {
+		t.Fatalf("expected a cookie and a token in the page, got %v and %s", cookies, w.Body.String())
+	}
+	token := match[1]
+
+	upload := func(token string, cookie *http.Cookie, header string) int {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		if token != "" {
+			writer.WriteField(csrfField, token)
+		}
+		part, _ := writer.CreateFormFile("file", "photo.jpg")
+		part.Write([]byte("data"))
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Origin", "http://example.com")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if header != "" {
+			req.Header.Set(header, "token")
+		}
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w.Code
+	}
+
+	other := &http.Cookie{Name: csrfCookie, Value: "00112233445566778899aabbccddeeff"}
+	for _, test := range []struct {
+		name   string
+		token  string
+		cookie *http.Cookie
+		header string
+		ok     bool
+	}{
+		{"form with token", token, cookies[0], "", true},
+		{"form without token", "", cookies[0], "", false},
+		{"form without cookie", token, nil, "", false},
+		{"token of another browser", token, other, "", false},
+		{"script", "", nil, "X-Turnstile-Token", true},
+		{"API client", "", nil, "Authorization", true},
+	} {
+		code := upload(test.token, test.cookie, test.header)
+		if ok := code != http.StatusForbidden; ok != test.ok {
+			t.Errorf("%s: got %d", test.name, code)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"html/template"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// devMode makes the server run locally without any setup: no CAPTCHA, local
+// storage, every request logged, and templates and static files read from
+// the working directory on every request, so edits show up on reload.
+var devMode bool
+
+func setupDevMode() {
+	devMode = os.Getenv("DEV_MODE") == "true"
+	if !devMode {
+		return
+	}
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+	for _, setting := range []struct {
+		name, value string
+	}{
+		{"CAPTCHA_PROVIDER", "none"},
+		{"BACKEND", "local"},
+		{"LOCAL_PATH", "./uploads"},
+	} {
+		if os.Getenv(setting.name) == "" {
+			os.Setenv(setting.name, setting.value)
+		}
+	}
+	log.Println("Developer mode enabled, don't use it in production")
+}
+
+// pageTemplates returns the server-rendered page templates, parsed from
+// disk in developer mode.
+func pageTemplates() *template.Template {
+	if !devMode {
+		return templates
+	}
+	t, err := template.ParseFS(os.DirFS("."), "templates/*.html")
+	if err != nil {
+		log.Printf("Error reloading templates, using the embedded ones: %v", err)
+		return templates
+	}
+	return t
+}
+
+// publicFiles returns the static files, read from disk in developer mode
+// when the server runs from the source tree.
+func publicFiles() (fs.FS, error) {
+	if devMode {
+		if info, err := os.Stat("public"); err == nil && info.IsDir() {
+			return os.DirFS("public"), nil
+		}
+	}
+	return fs.Sub(staticFiles, "public")
+}
+
+// withDevLogging logs every request with its status and duration in
+// developer mode.
+func withDevLogging(next http.Handler) http.Handler {
+	if !devMode {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		status := &statusWriter{trackingWriter: trackingWriter{ResponseWriter: w}, status: http.StatusOK}
+		next.ServeHTTP(status, r)
+		requestLogf(r.Context(), "%s %s %d %s", r.Method, r.URL.RequestURI(), status.status, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	trackingWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+	}
+	s.trackingWriter.WriteHeader(code)
+}
+
+// uploadPageURL is where the upload page of a server listening on addr can
+// be opened.
+func uploadPageURL(addr net.Addr) string {
+	if publicBaseURL != "" {
+		return publicURL("/")
+	}
+	if addr.Network() != "tcp" {
+		return addr.String()
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	scheme := "http"
+	if tlsCertFile != "" {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + "/"
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestSetupDevMode(t *testing.T) {
+	t.Setenv("DEV_MODE", "true")
+	for _, name := range []string{"CAPTCHA_PROVIDER", "BACKEND", "LOCAL_PATH", "TLS_CLIENT_CA"} {
+		t.Setenv(name, "")
+	}
+	originalProvider := captchaProvider
+	originalFlags := log.Flags()
+	defer func() {
+		log.SetFlags(originalFlags)
+		devMode = false
+		captchaProvider = originalProvider
+	}()
+
+	setupDevMode()
+	if !devMode || os.Getenv("CAPTCHA_PROVIDER") != "none" || os.Getenv("BACKEND") != "local" {
+		t.Fatalf("expected developer defaults, got provider %q and backend %q", os.Getenv("CAPTCHA_PROVIDER"), os.Getenv("BACKEND"))
+	}
+	if err := setupCaptcha(); err != nil {
+		t.Errorf("expected the CAPTCHA to be disabled without client certificates, got %v", err)
+	}
+	if pageTemplates().Lookup("share.html") == nil {
+		t.Error("expected templates to be read from disk")
+	}
+
+	handler := withDevLogging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected the status to pass through, got %d", w.Code)
+	}
+}
+
+func TestUploadPageURL(t *testing.T) {
+	for _, test := range []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.TCPAddr{IP: net.IPv6unspecified, Port: 8080}, "http://localhost:8080/"},
+		{&net.TCPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 80}, "http://192.168.1.2:80/"},
+		{&net.UnixAddr{Name: "/run/uploader.sock", Net: "unix"}, "/run/uploader.sock"},
+	} {
+		if got := uploadPageURL(test.addr); got != test.want {
+			t.Errorf("uploadPageURL(%v) = %q, want %q", test.addr, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	store "go-uploader/storage"
+)
+
+// diskReserve is the free space kept on the disk uploads are written to:
+// uploads that would use it are rejected before they are received.
+var diskReserve int64
+
+// uploadDisk is the local storage uploads are written to, the spool or the
+// local backend, whose free space is checked before accepting an upload.
+var uploadDisk *store.LocalStorage
+
+// setupDiskSpace finds the disk uploads are written to and reads the
+// reserve. It has to run after the spool is set up.
+func setupDiskSpace() error {
+	diskReserve, uploadDisk = 0, nil
+	if value := os.Getenv("LOCAL_DISK_RESERVE"); value != "" {
+		size, err := parseSize(value)
+		if err != nil || size < 0 {
+			return fmt.Errorf("LOCAL_DISK_RESERVE must be a size, got %q", value)
+		}
+		diskReserve = size
+	}
+	b := storage
+	if spool, ok := b.(*spooledBackend); ok {
+		b = spool.spool
+	}
+	local, ok := unwrapBackend(b).(*store.LocalStorage)
+	if !ok {
+		return nil
+	}
+	if _, err := local.FreeSpace(); errors.Is(err, errors.ErrUnsupported) {
+		log.Println("Free disk space can't be checked on this platform")
+		return nil
+	}
+	uploadDisk = local
+	if diskReserve > 0 {
+		log.Printf("Keeping %s free in %s", formatSize(diskReserve), local.BasePath)
+	}
+	return nil
+}
+
+func writeStorageFull(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusInsufficientStorage, problemStorageFull, "Not enough storage space left for this upload")
+}
+
+// checkDiskSpace reports whether the disk an upload of size bytes is
+// written to has room for it, so it can be rejected up front instead of
+// failing halfway. A size of -1, for chunked requests, only checks the
+// reserve. Uploads to other backends are always accepted.
+func checkDiskSpace(ctx context.Context, size int64) bool {
+	disk := uploadDisk
+	if d := requestDrop(ctx); d != nil && d.Backend != "" {
+		disk = d.disk
+	}
+	if disk == nil {
+		return true
+	}
+	free, err := disk.FreeSpace()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return true
+	}
+	if err != nil {
+		requestLogf(ctx, "Error checking free space in %s: %v", disk.BasePath, err)
+		return true
+	}
+	return free >= diskReserve+max(size, 0)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	store "go-uploader/storage"
+)
+
+func TestUploadHandlerDiskFull(t *testing.T) {
+	local, err := store.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	free, err := local.FreeSpace()
+	if err != nil {
+		t.Skipf("free space is not available: %v", err)
+	}
+	originalStorage := storage
+	storage = local
+	uploadDisk = local
+	defer func() {
+		storage = originalStorage
+		uploadDisk, diskReserve = nil, 0
+	}()
+	fakeTurnstile(t)
+
+	upload := func(contentLength int64) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "photo.jpg")
+		part.Write([]byte("photo"))
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Turnstile-Token", "token")
+		req.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w
+	}
+
+	if w := upload(2 * free); w.Code != http.StatusInsufficientStorage {
+		t.Errorf("upload larger than the free space = %d: %s", w.Code, w.Body)
+	}
+	// Chunked uploads only need the reserve
+	diskReserve = 2 * free
+	if w := upload(-1); w.Code != http.StatusInsufficientStorage {
+		t.Errorf("chunked upload into the reserve = %d: %s", w.Code, w.Body)
+	}
+	diskReserve = 0
+	if w := upload(-1); w.Code != http.StatusCreated {
+		t.Errorf("upload with free space = %d: %s", w.Code, w.Body)
+	}
+}
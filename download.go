@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	store "go-uploader/storage"
+)
+
+// serveStoredFile streams a file from the storage backend. Media types that
+// browsers can't execute are shown inline, everything else is downloaded.
+func serveStoredFile(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := storage.OpenFile(name)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, errStorageUnavailable) {
+		w.Header().Set("Retry-After", retryAfter(breakerCooldown))
+		http.Error(w, "Storage temporarily unavailable, please try again in a few minutes", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		requestLogf(r.Context(), "Error opening file %s: %v", logName(name), err)
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	contentType, filename := storedFileType(r, name)
+	disposition := "attachment"
+	if isInlineType(contentType) {
+		disposition = "inline"
+	}
+	header := mime.FormatMediaType(disposition, map[string]string{"filename": filename})
+	if header == "" {
+		// The original name may not be representable
+		header = mime.FormatMediaType(disposition, map[string]string{"filename": path.Base(name)})
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", header)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(stallWriter{w, r}, throttleDownload(r.Context(), f)); err != nil {
+		requestLogf(r.Context(), "Error sending file %s: %v", logName(name), err)
+	}
+}
+
+// storedFileType returns the content type and file name a stored file is
+// served with: those recorded at upload, or else guessed from its name.
+func storedFileType(r *http.Request, name string) (string, string) {
+	contentType, filename := mime.TypeByExtension(path.Ext(name)), path.Base(name)
+	meta, err := loadMetadata(name)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		requestLogf(r.Context(), "Error loading metadata of %s: %v", logName(name), err)
+	}
+	if err == nil {
+		if meta.ContentType != "" {
+			contentType = meta.ContentType
+		}
+		if meta.OriginalName != "" {
+			filename = meta.OriginalName
+		}
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return contentType, filename
+}
+
+func isInlineType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "image/svg+xml" {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "image/") ||
+		strings.HasPrefix(mediaType, "video/") ||
+		strings.HasPrefix(mediaType, "audio/")
+}
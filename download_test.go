@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+)
+
+func TestServeStoredFile_UploadedType(t *testing.T) {
+	fakeTurnstile(t)
+	originalStorage := storage
+	mock := &MockStorage{}
+	storage = mock
+	defer func() { storage = originalStorage }()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="report:final.dat"`)
+	header.Set("Content-Type", "application/pdf")
+	part, _ := writer.CreatePart(header)
+	part.Write([]byte("%PDF-1.7"))
+	writer.Close()
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var stored string
+	for name := range mock.files {
+		if !isInternalPath(name) {
+			stored = name
+		}
+	}
+	w = httptest.NewRecorder()
+	serveStoredFile(w, httptest.NewRequest("GET", "/"+stored, nil), stored)
+	if got := w.Header().Get("Content-Type"); got != "application/pdf" {
+		t.Errorf("expected the uploaded content type, got %q", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="report:final.dat"` {
+		t.Errorf("expected the original file name, got %q", got)
+	}
+}
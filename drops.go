@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	// Time zones of drops must load in containers without tzdata
+	_ "time/tzdata"
+
+	store "go-uploader/storage"
+)
+
+// drop is a named upload endpoint at /d/<name>, e.g. one per event, with its
+// own settings. Unset settings fall back to the global configuration.
+type drop struct {
+	Name  string
+	Title string
+	// Prefix is the folder all sessions of the drop are stored in
+	Prefix string
+	// Captcha overrides CAPTCHA_PROVIDER; "none" disables the check
+	Captcha string
+	// Backend names the drop's own storage backend, if it has one
+	Backend     string
+	MaxFiles    int
+	Pipeline    []pipelineStage
+	SessionHook []string
+	// Opens and Closes limit when uploads are accepted; zero values leave
+	// the window open on that side
+	Opens  time.Time
+	Closes time.Time
+	page   string
+	// disk is the drop's own local storage, if it has one
+	disk *store.LocalStorage
+}
+
+// dropTimeFormat is used for the open and close times of drops and when
+// showing them to guests.
+const (
+	dropTimeFormat    = "2006-01-02 15:04"
+	dropDisplayFormat = "02.01.2006 um 15:04 Uhr"
+)
+
+var drops map[string]*drop
+
+// dropPoW is set if a drop uses proof-of-work while the global provider
+// doesn't.
+var dropPoW bool
+
+var dropNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+type dropKey struct{}
+
+// setupDrops reads the drops listed in DROPS from DROP_<NAME>_<SETTING>
+// variables and registers their routes.
+func setupDrops() error {
+	names := splitList(os.Getenv("DROPS"))
+	if len(names) == 0 {
+		return nil
+	}
+
+	drops = make(map[string]*drop)
+	routes := map[string]store.Backend{}
+	for _, name := range names {
+		if !dropNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid drop name %q, use lowercase letters, digits and dashes", name)
+		}
+		env := func(setting string) string {
+			return os.Getenv("DROP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_" + setting)
+		}
+
+		d := &drop{Name: name, Title: env("TITLE"), Prefix: env("PREFIX"), Captcha: env("CAPTCHA")}
+		if d.Prefix == "" {
+			d.Prefix = name
+		}
+		d.Prefix = strings.Trim(d.Prefix, "/")
+		if _, ok := cleanStoragePath(d.Prefix); !ok {
+			return fmt.Errorf("drop %s: invalid prefix %q", name, d.Prefix)
+		}
+		switch d.Captcha {
+		case "", captchaProvider:
+		case "none":
+			// Like CAPTCHA_PROVIDER, only client certificates may replace it
+			if os.Getenv("TLS_CLIENT_CA") == "" && !devMode {
+				return fmt.Errorf("drop %s: CAPTCHA none requires TLS_CLIENT_CA", name)
+			}
+		case "turnstile":
+			if err := setupDropTurnstile(); err != nil {
+				return fmt.Errorf("drop %s: %w", name, err)
+			}
+		case "pow":
+			dropPoW = true
+		default:
+			return fmt.Errorf("drop %s: unknown CAPTCHA %q", name, d.Captcha)
+		}
+		if value := env("MAX_FILES"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 {
+				return fmt.Errorf("drop %s: MAX_FILES must be a positive number, got %q", name, value)
+			}
+			d.MaxFiles = limit
+		}
+		if spec := env("PIPELINE"); spec != "" {
+			stages, err := parsePipeline(spec)
+			if err != nil {
+				return fmt.Errorf("drop %s: parsing PIPELINE: %w", name, err)
+			}
+			d.Pipeline = stages
+		}
+		d.SessionHook = strings.Fields(env("HOOK_SESSION_COMMAND"))
+		if err := parseUploadWindow(d, env("OPENS"), env("CLOSES"), env("TIMEZONE")); err != nil {
+			return fmt.Errorf("drop %s: %w", name, err)
+		}
+		if backend := env("BACKEND"); backend != "" && backend != backendName {
+			b, err := newBackend(backend)
+			if err != nil {
+				return fmt.Errorf("drop %s: %w", name, err)
+			}
+			routes[d.Prefix] = guardBackend(backend, instrumentBackend(backend, b))
+			d.Backend = backend
+			if local, ok := b.(*store.LocalStorage); ok {
+				d.disk = local
+			}
+		}
+
+		page, _, err := renderIndexPage(d.Captcha, d.Title, "/d/"+name+"/upload")
+		if err != nil {
+			return fmt.Errorf("drop %s: %w", name, err)
+		}
+		d.page = page
+		drops[name] = d
+		log.Printf("Serving drop %s at /d/%s, storing into %s/", name, name, d.Prefix)
+	}
+	if len(routes) > 0 {
+		storage = &dropStorage{Default: storage, Routes: routes}
+	}
+	if dropPoW {
+		// The challenge endpoint is only registered for the global provider
+		if err := setupPoW(); err != nil {
+			return err
+		}
+	}
+
+	mux.HandleFunc("GET /d/{drop}", withDrop(func(w http.ResponseWriter, r *http.Request) {
+		serveIndexPage(w, r, requestDrop(r.Context()).page)
+	}))
+	mux.HandleFunc("POST /d/{drop}/upload", withDrop(requireClientCert(withUploadDeadline(withIdempotency(uploadHandler)))))
+	return nil
+}
+
+// parseUploadWindow sets the open and close times of a drop, given in its
+// time zone or the global TIMEZONE if none is set.
+func parseUploadWindow(d *drop, opens, closes, zone string) error {
+	location := sessionLocation
+	if zone != "" {
+		var err error
+		location, err = time.LoadLocation(zone)
+		if err != nil {
+			return fmt.Errorf("invalid TIMEZONE: %w", err)
+		}
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+		t     *time.Time
+	}{{"OPENS", opens, &d.Opens}, {"CLOSES", closes, &d.Closes}} {
+		if field.value == "" {
+			continue
+		}
+		t, err := time.ParseInLocation(dropTimeFormat, field.value, location)
+		if err != nil {
+			return fmt.Errorf("%s must be formatted as %q, got %q", field.name, dropTimeFormat, field.value)
+		}
+		*field.t = t
+	}
+	if !d.Opens.IsZero() && !d.Closes.IsZero() && !d.Closes.After(d.Opens) {
+		return fmt.Errorf("CLOSES must be after OPENS")
+	}
+	if !d.Opens.IsZero() || !d.Closes.IsZero() {
+		log.Printf("Drop %s accepts uploads from %s until %s", d.Name, formatWindowTime(d.Opens), formatWindowTime(d.Closes))
+	}
+	return nil
+}
+
+func formatWindowTime(t time.Time) string {
+	if t.IsZero() {
+		return "(open)"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// closedMessage returns the message for guests if the drop doesn't accept
+// uploads at now.
+func (d *drop) closedMessage(now time.Time) (string, bool) {
+	switch {
+	case !d.Opens.IsZero() && now.Before(d.Opens):
+		return "Uploads sind ab dem " + d.Opens.Format(dropDisplayFormat) + " möglich.", true
+	case !d.Closes.IsZero() && !now.Before(d.Closes):
+		return "Uploads sind seit dem " + d.Closes.Format(dropDisplayFormat) + " geschlossen. Vielen Dank für eure Fotos!", true
+	}
+	return "", false
+}
+
+// setupDropTurnstile sets up Turnstile for drops using it while the global
+// CAPTCHA_PROVIDER is a different one.
+func setupDropTurnstile() error {
+	if turnstileSecret != "" {
+		return nil
+	}
+	turnstileSecret = os.Getenv("TURNSTILE_SECRET")
+	if turnstileSecret == "" {
+		return fmt.Errorf("TURNSTILE_SECRET environment variable is not set")
+	}
+	setupTurnstile()
+	return nil
+}
+
+// withDrop resolves the drop named in the path and passes it on in the
+// request context.
+func withDrop(next http.HandlerFunc) http.HandlerFunc {
+	return pauseDuringMaintenance(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := drops[r.PathValue("drop")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if message, closed := d.closedMessage(time.Now()); closed {
+			if r.Method == http.MethodPost {
+				writeProblem(w, r, http.StatusForbidden, problemDropClosed, message)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			if err := pageTemplates().ExecuteTemplate(w, "closed.html", message); err != nil {
+				requestLogf(r.Context(), "Error rendering closed page: %v", err)
+			}
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), dropKey{}, d)))
+	})
+}
+
+// dropName returns the name of the drop of a request, if any.
+func dropName(ctx context.Context) string {
+	if d := requestDrop(ctx); d != nil {
+		return d.Name
+	}
+	return ""
+}
+
+// requestDrop returns the drop a request was made to, or nil for the main
+// upload page.
+func requestDrop(ctx context.Context) *drop {
+	d, _ := ctx.Value(dropKey{}).(*drop)
+	return d
+}
+
+// dropStorage stores the files of drops with their own backend there and
+// everything else in the default backend. Files are matched by the drop
+// prefix, also within the internal areas like quarantine/<prefix>/.
+type dropStorage struct {
+	Default store.Backend
+	Routes  map[string]store.Backend
+}
+
+func (s *dropStorage) backend(name string) store.Backend {
+	if rest, ok := strings.CutPrefix(name, trashPrefix); ok {
+		// Skip the batch folder
+		_, name, _ = strings.Cut(rest, "/")
+	}
+	// Areas can be nested, e.g. versions/pending/<prefix>/
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, area := range []string{pendingPrefix, quarantinePrefix, metaPrefix, versionsPrefix, thumbnailsPrefix} {
+			if rest, ok := strings.CutPrefix(name, area); ok {
+				name, trimmed = rest, true
+			}
+		}
+	}
+	for prefix, b := range s.Routes {
+		if strings.HasPrefix(name, prefix+"/") {
+			return b
+		}
+	}
+	return s.Default
+}
+
+func (s *dropStorage) SaveFile(name string, data io.Reader) (store.SaveResult, error) {
+	return s.backend(name).SaveFile(name, data)
+}
+
+func (s *dropStorage) OpenFile(name string) (io.ReadCloser, error) {
+	return s.backend(name).OpenFile(name)
+}
+
+func (s *dropStorage) DeleteFile(name string) error {
+	return s.backend(name).DeleteFile(name)
+}
+
+// ListFiles merges the listings of all backends.
+func (s *dropStorage) ListFiles(prefix string) ([]string, error) {
+	names, err := s.Default.ListFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range s.Routes {
+		more, err := b.ListFiles(prefix)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, more...)
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
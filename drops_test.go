@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+func TestDrops(t *testing.T) {
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() {
+		storage = originalStorage
+		drops = nil
+	}()
+
+	t.Setenv("DROPS", "wedding")
+	t.Setenv("DROP_WEDDING_TITLE", "Anna & Ben")
+	t.Setenv("DROP_WEDDING_PREFIX", "events/wedding")
+	t.Setenv("DROP_WEDDING_CAPTCHA", "none")
+	t.Setenv("DROP_WEDDING_MAX_FILES", "1")
+	if err := setupDrops(); err == nil {
+		t.Fatal("expected a drop without CAPTCHA to require client certificates")
+	}
+	t.Setenv("TLS_CLIENT_CA", "ca.pem")
+	if err := setupDrops(); err != nil {
+		t.Fatalf("setupDrops: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/d/wedding", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Anna &amp; Ben") || !strings.Contains(w.Body.String(), `\/d\/wedding\/upload`) {
+		t.Errorf("unexpected drop page: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/d/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown drop, got %d", w.Code)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		part, _ := writer.CreateFormFile("file", name)
+		part.Write([]byte(name))
+	}
+	writer.Close()
+	req := httptest.NewRequest("POST", "/d/wedding/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	// No CAPTCHA token is needed, and the drop's file limit applies
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusPartialContent, w.Code, w.Body.String())
+	}
+	names, _ := mockStorage.ListFiles("")
+	if len(names) != 1 || !strings.HasPrefix(names[0], "events/wedding/") || !strings.HasSuffix(names[0], "/a.jpg") {
+		t.Errorf("expected the first file in the drop's prefix, got %v", names)
+	}
+}
+
+func TestDropStorage(t *testing.T) {
+	primary, wedding := &MockStorage{}, &MockStorage{}
+	s := &dropStorage{Default: primary, Routes: map[string]store.Backend{"wedding": wedding}}
+
+	for _, name := range []string{
+		"wedding/s1/a.jpg",
+		"pending/wedding/s1/b.jpg",
+		"meta/wedding/s1/a.jpg.json",
+		"versions/pending/wedding/s1/b.jpg/1",
+		"trash/20240601T120000.000000000Z/quarantine/wedding/s1/c.jpg",
+	} {
+		s.SaveFile(name, strings.NewReader("x"))
+	}
+	s.SaveFile("s2/d.jpg", strings.NewReader("x"))
+	s.SaveFile("audit/2024-06-01/e.json", strings.NewReader("x"))
+
+	if len(wedding.files) != 5 || len(primary.files) != 2 {
+		t.Errorf("unexpected routing: drop %v, default %v", wedding.files, primary.files)
+	}
+	names, _ := s.ListFiles("")
+	if len(names) != 7 {
+		t.Errorf("expected merged listing of 7 files, got %v", names)
+	}
+}
+
+func TestDropUploadWindow(t *testing.T) {
+	d := &drop{Name: "party"}
+	if err := parseUploadWindow(d, "2024-06-01 14:00", "2024-06-02 02:00", "Europe/Berlin"); err != nil {
+		t.Fatalf("parseUploadWindow: %v", err)
+	}
+	if d.Opens.UTC().Hour() != 12 {
+		t.Errorf("expected opening time in Berlin time, got %s", d.Opens.UTC())
+	}
+
+	for now, closed := range map[time.Time]bool{
+		d.Opens.Add(-time.Minute):  true,
+		d.Opens:                    false,
+		d.Closes.Add(-time.Second): false,
+		d.Closes:                   true,
+	} {
+		if _, got := d.closedMessage(now); got != closed {
+			t.Errorf("closedMessage(%s) = %v, expected %v", now, got, closed)
+		}
+	}
+
+	drops = map[string]*drop{"party": d}
+	defer func() { drops = nil }()
+	handler := withDrop(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler must not be called outside the upload window")
+	})
+	for method, status := range map[string]int{"GET": http.StatusOK, "POST": http.StatusForbidden} {
+		req := httptest.NewRequest(method, "/d/party", nil)
+		req.SetPathValue("drop", "party")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != status || !strings.Contains(w.Body.String(), "geschlossen") {
+			t.Errorf("%s: unexpected response %d %s", method, w.Code, w.Body.String())
+		}
+	}
+
+	for _, window := range [][3]string{
+		{"2024-06-01", "", ""},
+		{"2024-06-02 14:00", "2024-06-01 14:00", ""},
+		{"", "", "Mars/Olympus"},
+	} {
+		if err := parseUploadWindow(&drop{}, window[0], window[1], window[2]); err == nil {
+			t.Errorf("%v: expected error", window)
+		}
+	}
+}
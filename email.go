@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// emailAddresses are the recipients whose mail is stored as uploads. The
+// receiver is off when it is empty.
+var emailAddresses []string
+
+// emailSenders limits the senders to these addresses or @domains, if set.
+var emailSenders []string
+
+// emailMaxSize limits the size of an email including all attachments.
+var emailMaxSize int64 = 50 << 20
+
+// emailHostname is announced in the SMTP greeting.
+var emailHostname = "go-uploader"
+
+// emailCommandTimeout is the time a client has for each SMTP command.
+const emailCommandTimeout = 5 * time.Minute
+
+// setupEmail starts an SMTP receiver on EMAIL_LISTEN that stores the
+// attachments of every email to EMAIL_ADDRESS as an upload session, for
+// guests who can only send photos by email.
+func setupEmail() error {
+	address := os.Getenv("EMAIL_LISTEN")
+	if address == "" {
+		return nil
+	}
+	emailAddresses = splitList(os.Getenv("EMAIL_ADDRESS"))
+	if len(emailAddresses) == 0 {
+		return fmt.Errorf("EMAIL_ADDRESS must be set with EMAIL_LISTEN")
+	}
+	emailSenders = splitList(os.Getenv("EMAIL_ALLOWED_SENDERS"))
+	if value := os.Getenv("EMAIL_MAX_SIZE"); value != "" {
+		size, err := parseSize(value)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("EMAIL_MAX_SIZE must be a positive size, got %q", value)
+		}
+		emailMaxSize = size
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		emailHostname = hostname
+	}
+	if checkingConfig {
+		// The running server may hold the port
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("invalid EMAIL_LISTEN %q: %w", address, err)
+		}
+		return nil
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("listening for email: %w", err)
+	}
+	go serveEmail(listener)
+	log.Printf("Receiving email to %s on %s", strings.Join(emailAddresses, ", "), listener.Addr())
+	return nil
+}
+
+func serveEmail(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error accepting SMTP connection: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go handleSMTP(conn)
+	}
+}
+
+// smtpSession is the state of an SMTP connection.
+type smtpSession struct {
+	conn       net.Conn
+	text       *textproto.Conn
+	from       string
+	recipients int
+}
+
+// reply sends a reply of one or more lines and gives the client time for
+// the next command.
+func (s *smtpSession) reply(code int, lines ...string) error {
+	s.conn.SetDeadline(time.Now().Add(emailCommandTimeout))
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		if err := s.text.PrintfLine("%d%s%s", code, separator, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *smtpSession) reset() {
+	s.from, s.recipients = "", 0
+}
+
+// handleSMTP speaks enough SMTP to receive mail from a relay or a mail
+// client: no authentication, no TLS and no relaying.
+func handleSMTP(conn net.Conn) {
+	defer conn.Close()
+	s := &smtpSession{conn: conn, text: textproto.NewConn(conn)}
+	if s.reply(220, emailHostname+" ESMTP go-uploader") != nil {
+		return
+	}
+	for {
+		line, err := s.text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			err = s.reply(250, emailHostname)
+		case "EHLO":
+			err = s.reply(250, emailHostname, "8BITMIME", fmt.Sprintf("SIZE %d", emailMaxSize))
+		case "MAIL":
+			address, ok := smtpPath(arg, "FROM:")
+			switch {
+			case !ok:
+				err = s.reply(501, "Syntax: MAIL FROM:<address>")
+			case s.from != "":
+				err = s.reply(503, "Sender already given")
+			default:
+				// The null sender of bounces is accepted and stored as such
+				s.from = address
+				if s.from == "" {
+					s.from = "<>"
+				}
+				err = s.reply(250, "OK")
+			}
+		case "RCPT":
+			address, ok := smtpPath(arg, "TO:")
+			switch {
+			case !ok:
+				err = s.reply(501, "Syntax: RCPT TO:<address>")
+			case s.from == "":
+				err = s.reply(503, "Need MAIL first")
+			case !slices.Contains(emailAddresses, strings.ToLower(address)):
+				err = s.reply(550, "No such mailbox")
+			default:
+				s.recipients++
+				err = s.reply(250, "OK")
+			}
+		case "DATA":
+			if s.recipients == 0 {
+				err = s.reply(503, "Need RCPT first")
+				break
+			}
+			if err = s.reply(354, "End data with <CR><LF>.<CR><LF>"); err != nil {
+				return
+			}
+			code, message := receiveEmail(conn, s.from, s.text.DotReader())
+			s.reset()
+			err = s.reply(code, message)
+		case "RSET":
+			s.reset()
+			err = s.reply(250, "OK")
+		case "NOOP":
+			err = s.reply(250, "OK")
+		case "QUIT":
+			s.reply(221, "Bye")
+			return
+		default:
+			err = s.reply(502, "Command not implemented")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// smtpPath returns the address of a MAIL FROM or RCPT TO argument, ignoring
+// parameters like SIZE.
+func smtpPath(arg, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.ToUpper(arg[:min(len(arg), len(prefix))]), prefix)
+	if !ok || rest != "" {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(path, "<") {
+		return "", false
+	}
+	address, _, ok := strings.Cut(path[1:], ">")
+	return address, ok
+}
+
+// receiveEmail stores the attachments of an email as an upload session and
+// returns the SMTP reply, so senders get a bounce if nothing was stored.
+func receiveEmail(conn net.Conn, envelopeFrom string, data io.Reader) (int, string) {
+	defer io.Copy(io.Discard, data)
+	limited := http.MaxBytesReader(nil, io.NopCloser(data), emailMaxSize)
+	msg, err := mail.ReadMessage(bufio.NewReader(limited))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return 552, "Message too large"
+	}
+	if err != nil {
+		return 554, "Invalid message"
+	}
+	sender := envelopeFrom
+	if from, err := msg.Header.AddressList("From"); err == nil && len(from) > 0 {
+		sender = from[0].Address
+	}
+	if !emailSenderAllowed(sender) {
+		log.Printf("Rejected email from %s on %s: sender not allowed", logName(sender), logIP(conn.RemoteAddr().String()))
+		return 550, "Sender not allowed"
+	}
+	if paused, _ := maintenanceMode(); paused {
+		return 451, "Uploads are paused, try again later"
+	}
+	release, ok := acquireUploadSlot()
+	if !ok {
+		return 451, "Too busy, try again later"
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), requestIDKey{}, newRequestID()), 4*time.Minute)
+	defer cancel()
+	// Session placeholders and audit events read the client from a request
+	r := (&http.Request{RemoteAddr: conn.RemoteAddr().String(), Header: http.Header{}}).WithContext(ctx)
+	folders := newSessionFolders(r, time.Now())
+	session := folders.Session
+	requestLogf(ctx, "Receiving email from %s into session %s", logName(sender), session)
+
+	var saved, failed int
+	var stored, accepted []*pipelineFile
+	err = walkEmailParts(textproto.MIMEHeader(msg.Header), msg.Body, func(name, contentType string, body io.Reader) {
+		folder, data := folders.forFile(body)
+		result := storeUpload(ctx, folder, "", name, contentType, data)
+		auditBlockedHash(r, result)
+		switch result.outcome {
+		case outcomeRejected, outcomeFailed:
+			failed++
+			if result.outcome == outcomeRejected {
+				uploadedFiles.WithLabelValues("rejected").Inc()
+			} else {
+				uploadedFiles.WithLabelValues("failed").Inc()
+			}
+		case outcomeQuarantined:
+			saved++
+			uploadedFiles.WithLabelValues("quarantined").Inc()
+			accepted = append(accepted, result.file)
+		default:
+			saved++
+			uploadedFiles.WithLabelValues("saved").Inc()
+			stored = append(stored, result.file)
+			accepted = append(accepted, result.file)
+		}
+	})
+	// Attachments are stored as they arrive, so those before the limit
+	// have to go again
+	if _, err := limited.Read(make([]byte, 1)); errors.As(err, &tooLarge) {
+		requestLogf(ctx, "Email from %s exceeds %d bytes, removed %d stored attachment(s)", logName(sender), emailMaxSize, removeUpload(ctx, accepted))
+		return 552, "Message too large"
+	}
+	if err != nil {
+		requestLogf(ctx, "Error reading email from %s: %v", logName(sender), err)
+	}
+
+	requestLogf(ctx, "Email into session %s: %d saved, %d failed", session, saved, failed)
+	uploadSessions.WithLabelValues(sessionResult(saved, failed)).Inc()
+	if saved == 0 {
+		if failed == 0 {
+			return 554, "No attachments found"
+		}
+		return 554, "No attachments could be stored"
+	}
+	message := "Sent by email from " + sender
+	if subject := emailSubject(msg.Header); subject != "" {
+		message += ": " + subject
+	}
+	if err := saveMessage(r, session, readMessage(strings.NewReader(message))); err != nil {
+		requestLogf(ctx, "Error saving message for session %s: %v", session, err)
+	}
+	recordAudit(r, "upload.email", session, map[string]any{"from": sender, "saved": saved, "failed": failed})
+	writeSessionChecksums(ctx, stored)
+	emitEvent(&uploadEvent{Type: eventSessionCompleted, Session: session, RequestID: requestID(ctx), Saved: saved, Failed: failed})
+	runSessionHook(ctx, session, saved, failed, message)
+	if failed > 0 {
+		return 250, fmt.Sprintf("Stored %d attachment(s), %d failed", saved, failed)
+	}
+	return 250, fmt.Sprintf("Stored %d attachment(s)", saved)
+}
+
+func emailSenderAllowed(sender string) bool {
+	if len(emailSenders) == 0 {
+		return true
+	}
+	sender = strings.ToLower(sender)
+	_, domain, _ := strings.Cut(sender, "@")
+	return slices.Contains(emailSenders, sender) || slices.Contains(emailSenders, "@"+domain)
+}
+
+func emailSubject(header mail.Header) string {
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil {
+		return header.Get("Subject")
+	}
+	return subject
+}
+
+// walkEmailParts calls attachment for every part of a message that has a
+// file name, descending into nested multiparts. Text bodies are skipped.
+func walkEmailParts(header textproto.MIMEHeader, body io.Reader, attachment func(name, contentType string, body io.Reader)) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := walkEmailParts(part.Header, part, attachment); err != nil {
+				return err
+			}
+		}
+	}
+
+	name := ""
+	if _, dispositionParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		name = dispositionParams["filename"]
+	}
+	if name == "" {
+		name = params["name"]
+	}
+	if name == "" {
+		return nil
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		// The decoder skips the line breaks
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	attachment(name, mediaType, body)
+	return nil
+}
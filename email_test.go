@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestEmailReceiver(t *testing.T) {
+	originalStorage := storage
+	mock := &MockStorage{}
+	storage = mock
+	emailAddresses, emailSenders = []string{"photos@example.com"}, []string{"@family.example"}
+	defer func() {
+		storage = originalStorage
+		emailAddresses, emailSenders = nil, nil
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveEmail(listener)
+
+	message := strings.ReplaceAll(`From: Grandma <grandma@family.example>
+To: photos@example.com
+Subject: =?UTF-8?Q?Gr=C3=BC=C3=9Fe?=
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="b1"
+
+--b1
+Content-Type: text/plain
+
+Here are my photos
+--b1
+Content-Type: image/jpeg; name="IMG_0001.jpg"
+Content-Disposition: attachment; filename="IMG_0001.jpg"
+Content-Transfer-Encoding: base64
+
+aGVsbG8g
+d29ybGQ=
+--b1--
+`, "\n", "\r\n")
+	send := func(from, to string) error {
+		return smtp.SendMail(listener.Addr().String(), nil, from, []string{to}, []byte(message))
+	}
+	if err := send("grandma@family.example", "photos@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	var stored, messages int
+	for name, content := range mock.files {
+		switch {
+		case strings.HasSuffix(name, "/IMG_0001.jpg") && !isInternalPath(name):
+			stored++
+			if string(content) != "hello world" {
+				t.Errorf("expected the decoded attachment, got %q", content)
+			}
+		case strings.HasSuffix(name, "/message"):
+			messages++
+			if !strings.Contains(string(content), "grandma@family.example: Grüße") {
+				t.Errorf("expected the sender and subject in the message, got %s", content)
+			}
+		}
+	}
+	if stored != 1 || messages != 1 {
+		t.Errorf("expected the attachment and a message, got %v", mock.files)
+	}
+
+	if err := send("grandma@family.example", "other@example.com"); err == nil {
+		t.Error("expected an unknown recipient to be rejected")
+	}
+	message = strings.Replace(message, "grandma@family.example", "spam@example.net", 1)
+	if err := send("spam@example.net", "photos@example.com"); err == nil {
+		t.Error("expected a sender not on the list to be rejected")
+	}
+}
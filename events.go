@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// Event types published to the message bus.
+const (
+	eventFileSaved        = "file.saved"
+	eventSessionCompleted = "session.completed"
+)
+
+// uploadEvent describes a stored file or a finished upload session for
+// downstream consumers.
+type uploadEvent struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Session     string    `json:"session"`
+	Drop        string    `json:"drop,omitempty"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Saved       int       `json:"saved,omitempty"`
+	Failed      int       `json:"failed,omitempty"`
+	// Message is the note the guest left with a session
+	Message string `json:"message,omitempty"`
+}
+
+// eventPublisher delivers encoded events to a message bus.
+type eventPublisher interface {
+	Name() string
+	Publish(ctx context.Context, event *uploadEvent, data []byte) error
+}
+
+var publisher eventPublisher
+
+// eventQueue decouples publishing from the upload, so a slow bus doesn't
+// delay guests. Events are dropped when it's full.
+var eventQueue chan *uploadEvent
+
+const eventQueueSize = 1000
+
+func setupEvents() error {
+	bus := os.Getenv("EVENT_BUS")
+	brokers := os.Getenv("EVENT_BROKERS")
+	topic := os.Getenv("EVENT_TOPIC")
+	if topic == "" {
+		topic = "uploads"
+	}
+
+	switch bus {
+	case "":
+		return nil
+	case "nats":
+		if brokers == "" {
+			brokers = nats.DefaultURL
+		}
+		conn, err := nats.Connect(brokers, nats.Name("go-uploader"))
+		if err != nil {
+			return fmt.Errorf("connecting to NATS: %w", err)
+		}
+		publisher = &natsPublisher{Conn: conn, Subject: topic}
+	case "kafka":
+		if brokers == "" {
+			return fmt.Errorf("EVENT_BROKERS is required for the kafka event bus")
+		}
+		publisher = &kafkaPublisher{Writer: &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		}}
+	case "sqs":
+		if !strings.HasPrefix(topic, "https://") {
+			return fmt.Errorf("EVENT_TOPIC must be the queue URL for the sqs event bus")
+		}
+		cfg, err := config.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			return fmt.Errorf("loading AWS config: %w", err)
+		}
+		publisher = &sqsPublisher{Client: sqs.NewFromConfig(cfg), QueueURL: topic}
+	default:
+		return fmt.Errorf("unknown EVENT_BUS %q", bus)
+	}
+
+	eventQueue = make(chan *uploadEvent, eventQueueSize)
+	if checkingConfig {
+		return nil
+	}
+	go publishEvents()
+	log.Printf("Publishing upload events to %s (%s)", bus, topic)
+	return nil
+}
+
+// emitEvent queues an event for publishing, if an event bus is configured.
+func emitEvent(event *uploadEvent) {
+	if eventQueue == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	select {
+	case eventQueue <- event:
+	default:
+		log.Printf("Event queue full, dropping %s event for %s", event.Type, logName(event.Session))
+	}
+}
+
+func publishEvents() {
+	for event := range eventQueue {
+		data, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Error encoding %s event: %v", event.Type, err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = publisher.Publish(ctx, event, data)
+		cancel()
+		if err != nil {
+			log.Printf("Error publishing %s event for %s to %s: %v", event.Type, logName(event.Session), publisher.Name(), err)
+		}
+	}
+}
+
+// natsPublisher publishes to "<subject>.<event type>", e.g. uploads.file.saved.
+type natsPublisher struct {
+	Conn    *nats.Conn
+	Subject string
+}
+
+func (n *natsPublisher) Name() string { return "nats" }
+
+func (n *natsPublisher) Publish(_ context.Context, event *uploadEvent, data []byte) error {
+	return n.Conn.Publish(n.Subject+"."+event.Type, data)
+}
+
+// kafkaPublisher keys messages by session, so events of a session stay in
+// order on a single partition.
+type kafkaPublisher struct {
+	Writer *kafka.Writer
+}
+
+func (k *kafkaPublisher) Name() string { return "kafka" }
+
+func (k *kafkaPublisher) Publish(ctx context.Context, event *uploadEvent, data []byte) error {
+	return k.Writer.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(event.Session),
+		Value:   data,
+		Headers: []kafka.Header{{Key: "type", Value: []byte(event.Type)}},
+	})
+}
+
+// sqsPublisher sends events as messages with a type attribute. FIFO queues
+// are grouped by session.
+type sqsPublisher struct {
+	Client   *sqs.Client
+	QueueURL string
+}
+
+func (s *sqsPublisher) Name() string { return "sqs" }
+
+func (s *sqsPublisher) Publish(ctx context.Context, event *uploadEvent, data []byte) error {
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(s.QueueURL),
+		MessageBody: aws.String(string(data)),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String(event.Type)},
+		},
+	}
+	if strings.HasSuffix(s.QueueURL, ".fifo") {
+		input.MessageGroupId = aws.String(event.Session)
+		sum := sha256.Sum256([]byte(event.Type + ":" + event.Session + ":" + event.Path))
+		input.MessageDeduplicationId = aws.String(hex.EncodeToString(sum[:]))
+	}
+	_, err := s.Client.SendMessage(ctx, input)
+	return err
+}
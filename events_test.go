@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestUploadHandler_EmitsEvents(t *testing.T) {
+	fakeTurnstile(t)
+	originalStorage := storage
+	storage = &MockStorage{}
+	eventQueue = make(chan *uploadEvent, 10)
+	defer func() {
+		storage = originalStorage
+		eventQueue = nil
+	}()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "photo.jpg")
+	part.Write([]byte("image data"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	uploadHandler(httptest.NewRecorder(), req)
+
+	if len(eventQueue) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(eventQueue))
+	}
+	file := <-eventQueue
+	if file.Type != eventFileSaved || file.Size != 10 || file.Status != "stored" || file.Session == "" {
+		t.Errorf("unexpected file event: %+v", file)
+	}
+	session := <-eventQueue
+	if session.Type != eventSessionCompleted || session.Saved != 1 || session.Session != file.Session {
+		t.Errorf("unexpected session event: %+v", session)
+	}
+}
+
+type recordingPublisher struct {
+	published [][]byte
+}
+
+func (p *recordingPublisher) Name() string { return "recording" }
+
+func (p *recordingPublisher) Publish(_ context.Context, _ *uploadEvent, data []byte) error {
+	p.published = append(p.published, data)
+	return nil
+}
+
+func TestPublishEvents(t *testing.T) {
+	recorder := &recordingPublisher{}
+	publisher = recorder
+	eventQueue = make(chan *uploadEvent, 10)
+	defer func() {
+		publisher = nil
+		eventQueue = nil
+	}()
+
+	emitEvent(&uploadEvent{Type: eventSessionCompleted, Session: "2024-06-01_14-03-22.123", Saved: 3})
+	close(eventQueue)
+	publishEvents()
+
+	if len(recorder.published) != 1 {
+		t.Fatalf("expected 1 published event, got %d", len(recorder.published))
+	}
+	var event map[string]any
+	json.Unmarshal(recorder.published[0], &event)
+	if event["type"] != "session.completed" || event["saved"] != float64(3) || event["time"] == nil {
+		t.Errorf("unexpected event payload: %s", recorder.published[0])
+	}
+}
+
+func TestSetupEvents_Validation(t *testing.T) {
+	defer os.Unsetenv("EVENT_BUS")
+	for _, bus := range []string{"kafka", "sqs", "carrier-pigeon"} {
+		os.Setenv("EVENT_BUS", bus)
+		if err := setupEvents(); err == nil {
+			t.Errorf("expected incomplete %s configuration to be rejected", bus)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// exifData holds the EXIF fields we care about.
+type exifData struct {
+	Orientation int
+	Make        string
+	Model       string
+	// DateTime is the capture time as recorded by the camera, in its local
+	// time without zone, e.g. "2024:06:01 14:30:00"
+	DateTime string
+}
+
+// exifTimeFormat is the layout of the EXIF date fields.
+const exifTimeFormat = "2006:01:02 15:04:05"
+
+// captureTime parses DateTime. Cameras without a clock write zeros or
+// nothing at all, which is reported as false.
+func (e *exifData) captureTime() (time.Time, bool) {
+	taken, err := time.Parse(exifTimeFormat, e.DateTime)
+	return taken, err == nil
+}
+
+// EXIF tags read from the first IFD and the Exif sub-IFD.
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+// parseJPEGExif extracts EXIF data from the APP1 segment at the start of a
+// JPEG file. It returns nil if the data isn't a JPEG or carries no EXIF.
+func parseJPEGExif(data []byte) *exifData {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return nil
+		}
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || length < 2 || pos+2+length > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseTIFF(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return nil
+}
+
+func parseTIFF(tiff []byte) *exifData {
+	if len(tiff) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	exif := &exifData{Orientation: 1}
+	var exifIFD int
+	readIFD(tiff, order, int(order.Uint32(tiff[4:])), func(tag uint16, entry []byte) {
+		switch tag {
+		case tagOrientation:
+			exif.Orientation = int(order.Uint16(entry[8:]))
+		case tagMake:
+			exif.Make = exifString(tiff, order, entry)
+		case tagModel:
+			exif.Model = exifString(tiff, order, entry)
+		case tagDateTime:
+			exif.DateTime = exifString(tiff, order, entry)
+		case tagExifIFD:
+			exifIFD = int(order.Uint32(entry[8:]))
+		}
+	})
+	if exifIFD > 0 {
+		// The original capture time is preferred over the modification time
+		readIFD(tiff, order, exifIFD, func(tag uint16, entry []byte) {
+			if tag == tagDateTimeOriginal {
+				if value := exifString(tiff, order, entry); value != "" {
+					exif.DateTime = value
+				}
+			}
+		})
+	}
+	return exif
+}
+
+// readIFD calls fn with the tag and the 12 byte entry of every field in the
+// IFD at offset.
+func readIFD(tiff []byte, order binary.ByteOrder, offset int, fn func(tag uint16, entry []byte)) {
+	if offset < 0 || offset+2 > len(tiff) {
+		return
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return
+		}
+		fn(order.Uint16(tiff[entry:]), tiff[entry:entry+12])
+	}
+}
+
+// exifString returns the value of an ASCII field, which is stored in the
+// entry itself if it fits into four bytes.
+func exifString(tiff []byte, order binary.ByteOrder, entry []byte) string {
+	const typeASCII = 2
+	if order.Uint16(entry[2:]) != typeASCII {
+		return ""
+	}
+	count := int(order.Uint32(entry[4:]))
+	value := entry[8:12]
+	if count > 4 {
+		offset := int(order.Uint32(entry[8:]))
+		if offset < 0 || count > len(tiff) || offset > len(tiff)-count {
+			return ""
+		}
+		value = tiff[offset : offset+count]
+	} else {
+		value = value[:count]
+	}
+	return strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const defaultBlockedExtensions = ".exe,.scr,.js,.jse,.bat,.cmd,.com,.pif,.msi,.vbs,.vbe,.ps1,.hta,.jar,.lnk,.reg,.dll"
+
+var blockedExtensions []string
+
+func setupExtensionBlocklist() {
+	blockedExtensions = nil
+	value := os.Getenv("BLOCKED_EXTENSIONS")
+	switch value {
+	case "":
+		value = defaultBlockedExtensions
+	case "none":
+		log.Println("Extension blocklist disabled")
+		return
+	}
+
+	for _, ext := range splitList(value) {
+		blockedExtensions = append(blockedExtensions, "."+strings.TrimPrefix(ext, "."))
+	}
+	log.Printf("Rejecting files with extensions: %s", strings.Join(blockedExtensions, ", "))
+}
+
+// checkExtension returns a reason if the filename uses a forbidden extension,
+// including tricks like "invoice.pdf.exe", trailing dots ("setup.exe.") and
+// right-to-left override characters ("photo‮gpj.exe").
+func checkExtension(name string) string {
+	if strings.ContainsAny(name, "‪‫‭‮⁦⁧⁨") {
+		return "filename contains text direction override characters"
+	}
+
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if len(blockedExtensions) == 0 {
+		return ""
+	}
+
+	// Windows ignores trailing dots and spaces, so "setup.exe. " is an .exe
+	parts := strings.Split(strings.ToLower(strings.TrimRight(name, ". ")), ".")
+	if len(parts) < 2 {
+		return ""
+	}
+	ext := "." + parts[len(parts)-1]
+	if !isBlockedExtension(ext) {
+		return ""
+	}
+	if len(parts) > 2 {
+		return fmt.Sprintf("double extension .%s%s not allowed", parts[len(parts)-2], ext)
+	}
+	return fmt.Sprintf("file type %s not allowed", ext)
+}
+
+func isBlockedExtension(ext string) bool {
+	for _, blocked := range blockedExtensions {
+		if ext == blocked {
+			return true
+		}
+	}
+	return false
+}
+
+// fixExtensions corrects missing or wrong extensions of uploads whose type
+// can be detected from their content.
+var fixExtensions bool
+
+// detectedExtensions are the types extensions are corrected to, with the
+// extension used for each.
+var detectedExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/bmp":       ".bmp",
+	"image/heic":      ".heic",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"video/quicktime": ".mov",
+	"application/pdf": ".pdf",
+}
+
+func setupExtensionFixing() {
+	fixExtensions = os.Getenv("FIX_EXTENSIONS") == "true"
+	if fixExtensions {
+		log.Println("Correcting file extensions from the detected content type")
+	}
+}
+
+// detectType sniffs the content type from the first bytes of a file. Unlike
+// http.DetectContentType it also knows the HEIC and QuickTime containers
+// phones use.
+func detectType(head []byte) string {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		switch string(head[8:12]) {
+		case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+			return "image/heic"
+		case "qt  ":
+			return "video/quicktime"
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return mediaType
+}
+
+// correctExtension returns name with the extension of the detected type if
+// it has none, one of an unknown type or one of a different type of the same
+// kind, e.g. a PNG named photo.jpg. Other mismatches like a song.m4a sniffed
+// as MP4 video are left alone, as is content that can't be identified. The
+// detected type is returned along with the name.
+func correctExtension(name string, head []byte) (string, string, bool) {
+	detected := detectType(head)
+	ext, ok := detectedExtensions[detected]
+	if !ok {
+		return name, "", false
+	}
+
+	current := strings.ToLower(path.Ext(name))
+	if current == ext {
+		return name, detected, false
+	}
+	declared, _, _ := mime.ParseMediaType(mime.TypeByExtension(current))
+	if declared == detected {
+		// Another extension of the same type, e.g. .jpeg
+		return name, detected, false
+	}
+	if declared != "" && declared != "application/octet-stream" {
+		declaredKind, _, _ := strings.Cut(declared, "/")
+		detectedKind, _, _ := strings.Cut(detected, "/")
+		if declaredKind != detectedKind {
+			return name, detected, false
+		}
+	}
+	return strings.TrimSuffix(name, path.Ext(name)) + ext, detected, true
+}
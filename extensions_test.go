@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckExtension(t *testing.T) {
+	setupExtensionBlocklist()
+	defer func() { blockedExtensions = nil }()
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"photo.jpg", ""},
+		{"README", ""},
+		{"setup.exe", "file type .exe not allowed"},
+		{"invoice.pdf.exe", "double extension .pdf.exe not allowed"},
+		{"INVOICE.PDF.SCR", "double extension .pdf.scr not allowed"},
+		{"setup.exe. ", "file type .exe not allowed"},
+		{"photo.exe.jpg", ""},
+		{"photo‮gpj.exe", "filename contains text direction override characters"},
+	}
+
+	for _, test := range tests {
+		if result := checkExtension(test.name); result != test.expected {
+			t.Errorf("checkExtension(%q) = %q, want %q", test.name, result, test.expected)
+		}
+	}
+}
+
+func TestUploadHandler_BlockedExtension(t *testing.T) {
+	fakeTurnstile(t)
+	setupExtensionBlocklist()
+	defer func() { blockedExtensions = nil }()
+
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	upload := func(names ...string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for _, name := range names {
+			part, _ := writer.CreateFormFile("file", name)
+			part.Write([]byte("content"))
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w
+	}
+
+	w := upload("photo.jpg", "invoice.pdf.exe")
+	if w.Code != http.StatusPartialContent || !strings.Contains(w.Body.String(), "invoice.pdf.exe: double extension .pdf.exe not allowed") {
+		t.Errorf("Expected partial success with per-file error, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = upload("virus.bat")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 when all files are rejected, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestCorrectExtension(t *testing.T) {
+	jpeg := []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF\x00")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+	mp4 := []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+
+	tests := []struct {
+		name     string
+		head     []byte
+		expected string
+	}{
+		{"1000012345", jpeg, "1000012345.jpg"},
+		{"photo.jpg", png, "photo.png"},
+		{"photo.JPEG", jpeg, "photo.JPEG"},
+		{"photo.jpg", jpeg, "photo.jpg"},
+		{"IMG_0001.jpg", heic, "IMG_0001.heic"},
+		{"share.bin", heic, "share.heic"},
+		{"song.m4a", mp4, "song.m4a"},
+		{"notes.txt", []byte("hello world"), "notes.txt"},
+		{"notes", []byte("hello world"), "notes"},
+	}
+
+	for _, test := range tests {
+		corrected, _, _ := correctExtension(test.name, test.head)
+		if corrected != test.expected {
+			t.Errorf("correctExtension(%q) = %q, expected %q", test.name, corrected, test.expected)
+		}
+	}
+}
+
+func TestUploadHandler_FixExtensions(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	fixExtensions = true
+	defer func() {
+		storage = originalStorage
+		fixExtensions = false
+	}()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "1000012345")
+	part.Write([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	writer.Close()
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	uploadHandler(httptest.NewRecorder(), req)
+
+	var stored, meta string
+	for name, content := range mockStorage.files {
+		if strings.HasPrefix(name, metaPrefix) {
+			meta = string(content)
+		} else {
+			stored = name
+		}
+	}
+	if !strings.HasSuffix(stored, "/1000012345.png") || !strings.Contains(meta, `"original_name":"1000012345"`) {
+		t.Errorf("unexpected stored file %q with metadata %s", stored, meta)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxFilenameLength limits stored filenames, in bytes. Filesystems commonly
+// allow 255 bytes per name; the default leaves room for the suffix
+// uniqueFileName may add.
+var maxFilenameLength = 200
+
+// maxExtensionLength is the longest extension kept when truncating, anything
+// longer is more likely part of the name.
+const maxExtensionLength = 16
+
+func setupFilenameLength() error {
+	value := os.Getenv("MAX_FILENAME_LENGTH")
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 32 || n > 1000 {
+		return fmt.Errorf("MAX_FILENAME_LENGTH must be between 32 and 1000, got %q", value)
+	}
+	maxFilenameLength = n
+	log.Printf("Truncating filenames longer than %d bytes", maxFilenameLength)
+	return nil
+}
+
+// truncateFilename shortens the base of name to maxFilenameLength bytes,
+// keeping the extension. A hash of the full name is appended, so long names
+// that only differ at the end stay distinct.
+func truncateFilename(name string) string {
+	if len(name) <= maxFilenameLength {
+		return name
+	}
+	ext := path.Ext(name)
+	if len(ext) > maxExtensionLength {
+		ext = ""
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(sum[:4])
+
+	base := strings.TrimSuffix(name, ext)
+	keep := maxFilenameLength - len(ext) - len(suffix)
+	// Don't cut a multi-byte character in half
+	for keep > 0 && !utf8.RuneStart(base[keep]) {
+		keep--
+	}
+	return base[:keep] + suffix + ext
+}
+
+// relativePathField names the form field a client sends before a file part
+// to store that file in subfolders, e.g. "shoot/day1/IMG_0001.jpg" for
+// files of a folder selected with webkitdirectory.
+const relativePathField = "relative_path"
+
+// maxFolderDepth limits the subfolders recreated from a relative path.
+// Deeper folders are merged into the last one allowed, joined by "_".
+const maxFolderDepth = 5
+
+// readRelativePath reads a relative path form field.
+func readRelativePath(part io.Reader) string {
+	value, _ := io.ReadAll(io.LimitReader(part, 4096))
+	return string(value)
+}
+
+// relativeDir returns the sanitized folders of a relative path, without the
+// file name. Empty, "." and ".." segments are dropped, so the result always
+// stays within the session.
+func relativeDir(relativePath string) string {
+	segments := strings.FieldsFunc(relativePath, func(r rune) bool { return r == '/' || r == '\\' })
+	if len(segments) == 0 {
+		return ""
+	}
+	var dirs []string
+	for _, segment := range segments[:len(segments)-1] {
+		if segment == "." || segment == ".." {
+			continue
+		}
+		dirs = append(dirs, sanitizeFilename(segment))
+	}
+	if len(dirs) > maxFolderDepth {
+		dirs = append(dirs[:maxFolderDepth-1], strings.Join(dirs[maxFolderDepth-1:], "_"))
+	}
+	for i, dir := range dirs {
+		dirs[i] = truncateFilename(dir)
+	}
+	return strings.Join(dirs, "/")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const galleryPurpose = "gallery"
+
+// galleryCookie grants read access to the files of a shared session. It is
+// scoped to the session's folder below /gallery/, so a page can embed any
+// number of its images without signing each link.
+const galleryCookie = "gallery_access"
+
+// galleryThumbnailSize is the longest side of thumbnails on gallery pages.
+const galleryThumbnailSize = 320
+
+type galleryClaims struct {
+	Path    string `json:"p"`
+	Expires int64  `json:"e"`
+}
+
+func setupGallery() {
+	mux.HandleFunc("GET /gallery/{path...}", galleryHandler)
+}
+
+// galleryURL is the base of the gallery links of a session folder.
+func galleryURL(session string) string {
+	return publicURL("/gallery/" + session)
+}
+
+// grantGalleryAccess sets the cookie for the files below session and their
+// thumbnails, valid until expires.
+func grantGalleryAccess(w http.ResponseWriter, r *http.Request, session string, expires time.Time) error {
+	token, err := signToken(galleryPurpose, galleryClaims{Path: session, Expires: expires.Unix()})
+	if err != nil {
+		return err
+	}
+	for _, base := range []string{"/gallery/", "/thumb/"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     galleryCookie,
+			Value:    token,
+			Path:     publicBasePath + (&url.URL{Path: base + session + "/"}).EscapedPath(),
+			Expires:  expires,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return nil
+}
+
+// galleryAccess reports whether one of the gallery cookies sent with r
+// covers name. Browsers send one per enclosing session folder.
+func galleryAccess(r *http.Request, name string) bool {
+	now := time.Now().Unix()
+	for _, cookie := range r.CookiesNamed(galleryCookie) {
+		var claims galleryClaims
+		if parseToken(galleryPurpose, cookie.Value, &claims) != nil {
+			continue
+		}
+		if now <= claims.Expires && strings.HasPrefix(name, claims.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// galleryHandler serves /gallery/<session>/<name> to holders of the
+// session's gallery cookie, as a thumbnail with ?thumbnail.
+func galleryHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := cleanStoragePath(r.PathValue("path"))
+	if !ok || !galleryAccess(r, name) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Query().Has("thumbnail") && isDisplayableImage(path.Base(name)) {
+		serveThumbnail(w, r, name, galleryThumbnailSize)
+		return
+	}
+	serveStoredFile(w, r, name)
+}
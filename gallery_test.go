@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGalleryCookie(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	originalStorage := storage
+	storage = &MockStorage{files: map[string][]byte{
+		"session1/photo.jpg":  []byte("not really a jpeg"),
+		"session1/notes.txt":  []byte("notes"),
+		"session2/other.jpg":  []byte("other"),
+		"session10/photo.jpg": []byte("prefix"),
+	}}
+	defer func() { storage = originalStorage }()
+
+	_, share := createShare(t, `{"path":"session1"}`)
+	listing := getShare(share.URL)
+	if !strings.Contains(listing.Body.String(), `src="/gallery/session1/photo.jpg?thumbnail"`) {
+		t.Fatalf("expected thumbnails in the listing, got %s", listing.Body.String())
+	}
+	cookies := listing.Result().Cookies()
+	if len(cookies) != 2 || cookies[0].Name != galleryCookie || cookies[0].Path != "/gallery/session1/" || cookies[1].Path != "/thumb/session1/" {
+		t.Fatalf("expected gallery cookies for the session and its thumbnails, got %v", cookies)
+	}
+
+	get := func(name string, withCookie bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/gallery/"+name, nil)
+		req.SetPathValue("path", strings.SplitN(name, "?", 2)[0])
+		if withCookie {
+			req.AddCookie(cookies[0])
+		}
+		w := httptest.NewRecorder()
+		galleryHandler(w, req)
+		return w
+	}
+	for _, tc := range []struct {
+		name   string
+		cookie bool
+		want   int
+	}{
+		{"session1/photo.jpg?thumbnail", true, http.StatusOK},
+		{"session1/notes.txt", true, http.StatusOK},
+		{"session1/photo.jpg", false, http.StatusNotFound},
+		{"session2/other.jpg", true, http.StatusNotFound},
+		{"session10/photo.jpg", true, http.StatusNotFound},
+	} {
+		if w := get(tc.name, tc.cookie); w.Code != tc.want {
+			t.Errorf("%s (cookie %v): expected %d, got %d", tc.name, tc.cookie, tc.want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoCountries and geoASNs are MaxMind databases, e.g. GeoLite2-Country and
+// GeoLite2-ASN. Either may be nil.
+var geoCountries, geoASNs *maxminddb.Reader
+
+// Country codes and AS numbers uploads are allowed from or blocked from.
+var (
+	allowedCountries, blockedCountries []string
+	allowedASNs, blockedASNs           []uint
+)
+
+// geoLocation is what the databases know about an address. Empty fields
+// mean the address isn't in the database, e.g. private ones.
+type geoLocation struct {
+	Country string
+	ASN     uint
+}
+
+// geoLookup looks up an address. Tests replace it to avoid shipping
+// databases.
+var geoLookup = lookupGeoLocation
+
+func setupGeoIP() error {
+	allowedCountries, blockedCountries, allowedASNs, blockedASNs = nil, nil, nil, nil
+	for _, setting := range []struct {
+		name  string
+		value *[]string
+	}{
+		{"ALLOWED_COUNTRIES", &allowedCountries},
+		{"BLOCKED_COUNTRIES", &blockedCountries},
+	} {
+		for _, code := range splitList(os.Getenv(setting.name)) {
+			if len(code) != 2 {
+				return fmt.Errorf("%s must list two-letter country codes, got %q", setting.name, code)
+			}
+			*setting.value = append(*setting.value, strings.ToUpper(code))
+		}
+	}
+	for _, setting := range []struct {
+		name  string
+		value *[]uint
+	}{
+		{"ALLOWED_ASNS", &allowedASNs},
+		{"BLOCKED_ASNS", &blockedASNs},
+	} {
+		for _, entry := range splitList(os.Getenv(setting.name)) {
+			asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(entry), "AS"), 10, 32)
+			if err != nil {
+				return fmt.Errorf("%s must list AS numbers, got %q", setting.name, entry)
+			}
+			*setting.value = append(*setting.value, uint(asn))
+		}
+	}
+
+	countryRules := len(allowedCountries) > 0 || len(blockedCountries) > 0
+	asnRules := len(allowedASNs) > 0 || len(blockedASNs) > 0
+	for _, db := range []struct {
+		name   string
+		reader **maxminddb.Reader
+		needed bool
+	}{
+		{"GEOIP_COUNTRY_DB", &geoCountries, countryRules},
+		{"GEOIP_ASN_DB", &geoASNs, asnRules},
+	} {
+		path := os.Getenv(db.name)
+		if path == "" {
+			if db.needed {
+				return fmt.Errorf("%s is required for the configured restrictions", db.name)
+			}
+			continue
+		}
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", db.name, err)
+		}
+		*db.reader = reader
+		log.Printf("Loaded GeoIP database %s (%s, built %d)", path, reader.Metadata.DatabaseType, reader.Metadata.BuildEpoch)
+	}
+	if countryRules || asnRules {
+		log.Printf("Restricting uploads by location: countries allowed %v, blocked %v; ASNs allowed %v, blocked %v",
+			allowedCountries, blockedCountries, allowedASNs, blockedASNs)
+	}
+	return nil
+}
+
+func lookupGeoLocation(ip net.IP) (geoLocation, error) {
+	var location geoLocation
+	if geoCountries != nil {
+		var record struct {
+			Country struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+		}
+		if err := geoCountries.Lookup(ip, &record); err != nil {
+			return location, err
+		}
+		location.Country = record.Country.ISOCode
+	}
+	if geoASNs != nil {
+		var record struct {
+			ASN uint `maxminddb:"autonomous_system_number"`
+		}
+		if err := geoASNs.Lookup(ip, &record); err != nil {
+			return location, err
+		}
+		location.ASN = record.ASN
+	}
+	return location, nil
+}
+
+// checkGeoIP reports whether uploads are allowed from the location of the
+// client. Addresses missing from the databases are allowed, so private
+// networks and fresh allocations aren't locked out.
+func checkGeoIP(r *http.Request) (geoLocation, bool) {
+	if len(allowedCountries)+len(blockedCountries)+len(allowedASNs)+len(blockedASNs) == 0 {
+		return geoLocation{}, true
+	}
+	host := clientIP(r)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return geoLocation{}, true
+	}
+	location, err := geoLookup(ip)
+	if err != nil {
+		requestLogf(r.Context(), "Error looking up location of %s: %v", logIP(host), err)
+		return location, true
+	}
+
+	allowed := true
+	if location.Country != "" {
+		allowed = allowed && (len(allowedCountries) == 0 || slices.Contains(allowedCountries, location.Country)) &&
+			!slices.Contains(blockedCountries, location.Country)
+	}
+	if location.ASN != 0 {
+		allowed = allowed && (len(allowedASNs) == 0 || slices.Contains(allowedASNs, location.ASN)) &&
+			!slices.Contains(blockedASNs, location.ASN)
+	}
+
+	country := location.Country
+	if country == "" {
+		country = "unknown"
+	}
+	result := "allowed"
+	if !allowed {
+		result = "blocked"
+	}
+	geoChecks.WithLabelValues(country, result).Inc()
+	return location, allowed
+}
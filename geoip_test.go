@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckGeoIP(t *testing.T) {
+	locations := map[string]geoLocation{
+		"198.51.100.1": {Country: "DE", ASN: 3320},
+		"198.51.100.2": {Country: "DE", ASN: 16509},
+		"198.51.100.3": {Country: "RU", ASN: 12389},
+	}
+	originalLookup := geoLookup
+	geoLookup = func(ip net.IP) (geoLocation, error) { return locations[ip.String()], nil }
+	t.Setenv("ALLOWED_COUNTRIES", "de,at")
+	t.Setenv("BLOCKED_ASNS", "AS16509")
+	t.Setenv("GEOIP_COUNTRY_DB", "")
+	defer func() {
+		geoLookup = originalLookup
+		allowedCountries, blockedASNs = nil, nil
+	}()
+	// The databases are only opened when configured
+	if err := setupGeoIP(); err == nil {
+		t.Fatal("expected error without databases")
+	}
+	allowedCountries, blockedASNs = []string{"DE", "AT"}, []uint{16509}
+
+	for addr, want := range map[string]bool{
+		"198.51.100.1": true,
+		"198.51.100.2": false,
+		"198.51.100.3": false,
+		"10.0.0.1":     true,
+	} {
+		r := httptest.NewRequest("POST", "/upload", nil)
+		r.RemoteAddr = addr + ":1234"
+		if _, ok := checkGeoIP(r); ok != want {
+			t.Errorf("%s: allowed = %v, want %v", addr, ok, want)
+		}
+	}
+
+	t.Setenv("ALLOWED_COUNTRIES", "Germany")
+	if err := setupGeoIP(); err == nil {
+		t.Error("expected error for a country name")
+	}
+}
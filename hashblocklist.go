@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// blockedHashes are the SHA-256 digests of files that must never be stored
+// again, e.g. abusive content removed earlier. Nil disables the check.
+var blockedHashes map[string]bool
+
+// hashBlocklistAction is "reject" or "quarantine", for files on the list.
+var hashBlocklistAction = "reject"
+
+// hashBlocklistClient fetches lists given as URL.
+var hashBlocklistClient = &http.Client{Timeout: 30 * time.Second}
+
+// setupHashBlocklist reads HASH_BLOCKLIST, a file or http(s) URL, again on
+// every reload, so additions apply without a restart.
+func setupHashBlocklist() error {
+	blockedHashes = nil
+	hashBlocklistAction = "reject"
+	source := os.Getenv("HASH_BLOCKLIST")
+	if source == "" {
+		return nil
+	}
+	switch action := os.Getenv("HASH_BLOCKLIST_ACTION"); action {
+	case "", "reject":
+	case "quarantine":
+		hashBlocklistAction = action
+	default:
+		return fmt.Errorf("HASH_BLOCKLIST_ACTION must be reject or quarantine, got %q", action)
+	}
+
+	list, err := openHashBlocklist(source)
+	if err != nil {
+		return fmt.Errorf("reading HASH_BLOCKLIST: %w", err)
+	}
+	defer list.Close()
+	hashes, err := parseHashBlocklist(list)
+	if err != nil {
+		return fmt.Errorf("reading HASH_BLOCKLIST: %w", err)
+	}
+	blockedHashes = hashes
+	log.Printf("Blocking %d known file hash(es) from %s, action: %s", len(hashes), source, hashBlocklistAction)
+	return nil
+}
+
+func openHashBlocklist(source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+	resp, err := hashBlocklistClient.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// parseHashBlocklist reads one hex SHA-256 digest per line. Anything after
+// the digest is ignored, so sha256sum output works as is, as do empty lines
+// and comments starting with #.
+func parseHashBlocklist(r io.Reader) (map[string]bool, error) {
+	hashes := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		hash := strings.ToLower(fields[0])
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("line %d: %q is not a SHA-256 digest", line, fields[0])
+		}
+		hashes[hash] = true
+	}
+	return hashes, scanner.Err()
+}
+
+// blockedFile is an upload that matched the hash blocklist.
+type blockedFile struct {
+	Name   string
+	SHA256 string
+	Action string
+}
+
+// checkHashBlocklist returns the first of the digests that is on the
+// blocklist along with the action to take, or "".
+func checkHashBlocklist(hashes ...string) (string, string) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	for _, hash := range hashes {
+		if blockedHashes[hash] {
+			return hash, hashBlocklistAction
+		}
+	}
+	return "", ""
+}
+
+// auditBlockedHash records an upload that matched the hash blocklist.
+func auditBlockedHash(r *http.Request, result fileResult) {
+	if result.blocked == nil {
+		return
+	}
+	recordAudit(r, "upload.blocked_hash", result.blocked.Name, map[string]any{
+		"sha256": result.blocked.SHA256,
+		"action": result.blocked.Action,
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseHashBlocklist(t *testing.T) {
+	hashes, err := parseHashBlocklist(strings.NewReader("# removed 2024-06-02\n\nE3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855  empty.jpg\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 1 || !hashes["e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"] {
+		t.Errorf("unexpected hashes %v", hashes)
+	}
+	if _, err := parseHashBlocklist(strings.NewReader("abc123\n")); err == nil {
+		t.Error("expected an error for a short digest")
+	}
+}
+
+func TestUploadHandler_HashBlocklist(t *testing.T) {
+	fakeTurnstile(t)
+	blocked := []byte("abusive content")
+	sum := sha256.Sum256(blocked)
+	list := filepath.Join(t.TempDir(), "blocklist.txt")
+	os.WriteFile(list, []byte(hex.EncodeToString(sum[:])+"\n"), 0o644)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, list)
+	}))
+	defer server.Close()
+
+	originalStorage := storage
+	defer func() {
+		storage = originalStorage
+		blockedHashes, hashBlocklistAction = nil, "reject"
+	}()
+
+	upload := func() int {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "photo.jpg")
+		part.Write(blocked)
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w.Code
+	}
+	stored := func(mock *MockStorage, prefix string) (files, audits int) {
+		for name := range mock.files {
+			switch {
+			case strings.HasPrefix(name, auditPrefix):
+				audits++
+			case strings.HasPrefix(name, prefix) && !strings.HasSuffix(name, reasonSuffix):
+				files++
+			}
+		}
+		return files, audits
+	}
+
+	for _, test := range []struct {
+		source, action string
+		code           int
+		prefix         string
+		files          int
+	}{
+		{list, "", http.StatusUnprocessableEntity, "", 0},
+		{server.URL, "quarantine", http.StatusCreated, quarantinePrefix, 1},
+	} {
+		t.Setenv("HASH_BLOCKLIST", test.source)
+		t.Setenv("HASH_BLOCKLIST_ACTION", test.action)
+		if err := setupHashBlocklist(); err != nil {
+			t.Fatal(err)
+		}
+		mock := &MockStorage{}
+		storage = mock
+		if code := upload(); code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.source, test.code, code)
+		}
+		files, audits := stored(mock, test.prefix)
+		if files != test.files || audits != 1 {
+			t.Errorf("%s: expected %d file(s) and an audit event, got %v", test.source, test.files, mock.files)
+		}
+	}
+
+	t.Setenv("HASH_BLOCKLIST_ACTION", "delete")
+	if err := setupHashBlocklist(); err == nil {
+		t.Error("expected an unknown action to be rejected")
+	}
+}
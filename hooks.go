@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+// Commands run for every stored file and every finished session. They are
+// split on whitespace and run without a shell.
+var fileHookCommand []string
+var sessionHookCommand []string
+var hookTimeout = 30 * time.Second
+
+// hookOutputLimit caps how much of a hook's output is logged.
+const hookOutputLimit = 4096
+
+func setupHooks() error {
+	fileHookCommand = strings.Fields(os.Getenv("HOOK_FILE_COMMAND"))
+	sessionHookCommand = strings.Fields(os.Getenv("HOOK_SESSION_COMMAND"))
+	if value := os.Getenv("HOOK_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid HOOK_TIMEOUT %q", value)
+		}
+		hookTimeout = timeout
+	}
+
+	for _, command := range [][]string{fileHookCommand, sessionHookCommand} {
+		if len(command) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(command[0]); err != nil {
+			return fmt.Errorf("hook command %s: %w", command[0], err)
+		}
+		log.Printf("Running hook command %s", command[0])
+	}
+	return nil
+}
+
+// runHook runs a hook command with the event as JSON on stdin and the main
+// fields as environment variables, so simple scripts don't need to parse
+// JSON. For the local backend, UPLOAD_LOCAL_FILE holds the file's full path.
+func runHook(ctx context.Context, command []string, event *uploadEvent, stored string) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	arg := event.Session
+	if stored != "" {
+		arg = stored
+	}
+	cmd := exec.CommandContext(ctx, command[0], append(command[1:], arg)...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"UPLOAD_EVENT="+event.Type,
+		"UPLOAD_SESSION="+event.Session,
+		"UPLOAD_DROP="+event.Drop,
+		"UPLOAD_PATH="+event.Path,
+		"UPLOAD_STORED_PATH="+stored,
+		"UPLOAD_BASE_URL="+publicBaseURL,
+		"UPLOAD_MESSAGE="+event.Message,
+	)
+	if local, ok := unwrapBackend(storage).(*store.LocalStorage); ok && stored != "" {
+		if path, err := filepath.Abs(filepath.Join(local.BasePath, stored)); err == nil {
+			cmd.Env = append(cmd.Env, "UPLOAD_LOCAL_FILE="+path)
+		}
+	}
+
+	output, err := cmd.CombinedOutput()
+	if len(output) > hookOutputLimit {
+		output = output[:hookOutputLimit]
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", command[0], err, bytes.TrimSpace(output))
+	}
+	if len(output) > 0 {
+		requestLogf(ctx, "Hook %s: %s", command[0], bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// execStep runs HOOK_FILE_COMMAND for the stored file; a non-zero exit
+// status fails the step.
+type execStep struct{}
+
+func (execStep) Name() string { return "exec" }
+
+func (execStep) Run(ctx context.Context, file *pipelineFile) error {
+	return runHook(ctx, fileHookCommand, &uploadEvent{
+		Type:        eventFileSaved,
+		Time:        time.Now().UTC(),
+		Session:     file.Session,
+		Drop:        dropName(ctx),
+		RequestID:   file.RequestID,
+		Path:        file.Name,
+		Size:        file.Size,
+		ContentType: file.ContentType,
+		Status:      file.Status,
+	}, file.Stored)
+}
+
+// runSessionHook runs HOOK_SESSION_COMMAND in the background once an upload
+// request has finished, so it doesn't delay the response.
+func runSessionHook(ctx context.Context, session string, saved, failed int, message string) {
+	command := sessionHookCommand
+	if d := requestDrop(ctx); d != nil && len(d.SessionHook) > 0 {
+		command = d.SessionHook
+	}
+	if len(command) == 0 {
+		return
+	}
+	event := &uploadEvent{
+		Type:      eventSessionCompleted,
+		Time:      time.Now().UTC(),
+		Session:   session,
+		Drop:      dropName(ctx),
+		RequestID: requestID(ctx),
+		Saved:     saved,
+		Failed:    failed,
+		Message:   message,
+	}
+	go func() {
+		if err := runHook(context.WithoutCancel(ctx), command, event, ""); err != nil {
+			requestLogf(ctx, "Session hook failed for %s: %v", session, err)
+		}
+	}()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	store "go-uploader/storage"
+)
+
+func writeHookScript(t *testing.T, script string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook tests use shell scripts")
+	}
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecStep(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := writeHookScript(t, `cat > `+out+`.json; echo "$1 $UPLOAD_EVENT $UPLOAD_LOCAL_FILE" > `+out+`.txt`)
+	fileHookCommand = []string{script}
+	defer func() { fileHookCommand = nil }()
+
+	originalStorage := storage
+	storage = &store.LocalStorage{BasePath: dir}
+	defer func() { storage = originalStorage }()
+
+	file := &pipelineFile{Name: "session1/photo.jpg", Stored: "pending/session1/photo.jpg", Session: "session1", Size: 42, Status: "pending"}
+	if err := (execStep{}).Run(context.Background(), file); err != nil {
+		t.Fatalf("execStep failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(out + ".json")
+	var event uploadEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("expected event JSON on stdin, got %q", data)
+	}
+	if event.Path != "session1/photo.jpg" || event.Size != 42 || event.Status != "pending" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	args, _ := os.ReadFile(out + ".txt")
+	want := "pending/session1/photo.jpg file.saved " + filepath.Join(dir, "pending/session1/photo.jpg")
+	if strings.TrimSpace(string(args)) != want {
+		t.Errorf("expected %q, got %q", want, args)
+	}
+}
+
+func TestExecStep_Failure(t *testing.T) {
+	fileHookCommand = []string{writeHookScript(t, "echo 'virus found' >&2; exit 3")}
+	defer func() { fileHookCommand = nil }()
+
+	err := (execStep{}).Run(context.Background(), &pipelineFile{Name: "session1/photo.jpg", Stored: "session1/photo.jpg"})
+	if err == nil || !strings.Contains(err.Error(), "virus found") {
+		t.Errorf("expected failure with output, got %v", err)
+	}
+}
+
+func TestParsePipeline_ExecRequiresCommand(t *testing.T) {
+	if _, err := parsePipeline("exec"); err == nil {
+		t.Error("expected exec step without HOOK_FILE_COMMAND to be rejected")
+	}
+}
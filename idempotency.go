@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// idempotencyWindow is how long the result of a request with an
+// Idempotency-Key header is returned for retries.
+var idempotencyWindow = 24 * time.Hour
+
+// maxIdempotencyKeyLength limits the keys, which are held in memory.
+const maxIdempotencyKeyLength = 255
+
+// idempotentResult is the response to a request, or a request still being
+// processed if done is false.
+type idempotentResult struct {
+	done        bool
+	expires     time.Time
+	status      int
+	contentType string
+	body        []byte
+}
+
+// idempotentResults are kept in process, like the memory rate limit
+// counters; retries reaching another replica are processed again.
+var idempotentResults = struct {
+	sync.Mutex
+	results   map[string]*idempotentResult
+	lastSweep time.Time
+}{results: make(map[string]*idempotentResult)}
+
+func setupIdempotency() error {
+	value := os.Getenv("IDEMPOTENCY_WINDOW")
+	if value == "" {
+		return nil
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window <= 0 {
+		return fmt.Errorf("IDEMPOTENCY_WINDOW must be a positive duration, got %q", value)
+	}
+	idempotencyWindow = window
+	log.Printf("Replaying results of upload requests with an Idempotency-Key for %s", idempotencyWindow)
+	return nil
+}
+
+// withIdempotency returns the original response to retries of a successful
+// request with the same Idempotency-Key, instead of storing the files again.
+// Failed requests are not remembered, so they can be retried. Keys are scoped
+// to the client, see idempotencyKey, so nobody else can fetch a response by
+// its key.
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+			return
+		}
+		// Requests the handler rejects before the CAPTCHA aren't replayed. The
+		// CAPTCHA itself was solved by the original request from the same
+		// client, whose token a retry can't use again.
+		if checkOrigin(r) != nil {
+			next(w, r)
+			return
+		}
+		if _, ok := parseUploadLink(r); !ok {
+			next(w, r)
+			return
+		}
+		if _, ok := uploadSessionID(r); !ok {
+			next(w, r)
+			return
+		}
+		key = idempotencyKey(r, key)
+
+		result, ok := claimIdempotencyKey(key, time.Now())
+		if !ok {
+			requestLogf(r.Context(), "Rejected retry of upload request still in progress")
+			writeProblem(w, r, http.StatusConflict, problemRequestInProgress, "The upload with this Idempotency-Key is still in progress")
+			return
+		}
+		if result != nil {
+			requestLogf(r.Context(), "Replaying result of an earlier upload request")
+			if result.contentType != "" {
+				w.Header().Set("Content-Type", result.contentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(result.status)
+			w.Write(result.body)
+			return
+		}
+
+		recorder := &resultRecorder{ResponseWriter: w, status: http.StatusOK}
+		// Deferred to free the key if the handler panics
+		defer finishIdempotencyKey(key, recorder)
+		next(recorder, r)
+	}
+}
+
+// idempotencyKey scopes a client's key to the endpoint, as drops have their
+// own keys, and to the client. Clients are told apart by the upload session
+// ID they chose or the upload link they were given, which survive a change
+// of network, and otherwise by their address.
+func idempotencyKey(r *http.Request, key string) string {
+	token := r.Header.Get(uploadTokenHeader)
+	if token == "" {
+		token = r.URL.Query().Get("upload_token")
+	}
+	client := "session\x00" + r.Header.Get(uploadSessionHeader) + "\x00" + token
+	if r.Header.Get(uploadSessionHeader) == "" && token == "" {
+		host, _, err := net.SplitHostPort(clientIP(r))
+		if err != nil {
+			host = clientIP(r)
+		}
+		client = "address\x00" + host
+	}
+	return r.URL.Path + "\x00" + hex.EncodeToString(tokenMAC("idempotency", client)[:16]) + "\x00" + key
+}
+
+// claimIdempotencyKey returns the stored result for key, or marks key as in
+// progress and returns nil. It returns false if key is already in progress.
+func claimIdempotencyKey(key string, now time.Time) (*idempotentResult, bool) {
+	idempotentResults.Lock()
+	defer idempotentResults.Unlock()
+
+	if now.Sub(idempotentResults.lastSweep) > time.Minute {
+		for k, result := range idempotentResults.results {
+			if result.done && now.After(result.expires) {
+				delete(idempotentResults.results, k)
+			}
+		}
+		idempotentResults.lastSweep = now
+	}
+
+	result, ok := idempotentResults.results[key]
+	switch {
+	case ok && !result.done:
+		return nil, false
+	case ok && now.Before(result.expires):
+		return result, true
+	}
+	idempotentResults.results[key] = &idempotentResult{}
+	return nil, true
+}
+
+func finishIdempotencyKey(key string, recorder *resultRecorder) {
+	idempotentResults.Lock()
+	defer idempotentResults.Unlock()
+	if !recorder.wroteHeader || recorder.status >= 300 {
+		delete(idempotentResults.results, key)
+		return
+	}
+	idempotentResults.results[key] = &idempotentResult{
+		done:        true,
+		expires:     time.Now().Add(idempotencyWindow),
+		status:      recorder.status,
+		contentType: recorder.Header().Get("Content-Type"),
+		body:        recorder.body.Bytes(),
+	}
+}
+
+// resultRecorder keeps a copy of the response written through it.
+type resultRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rr *resultRecorder) WriteHeader(code int) {
+	if !rr.wroteHeader {
+		rr.status = code
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *resultRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+func (rr *resultRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithIdempotency(t *testing.T) {
+	defer func() { idempotentResults.results = make(map[string]*idempotentResult) }()
+	calls := 0
+	status := http.StatusCreated
+	handler := withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"session":"s1"}`))
+	})
+	send := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	send("/upload", "retry-1")
+	rec := send("/upload", "retry-1")
+	if calls != 1 || rec.Code != http.StatusCreated || rec.Body.String() != `{"session":"s1"}` || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected a replay, got %d calls, %d %q", calls, rec.Code, rec.Body.String())
+	}
+	if send("/d/party/upload", "retry-1"); calls != 2 {
+		t.Error("expected keys to be scoped by path")
+	}
+
+	// Another client can't fetch the response with the same key
+	req := httptest.NewRequest("POST", "/upload", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	req.Header.Set("Idempotency-Key", "retry-1")
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if calls != 3 || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected a request of another client to run, got %d calls", calls)
+	}
+	// Neither can a request the handler rejects before the CAPTCHA
+	req = httptest.NewRequest("POST", "/upload", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Idempotency-Key", "retry-1")
+	req.Header.Set(uploadTokenHeader, "forged")
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if calls != 4 || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected a request with an invalid upload link to run, got %d calls", calls)
+	}
+
+	// Retries with the same upload session ID match from another address
+	for _, addr := range []string{"192.0.2.1:1234", "198.51.100.7:1234"} {
+		req = httptest.NewRequest("POST", "/upload", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("Idempotency-Key", "retry-1")
+		req.Header.Set(uploadSessionHeader, "mobile-session-0123456789")
+		rec = httptest.NewRecorder()
+		handler(rec, req)
+	}
+	if calls != 5 || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected a retry of the same upload session to be replayed, got %d calls", calls)
+	}
+
+	// Failures can be retried
+	status = http.StatusUnprocessableEntity
+	send("/upload", "retry-2")
+	send("/upload", "retry-2")
+	if calls != 7 {
+		t.Errorf("expected failed requests to run again, got %d calls", calls)
+	}
+
+	// A retry while the first request is running is rejected
+	req = httptest.NewRequest("POST", "/upload", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if _, ok := claimIdempotencyKey(idempotencyKey(req, "retry-3"), time.Now()); !ok {
+		t.Fatal("expected to claim a new key")
+	}
+	if rec := send("/upload", "retry-3"); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a request in progress, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestImageServiceURL(t *testing.T) {
+	defer func() { imageService.Kind = "" }()
+
+	t.Setenv("IMAGE_SERVICE", "imgproxy")
+	t.Setenv("IMAGE_SERVICE_URL", "https://img.example.com/")
+	t.Setenv("IMAGE_SERVICE_SOURCE", "s3://go-upload/uploads/")
+	t.Setenv("IMAGE_SERVICE_KEY", "6b6579")
+	t.Setenv("IMAGE_SERVICE_SALT", "73616c74")
+	if err := setupImageService(); err != nil {
+		t.Fatal(err)
+	}
+	link := imageServiceURL("session1/photo 1.jpg", 320)
+	signature, path, _ := strings.Cut(strings.TrimPrefix(link, "https://img.example.com/"), "/")
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("salt/" + path))
+	if signature != base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("unexpected signature in %s", link)
+	}
+	source := base64.RawURLEncoding.EncodeToString([]byte("s3://go-upload/uploads/session1/photo 1.jpg"))
+	if path != "rs:fit:320:320/"+source+".jpg" {
+		t.Errorf("unexpected imgproxy path %s", path)
+	}
+
+	t.Setenv("IMAGE_SERVICE", "thumbor")
+	t.Setenv("IMAGE_SERVICE_KEY", "secret")
+	if err := setupImageService(); err != nil {
+		t.Fatal(err)
+	}
+	if link := imageServiceURL("session1/photo 1.jpg", 320); !strings.HasSuffix(link, "=/fit-in/320x320/s3://go-upload/uploads/session1/photo%201.jpg") {
+		t.Errorf("unexpected Thumbor URL %s", link)
+	}
+
+	t.Setenv("IMAGE_SERVICE_KEY", "")
+	if err := setupImageService(); err == nil {
+		t.Error("expected a key to be required")
+	}
+}
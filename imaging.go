@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Images larger than these dimensions are downscaled before storage. Zero
+// disables resizing.
+var resizeMaxWidth, resizeMaxHeight int
+var resizeQuality = 85
+
+// imageMaxPixels limits the images that are decoded, since a small file can
+// declare huge dimensions and decoding allocates memory for every pixel.
+// Larger images are stored as uploaded and get no thumbnails.
+var imageMaxPixels = 50_000_000
+
+// imageSlots bounds the images decoded at the same time for resizing and
+// thumbnails, as each one may take up to 4 bytes per pixel.
+var imageSlots = make(chan struct{}, 2)
+
+func setupResizing() error {
+	if value := os.Getenv("IMAGE_MAX_PIXELS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("IMAGE_MAX_PIXELS must be a positive number, got %q", value)
+		}
+		imageMaxPixels = n
+	}
+	if value := os.Getenv("IMAGE_CONCURRENCY"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("IMAGE_CONCURRENCY must be a positive number, got %q", value)
+		}
+		imageSlots = make(chan struct{}, n)
+	}
+	for _, setting := range []struct {
+		name  string
+		value *int
+		max   int
+	}{
+		{"RESIZE_MAX_WIDTH", &resizeMaxWidth, 1 << 16},
+		{"RESIZE_MAX_HEIGHT", &resizeMaxHeight, 1 << 16},
+		{"RESIZE_QUALITY", &resizeQuality, 100},
+	} {
+		value := os.Getenv(setting.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > setting.max {
+			return fmt.Errorf("%s must be between 1 and %d, got %q", setting.name, setting.max, value)
+		}
+		*setting.value = n
+	}
+	if resizeMaxWidth > 0 || resizeMaxHeight > 0 {
+		log.Printf("Downscaling images larger than %dx%d (0 = unlimited) at quality %d", resizeMaxWidth, resizeMaxHeight, resizeQuality)
+	}
+	return nil
+}
+
+// resizeUpload downscales oversized JPEG and PNG images. Other files and
+// images within the limits are passed through untouched.
+func resizeUpload(name string, data io.Reader) (io.Reader, error) {
+	if resizeMaxWidth == 0 && resizeMaxHeight == 0 {
+		return data, nil
+	}
+
+	// Keep the header bytes read by DecodeConfig so the original stream can
+	// be reassembled if the image doesn't need resizing.
+	var head bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(data, &head))
+	original := io.MultiReader(bytes.NewReader(head.Bytes()), data)
+	if err != nil || (format != "jpeg" && format != "png") {
+		return original, nil
+	}
+
+	orientation := 1
+	if exif := parseJPEGExif(head.Bytes()); exif != nil {
+		orientation = exif.Orientation
+	}
+	width, height := fitDimensions(cfg.Width, cfg.Height, orientation, resizeMaxWidth, resizeMaxHeight)
+	if width == cfg.Width && height == cfg.Height {
+		return original, nil
+	}
+	if !decodable(cfg) {
+		log.Printf("Not resizing %s, %dx%d pixels exceed IMAGE_MAX_PIXELS", logName(name), cfg.Width, cfg.Height)
+		return original, nil
+	}
+
+	defer acquireImageSlot()()
+	img, _, err := image.Decode(original)
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	resized := orient(downscale(img, width, height), orientation)
+
+	var out bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&out, resized)
+	} else {
+		err = jpeg.Encode(&out, resized, &jpeg.Options{Quality: resizeQuality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encoding image: %w", err)
+	}
+	log.Printf("Resized %s from %dx%d to %dx%d", logName(name), cfg.Width, cfg.Height, resized.Bounds().Dx(), resized.Bounds().Dy())
+	return &out, nil
+}
+
+// acquireImageSlot waits until an image may be decoded and returns the
+// function releasing the slot again.
+func acquireImageSlot() func() {
+	slots := imageSlots
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// decodable reports whether an image is small enough to be decoded.
+func decodable(cfg image.Config) bool {
+	return cfg.Width > 0 && cfg.Height > 0 && int64(cfg.Width)*int64(cfg.Height) <= int64(imageMaxPixels)
+}
+
+// describeImage reads the dimensions of a stored image from the start of its
+// content, and the camera and capture time from the start of the upload as
+// received, since resizing drops the EXIF data. It returns nil for files
+// that aren't images.
+func describeImage(original, stored []byte) *imageInfo {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(stored))
+	if err != nil {
+		return nil
+	}
+	info := &imageInfo{Width: cfg.Width, Height: cfg.Height}
+	if exif := parseJPEGExif(stored); exif != nil && exif.Orientation >= 5 {
+		info.Width, info.Height = cfg.Height, cfg.Width
+	}
+
+	if exif := parseJPEGExif(original); exif != nil {
+		if taken, ok := exif.captureTime(); ok {
+			info.Taken = taken.Format("2006-01-02T15:04:05")
+		}
+		// Most cameras repeat the make in the model
+		info.Camera = exif.Model
+		if exif.Make != "" && !strings.HasPrefix(strings.ToLower(exif.Model), strings.ToLower(exif.Make)) {
+			info.Camera = strings.TrimSpace(exif.Make + " " + exif.Model)
+		}
+	}
+	return info
+}
+
+// fitDimensions returns the stored (unrotated) dimensions that make the
+// displayed image fit within the limits. A zero limit is unlimited.
+func fitDimensions(width, height, orientation, maxWidth, maxHeight int) (int, int) {
+	displayWidth, displayHeight := width, height
+	if orientation >= 5 {
+		displayWidth, displayHeight = height, width
+	}
+
+	scale := 1.0
+	if maxWidth > 0 && displayWidth > maxWidth {
+		scale = min(scale, float64(maxWidth)/float64(displayWidth))
+	}
+	if maxHeight > 0 && displayHeight > maxHeight {
+		scale = min(scale, float64(maxHeight)/float64(displayHeight))
+	}
+	if scale == 1 {
+		return width, height
+	}
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
+
+// downscale resizes img by averaging all source pixels covered by each
+// destination pixel.
+func downscale(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	ycbcr, isYCbCr := img.(*image.YCbCr)
+
+	for dy := 0; dy < height; dy++ {
+		y0 := dy * srcHeight / height
+		y1 := max(y0+1, (dy+1)*srcHeight/height)
+		for dx := 0; dx < width; dx++ {
+			x0 := dx * srcWidth / width
+			x1 := max(x0+1, (dx+1)*srcWidth/width)
+
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					px, py := bounds.Min.X+x, bounds.Min.Y+y
+					if isYCbCr {
+						yi, ci := ycbcr.YOffset(px, py), ycbcr.COffset(px, py)
+						cr, cg, cb := color.YCbCrToRGB(ycbcr.Y[yi], ycbcr.Cb[ci], ycbcr.Cr[ci])
+						r += uint64(cr) * 0x101
+						g += uint64(cg) * 0x101
+						b += uint64(cb) * 0x101
+						a += 0xFFFF
+					} else {
+						cr, cg, cb, ca := img.At(px, py).RGBA()
+						r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					}
+					n++
+				}
+			}
+			dst.SetRGBA(dx, dy, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// orient applies an EXIF orientation so the re-encoded image, which carries
+// no EXIF data, displays the right way up.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dstWidth, dstHeight := w, h
+	if orientation >= 5 {
+		dstWidth, dstHeight = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var nx, ny int
+			switch orientation {
+			case 2:
+				nx, ny = w-1-x, y
+			case 3:
+				nx, ny = w-1-x, h-1-y
+			case 4:
+				nx, ny = x, h-1-y
+			case 5:
+				nx, ny = y, x
+			case 6:
+				nx, ny = h-1-y, x
+			case 7:
+				nx, ny = h-1-y, w-1-x
+			case 8:
+				nx, ny = y, w-1-x
+			}
+			dst.SetRGBA(nx, ny, img.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
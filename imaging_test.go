@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+)
+
+// jpegWithOrientation encodes a test JPEG and inserts an EXIF APP1 segment
+// carrying the given orientation.
+func jpegWithOrientation(t *testing.T, width, height, orientation int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tiff[18:], uint16(orientation))
+	return insertExif(buf.Bytes(), tiff)
+}
+
+// insertExif adds an EXIF APP1 segment with the given TIFF structure right
+// after the SOI marker of a JPEG.
+func insertExif(data, tiff []byte) []byte {
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestParseJPEGExif(t *testing.T) {
+	exif := parseJPEGExif(jpegWithOrientation(t, 8, 8, 6))
+	if exif == nil || exif.Orientation != 6 {
+		t.Fatalf("Expected orientation 6, got %+v", exif)
+	}
+	if exif := parseJPEGExif([]byte("not a jpeg")); exif != nil {
+		t.Errorf("Expected nil for non-JPEG data, got %+v", exif)
+	}
+}
+
+func TestParseTIFF_CameraAndDate(t *testing.T) {
+	le := binary.LittleEndian
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	entry := func(tag, typ uint16, count, value uint32) {
+		tiff = le.AppendUint16(tiff, tag)
+		tiff = le.AppendUint16(tiff, typ)
+		tiff = le.AppendUint32(tiff, count)
+		tiff = le.AppendUint32(tiff, value)
+	}
+	// IFD0 at 8, the Exif IFD at 50 and the strings from 68 on
+	tiff = le.AppendUint16(tiff, 3)
+	entry(tagMake, 2, 6, 68)
+	entry(tagModel, 2, 13, 74)
+	entry(tagExifIFD, 4, 1, 50)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = le.AppendUint16(tiff, 1)
+	entry(tagDateTimeOriginal, 2, 20, 87)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(tiff, "Canon\x00Canon EOS R6\x002024:06:01 14:30:00\x00"...)
+
+	exif := parseTIFF(tiff)
+	if exif == nil || exif.Make != "Canon" || exif.Model != "Canon EOS R6" || exif.DateTime != "2024:06:01 14:30:00" {
+		t.Errorf("unexpected EXIF data %+v", exif)
+	}
+}
+
+func TestDescribeImage(t *testing.T) {
+	// Stored sideways, displayed upright
+	data := jpegWithOrientation(t, 40, 20, 6)
+	info := describeImage(data, data)
+	if info == nil || info.Width != 20 || info.Height != 40 {
+		t.Errorf("unexpected image info %+v", info)
+	}
+	if info := describeImage([]byte("text"), []byte("text")); info != nil {
+		t.Errorf("expected nil for non-images, got %+v", info)
+	}
+}
+
+func TestResizeUpload(t *testing.T) {
+	resizeMaxWidth, resizeMaxHeight = 100, 100
+	defer func() { resizeMaxWidth, resizeMaxHeight = 0, 0 }()
+
+	tests := []struct {
+		name        string
+		width       int
+		height      int
+		orientation int
+		wantWidth   int
+		wantHeight  int
+	}{
+		{"Landscape", 400, 200, 1, 100, 50},
+		{"Rotated", 400, 300, 6, 75, 100},
+		{"Small", 80, 60, 1, 80, 60},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := jpegWithOrientation(t, test.width, test.height, test.orientation)
+			reader, err := resizeUpload("photo.jpg", bytes.NewReader(original))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(reader)
+
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != test.wantWidth || cfg.Height != test.wantHeight {
+				t.Errorf("Expected %dx%d, got %dx%d", test.wantWidth, test.wantHeight, cfg.Width, cfg.Height)
+			}
+			if test.wantWidth == test.width && !bytes.Equal(data, original) {
+				t.Error("Expected images within the limits to be passed through unchanged")
+			}
+		})
+	}
+
+	reader, err := resizeUpload("notes.txt", bytes.NewReader([]byte("plain text")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(reader); string(data) != "plain text" {
+		t.Errorf("Expected non-images to pass through, got %q", data)
+	}
+}
+
+// pngDeclaring encodes a tiny PNG and patches its header to declare the
+// given dimensions, like a decompression bomb.
+func pngDeclaring(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// The IHDR chunk follows the 8 byte signature: length, type, data, CRC
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestResizeUpload_PixelLimit(t *testing.T) {
+	resizeMaxWidth, resizeMaxHeight = 100, 100
+	defer func() { resizeMaxWidth, resizeMaxHeight = 0, 0 }()
+
+	bomb := pngDeclaring(t, 60000, 60000)
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(bomb)); err != nil || cfg.Width != 60000 {
+		t.Fatalf("DecodeConfig = %+v, %v", cfg, err)
+	}
+	reader, err := resizeUpload("bomb.png", bytes.NewReader(bomb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(reader); !bytes.Equal(data, bomb) {
+		t.Error("Expected an image over the pixel limit to be stored as uploaded")
+	}
+}
+
+func TestAcquireImageSlot(t *testing.T) {
+	originalSlots := imageSlots
+	imageSlots = make(chan struct{}, 1)
+	defer func() { imageSlots = originalSlots }()
+
+	release := acquireImageSlot()
+	acquired := make(chan func())
+	go func() { acquired <- acquireImageSlot() }()
+	select {
+	case <-acquired:
+		t.Fatal("expected the second decode to wait for a free slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("expected the second decode to get the released slot")
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// importToken protects the import endpoint, which is off when it is empty.
+var importToken string
+
+// importMaxSize limits the size of each imported file.
+var importMaxSize int64 = 50 << 20
+
+// importAllowedTypes are the media type prefixes that can be imported.
+var importAllowedTypes = []string{"image/", "video/"}
+
+// importMaxURLs limits the URLs per import request.
+const importMaxURLs = 20
+
+var importClient *http.Client
+
+// importAddressAllowed decides which addresses imports may connect to.
+// Tests replace it to reach local servers.
+var importAddressAllowed = isPublicAddress
+
+var errBlockedAddress = errors.New("address not allowed")
+
+// blockedPrefixes are special-purpose ranges not covered by the netip
+// predicates, e.g. carrier-grade NAT, which often reaches internal hosts.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// importRequest lists the URLs to import into a new session.
+type importRequest struct {
+	URLs []string `json:"urls"`
+}
+
+func setupImport() error {
+	importToken = os.Getenv("IMPORT_TOKEN")
+	if importToken == "" {
+		return nil
+	}
+	if value := os.Getenv("IMPORT_MAX_SIZE"); value != "" {
+		size, err := parseSize(value)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("invalid IMPORT_MAX_SIZE %q", value)
+		}
+		importMaxSize = size
+	}
+	if value := os.Getenv("IMPORT_ALLOWED_TYPES"); value != "" {
+		importAllowedTypes = splitList(value)
+	}
+	importClient = newImportClient()
+
+	mux.HandleFunc("POST /import", requireClientCert(pauseDuringMaintenance(requireImportToken(withUploadDeadline(importHandler)))))
+	log.Printf("Importing files of types %s up to %d bytes from URLs at /import", strings.Join(importAllowedTypes, ", "), importMaxSize)
+	return nil
+}
+
+func requireImportToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(importToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, r, http.StatusUnauthorized, problemUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// newImportClient returns a client that only connects to public addresses.
+// The check runs on the resolved address of every connection, so it also
+// covers redirects and DNS names pointing to internal hosts. Proxies from
+// the environment are ignored, they would connect on our behalf.
+func newImportClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !importAddressAllowed(addr) {
+				return fmt.Errorf("%w: %s", errBlockedAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// isPublicAddress reports whether addr is a globally routable unicast address.
+func isPublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// importHandler fetches the posted URLs into a new session, running every
+// file through the same checks and pipeline as uploads.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 4*time.Minute)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var req importRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil || len(req.URLs) == 0 {
+		writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, "Expected a JSON body with urls")
+		return
+	}
+	if len(req.URLs) > importMaxURLs {
+		writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, fmt.Sprintf("At most %d URLs can be imported at once", importMaxURLs))
+		return
+	}
+
+	release, ok := acquireUploadSlot()
+	if !ok {
+		writeBusy(w, r)
+		return
+	}
+	defer release()
+
+	folders := newSessionFolders(r, time.Now())
+	session := folders.Session
+	requestLogf(ctx, "Starting import of %d URL(s) into session %s", len(req.URLs), session)
+
+	var saved, failed int
+	var stored, accepted []*pipelineFile
+	var fileErrors []string
+	for _, rawURL := range req.URLs {
+		result := importURL(ctx, folders, rawURL)
+		auditBlockedHash(r, result)
+		switch result.outcome {
+		case outcomeRejected, outcomeFailed:
+			failed++
+			if result.outcome == outcomeRejected {
+				uploadedFiles.WithLabelValues("rejected").Inc()
+			} else {
+				uploadedFiles.WithLabelValues("failed").Inc()
+			}
+			fileErrors = append(fileErrors, fmt.Sprintf("%s: %s", rawURL, result.message))
+		case outcomeQuarantined:
+			saved++
+			uploadedFiles.WithLabelValues("quarantined").Inc()
+			accepted = append(accepted, result.file)
+		default:
+			saved++
+			uploadedFiles.WithLabelValues("saved").Inc()
+			stored = append(stored, result.file)
+			accepted = append(accepted, result.file)
+		}
+	}
+
+	requestLogf(ctx, "Import into session %s: %d saved, %d failed", session, saved, failed)
+	uploadSessions.WithLabelValues(sessionResult(saved, failed)).Inc()
+	writeSessionChecksums(ctx, stored)
+	if saved > 0 {
+		emitEvent(&uploadEvent{Type: eventSessionCompleted, Session: session, RequestID: requestID(ctx), Saved: saved, Failed: failed})
+		runSessionHook(ctx, session, saved, failed, "")
+	}
+
+	details := ""
+	if len(fileErrors) > 0 {
+		details = "\n" + strings.Join(fileErrors, "\n")
+	}
+	if saved == 0 {
+		sendProblem(w, r, problem{Type: problemFilesRejected, Status: http.StatusUnprocessableEntity, Detail: "No files imported", Errors: fileErrors})
+		return
+	}
+	resp := uploadResponse{Session: session, Saved: saved, Failed: failed}
+	if failed > 0 {
+		resp.Message = fmt.Sprintf("Partially successful: %d file(s) imported, %d failed", saved, failed) + details
+		writeUploadResult(w, r, http.StatusPartialContent, resp, accepted)
+		return
+	}
+	resp.Message = fmt.Sprintf("Imported %d file(s)", saved)
+	writeUploadResult(w, r, http.StatusCreated, resp, accepted)
+}
+
+// importURL fetches a single URL and stores it in the session.
+func importURL(ctx context.Context, folders sessionFolders, rawURL string) fileResult {
+	session := folders.Session
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fileResult{outcome: outcomeRejected, message: "not an http or https URL"}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fileResult{outcome: outcomeRejected, message: "not an http or https URL"}
+	}
+	req.Header.Set("User-Agent", "go-uploader/"+version)
+
+	resp, err := importClient.Do(req)
+	if err != nil {
+		requestLogf(ctx, "Error fetching %s for session %s: %v", u.Redacted(), session, err)
+		if errors.Is(err, errBlockedAddress) {
+			return fileResult{outcome: outcomeRejected, message: "address not allowed"}
+		}
+		return fileResult{outcome: outcomeFailed, message: "could not be fetched", err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		requestLogf(ctx, "Fetching %s for session %s returned %s", u.Redacted(), session, resp.Status)
+		return fileResult{outcome: outcomeFailed, message: "server returned " + resp.Status, err: fmt.Errorf("fetching: %s", resp.Status)}
+	}
+	if resp.ContentLength > importMaxSize {
+		return fileResult{outcome: outcomeRejected, message: fmt.Sprintf("larger than %d bytes", importMaxSize)}
+	}
+
+	declared, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	body := bufio.NewReader(http.MaxBytesReader(nil, resp.Body, importMaxSize))
+	head, _ := body.Peek(512)
+	detected := detectType(head)
+	// The content decides, unless it can't be identified
+	if !importTypeAllowed(detected) && (detected != "application/octet-stream" || !importTypeAllowed(declared)) {
+		requestLogf(ctx, "Rejected import of %s for session %s: type %s (declared %s)", u.Redacted(), session, detected, declared)
+		return fileResult{outcome: outcomeRejected, message: "file type " + detected + " not allowed"}
+	}
+
+	folder, data := folders.forFile(body)
+	result := storeUpload(ctx, folder, "", importFileName(resp), declared, data)
+	var tooLarge *http.MaxBytesError
+	if errors.As(result.err, &tooLarge) {
+		result.outcome = outcomeRejected
+		result.message = fmt.Sprintf("larger than %d bytes", importMaxSize)
+	}
+	return result
+}
+
+func importTypeAllowed(mediaType string) bool {
+	for _, allowed := range importAllowedTypes {
+		if strings.HasPrefix(mediaType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// importFileName takes the name from the Content-Disposition header or the
+// last segment of the final URL after redirects.
+func importFileName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "." && name != "/" {
+		return name
+	}
+	return "import"
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:2800::1":    true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"192.168.0.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := isPublicAddress(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("isPublicAddress(%s) = %v, expected %v", addr, got, public)
+		}
+	}
+}
+
+func TestImportHandler(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photos/beach.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+		case "/page.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("<html><body>not a photo</body></html>"))
+		case "/large.png":
+			w.Write(append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 2048)...))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer remote.Close()
+
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	importToken = "import-secret"
+	importClient = newImportClient()
+	importMaxSize = 1024
+	defer func() {
+		storage = originalStorage
+		importToken = ""
+		importMaxSize = 50 << 20
+		importAddressAllowed = isPublicAddress
+	}()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/import", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer import-secret")
+		w := httptest.NewRecorder()
+		requireImportToken(importHandler)(w, req)
+		return w
+	}
+
+	// The test server listens on localhost, which imports must not reach
+	w := post(`{"urls":["` + remote.URL + `/photos/beach.png"]}`)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "address not allowed") {
+		t.Errorf("expected local address to be blocked, got %d %q", w.Code, w.Body.String())
+	}
+
+	importAddressAllowed = func(netip.Addr) bool { return true }
+	w = post(`{"urls":["` + remote.URL + `/photos/beach.png","` + remote.URL + `/page.jpg","` + remote.URL + `/large.png","` + remote.URL + `/missing.jpg","file:///etc/passwd"]}`)
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusPartialContent, w.Code, w.Body.String())
+	}
+	for _, message := range []string{"file type text/html not allowed", "larger than 1024 bytes", "server returned 404", "not an http or https URL"} {
+		if !strings.Contains(w.Body.String(), message) {
+			t.Errorf("expected %q in response %q", message, w.Body.String())
+		}
+	}
+	names, _ := mockStorage.ListFiles("")
+	if len(names) != 1 || !strings.HasSuffix(names[0], "/beach.png") {
+		t.Errorf("expected only beach.png to be stored, got %v", names)
+	}
+
+	req := httptest.NewRequest("POST", "/import", strings.NewReader(`{"urls":[]}`))
+	w = httptest.NewRecorder()
+	requireImportToken(importHandler)(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without token, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
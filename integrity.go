@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+// integrityInterval is how often stored files are re-read and compared with
+// the digest recorded at upload time. The job is off when it is zero.
+var integrityInterval time.Duration
+
+func setupIntegrityCheck() error {
+	integrityInterval = 0
+	value := os.Getenv("INTEGRITY_CHECK_INTERVAL")
+	if value == "" {
+		return nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("INTEGRITY_CHECK_INTERVAL must be a positive duration, got %q", value)
+	}
+	integrityInterval = interval
+	log.Printf("Verifying stored files every %s", integrityInterval)
+	if checkingConfig {
+		return nil
+	}
+
+	go func() {
+		for {
+			time.Sleep(integrityInterval)
+			runIntegrityCheck()
+		}
+	}()
+	return nil
+}
+
+// integrityMismatch is a file whose content no longer matches its digest.
+type integrityMismatch struct {
+	Path     string `json:"path"`
+	Stored   string `json:"stored"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// integrityReport describes the last completed run.
+type integrityReport struct {
+	Started    time.Time           `json:"started"`
+	Finished   time.Time           `json:"finished"`
+	Checked    int                 `json:"checked"`
+	Mismatches []integrityMismatch `json:"mismatches"`
+}
+
+var integrity struct {
+	sync.Mutex
+	last *integrityReport
+}
+
+// runIntegrityCheck verifies every file with a recorded digest. Files are
+// looked up wherever they currently are, as moderation moves them around
+// after the digest was recorded.
+func runIntegrityCheck() *integrityReport {
+	report := &integrityReport{Started: time.Now().UTC(), Mismatches: []integrityMismatch{}}
+	names, err := storage.ListFiles(metaPrefix)
+	if err != nil {
+		log.Printf("Error listing metadata for integrity check: %v", err)
+		return nil
+	}
+
+	for _, key := range names {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(key, metaPrefix), ".json")
+		if !ok {
+			continue
+		}
+		meta, err := loadMetadata(name)
+		if err != nil || meta.SHA256 == "" {
+			continue
+		}
+
+		stored, actual, err := hashStoredFile(name)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		report.Checked++
+		integrityCheckedFiles.Inc()
+		if err == nil && actual == meta.SHA256 {
+			continue
+		}
+
+		mismatch := integrityMismatch{Path: name, Stored: stored, Expected: meta.SHA256, Actual: actual}
+		if err != nil {
+			mismatch.Error = err.Error()
+			log.Printf("Integrity check could not read %s: %v", logName(stored), err)
+		} else {
+			log.Printf("Integrity check failed for %s: expected %s, got %s", logName(stored), meta.SHA256, actual)
+		}
+		integrityMismatches.Inc()
+		report.Mismatches = append(report.Mismatches, mismatch)
+	}
+
+	report.Finished = time.Now().UTC()
+	integrityLastRun.Set(float64(report.Finished.Unix()))
+	log.Printf("Integrity check verified %d file(s), %d mismatch(es)", report.Checked, len(report.Mismatches))
+
+	integrity.Lock()
+	integrity.last = report
+	integrity.Unlock()
+	return report
+}
+
+// hashStoredFile hashes the file with public path name in the first area it
+// is found in and returns where that was.
+func hashStoredFile(name string) (string, string, error) {
+	for _, stored := range []string{name, pendingPrefix + name, quarantinePrefix + name} {
+		f, err := storage.OpenFile(stored)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return stored, "", err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return stored, "", err
+		}
+		return stored, hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	return "", "", store.ErrNotFound
+}
+
+// integrityReportHandler returns the report of the last run.
+func integrityReportHandler(w http.ResponseWriter, r *http.Request) {
+	integrity.Lock()
+	report := integrity.last
+	integrity.Unlock()
+	if report == nil {
+		http.Error(w, "No integrity check has completed yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
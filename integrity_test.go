@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunIntegrityCheck(t *testing.T) {
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() {
+		storage = originalStorage
+		integrity.last = nil
+	}()
+
+	for name, content := range map[string]string{
+		"session1/good.jpg":         "good",
+		"session1/bad.jpg":          "bad",
+		"pending/session1/held.jpg": "held",
+	} {
+		storage.SaveFile(name, strings.NewReader(content))
+		public := strings.TrimPrefix(name, pendingPrefix)
+		sum := sha256.Sum256([]byte(content))
+		saveMetadata(&fileMetadata{Path: public, SHA256: hex.EncodeToString(sum[:])})
+	}
+	// Silent corruption after the upload
+	storage.SaveFile("session1/bad.jpg", strings.NewReader("b4d"))
+	// Metadata without digest and deleted files are skipped
+	saveMetadata(&fileMetadata{Path: "session1/nodigest.jpg"})
+	storage.SaveFile("session1/nodigest.jpg", strings.NewReader("x"))
+	saveMetadata(&fileMetadata{Path: "session1/deleted.jpg", SHA256: "00"})
+
+	w := httptest.NewRecorder()
+	integrityReportHandler(w, httptest.NewRequest("GET", "/admin/integrity", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d before the first run, got %d", http.StatusNotFound, w.Code)
+	}
+
+	report := runIntegrityCheck()
+	if report.Checked != 3 {
+		t.Errorf("expected 3 checked files, got %d", report.Checked)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0].Path != "session1/bad.jpg" {
+		t.Fatalf("unexpected mismatches: %+v", report.Mismatches)
+	}
+
+	w = httptest.NewRecorder()
+	integrityReportHandler(w, httptest.NewRequest("GET", "/admin/integrity", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"path":"session1/bad.jpg"`) {
+		t.Errorf("unexpected report: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapConfig describes the directory admins sign in with. Admins send their
+// directory credentials with HTTP Basic authentication and must be members
+// of AdminGroup.
+var ldapConfig struct {
+	URL          string
+	StartTLS     bool
+	BindDN       string
+	BindPassword string
+	UserBaseDN   string
+	UserFilter   string
+	AdminGroup   string
+}
+
+// ldapTimeout bounds connecting to and every request of the directory.
+const ldapTimeout = 10 * time.Second
+
+// ldapCacheTTL is how long a successful login is remembered, so API clients
+// don't cause a bind per request.
+const ldapCacheTTL = time.Minute
+
+var (
+	errLDAPInvalidCredentials = errors.New("invalid credentials")
+	errLDAPNotAdmin           = errors.New("not a member of the admin group")
+)
+
+// ldapAuthenticate checks the credentials of an admin. Tests replace it to
+// avoid running a directory server.
+var ldapAuthenticate = authenticateLDAP
+
+// ldapLogins maps a hash of username and password to the expiry of a
+// successful login. Passwords are never kept.
+var ldapLogins = struct {
+	sync.Mutex
+	expires map[[sha256.Size]byte]time.Time
+}{expires: make(map[[sha256.Size]byte]time.Time)}
+
+func setupLDAP() error {
+	ldapConfig.URL = os.Getenv("LDAP_URL")
+	if ldapConfig.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(ldapConfig.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return fmt.Errorf("LDAP_URL must be an ldap:// or ldaps:// URL, got %q", ldapConfig.URL)
+	}
+	ldapConfig.StartTLS = os.Getenv("LDAP_START_TLS") == "true"
+	if ldapConfig.StartTLS && u.Scheme == "ldaps" {
+		return fmt.Errorf("LDAP_START_TLS can't be used with ldaps://")
+	}
+	ldapConfig.BindDN = os.Getenv("LDAP_BIND_DN")
+	ldapConfig.BindPassword = os.Getenv("LDAP_BIND_PASSWORD")
+	ldapConfig.UserBaseDN = os.Getenv("LDAP_USER_BASE_DN")
+	ldapConfig.AdminGroup = os.Getenv("LDAP_ADMIN_GROUP")
+	if ldapConfig.UserBaseDN == "" || ldapConfig.AdminGroup == "" {
+		return fmt.Errorf("LDAP_URL requires LDAP_USER_BASE_DN and LDAP_ADMIN_GROUP")
+	}
+	ldapConfig.UserFilter = os.Getenv("LDAP_USER_FILTER")
+	if ldapConfig.UserFilter == "" {
+		ldapConfig.UserFilter = "(uid=%s)"
+	}
+	if strings.Count(ldapConfig.UserFilter, "%s") != 1 {
+		return fmt.Errorf("LDAP_USER_FILTER must contain %%s once, got %q", ldapConfig.UserFilter)
+	}
+	log.Printf("Authenticating admins against %s, requiring membership in %s", ldapConfig.URL, ldapConfig.AdminGroup)
+	return nil
+}
+
+// checkLDAPLogin authenticates an admin, using the cache of recent logins.
+func checkLDAPLogin(ctx context.Context, username, password string) error {
+	// An empty password would be an unauthenticated bind, which succeeds
+	if username == "" || password == "" {
+		return errLDAPInvalidCredentials
+	}
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+	now := time.Now()
+
+	ldapLogins.Lock()
+	expires, ok := ldapLogins.expires[key]
+	ldapLogins.Unlock()
+	if ok && now.Before(expires) {
+		return nil
+	}
+
+	if err := ldapAuthenticate(username, password); err != nil {
+		return err
+	}
+	requestLogf(ctx, "Admin %q signed in through LDAP", username)
+
+	ldapLogins.Lock()
+	defer ldapLogins.Unlock()
+	for k, expires := range ldapLogins.expires {
+		if now.After(expires) {
+			delete(ldapLogins.expires, k)
+		}
+	}
+	ldapLogins.expires[key] = now.Add(ldapCacheTTL)
+	return nil
+}
+
+// authenticateLDAP looks up the user, binds with the password to verify it,
+// and checks that the user is a member of the admin group.
+func authenticateLDAP(username, password string) error {
+	conn, err := ldap.DialURL(ldapConfig.URL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetTimeout(ldapTimeout)
+
+	if ldapConfig.StartTLS {
+		u, _ := url.Parse(ldapConfig.URL)
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			return fmt.Errorf("starting TLS: %w", err)
+		}
+	}
+	bindService := func() error {
+		if ldapConfig.BindDN == "" {
+			return nil
+		}
+		if err := conn.Bind(ldapConfig.BindDN, ldapConfig.BindPassword); err != nil {
+			return fmt.Errorf("binding as %s: %w", ldapConfig.BindDN, err)
+		}
+		return nil
+	}
+	if err := bindService(); err != nil {
+		return err
+	}
+
+	users, err := conn.Search(ldap.NewSearchRequest(
+		ldapConfig.UserBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		fmt.Sprintf(ldapConfig.UserFilter, ldap.EscapeFilter(username)), []string{"dn"}, nil,
+	))
+	if err != nil {
+		return fmt.Errorf("searching user: %w", err)
+	}
+	if len(users.Entries) != 1 {
+		return errLDAPInvalidCredentials
+	}
+	userDN := users.Entries[0].DN
+
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return errLDAPInvalidCredentials
+		}
+		return fmt.Errorf("binding as %s: %w", userDN, err)
+	}
+	// Users can't always read group memberships
+	if err := bindService(); err != nil {
+		return err
+	}
+
+	member := ldap.EscapeFilter(userDN)
+	groups, err := conn.Search(ldap.NewSearchRequest(
+		ldapConfig.AdminGroup, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(ldapTimeout.Seconds()), false,
+		fmt.Sprintf("(|(member=%s)(uniqueMember=%s))", member, member), []string{"dn"}, nil,
+	))
+	if err != nil {
+		return fmt.Errorf("checking group membership: %w", err)
+	}
+	if len(groups.Entries) == 0 {
+		return errLDAPNotAdmin
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdmin_LDAP(t *testing.T) {
+	t.Setenv("LDAP_URL", "ldaps://ldap.example.com")
+	t.Setenv("LDAP_USER_BASE_DN", "ou=people,dc=example,dc=com")
+	t.Setenv("LDAP_ADMIN_GROUP", "cn=uploader-admins,ou=groups,dc=example,dc=com")
+	if err := setupLDAP(); err != nil {
+		t.Fatal(err)
+	}
+	var binds int
+	originalAuthenticate := ldapAuthenticate
+	ldapAuthenticate = func(username, password string) error {
+		binds++
+		switch {
+		case password != "secret":
+			return errLDAPInvalidCredentials
+		case username != "alice":
+			return errLDAPNotAdmin
+		}
+		return nil
+	}
+	defer func() {
+		ldapAuthenticate = originalAuthenticate
+		ldapConfig.URL = ""
+	}()
+
+	handler := requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	get := func(username, password string) int {
+		req := httptest.NewRequest("GET", "/admin/stats", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	if code := get("alice", "secret"); code != http.StatusOK {
+		t.Errorf("expected admin to be let in, got %d", code)
+	}
+	get("alice", "secret")
+	if binds != 1 {
+		t.Errorf("expected the login to be cached, got %d binds", binds)
+	}
+	for _, creds := range [][2]string{{"alice", "wrong"}, {"bob", "secret"}, {"alice", ""}, {"", ""}} {
+		if code := get(creds[0], creds[1]); code != http.StatusUnauthorized {
+			t.Errorf("%q: expected 401, got %d", creds, code)
+		}
+	}
+
+	t.Setenv("LDAP_USER_FILTER", "(sAMAccountName=*)")
+	if err := setupLDAP(); err == nil {
+		t.Error("expected an error for a filter without placeholder")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// maxFilesPerRequest caps the number of files accepted from a single upload
+// request; zero means unlimited.
+var maxFilesPerRequest int
+
+func setupFileCountLimit() error {
+	maxFilesPerRequest = 0
+	value := os.Getenv("MAX_FILES_PER_REQUEST")
+	if value == "" {
+		return nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return fmt.Errorf("MAX_FILES_PER_REQUEST must be a positive number, got %q", value)
+	}
+	log.Printf("Accepting at most %d files per upload", limit)
+	maxFilesPerRequest = limit
+	return nil
+}
+
+// maxUploadSize caps the size of an upload request body; zero means
+// unlimited.
+var maxUploadSize int64
+
+func setupUploadSizeLimit() error {
+	maxUploadSize = 0
+	value := os.Getenv("MAX_UPLOAD_SIZE")
+	if value == "" {
+		return nil
+	}
+	size, err := parseSize(value)
+	if err != nil || size <= 0 {
+		return fmt.Errorf("MAX_UPLOAD_SIZE must be a positive size, got %q", value)
+	}
+	log.Printf("Accepting upload requests of at most %d bytes", size)
+	maxUploadSize = size
+	return nil
+}
+
+// rejectEmptyFiles rejects zero-byte files, which interrupted mobile uploads
+// leave behind, instead of storing them.
+var rejectEmptyFiles bool
+
+func setupEmptyFileRejection() {
+	rejectEmptyFiles = os.Getenv("REJECT_EMPTY_FILES") == "true"
+	if rejectEmptyFiles {
+		log.Println("Rejecting empty files")
+	}
+}
+
+// uploadSlots bounds the number of uploads processed at the same time, which
+// together with the per-upload buffers bounds the total memory use.
+var uploadSlots chan struct{}
+
+func setupConcurrencyLimit() error {
+	uploadSlots = nil
+	value := os.Getenv("MAX_CONCURRENT_UPLOADS")
+	if value == "" {
+		return nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return fmt.Errorf("MAX_CONCURRENT_UPLOADS must be a positive number, got %q", value)
+	}
+	log.Printf("Processing at most %d uploads concurrently", limit)
+	uploadSlots = make(chan struct{}, limit)
+	return nil
+}
+
+// busyRetryAfter is the wait suggested to clients when all upload slots are
+// taken. Most uploads finish within seconds, so a short wait spreads retries.
+const busyRetryAfter = 5 * time.Second
+
+// writeBusy answers a request that found no free upload slot.
+func writeBusy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", retryAfter(busyRetryAfter))
+	writeProblem(w, r, http.StatusServiceUnavailable, problemServerBusy, "Server busy, please try again shortly")
+}
+
+// acquireUploadSlot reserves a slot for an upload without blocking. The
+// returned function releases the slot again, even if the limit has been
+// reloaded in the meantime.
+func acquireUploadSlot() (func(), bool) {
+	configLock.RLock()
+	slots := uploadSlots
+	configLock.RUnlock()
+	if slots == nil {
+		return func() {}, true
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireUploadSlot(t *testing.T) {
+	uploadSlots = make(chan struct{}, 1)
+	defer func() { uploadSlots = nil }()
+
+	release, ok := acquireUploadSlot()
+	if !ok {
+		t.Fatal("Expected first slot to be available")
+	}
+	if _, ok := acquireUploadSlot(); ok {
+		t.Error("Expected second upload to be rejected while the slot is taken")
+	}
+	release()
+	if _, ok := acquireUploadSlot(); !ok {
+		t.Error("Expected slot to be available after release")
+	}
+}
+
+func TestUploadHandler_Busy(t *testing.T) {
+	uploadSlots = make(chan struct{}, 1)
+	uploadSlots <- struct{}{}
+	defer func() { uploadSlots = nil }()
+
+	req := httptest.NewRequest("POST", "/upload", nil)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" {
+		t.Errorf("expected 503 with Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+// readTracker records whether the body was read.
+type readTracker struct {
+	io.Reader
+	read bool
+}
+
+func (rt *readTracker) Read(p []byte) (int, error) {
+	rt.read = true
+	return rt.Reader.Read(p)
+}
+
+func TestUploadHandler_RejectsBeforeReadingBody(t *testing.T) {
+	turnstile := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+	}))
+	defer turnstile.Close()
+	originalURL := turnstileURL
+	turnstileURL = turnstile.URL
+	defer func() { turnstileURL = originalURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(uploadHandler))
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 10 * time.Second}}
+
+	body := &readTracker{Reader: strings.NewReader(strings.Repeat("x", 1<<20))}
+	req, _ := http.NewRequest("POST", server.URL, body)
+	req.ContentLength = 1 << 20
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	req.Header.Set("Expect", "100-continue")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", resp.StatusCode)
+	}
+	if body.read {
+		t.Error("expected the body not to be sent")
+	}
+}
+
+func TestUploadHandler_MaxUploadSize(t *testing.T) {
+	fakeTurnstile(t)
+	originalStorage := storage
+	storage = &MockStorage{}
+	maxUploadSize = 1000
+	defer func() {
+		storage = originalStorage
+		maxUploadSize = 0
+	}()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "photo.jpg")
+	part.Write(bytes.Repeat([]byte("x"), 2000))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", bytes.NewReader(body.Bytes()))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 from Content-Length, got %d", w.Code)
+	}
+
+	// Chunked requests are cut off while reading
+	req = httptest.NewRequest("POST", "/upload", bytes.NewReader(body.Bytes()))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w = httptest.NewRecorder()
+	uploadHandler(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 while reading, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestUploadHandler_FileCountLimit(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	maxFilesPerRequest = 2
+	defer func() { maxFilesPerRequest = 0 }()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for i := 0; i < 5; i++ {
+		part, _ := writer.CreateFormFile("file", fmt.Sprintf("photo%d.jpg", i))
+		part.Write([]byte("content"))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+
+	if w.Code != http.StatusPartialContent || !strings.Contains(w.Body.String(), "3 file(s) not uploaded: at most 2 files") {
+		t.Errorf("Expected remaining files to be rejected, got %d %q", w.Code, w.Body.String())
+	}
+	if len(mockStorage.files) != 2 {
+		t.Errorf("Expected 2 stored files, got %d", len(mockStorage.files))
+	}
+}
+
+func TestUploadHandler_RejectEmptyFiles(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	rejectEmptyFiles = true
+	defer func() {
+		storage = originalStorage
+		rejectEmptyFiles = false
+	}()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "photo.jpg")
+	part.Write([]byte("content"))
+	writer.CreateFormFile("file", "empty.jpg")
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+
+	if w.Code != http.StatusPartialContent || !strings.Contains(w.Body.String(), "empty.jpg: file is empty") {
+		t.Errorf("Expected the empty file to be rejected, got %d %q", w.Code, w.Body.String())
+	}
+	if len(mockStorage.files) != 1 {
+		t.Errorf("Expected 1 stored file, got %d", len(mockStorage.files))
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	store "go-uploader/storage"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxCachedFileSize bounds the metadata sidecars kept in the cache.
+const maxCachedFileSize = 64 << 10
+
+// listCache holds listings and metadata sidecars for a limited time.
+// Failures are treated as misses, the backend stays the source of truth.
+type listCache interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+	Delete(ctx context.Context, keys ...string)
+}
+
+func setupListCache() error {
+	value := os.Getenv("LIST_CACHE_TTL")
+	if value == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return fmt.Errorf("LIST_CACHE_TTL must be a positive duration, got %q", value)
+	}
+
+	var cache listCache
+	if url := os.Getenv("LIST_CACHE_REDIS_URL"); url != "" {
+		options, err := redis.ParseURL(url)
+		if err != nil {
+			return fmt.Errorf("parsing LIST_CACHE_REDIS_URL: %w", err)
+		}
+		client := redis.NewClient(options)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("connecting to Redis: %w", err)
+		}
+		log.Printf("Caching listings for %s in Redis at %s", ttl, options.Addr)
+		cache = &redisListCache{client: client}
+	} else {
+		log.Printf("Caching listings for %s in memory", ttl)
+		cache = newMemoryListCache()
+	}
+	storage = &cachedBackend{Backend: storage, cache: cache, ttl: ttl}
+	return nil
+}
+
+// cachedBackend caches the listings of folders and the metadata sidecars of
+// a backend. Files saved or deleted through it invalidate the listings of
+// all folders containing them right away; changes made by other replicas
+// show up after the TTL unless the cache is shared through Redis.
+type cachedBackend struct {
+	store.Backend
+	cache listCache
+	ttl   time.Duration
+	// generation changes with every invalidation, so a listing that ran
+	// concurrently with a write isn't cached after the write invalidated it
+	generation atomic.Uint64
+}
+
+func (b *cachedBackend) SaveFile(name string, data io.Reader) (store.SaveResult, error) {
+	result, err := b.Backend.SaveFile(name, data)
+	b.invalidate(name)
+	return result, err
+}
+
+func (b *cachedBackend) DeleteFile(name string) error {
+	err := b.Backend.DeleteFile(name)
+	b.invalidate(name)
+	return err
+}
+
+// ListFiles caches folder listings. Other prefixes aren't cached, since
+// invalidation only knows the folders of a file.
+func (b *cachedBackend) ListFiles(prefix string) ([]string, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		return b.Backend.ListFiles(prefix)
+	}
+	ctx := context.Background()
+	key := "list:" + prefix
+	if data, ok := b.cache.Get(ctx, key); ok {
+		var names []string
+		if json.Unmarshal(data, &names) == nil {
+			cacheRequests.WithLabelValues("list", "hit").Inc()
+			return names, nil
+		}
+	}
+	cacheRequests.WithLabelValues("list", "miss").Inc()
+
+	generation := b.generation.Load()
+	names, err := b.Backend.ListFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(names); err == nil && b.generation.Load() == generation {
+		b.cache.Set(ctx, key, data, b.ttl)
+	}
+	return names, nil
+}
+
+// OpenFile caches metadata sidecars, which listings with details read for
+// every file.
+func (b *cachedBackend) OpenFile(name string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(name, metaPrefix) {
+		return b.Backend.OpenFile(name)
+	}
+	ctx := context.Background()
+	key := "file:" + name
+	if data, ok := b.cache.Get(ctx, key); ok {
+		cacheRequests.WithLabelValues("metadata", "hit").Inc()
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	cacheRequests.WithLabelValues("metadata", "miss").Inc()
+
+	generation := b.generation.Load()
+	f, err := b.Backend.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxCachedFileSize+1))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if len(data) > maxCachedFileSize {
+		// Too large to cache, pass it on
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), f), f}, nil
+	}
+	f.Close()
+	if b.generation.Load() == generation {
+		b.cache.Set(ctx, key, data, b.ttl)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// invalidate removes name and the listings of every folder containing it.
+func (b *cachedBackend) invalidate(name string) {
+	b.generation.Add(1)
+	keys := []string{"file:" + name, "list:"}
+	for i, c := range name {
+		if c == '/' {
+			keys = append(keys, "list:"+name[:i+1])
+		}
+	}
+	b.cache.Delete(context.Background(), keys...)
+}
+
+// memoryListCache keeps entries in process.
+type memoryListCache struct {
+	sync.Mutex
+	entries   map[string]memoryListEntry
+	lastSweep time.Time
+}
+
+type memoryListEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func newMemoryListCache() *memoryListCache {
+	return &memoryListCache{entries: make(map[string]memoryListEntry)}
+}
+
+func (c *memoryListCache) Get(_ context.Context, key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *memoryListCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[key] = memoryListEntry{value: value, expires: now.Add(ttl)}
+}
+
+func (c *memoryListCache) Delete(_ context.Context, keys ...string) {
+	c.Lock()
+	defer c.Unlock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+}
+
+// redisListCache shares the cache between replicas, so uploads to one
+// invalidate the listings of all.
+type redisListCache struct {
+	client *redis.Client
+}
+
+const redisListCachePrefix = "go-uploader:cache:"
+
+func (c *redisListCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	data, err := c.client.Get(ctx, redisListCachePrefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Error reading cache entry %s: %v", logName(key), err)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *redisListCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	if err := c.client.Set(ctx, redisListCachePrefix+key, value, ttl).Err(); err != nil {
+		log.Printf("Error writing cache entry %s: %v", logName(key), err)
+	}
+}
+
+func (c *redisListCache) Delete(ctx context.Context, keys ...string) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = redisListCachePrefix + key
+	}
+	if err := c.client.Del(ctx, prefixed...).Err(); err != nil {
+		log.Printf("Error invalidating cache entries: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type countingStorage struct {
+	MockStorage
+	lists int
+	opens int
+}
+
+func (c *countingStorage) ListFiles(prefix string) ([]string, error) {
+	c.lists++
+	return c.MockStorage.ListFiles(prefix)
+}
+
+func (c *countingStorage) OpenFile(name string) (io.ReadCloser, error) {
+	c.opens++
+	return c.MockStorage.OpenFile(name)
+}
+
+func TestCachedBackend(t *testing.T) {
+	backend := &countingStorage{}
+	cached := &cachedBackend{Backend: backend, cache: newMemoryListCache(), ttl: time.Minute}
+
+	cached.SaveFile("party/alice/a.jpg", strings.NewReader("a"))
+	list := func(prefix string) []string {
+		t.Helper()
+		names, err := cached.ListFiles(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+
+	list("party/")
+	if names := list("party/"); len(names) != 1 || backend.lists != 1 {
+		t.Fatalf("expected a cached listing, got %v after %d lists", names, backend.lists)
+	}
+	list("party/al")
+	list("party/al")
+	if backend.lists != 3 {
+		t.Errorf("expected partial prefixes not to be cached, got %d lists", backend.lists)
+	}
+
+	list("")
+	cached.SaveFile("party/alice/b.jpg", strings.NewReader("b"))
+	if names := list("party/"); len(names) != 2 {
+		t.Errorf("expected the upload to invalidate the folder, got %v", names)
+	}
+	if names := list(""); len(names) != 2 {
+		t.Errorf("expected the upload to invalidate the root, got %v", names)
+	}
+	list("other/")
+	cached.DeleteFile("party/alice/a.jpg")
+	if names := list("party/alice/"); len(names) != 1 {
+		t.Errorf("expected the delete to invalidate the folder, got %v", names)
+	}
+	lists := backend.lists
+	list("other/")
+	if backend.lists != lists {
+		t.Error("expected unrelated folders to stay cached")
+	}
+
+	meta := metaPrefix + "party/alice/b.jpg.json"
+	cached.SaveFile(meta, strings.NewReader(`{"name":"b.jpg"}`))
+	for range 2 {
+		f, err := cached.OpenFile(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(f)
+		f.Close()
+		if string(data) != `{"name":"b.jpg"}` {
+			t.Errorf("unexpected metadata %q", data)
+		}
+	}
+	if backend.opens != 1 {
+		t.Errorf("expected metadata to be cached, got %d opens", backend.opens)
+	}
+	cached.DeleteFile(meta)
+	if _, err := cached.OpenFile(meta); err == nil {
+		t.Error("expected deleted metadata to be gone")
+	}
+}
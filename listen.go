@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// shutdownTimeout bounds how long a stopping server waits for uploads in
+// progress; it matches the server's read timeout.
+const shutdownTimeout = 5 * time.Minute
+
+// listen opens the listener for the public server. A socket passed by systemd
+// takes precedence, otherwise LISTEN_ADDR is used, which is either a TCP
+// address or a Unix socket path prefixed with "unix:". With PROXY_PROTOCOL,
+// connections may start with a PROXY protocol header naming the client.
+func listen() (net.Listener, error) {
+	listener, err := openListener()
+	if err != nil || os.Getenv("PROXY_PROTOCOL") != "true" {
+		return listener, err
+	}
+	log.Printf("Accepting PROXY protocol headers")
+	return &proxyproto.Listener{Listener: listener, ConnPolicy: proxyProtocolPolicy}, nil
+}
+
+// proxyProtocolPolicy believes PROXY protocol headers only from trusted
+// proxies, if any are configured, so clients connecting directly can't claim
+// another address. Without a header, the connecting address is used.
+func proxyProtocolPolicy(opts proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+	if len(trustedProxies) == 0 {
+		return proxyproto.USE, nil
+	}
+	host, _, err := net.SplitHostPort(opts.Upstream.String())
+	if err != nil {
+		host = opts.Upstream.String()
+	}
+	if isTrustedProxy(host) {
+		return proxyproto.USE, nil
+	}
+	return proxyproto.REJECT, nil
+}
+
+func openListener() (net.Listener, error) {
+	listener, err := systemdListener()
+	if listener != nil || err != nil {
+		return listener, err
+	}
+
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return listenUnix(path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	// Remove a socket left behind by a previous run, but never a regular file
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	mode, err := unixSocketMode()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// unixSocketMode reads UNIX_SOCKET_MODE, the octal permissions of the
+// socket.
+func unixSocketMode() (os.FileMode, error) {
+	value := os.Getenv("UNIX_SOCKET_MODE")
+	if value == "" {
+		return 0o660, nil
+	}
+	parsed, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid UNIX_SOCKET_MODE %q: %w", value, err)
+	}
+	return os.FileMode(parsed), nil
+}
+
+// systemdListener returns the socket passed by systemd socket activation, or
+// nil if the process wasn't socket activated.
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	if count > 1 {
+		log.Printf("systemd passed %d sockets, using only the first one", count)
+	}
+
+	// Don't pass the sockets on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(listenFDsStart, "systemd-socket")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("using systemd socket: %w", err)
+	}
+	return listener, nil
+}
+
+// serve runs the server until it fails or the process is asked to stop. On
+// SIGTERM or SIGINT, new connections are refused and uploads in progress are
+// given time to finish, so restarts don't break them.
+func serve(server *http.Server, listener net.Listener) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stop)
+
+	errs := make(chan error, 1)
+	go func() {
+		if tlsCertFile != "" {
+			errs <- server.ServeTLS(listener, tlsCertFile, tlsKeyFile)
+			return
+		}
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %v, waiting for uploads in progress to finish", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
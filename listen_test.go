@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pires/go-proxyproto"
+)
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uploader.sock")
+	os.Setenv("LISTEN_ADDR", "unix:"+path)
+	defer os.Unsetenv("LISTEN_ADDR")
+
+	listener, err := listen()
+	if err != nil {
+		t.Fatalf("listen() failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("socket not created: %v", err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("expected mode 0660, got %v", info.Mode().Perm())
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	conn.Close()
+	listener.Close()
+}
+
+func TestListen_ReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uploader.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep the socket file around like a crashed process would
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listenUnix(path)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced: %v", err)
+	}
+	listener.Close()
+}
+
+func TestListen_RefusesToReplaceFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.txt")
+	os.WriteFile(path, []byte("important"), 0o644)
+
+	if _, err := listenUnix(path); err == nil {
+		t.Fatal("expected an error for a regular file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "important" {
+		t.Error("regular file was modified")
+	}
+}
+
+func TestSystemdListener_OtherProcess(t *testing.T) {
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+
+	listener, err := systemdListener()
+	if listener != nil || err != nil {
+		t.Errorf("expected sockets for another process to be ignored, got %v, %v", listener, err)
+	}
+}
+
+func TestListen_ProxyProtocol(t *testing.T) {
+	t.Setenv("LISTEN_ADDR", "127.0.0.1:0")
+	t.Setenv("PROXY_PROTOCOL", "true")
+	listener, err := listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(clientIP(r)))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	get := func(header string) (string, error) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		conn.Write([]byte(header + "GET / HTTP/1.0\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	if ip, err := get("PROXY TCP4 198.51.100.7 127.0.0.1 5000 80\r\n"); err != nil || ip != "198.51.100.7" {
+		t.Errorf("expected the address from the header, got %q, %v", ip, err)
+	}
+	if ip, err := get(""); err != nil || ip != "127.0.0.1" {
+		t.Errorf("expected the connecting address without header, got %q, %v", ip, err)
+	}
+
+	// Headers from untrusted peers are refused
+	trustedProxies = []*net.IPNet{{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)}}
+	defer func() { trustedProxies = nil }()
+	for addr, want := range map[string]proxyproto.Policy{
+		"10.1.2.3:5000":     proxyproto.USE,
+		"198.51.100.7:5000": proxyproto.REJECT,
+	} {
+		upstream, _ := net.ResolveTCPAddr("tcp", addr)
+		if policy, _ := proxyProtocolPolicy(proxyproto.ConnPolicyOptions{Upstream: upstream}); policy != want {
+			t.Errorf("%s: got policy %v, want %v", addr, policy, want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+// fileDetails is a listed file with its recorded metadata.
+type fileDetails struct {
+	Path  string     `json:"path"`
+	Image *imageInfo `json:"image,omitempty"`
+	// Archived is set for files in the archive tier
+	Archived time.Time `json:"archived,omitzero"`
+}
+
+// fileListHandler lists the published files, optionally below a prefix such
+// as a session folder. With details=true, files are returned as objects
+// including the image dimensions and EXIF data recorded at upload.
+func fileListHandler(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.Trim(path.Clean("/"+r.URL.Query().Get("prefix")), "/")
+	if prefix != "" {
+		if isInternalPath(prefix) {
+			http.Error(w, "Invalid prefix", http.StatusBadRequest)
+			return
+		}
+		prefix += "/"
+	}
+
+	names, err := storage.ListFiles(prefix)
+	if err != nil {
+		requestLogf(r.Context(), "Error listing files: %v", err)
+		http.Error(w, "Failed to list files", http.StatusInternalServerError)
+		return
+	}
+	files := []string{}
+	for _, name := range names {
+		if !isInternalPath(name) {
+			files = append(files, name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("details") != "true" {
+		json.NewEncoder(w).Encode(files)
+		return
+	}
+	details := make([]fileDetails, 0, len(files))
+	for _, name := range files {
+		file := fileDetails{Path: name}
+		meta, err := loadMetadata(name)
+		if err == nil {
+			file.Image = meta.Image
+			file.Archived = meta.Archived
+		} else if !errors.Is(err, store.ErrNotFound) {
+			requestLogf(r.Context(), "Error loading metadata of %s: %v", logName(name), err)
+		}
+		details = append(details, file)
+	}
+	json.NewEncoder(w).Encode(details)
+}
+
+// fileDeleteHandler deletes a single published file and its metadata.
+func fileDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := cleanStoragePath(r.PathValue("path"))
+	if !ok {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	batch := newTrashBatch()
+	err := discardFile(name, batch)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = discardFile(metadataKey(name), batch)
+		if errors.Is(err, store.ErrNotFound) {
+			err = nil
+		}
+	}
+	if err != nil {
+		requestLogf(r.Context(), "Error deleting %s: %v", logName(name), err)
+		http.Error(w, "Failed to delete file", http.StatusInternalServerError)
+		return
+	}
+	if err := removeThumbnails(name); err != nil {
+		requestLogf(r.Context(), "Error removing thumbnails of %s: %v", logName(name), err)
+	}
+	requestLogf(r.Context(), "Deleted %s", logName(name))
+	recordAudit(r, "file.delete", name, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// storageStats counts the files in each storage area.
+type storageStats struct {
+	Sessions    int `json:"sessions"`
+	Files       int `json:"files"`
+	Pending     int `json:"pending"`
+	Quarantined int `json:"quarantined"`
+	Versions    int `json:"versions"`
+	Trash       int `json:"trash"`
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := storage.ListFiles("")
+	if err != nil {
+		requestLogf(r.Context(), "Error listing files: %v", err)
+		http.Error(w, "Failed to collect stats", http.StatusInternalServerError)
+		return
+	}
+
+	var stats storageStats
+	sessions := map[string]bool{}
+	for _, name := range names {
+		switch {
+		case strings.HasPrefix(name, pendingPrefix):
+			stats.Pending++
+		case strings.HasPrefix(name, quarantinePrefix):
+			if !strings.HasSuffix(name, reasonSuffix) {
+				stats.Quarantined++
+			}
+		case strings.HasPrefix(name, versionsPrefix):
+			stats.Versions++
+		case strings.HasPrefix(name, trashPrefix):
+			stats.Trash++
+		case isInternalPath(name):
+		default:
+			stats.Files++
+			if session, _, ok := strings.Cut(name, "/"); ok {
+				sessions[session] = true
+			}
+		}
+	}
+	stats.Sessions = len(sessions)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
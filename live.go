@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const livePurpose = "live"
+
+// liveToken protects the live feed. The feed is off when it is empty.
+var liveToken string
+
+// liveThumbnailSize is the longest side of thumbnails on the live feed.
+var liveThumbnailSize = 640
+
+// liveLinkExpiry is how long thumbnail links of the feed stay valid.
+const liveLinkExpiry = 24 * time.Hour
+
+// liveKeepAlive is how often an idle feed sends a comment, so proxies don't
+// close the connection.
+const liveKeepAlive = 30 * time.Second
+
+// liveImage is sent to feed clients for every newly published image.
+type liveImage struct {
+	Path         string    `json:"path"`
+	Session      string    `json:"session"`
+	Drop         string    `json:"drop,omitempty"`
+	Time         time.Time `json:"time"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+}
+
+// liveClaims is the signed payload of a thumbnail link.
+type liveClaims struct {
+	Path    string `json:"p"`
+	Expires int64  `json:"e"`
+}
+
+var liveFeed struct {
+	sync.Mutex
+	subscribers map[chan *liveImage]struct{}
+}
+
+func setupLive() error {
+	liveToken = os.Getenv("LIVE_TOKEN")
+	if liveToken == "" {
+		return nil
+	}
+	if value := os.Getenv("LIVE_THUMBNAIL_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > 1<<16 {
+			return fmt.Errorf("LIVE_THUMBNAIL_SIZE must be between 1 and %d, got %q", 1<<16, value)
+		}
+		liveThumbnailSize = size
+	}
+	mux.HandleFunc("GET /live/events", requireLiveToken(liveEventsHandler))
+	mux.HandleFunc("GET /live/thumbnails/{token}", liveThumbnailHandler)
+	log.Println("Serving the live upload feed at /live/events")
+	return setupSlideshow()
+}
+
+// requireLiveToken accepts the token as bearer token or, since EventSource
+// can't set headers, as "token" query parameter.
+func requireLiveToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(liveToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isDisplayableImage reports whether name is an image browsers can display.
+func isDisplayableImage(name string) bool {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	return strings.HasPrefix(contentType, "image/") && isInlineType(contentType)
+}
+
+// liveThumbnailURL returns a signed link to the thumbnail of name.
+func liveThumbnailURL(name string) (string, error) {
+	if link := imageServiceURL(name, liveThumbnailSize); link != "" {
+		return link, nil
+	}
+	token, err := signToken(livePurpose, liveClaims{Path: name, Expires: time.Now().Add(liveLinkExpiry).Unix()})
+	if err != nil {
+		return "", err
+	}
+	return publicURL("/live/thumbnails/" + token), nil
+}
+
+// publishLive sends a newly visible file to all feed clients and the
+// slideshow, if it is an image. Slow clients miss images rather than
+// delaying uploads.
+func publishLive(name, session, drop string) {
+	if liveToken == "" || !isDisplayableImage(name) {
+		return
+	}
+	addSlide(name)
+	thumbnail, err := liveThumbnailURL(name)
+	if err != nil {
+		log.Printf("Error signing live thumbnail link for %s: %v", logName(name), err)
+		return
+	}
+	event := &liveImage{
+		Path:         name,
+		Session:      session,
+		Drop:         drop,
+		Time:         time.Now().UTC(),
+		ThumbnailURL: thumbnail,
+	}
+
+	liveFeed.Lock()
+	defer liveFeed.Unlock()
+	for ch := range liveFeed.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func subscribeLive() chan *liveImage {
+	ch := make(chan *liveImage, 16)
+	liveFeed.Lock()
+	if liveFeed.subscribers == nil {
+		liveFeed.subscribers = make(map[chan *liveImage]struct{})
+	}
+	liveFeed.subscribers[ch] = struct{}{}
+	liveFeed.Unlock()
+	liveClients.Inc()
+	return ch
+}
+
+func unsubscribeLive(ch chan *liveImage) {
+	liveFeed.Lock()
+	delete(liveFeed.subscribers, ch)
+	liveFeed.Unlock()
+	liveClients.Dec()
+}
+
+// liveEventsHandler streams an "image" event per published image as
+// server-sent events.
+func liveEventsHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The feed stays open far longer than any response deadline
+	setWriteDeadline(w, r, time.Time{})
+
+	ch := subscribeLive()
+	defer unsubscribeLive(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	io.WriteString(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		requestLogf(r.Context(), "Live feed can't be streamed: %v", err)
+		return
+	}
+	requestLogf(r.Context(), "Live feed client connected from %s", logIP(clientIP(r)))
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			requestLogf(r.Context(), "Live feed client disconnected")
+			return
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case event := <-ch:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: image\ndata: %s\n\n", data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// liveThumbnailHandler serves a downscaled JPEG of a published image. Images
+// that can't be decoded are served as they are.
+func liveThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	var claims liveClaims
+	if err := parseToken(livePurpose, r.PathValue("token"), &claims); err != nil || !isDisplayableImage(claims.Path) {
+		http.NotFound(w, r)
+		return
+	}
+	if time.Now().Unix() > claims.Expires {
+		http.Error(w, "Link expired", http.StatusGone)
+		return
+	}
+	serveThumbnail(w, r, claims.Path, liveThumbnailSize)
+}
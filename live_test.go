@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveFeed(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	liveToken = "live-secret"
+	originalStorage := storage
+	storage = &MockStorage{files: map[string][]byte{
+		"session1/photo.jpg": jpegWithOrientation(t, 1200, 800, 6),
+	}}
+	defer func() {
+		storage = originalStorage
+		liveToken = ""
+		slides.paths = nil
+	}()
+
+	server := httptest.NewServer(requireLiveToken(liveEventsHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?token=wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %d for a wrong token, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "?token=live-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("unexpected first line %q", line)
+	}
+
+	publishLive("session1/notes.txt", "session1", "")
+	publishLive("session1/photo.jpg", "session1", "wedding")
+
+	var event liveImage
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "event: image\n" {
+			line, _ = reader.ReadString('\n')
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	if event.Path != "session1/photo.jpg" || event.Drop != "wedding" || !strings.HasPrefix(event.ThumbnailURL, "/live/thumbnails/") {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	req := httptest.NewRequest("GET", event.ThumbnailURL, nil)
+	req.SetPathValue("token", strings.TrimPrefix(event.ThumbnailURL, "/live/thumbnails/"))
+	w := httptest.NewRecorder()
+	liveThumbnailHandler(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("unexpected thumbnail response %d", w.Code)
+	}
+	img, err := jpeg.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rotated by the EXIF orientation and scaled to the thumbnail size
+	if img.Bounds().Dx() != 427 || img.Bounds().Dy() != 640 {
+		t.Errorf("unexpected thumbnail size %v", img.Bounds())
+	}
+
+	token, _ := signToken(livePurpose, liveClaims{Path: "session1/photo.jpg", Expires: time.Now().Add(-time.Minute).Unix()})
+	req.SetPathValue("token", token)
+	w = httptest.NewRecorder()
+	liveThumbnailHandler(w, req)
+	if w.Code != http.StatusGone {
+		t.Errorf("expected status %d for an expired link, got %d", http.StatusGone, w.Code)
+	}
+}
+
+func TestSlideshow(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	liveToken = "live-secret"
+	originalStorage := storage
+	storage = &MockStorage{files: map[string][]byte{
+		"2024-06-01_12-00-00.000/a.jpg":            []byte("a"),
+		"2024-06-01_13-00-00.000/b.png":            []byte("b"),
+		"2024-06-01_13-00-00.000/notes.txt":        []byte("c"),
+		"pending/2024-06-01_14-00-00.000/c.jpg":    []byte("d"),
+		"quarantine/2024-06-01_14-00-00.000/d.jpg": []byte("e"),
+		"2024-06-01_15-00-00.000/e.jpg":            []byte("f"),
+	}}
+	originalSize := slideshowSize
+	slideshowSize = 2
+	defer func() {
+		storage = originalStorage
+		liveToken = ""
+		slideshowSize = originalSize
+		slides.paths = nil
+	}()
+
+	seedSlides()
+	publishLive("2024-06-01_15-00-00.000/e.jpg", "2024-06-01_15-00-00.000", "")
+
+	w := httptest.NewRecorder()
+	requireLiveToken(slideshowImagesHandler)(w, httptest.NewRequest("GET", "/slideshow/images?token=live-secret", nil))
+	var images []slide
+	if err := json.Unmarshal(w.Body.Bytes(), &images); err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[0].Path != "2024-06-01_15-00-00.000/e.jpg" || images[1].Path != "2024-06-01_13-00-00.000/b.png" {
+		t.Errorf("expected the two latest public images, newest first, got %+v", images)
+	}
+
+	// Deleted images leave the slideshow
+	if err := discardFile("2024-06-01_13-00-00.000/b.png", newTrashBatch()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deleteSession("2024-06-01_15-00-00.000"); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	requireLiveToken(slideshowImagesHandler)(w, httptest.NewRequest("GET", "/slideshow/images?token=live-secret", nil))
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("expected deleted images to be removed, got %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	requireLiveToken(slideshowPageHandler)(w, httptest.NewRequest("GET", "/slideshow", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without token, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
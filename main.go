@@ -6,7 +6,6 @@ import (
 	"embed"
 	"errors"
 	"fmt"
-	"github.com/meyskens/go-turnstile"
 	store "go-uploader/storage"
 	"html/template"
 	"io"
@@ -16,27 +15,90 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	// The container image has no zoneinfo, TIMEZONE has to work anyway
+	_ "time/tzdata"
 
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/text/unicode/norm"
 )
 
 //go:embed public
 var staticFiles embed.FS
 
+//go:embed templates
+var templateFiles embed.FS
+var templates = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+
 var storage store.Backend
 var turnstileSecret string
 
+// mux holds the public routes; debugging endpoints are served separately
+var mux = http.NewServeMux()
+
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	err := godotenv.Load()
 	if err != nil {
 		log.Println("No .env file found, continuing...")
 	}
-	turnstileSecret = os.Getenv("TURNSTILE_SECRET")
-	if turnstileSecret == "" {
-		log.Fatal("TURNSTILE_SECRET environment variable is not set")
+	setupDevMode()
+	build := currentVersion()
+	log.Printf("Starting go-uploader %s (commit %s, built %s, %s)", build.Version, build.Commit, build.BuildDate, build.GoVersion)
+	setupSigning()
+	err = setupCaptcha()
+	if err != nil {
+		log.Fatalf("Failed to setup CAPTCHA: %v", err)
+	}
+
+	err = setupCaptchaSessions()
+	if err != nil {
+		log.Fatalf("Failed to setup CAPTCHA sessions: %v", err)
+	}
+
+	err = setupCaptchaBypass()
+	if err != nil {
+		log.Fatalf("Failed to setup CAPTCHA bypass: %v", err)
+	}
+
+	err = setupPrivacy()
+	if err != nil {
+		log.Fatalf("Failed to setup privacy mode: %v", err)
+	}
+
+	err = setupTrustedProxies()
+	if err != nil {
+		log.Fatalf("Failed to setup trusted proxies: %v", err)
+	}
+
+	err = setupBaseURL()
+	if err != nil {
+		log.Fatalf("Failed to setup base URL: %v", err)
+	}
+
+	err = setupOriginCheck()
+	if err != nil {
+		log.Fatalf("Failed to setup origin check: %v", err)
+	}
+
+	err = setupGeoIP()
+	if err != nil {
+		log.Fatalf("Failed to setup GeoIP: %v", err)
+	}
+
+	err = setupStorageBreaker()
+	if err != nil {
+		log.Fatalf("Failed to setup storage circuit breaker: %v", err)
 	}
 
 	err = setupStorage()
@@ -44,39 +106,250 @@ func main() {
 		log.Fatalf("Failed to setup storage: %v", err)
 	}
 
-	indexCache, files, err := buildIndexPage()
+	err = setupSizeRouting()
+	if err != nil {
+		log.Fatalf("Failed to setup size routing: %v", err)
+	}
+
+	err = setupArchive()
+	if err != nil {
+		log.Fatalf("Failed to setup archive: %v", err)
+	}
+
+	err = setupSpool()
+	if err != nil {
+		log.Fatalf("Failed to setup spool: %v", err)
+	}
+
+	err = setupDiskSpace()
+	if err != nil {
+		log.Fatalf("Failed to setup disk space check: %v", err)
+	}
+
+	err = setupTypeRouting()
+	if err != nil {
+		log.Fatalf("Failed to setup type routing: %v", err)
+	}
+	setupValidation()
+	setupExtensionBlocklist()
+
+	err = setupHashBlocklist()
+	if err != nil {
+		log.Fatalf("Failed to setup hash blocklist: %v", err)
+	}
+
+	setupExtensionFixing()
+	err = setupFilenameLength()
+	if err != nil {
+		log.Fatalf("Failed to setup filename length: %v", err)
+	}
+	err = setupSessionFolders()
+	if err != nil {
+		log.Fatalf("Failed to setup session folders: %v", err)
+	}
+	setupModeration()
+	setupConsent()
+	setupVersioning()
+
+	err = setupResizing()
+	if err != nil {
+		log.Fatalf("Failed to setup image resizing: %v", err)
+	}
+
+	err = setupContentModeration()
+	if err != nil {
+		log.Fatalf("Failed to setup content moderation: %v", err)
+	}
+
+	err = setupThrottling()
+	if err != nil {
+		log.Fatalf("Failed to setup throttling: %v", err)
+	}
+
+	err = setupConcurrencyLimit()
+	if err != nil {
+		log.Fatalf("Failed to setup concurrency limit: %v", err)
+	}
+
+	err = setupFileCountLimit()
+	if err != nil {
+		log.Fatalf("Failed to setup file count limit: %v", err)
+	}
+
+	err = setupUploadSizeLimit()
+	if err != nil {
+		log.Fatalf("Failed to setup upload size limit: %v", err)
+	}
+	setupEmptyFileRejection()
+
+	err = setupRateLimiting()
+	if err != nil {
+		log.Fatalf("Failed to setup rate limiting: %v", err)
+	}
+
+	err = setupIdempotency()
+	if err != nil {
+		log.Fatalf("Failed to setup idempotency: %v", err)
+	}
+
+	setupSessionChecksums()
+
+	err = setupReceiptPage()
+	if err != nil {
+		log.Fatalf("Failed to setup receipt page: %v", err)
+	}
+
+	err = setupHooks()
+	if err != nil {
+		log.Fatalf("Failed to setup hooks: %v", err)
+	}
+
+	err = setupPipeline()
+	if err != nil {
+		log.Fatalf("Failed to setup pipeline: %v", err)
+	}
+
+	err = setupEvents()
+	if err != nil {
+		log.Fatalf("Failed to setup events: %v", err)
+	}
+
+	setupMaintenance()
+
+	err = setupIntegrityCheck()
+	if err != nil {
+		log.Fatalf("Failed to setup integrity check: %v", err)
+	}
+
+	err = setupTrash()
+	if err != nil {
+		log.Fatalf("Failed to setup trash: %v", err)
+	}
+
+	err = setupShares()
+	if err != nil {
+		log.Fatalf("Failed to setup share links: %v", err)
+	}
+
+	err = setupThumbnails()
+	if err != nil {
+		log.Fatalf("Failed to setup thumbnails: %v", err)
+	}
+
+	err = setupImageService()
+	if err != nil {
+		log.Fatalf("Failed to setup image service: %v", err)
+	}
+
+	err = setupImport()
+	if err != nil {
+		log.Fatalf("Failed to setup URL import: %v", err)
+	}
+
+	err = setupEmail()
+	if err != nil {
+		log.Fatalf("Failed to setup email: %v", err)
+	}
+
+	err = setupLive()
+	if err != nil {
+		log.Fatalf("Failed to setup live feed: %v", err)
+	}
+
+	err = setupDrops()
+	if err != nil {
+		log.Fatalf("Failed to setup drops: %v", err)
+	}
+
+	err = setupTelegram()
+	if err != nil {
+		log.Fatalf("Failed to setup Telegram bot: %v", err)
+	}
+
+	err = setupListCache()
+	if err != nil {
+		log.Fatalf("Failed to setup listing cache: %v", err)
+	}
+
+	err = setupPprof()
+	if err != nil {
+		log.Fatalf("Failed to setup pprof: %v", err)
+	}
+
+	err = setupLDAP()
+	if err != nil {
+		log.Fatalf("Failed to setup LDAP: %v", err)
+	}
+
+	page, files, err := buildIndexPage()
 	if err != nil {
 		log.Fatalf("Failed to build index page: %v", err)
 	}
+	indexPage = page
+	setupReload()
 
 	// Serve static files
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	static := staticHandler(files)
+	index := pauseDuringMaintenance(func(w http.ResponseWriter, r *http.Request) {
+		configLock.RLock()
+		page := indexPage
+		configLock.RUnlock()
+		if devMode {
+			var err error
+			if page, _, err = buildIndexPage(); err != nil {
+				requestLogf(r.Context(), "Error rendering index page: %v", err)
+				http.Error(w, "Error rendering index page", http.StatusInternalServerError)
+				return
+			}
+		}
+		serveIndexPage(w, r, page)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" || r.URL.Path == "/index.html" {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(indexCache))
+			index(w, r)
 			return
 		}
-
-		fileServer := http.FileServer(http.FS(files))
-		fileServer.ServeHTTP(w, r)
+		static.ServeHTTP(w, r)
 	})
 
-	http.HandleFunc("/upload", uploadHandler)
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+	mux.HandleFunc("/upload", requireClientCert(pauseDuringMaintenance(withUploadDeadline(withIdempotency(uploadHandler)))))
+	setupAdmin()
+	setupSessionStatus()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("GET /version", versionHandler)
+	mux.HandleFunc("POST /receipts/verify", receiptVerifyHandler)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
-	// Create server with timeouts to handle slow/interrupted uploads
+	// Create server with timeouts to handle slow/interrupted uploads; write
+	// deadlines are set per route
 	server := &http.Server{
-		Addr:         ":8080",
-		ReadTimeout:  5 * time.Minute,  // Allow up to 5 minutes for reading request body
-		WriteTimeout: 30 * time.Second, // Response timeout
-		IdleTimeout:  60 * time.Second, // Keep-alive timeout
+		Handler:           withRequestID(withDevLogging(withRecovery(withResponseDeadline(mux)))),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       uploadReadTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	err = setupProtocols(server)
+	if err != nil {
+		log.Fatalf("Failed to setup protocols: %v", err)
 	}
 
-	log.Println("Server started on :8080")
-	log.Fatal(server.ListenAndServe())
+	listener, err := listen()
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+
+	log.Printf("Server started on %s", listener.Addr())
+	if devMode {
+		log.Printf("Upload page: %s", uploadPageURL(listener.Addr()))
+	}
+	if err := serve(server, listener); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
 }
 
 func setupStorage() error {
@@ -86,43 +359,220 @@ func setupStorage() error {
 		backend = "local"
 	}
 
-	var err error
-	if backend == "local" {
+	backendName = backend
+
+	b, err := newBackend(backend)
+	if err != nil {
+		return err
+	}
+	storage = guardBackend(backend, instrumentBackend(backend, b))
+	return nil
+}
+
+// setupSizeRouting stores files larger than LARGE_FILE_THRESHOLD in
+// LARGE_FILE_BACKEND, and smaller ones in BACKEND.
+func setupSizeRouting() error {
+	large := os.Getenv("LARGE_FILE_BACKEND")
+	if large == "" {
+		return nil
+	}
+	if large == backendName {
+		return fmt.Errorf("LARGE_FILE_BACKEND must differ from BACKEND %q", backendName)
+	}
+	value := os.Getenv("LARGE_FILE_THRESHOLD")
+	threshold, err := parseSize(value)
+	if err != nil || threshold <= 0 {
+		return fmt.Errorf("LARGE_FILE_THRESHOLD must be a positive size, got %q", value)
+	}
+	b, err := newBackend(large)
+	if err != nil {
+		return fmt.Errorf("LARGE_FILE_BACKEND: %w", err)
+	}
+	storage = &store.SizeRouter{Small: storage, Large: guardBackend(large, instrumentBackend(large, b)), Threshold: threshold}
+	log.Printf("Storing files larger than %s in the %s backend", formatSize(threshold), large)
+	return nil
+}
+
+// newBackend sets up the named storage backend from the environment.
+func newBackend(backend string) (store.Backend, error) {
+	switch backend {
+	case "local":
 		log.Println("Using local storage backend")
 		uploadDir := os.Getenv("LOCAL_PATH")
 		if uploadDir == "" {
 			log.Println("LOCAL_PATH environment variable not set, using default: ./uploads")
 			uploadDir = "./uploads"
 		}
-		storage, err = store.NewLocalStorage(uploadDir)
-	} else if backend == "s3" {
+		local, err := store.NewLocalStorage(uploadDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, setting := range []struct {
+			name  string
+			value *fs.FileMode
+		}{
+			{"LOCAL_FILE_MODE", &local.FileMode},
+			{"LOCAL_DIR_MODE", &local.DirMode},
+		} {
+			if value := os.Getenv(setting.name); value != "" {
+				mode, err := strconv.ParseUint(value, 8, 32)
+				if err != nil || mode == 0 || mode > 0777 {
+					return nil, fmt.Errorf("%s must be an octal mode like 0640, got %q", setting.name, value)
+				}
+				*setting.value = fs.FileMode(mode)
+			}
+		}
+		for _, setting := range []struct {
+			name  string
+			value *int
+		}{
+			{"LOCAL_UID", &local.UID},
+			{"LOCAL_GID", &local.GID},
+		} {
+			if value := os.Getenv(setting.name); value != "" {
+				id, err := strconv.Atoi(value)
+				if err != nil || id < 0 {
+					return nil, fmt.Errorf("%s must be a numeric ID, got %q", setting.name, value)
+				}
+				*setting.value = id
+			}
+		}
+		if local.FileMode != 0 || local.DirMode != 0 || local.UID != 0 || local.GID != 0 {
+			log.Printf("Creating files with mode %#o and directories with mode %#o, owned by %d:%d (0 keeps the default)", local.FileMode, local.DirMode, local.UID, local.GID)
+		}
+		return local, nil
+	case "s3":
 		log.Println("Using S3 storage backend")
-		storage, err = store.NewS3Storage("go-upload", "uploads")
-	}
-	if err != nil {
-		return err
+		s3, err := store.NewS3Storage("go-upload", "uploads")
+		if err != nil {
+			return nil, err
+		}
+		if value := os.Getenv("S3_PART_SIZE"); value != "" {
+			s3.PartSize, err = parseSize(value)
+			if err != nil || s3.PartSize < manager.MinUploadPartSize {
+				return nil, fmt.Errorf("S3_PART_SIZE must be at least 5MB, got %q", value)
+			}
+		}
+		if value := os.Getenv("S3_CONCURRENCY"); value != "" {
+			s3.Concurrency, err = strconv.Atoi(value)
+			if err != nil || s3.Concurrency < 1 {
+				return nil, fmt.Errorf("S3_CONCURRENCY must be a positive number, got %q", value)
+			}
+		}
+		s3.KMSKeyID = os.Getenv("S3_KMS_KEY_ID")
+		if s3.KMSKeyID != "" {
+			log.Println("Encrypting S3 uploads with SSE-KMS")
+		}
+		if mode := os.Getenv("S3_OBJECT_LOCK_MODE"); mode != "" {
+			s3.LockMode = types.ObjectLockMode(strings.ToUpper(mode))
+			if s3.LockMode != types.ObjectLockModeGovernance && s3.LockMode != types.ObjectLockModeCompliance {
+				return nil, fmt.Errorf("S3_OBJECT_LOCK_MODE must be GOVERNANCE or COMPLIANCE, got %q", mode)
+			}
+			s3.LockRetention, err = time.ParseDuration(os.Getenv("S3_OBJECT_LOCK_RETENTION"))
+			if err != nil || s3.LockRetention <= 0 {
+				return nil, fmt.Errorf("S3_OBJECT_LOCK_RETENTION must be a positive duration, got %q", os.Getenv("S3_OBJECT_LOCK_RETENTION"))
+			}
+			log.Printf("Locking S3 uploads in %s mode for %s", s3.LockMode, s3.LockRetention)
+		}
+		for _, setting := range []struct {
+			name  string
+			value *time.Duration
+		}{
+			{"S3_ATTEMPT_TIMEOUT", &s3.AttemptTimeout},
+			{"S3_OPERATION_TIMEOUT", &s3.OperationTimeout},
+		} {
+			if value := os.Getenv(setting.name); value != "" {
+				*setting.value, err = time.ParseDuration(value)
+				if err != nil || *setting.value <= 0 {
+					return nil, fmt.Errorf("%s must be a positive duration, got %q", setting.name, value)
+				}
+			}
+		}
+		if value := os.Getenv("S3_CHECKSUM_ALGORITHM"); value != "" {
+			s3.ChecksumAlgorithm = types.ChecksumAlgorithm(strings.ToUpper(value))
+			if !slices.Contains(s3.ChecksumAlgorithm.Values(), s3.ChecksumAlgorithm) {
+				return nil, fmt.Errorf("S3_CHECKSUM_ALGORITHM must be one of %v, got %q", s3.ChecksumAlgorithm.Values(), value)
+			}
+			log.Printf("Storing %s checksums with S3 uploads", s3.ChecksumAlgorithm)
+		}
+		if os.Getenv("S3_ACCELERATE") == "true" {
+			s3.UseAccelerate()
+			log.Println("Using the S3 Transfer Acceleration endpoint")
+		}
+		s3.RequesterPays = os.Getenv("S3_REQUESTER_PAYS") == "true"
+		if s3.RequesterPays {
+			log.Println("Accepting requester-pays charges for S3 requests")
+		}
+		createBucket := os.Getenv("S3_CREATE_BUCKET") == "true"
+		if err := s3.VerifyBucket(createBucket); err != nil {
+			return nil, err
+		}
+		log.Printf("Verified access to S3 bucket %s", s3.BucketName)
+		log.Printf("S3 uploads buffer up to %d bytes each", s3.PartSize*int64(s3.Concurrency+1))
+		return s3, nil
+	case "memory":
+		maxSize := int64(256 << 20)
+		if value := os.Getenv("MEMORY_MAX_SIZE"); value != "" {
+			size, err := parseSize(value)
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("MEMORY_MAX_SIZE must be a positive size, got %q", value)
+			}
+			maxSize = size
+		}
+		var ttl time.Duration
+		if value := os.Getenv("MEMORY_TTL"); value != "" {
+			var err error
+			ttl, err = time.ParseDuration(value)
+			if err != nil || ttl <= 0 {
+				return nil, fmt.Errorf("MEMORY_TTL must be a positive duration, got %q", value)
+			}
+		}
+		log.Printf("Using memory storage backend with up to %d bytes; files are lost on restart", maxSize)
+		if ttl > 0 {
+			log.Printf("Removing files from memory after %s", ttl)
+		}
+		return store.NewMemoryStorage(maxSize, ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", backend)
 	}
-	return nil
 }
 
 func buildIndexPage() (string, fs.FS, error) {
+	return renderIndexPage(captchaProvider, "", "/upload")
+}
+
+// renderIndexPage renders the upload page for a CAPTCHA provider, with the
+// default title if title is empty.
+func renderIndexPage(provider, title, uploadURL string) (string, fs.FS, error) {
+	if provider == "" {
+		provider = captchaProvider
+	}
+	if title == "" {
+		title = "Hochzeitsfotos"
+	}
 	siteKey := os.Getenv("TURNSTILE_SITEKEY")
-	if siteKey == "" {
-		log.Fatal("TURNSTILE_SITEKEY is not set")
+	if siteKey == "" && provider == "turnstile" {
+		return "", nil, fmt.Errorf("TURNSTILE_SITEKEY is not set")
 	}
 
-	contentFS, err := fs.Sub(staticFiles, "public")
+	contentFS, err := publicFiles()
 	if err != nil {
 		return "", nil, err
 	}
 
-	tmpl, err := template.ParseFS(contentFS, "index.html")
+	tmpl, err := template.New("index.html").Funcs(template.FuncMap{"asset": assetURL}).ParseFS(contentFS, "index.html")
 	if err != nil {
 		return "", nil, err
 	}
 	var buf bytes.Buffer
 	err = tmpl.Execute(&buf, map[string]string{
-		"SiteKey": siteKey,
+		"Captcha":   provider,
+		"SiteKey":   siteKey,
+		"Action":    turnstileAction,
+		"Title":     title,
+		"UploadURL": uploadURL,
+		"TermsURL":  termsURL,
+		"CSRFToken": csrfTokenPlaceholder,
 	})
 	if err != nil {
 		return "", nil, err
@@ -132,7 +582,7 @@ func buildIndexPage() (string, fs.FS, error) {
 
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		writeProblem(w, r, http.StatusMethodNotAllowed, problemMethodNotAllowed, "Only POST allowed")
 		return
 	}
 
@@ -144,39 +594,190 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	contentType := r.Header.Get("Content-Type")
 	mediaType, params, err := mime.ParseMediaType(contentType)
 	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
-		http.Error(w, "Invalid Content-Type", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, problemInvalidContentType, "Invalid Content-Type")
+		return
+	}
+	if err := checkOrigin(r); err != nil {
+		requestLogf(ctx, "Rejected cross-site upload from %s: %v", logIP(clientIP(r)), err)
+		writeProblem(w, r, http.StatusForbidden, problemOriginNotAllowed, "Uploads are only accepted from the upload page")
+		return
+	}
+	sessionID, ok := uploadSessionID(r)
+	if !ok {
+		writeProblem(w, r, http.StatusBadRequest, problemInvalidRequest, fmt.Sprintf("%s must be %d to 64 letters, digits, '-', '_' or '.'", uploadSessionHeader, minUploadSessionIDLength))
 		return
 	}
+	// Every response from here on refers to the session, failures included
+	w.Header().Set(uploadSessionHeader, sessionID)
 
-	ts := turnstile.New(turnstileSecret)
-	token := r.Header.Get("X-Turnstile-Token")
-	resp, err := ts.Verify(token, r.RemoteAddr)
-	if err != nil || !resp.Success {
-		http.Error(w, "CAPTCHA verification failed", http.StatusForbidden)
+	link, ok := parseUploadLink(r)
+	if !ok {
+		requestLogf(ctx, "Rejected upload from %s with an invalid upload link", logIP(clientIP(r)))
+		writeProblem(w, r, http.StatusUnauthorized, problemUnauthorized, "The upload link is invalid or has expired")
+		return
+	}
+	uploadLimit := maxUploadSize
+	if link != nil {
+		ctx = context.WithValue(ctx, uploadLinkKey{}, link)
+		r = r.WithContext(ctx)
+		if link.MaxBytes > 0 && (uploadLimit <= 0 || link.MaxBytes < uploadLimit) {
+			uploadLimit = link.MaxBytes
+		}
+	}
+
+	// Everything up to the CAPTCHA is checked from the headers alone. The body
+	// is only read afterwards, so clients sending "Expect: 100-continue" don't
+	// transmit it at all when rejected.
+	if uploadLimit > 0 && r.ContentLength > uploadLimit {
+		requestLogf(ctx, "Rejected upload of %d bytes from %s", r.ContentLength, logIP(clientIP(r)))
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, problemTooLarge, fmt.Sprintf("Uploads are limited to %d bytes per request", uploadLimit))
 		return
 	}
 
-	mr := multipart.NewReader(r.Body, params["boundary"])
+	// Guests would only wait for the backend timeout, and a retry shouldn't
+	// cost them their CAPTCHA or rate limit
+	if wait := uploadBreakerWait(ctx); wait > 0 {
+		writeStorageUnavailable(w, r, wait)
+		return
+	}
+
+	if !checkDiskSpace(ctx, r.ContentLength) {
+		requestLogf(ctx, "Rejected upload of %d bytes from %s, the disk is full", r.ContentLength, logIP(clientIP(r)))
+		writeStorageFull(w, r)
+		return
+	}
+
+	// Before CAPTCHA verification and rate limiting, blocked clients cost nothing
+	if location, ok := checkGeoIP(r); !ok {
+		requestLogf(ctx, "Blocked upload from %s in %s (AS%d)", logIP(clientIP(r)), location.Country, location.ASN)
+		writeProblem(w, r, http.StatusForbidden, problemLocationBlocked, "Uploads are not available from your location")
+		return
+	}
+	if wait, ok := checkRateLimit(r); !ok {
+		requestLogf(ctx, "Rate limit exceeded for %s", logIP(clientIP(r)))
+		w.Header().Set("Retry-After", retryAfter(wait))
+		writeProblem(w, r, http.StatusTooManyRequests, problemRateLimited, "Too many uploads, please try again later")
+		return
+	}
+	if wait, ok := checkQuota(r); !ok {
+		requestLogf(ctx, "Upload quota exhausted for %s", logIP(clientIP(r)))
+		w.Header().Set("Retry-After", retryAfter(wait))
+		writeProblem(w, r, http.StatusTooManyRequests, problemQuotaExceeded, "Upload limit reached, please try again later")
+		return
+	}
+
+	release, ok := acquireUploadSlot()
+	if !ok {
+		writeBusy(w, r)
+		return
+	}
+	defer release()
+
+	// Signed upload links and recently solved CAPTCHAs replace the CAPTCHA,
+	// allowlisted networks don't need one
+	if network := captchaBypassNetwork(r); link == nil && network != nil {
+		requestLogf(ctx, "CAPTCHA skipped for %s on allowlisted network %s", logIP(clientIP(r)), network)
+	} else if link == nil && !validCaptchaSession(r) {
+		if err := verifyCaptcha(r); err != nil {
+			requestLogf(ctx, "CAPTCHA verification failed for %s: %v", logIP(clientIP(r)), err)
+			writeProblem(w, r, http.StatusForbidden, problemCaptchaFailed, "CAPTCHA verification failed")
+			return
+		}
+		issueCaptchaSession(w, r)
+	}
+
+	configLock.RLock()
+	fileLimit := maxFilesPerRequest
+	configLock.RUnlock()
+	if d := requestDrop(ctx); d != nil && d.MaxFiles > 0 {
+		fileLimit = d.MaxFiles
+	}
+
+	body := r.Body
+	if uploadLimit > 0 {
+		// Chunked requests don't announce their size
+		body = http.MaxBytesReader(w, body, uploadLimit)
+	}
+	mr := multipart.NewReader(throttleUpload(ctx, body), params["boundary"])
 	saved := 0
 	failed := 0
+	rejected := 0
+	quarantined := 0
+	files := 0
+	skipped := 0
 	var lastError error
+	var fileErrors []string
+	consented, consentRecorded := false, false
+	csrfRequired, csrfValid := requiresCSRFToken(r), false
+	cancelled := false
+	var message string
+	// relativePath applies to the next file
+	var relativePath string
+	var stored []*pipelineFile
+	// accepted also holds files held back in quarantine, for the receipt
+	var accepted []*pipelineFile
 
 	now := time.Now()
-	subfolder := now.Format("2006-01-02_15-04-05.000")
+	folders := newSessionFolders(r, now)
+	subfolder := folders.Session
+	ctx, cancelUpload := context.WithCancelCause(ctx)
+	defer cancelUpload(nil)
+	tracker := trackSession(sessionID, subfolder, cancelUpload)
+
+	defer func() {
+		tracker.finish(saved, failed, cancelled)
+		if saved+failed > 0 {
+			emitEvent(&uploadEvent{Type: eventSessionCompleted, Session: subfolder, Drop: dropName(ctx), RequestID: requestID(ctx), Saved: saved, Failed: failed, Message: message})
+			runSessionHook(ctx, subfolder, saved, failed, message)
+		}
+		writeSessionChecksums(ctx, stored)
+		addToQuota(r, saved)
+		sessionDuration.Observe(time.Since(now).Seconds())
+		uploadSessions.WithLabelValues(sessionResult(saved, failed)).Inc()
+		uploadedFiles.WithLabelValues("saved").Add(float64(saved - quarantined))
+		uploadedFiles.WithLabelValues("quarantined").Add(float64(quarantined))
+		uploadedFiles.WithLabelValues("rejected").Add(float64(rejected))
+		uploadedFiles.WithLabelValues("failed").Add(float64(failed - rejected))
+	}()
+
+	// abortCancelled discards the stored files if the client cancelled the
+	// upload through the session API
+	abortCancelled := func() bool {
+		if !errors.Is(context.Cause(ctx), errUploadCancelled) {
+			return false
+		}
+		cancelled = true
+		removed := removeUpload(ctx, accepted)
+		requestLogf(ctx, "Upload session %s cancelled by the client, removed %d file(s)", subfolder, removed)
+		recordAudit(r, "session.cancel", subfolder, map[string]any{"session_id": sessionID, "removed": removed})
+		failed += saved
+		saved, quarantined = 0, 0
+		stored, accepted = nil, nil
+		writeProblem(w, r, http.StatusConflict, problemCancelled, "Upload cancelled")
+		return true
+	}
 
-	log.Printf("Starting upload session: %s", subfolder)
+	requestLogf(ctx, "Starting upload session %s (ID %s) from %s", subfolder, sessionID, logIP(clientIP(r)))
 
 	for {
 		// Check context for timeout/cancellation
 		select {
 		case <-ctx.Done():
-			log.Printf("Upload cancelled or timed out for session %s: %v", subfolder, ctx.Err())
+			if abortCancelled() {
+				return
+			}
+			requestLogf(ctx, "Upload cancelled or timed out for session %s: %v", subfolder, ctx.Err())
 			if saved > 0 {
 				// Partial success - inform client
-				w.WriteHeader(http.StatusPartialContent)
-				w.Write([]byte(fmt.Sprintf("Upload partially completed: %d file(s) uploaded, %d failed due to timeout", saved, failed)))
+				writeUploadResult(w, r, http.StatusPartialContent, uploadResponse{
+					Message:   fmt.Sprintf("Upload partially completed: %d file(s) uploaded, %d failed due to timeout", saved, failed),
+					Session:   subfolder,
+					SessionID: sessionID,
+					Saved:     saved,
+					Failed:    failed,
+				}, accepted)
 			} else {
-				http.Error(w, "Upload timed out", http.StatusRequestTimeout)
+				writeProblem(w, r, http.StatusRequestTimeout, problemTimeout, "Upload timed out")
 			}
 			return
 		default:
@@ -184,17 +785,17 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 
 		part, err := mr.NextPart()
 		if err == io.EOF {
-			log.Printf("Upload session %s completed normally", subfolder)
+			requestLogf(ctx, "Upload session %s completed normally", subfolder)
 			break
 		}
 		if err != nil {
-			log.Printf("Error reading multipart data in session %s: %v", subfolder, err)
+			requestLogf(ctx, "Error reading multipart data in session %s: %v", subfolder, err)
 			lastError = err
 
 			// Check if this is an unexpected EOF (connection dropped)
 			if errors.Is(err, io.ErrUnexpectedEOF) || strings.Contains(err.Error(), "unexpected EOF") {
 				failed++
-				log.Printf("Connection interrupted during upload in session %s", subfolder)
+				requestLogf(ctx, "Connection interrupted during upload in session %s", subfolder)
 				// Don't break immediately - there might be more data
 				continue
 			}
@@ -202,56 +803,193 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 			// For other errors, break the loop
 			break
 		}
-		defer part.Close()
-
 		if part.FileName() == "" {
+			switch part.FormName() {
+			case csrfField:
+				csrfValid = readCSRFToken(r, part)
+			case consentField:
+				consented = readConsent(part)
+			case messageField:
+				message = readMessage(part)
+			case relativePathField:
+				relativePath = readRelativePath(part)
+			}
+			part.Close()
 			continue
 		}
 
-		filename := filepath.Join(subfolder, sanitizeFilename(part.FileName()))
-		log.Printf("Saving file: %s", filename)
+		if csrfRequired {
+			// The token has to come before the files, like the consent
+			if !csrfValid {
+				part.Close()
+				requestLogf(ctx, "Rejected form upload from %s without a valid CSRF token", logIP(clientIP(r)))
+				writeProblem(w, r, http.StatusForbidden, problemCSRFFailed, "The form has expired, please reload the page and try again")
+				return
+			}
+			csrfRequired = false
+		}
+
+		if termsURL != "" && !consentRecorded {
+			// The consent field has to come before the files
+			if !consented {
+				part.Close()
+				requestLogf(ctx, "Rejected upload session %s without consent to the terms", subfolder)
+				writeProblem(w, r, http.StatusForbidden, problemConsentRequired, "Please accept the terms of use before uploading")
+				return
+			}
+			if err := saveConsent(r, subfolder); err != nil {
+				part.Close()
+				requestLogf(ctx, "Error saving consent for session %s: %v", subfolder, err)
+				writeProblem(w, r, http.StatusInternalServerError, problemInternal, "Failed to record consent, please try again")
+				return
+			}
+			consentRecorded = true
+		}
 
-		if err := storage.SaveFile(filename, part); err != nil {
-			log.Printf("Error saving file %s in session %s: %v", filename, subfolder, err)
+		files++
+		dir := relativeDir(relativePath)
+		relativePath = ""
+		tracker.receiving(part.FileName())
+		if fileLimit > 0 && files > fileLimit {
+			// Keep draining so the client receives a proper response
+			part.Close()
 			failed++
-			lastError = err
+			rejected++
+			skipped++
+			tracker.received("rejected", saved, failed)
 			continue
 		}
-		saved++
-		log.Printf("Successfully saved file: %s", filename)
+
+		folder, body := folders.forFile(part)
+		result := storeUpload(ctx, folder, dir, part.FileName(), part.Header.Get("Content-Type"), body)
+		part.Close()
+		auditBlockedHash(r, result)
+		switch result.outcome {
+		case outcomeRejected:
+			failed++
+			rejected++
+			fileErrors = append(fileErrors, fmt.Sprintf("%s: %s", part.FileName(), result.message))
+			tracker.received("rejected", saved, failed)
+		case outcomeFailed:
+			failed++
+			lastError = result.err
+			fileErrors = append(fileErrors, fmt.Sprintf("%s: %s", part.FileName(), result.message))
+			tracker.received("failed", saved, failed)
+		case outcomeQuarantined:
+			saved++
+			quarantined++
+			accepted = append(accepted, result.file)
+			tracker.received(result.file.Status, saved, failed)
+		default:
+			saved++
+			stored = append(stored, result.file)
+			accepted = append(accepted, result.file)
+			tracker.received(result.file.Status, saved, failed)
+		}
+	}
+
+	if abortCancelled() {
+		return
+	}
+	requestLogf(ctx, "Upload session %s summary: %d saved, %d failed", subfolder, saved, failed)
+
+	if message != "" && saved > 0 {
+		if err := saveMessage(r, subfolder, message); err != nil {
+			requestLogf(ctx, "Error saving message for session %s: %v", subfolder, err)
+		}
+	}
+
+	if skipped > 0 {
+		requestLogf(ctx, "Skipped %d file(s) in session %s over the limit of %d", skipped, subfolder, fileLimit)
+		fileErrors = append(fileErrors, fmt.Sprintf("%d file(s) not uploaded: at most %d files are allowed per upload", skipped, fileLimit))
 	}
 
-	log.Printf("Upload session %s summary: %d saved, %d failed", subfolder, saved, failed)
+	details := ""
+	if len(fileErrors) > 0 {
+		details = "\n" + strings.Join(fileErrors, "\n")
+	}
 
+	var tooLarge *http.MaxBytesError
 	if saved == 0 {
-		if lastError != nil {
+		if rejected > 0 && rejected == failed {
+			// Retrying won't help, the files themselves are not accepted
+			sendProblem(w, r, problem{Type: problemFilesRejected, Status: http.StatusUnprocessableEntity, Detail: "No files uploaded", Errors: fileErrors})
+		} else if errors.Is(lastError, syscall.ENOSPC) {
+			writeStorageFull(w, r)
+		} else if errors.Is(lastError, errStorageUnavailable) {
+			writeStorageUnavailable(w, r, breakerCooldown)
+		} else if errors.As(lastError, &tooLarge) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, problemTooLarge, fmt.Sprintf("Uploads are limited to %d bytes per request", tooLarge.Limit))
+		} else if lastError != nil {
 			if errors.Is(lastError, io.ErrUnexpectedEOF) || strings.Contains(lastError.Error(), "unexpected EOF") {
-				http.Error(w, "Upload failed due to connection issues. Please check your internet connection and try again.", http.StatusBadRequest)
+				writeProblem(w, r, http.StatusBadRequest, problemUploadFailed, "Upload failed due to connection issues. Please check your internet connection and try again.")
 			} else {
-				http.Error(w, fmt.Sprintf("Upload failed: %v", lastError), http.StatusBadRequest)
+				writeProblem(w, r, http.StatusBadRequest, problemUploadFailed, fmt.Sprintf("Upload failed: %v", lastError))
 			}
 		} else {
-			http.Error(w, "No files uploaded", http.StatusBadRequest)
+			writeProblem(w, r, http.StatusBadRequest, problemUploadFailed, "No files uploaded")
 		}
 		return
 	}
 
+	resp := uploadResponse{Session: subfolder, SessionID: sessionID, Saved: saved, Failed: failed}
 	if failed > 0 {
 		// Partial success
-		w.WriteHeader(http.StatusPartialContent)
-		w.Write([]byte(fmt.Sprintf("Partially successful: %d file(s) uploaded, %d failed", saved, failed)))
+		resp.Message = fmt.Sprintf("Partially successful: %d file(s) uploaded, %d failed", saved, failed) + details
+		writeUploadResult(w, r, http.StatusPartialContent, resp, accepted)
 	} else {
 		// Complete success
-		w.WriteHeader(http.StatusCreated)
-		w.Write([]byte(fmt.Sprintf("Uploaded %d file(s)", saved)))
+		resp.Message = fmt.Sprintf("Uploaded %d file(s)", saved)
+		writeUploadResult(w, r, http.StatusCreated, resp, accepted)
 	}
 }
 
+// sanitizeFilename removes path separators and makes the name portable: it
+// is normalized to NFC, so the decomposed names macOS sends match the same
+// name from other systems, and names Windows can't create are changed, so
+// downloaded archives extract cleanly there.
 func sanitizeFilename(name string) string {
-	return strings.Map(func(r rune) rune {
+	name = strings.Map(func(r rune) rune {
 		if r == '/' || r == '\\' || r == ':' {
 			return -1
 		}
 		return r
-	}, name)
+	}, norm.NFC.String(name))
+
+	// Windows drops trailing dots and spaces
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "unnamed"
+	}
+	if isReservedWindowsName(name) {
+		return "_" + name
+	}
+	return name
+}
+
+// isReservedWindowsName reports whether name is a device name like CON or
+// COM1, which Windows reserves with any extension.
+func isReservedWindowsName(name string) bool {
+	base, _, _ := strings.Cut(name, ".")
+	switch strings.ToUpper(strings.TrimRight(base, " ")) {
+	case "CON", "PRN", "AUX", "NUL",
+		"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+		"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9":
+		return true
+	}
+	return false
+}
+
+// contextReader fails reads once the context is done, so backends abort and
+// clean up a save that is still in progress when the upload is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
 }
@@ -8,8 +8,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
+
+	store "go-uploader/storage"
 )
 
 // Mock storage for testing
@@ -19,24 +25,51 @@ type MockStorage struct {
 	saveErr error
 }
 
-func (m *MockStorage) SaveFile(name string, data io.Reader) error {
+func (m *MockStorage) SaveFile(name string, data io.Reader) (store.SaveResult, error) {
 	if m.files == nil {
 		m.files = make(map[string][]byte)
 	}
 
 	if name == m.failOn {
-		return m.saveErr
+		return store.SaveResult{}, m.saveErr
 	}
 
 	content, err := io.ReadAll(data)
 	if err != nil {
-		return err
+		return store.SaveResult{}, err
 	}
 
 	m.files[name] = content
+	return store.SaveResult{Size: int64(len(content)), Ref: "mock://" + name}, nil
+}
+
+func (m *MockStorage) OpenFile(name string) (io.ReadCloser, error) {
+	content, ok := m.files[name]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (m *MockStorage) DeleteFile(name string) error {
+	if _, ok := m.files[name]; !ok {
+		return store.ErrNotFound
+	}
+	delete(m.files, name)
 	return nil
 }
 
+func (m *MockStorage) ListFiles(prefix string) ([]string, error) {
+	var names []string
+	for name := range m.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 func TestUploadHandler_TimeoutHandling(t *testing.T) {
 	// Setup
 	mockStorage := &MockStorage{}
@@ -138,6 +171,13 @@ func TestSanitizeFilename(t *testing.T) {
 		{"windows\\path.txt", "windowspath.txt"},
 		{"with:colon.txt", "withcolon.txt"},
 		{"../../../etc/passwd", "......etcpasswd"},
+		{"Cafe\u0301.jpg", "Caf\u00e9.jpg"},
+		{"photo.jpg. ", "photo.jpg"},
+		{"..", "unnamed"},
+		{"CON", "_CON"},
+		{"nul.txt", "_nul.txt"},
+		{"com1 .tar.gz", "_com1 .tar.gz"},
+		{"CONSOLE.txt", "CONSOLE.txt"},
 	}
 
 	for _, test := range tests {
@@ -171,3 +211,75 @@ func TestServerTimeouts(t *testing.T) {
 		t.Fatalf("buildIndexPage() failed: %v", err)
 	}
 }
+
+func TestTruncateFilename(t *testing.T) {
+	long := strings.Repeat("a", 300) + ".jpg"
+	truncated := truncateFilename(long)
+	if len(truncated) != maxFilenameLength || !strings.HasSuffix(truncated, ".jpg") {
+		t.Errorf("unexpected truncation %q (%d bytes)", truncated, len(truncated))
+	}
+	if other := truncateFilename(strings.Repeat("a", 300) + "b.jpg"); other == truncated {
+		t.Error("expected names differing at the end to stay distinct")
+	}
+
+	umlauts := truncateFilename(strings.Repeat("ä", 150) + ".png")
+	if !utf8.ValidString(umlauts) || len(umlauts) > maxFilenameLength {
+		t.Errorf("unexpected truncation %q (%d bytes)", umlauts, len(umlauts))
+	}
+	if name := truncateFilename("short.jpg"); name != "short.jpg" {
+		t.Errorf("expected short names to be kept, got %q", name)
+	}
+}
+
+func TestRelativeDir(t *testing.T) {
+	for _, test := range []struct {
+		path, want string
+	}{
+		{"IMG_0001.jpg", ""},
+		{"shoot/day1/IMG_0001.jpg", "shoot/day1"},
+		{`shoot\day1\IMG_0001.jpg`, "shoot/day1"},
+		{"../../etc/passwd", "etc"},
+		{"/abs//./shoot/CON/x.jpg", "abs/shoot/_CON"},
+		{"a/b/c/d/e/f/g/x.jpg", "a/b/c/d/e_f_g"},
+	} {
+		if got := relativeDir(test.path); got != test.want {
+			t.Errorf("relativeDir(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestUploadHandler_FolderUpload(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("relative_path", "shoot/ceremony/IMG_0001.jpg")
+	part, _ := writer.CreateFormFile("file", "IMG_0001.jpg")
+	part.Write([]byte("first"))
+	part, _ = writer.CreateFormFile("file", "loose.jpg")
+	part.Write([]byte("second"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var names []string
+	for name := range mockStorage.files {
+		if !strings.HasPrefix(name, metaPrefix) {
+			names = append(names, name[strings.Index(name, "/")+1:])
+		}
+	}
+	sort.Strings(names)
+	if !slices.Equal(names, []string{"loose.jpg", "shoot/ceremony/IMG_0001.jpg"}) {
+		t.Errorf("expected the folder to be recreated for the first file only, got %v", names)
+	}
+}
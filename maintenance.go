@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+const defaultMaintenanceMessage = "Uploads sind gerade pausiert. Bitte versuche es in ein paar Minuten noch einmal."
+
+// maintenanceFile enables maintenance mode while it exists, so it can be
+// toggled from the host with touch and rm.
+var maintenanceFile string
+
+// maintenance is the state toggled through the admin API.
+var maintenance struct {
+	sync.Mutex
+	enabled bool
+	message string
+}
+
+type maintenanceStatus struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+}
+
+func setupMaintenance() {
+	maintenanceFile = os.Getenv("MAINTENANCE_FILE")
+	if maintenanceFile != "" {
+		log.Printf("Pausing uploads while %s exists", maintenanceFile)
+	}
+}
+
+// maintenanceMode reports whether uploads are paused and the message shown to
+// guests.
+func maintenanceMode() (bool, string) {
+	maintenance.Lock()
+	enabled, message := maintenance.enabled, maintenance.message
+	maintenance.Unlock()
+
+	if !enabled && maintenanceFile != "" {
+		if _, err := os.Stat(maintenanceFile); err == nil {
+			enabled = true
+		}
+	}
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	return enabled, message
+}
+
+// pauseDuringMaintenance answers with 503 instead of calling next while
+// maintenance mode is on. Health checks, metrics and the admin API are not
+// wrapped and keep working.
+func pauseDuringMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enabled, message := maintenanceMode()
+		if !enabled {
+			next(w, r)
+			return
+		}
+		if r.Method == http.MethodPost {
+			writeProblem(w, r, http.StatusServiceUnavailable, problemMaintenance, message)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := pageTemplates().ExecuteTemplate(w, "maintenance.html", message); err != nil {
+			log.Printf("Error rendering maintenance page: %v", err)
+		}
+	}
+}
+
+func maintenanceStatusHandler(w http.ResponseWriter, _ *http.Request) {
+	enabled, message := maintenanceMode()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(maintenanceStatus{Enabled: enabled, Message: message})
+}
+
+func maintenanceUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var req maintenanceStatus
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	maintenance.Lock()
+	maintenance.enabled = req.Enabled
+	maintenance.message = req.Message
+	maintenance.Unlock()
+
+	if req.Enabled {
+		requestLogf(r.Context(), "Maintenance mode enabled, uploads are paused")
+	} else {
+		requestLogf(r.Context(), "Maintenance mode disabled")
+	}
+	recordAudit(r, "maintenance.update", "maintenance", map[string]any{"enabled": req.Enabled, "message": req.Message})
+	maintenanceStatusHandler(w, r)
+}
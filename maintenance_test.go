@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPauseDuringMaintenance_AdminToggle(t *testing.T) {
+	oldStorage := storage
+	storage = &MockStorage{}
+	defer func() {
+		storage = oldStorage
+		maintenance.enabled = false
+		maintenance.message = ""
+	}()
+
+	called := false
+	handler := pauseDuringMaintenance(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	w := httptest.NewRecorder()
+	maintenanceUpdateHandler(w, httptest.NewRequest("PUT", "/admin/maintenance", strings.NewReader(`{"enabled": true, "message": "Back after the speeches"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/upload", nil))
+	if called || w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected upload to be paused, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Back after the speeches") {
+		t.Errorf("expected custom message, got %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "<html") {
+		t.Errorf("expected maintenance page, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	maintenanceUpdateHandler(w, httptest.NewRequest("PUT", "/admin/maintenance", strings.NewReader(`{"enabled": false}`)))
+	handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/upload", nil))
+	if !called {
+		t.Error("expected uploads to resume")
+	}
+}
+
+func TestMaintenanceMode_File(t *testing.T) {
+	maintenanceFile = filepath.Join(t.TempDir(), "maintenance")
+	defer func() { maintenanceFile = "" }()
+
+	if enabled, _ := maintenanceMode(); enabled {
+		t.Fatal("expected maintenance mode to be off")
+	}
+	os.WriteFile(maintenanceFile, nil, 0o644)
+	enabled, message := maintenanceMode()
+	if !enabled || message != defaultMaintenanceMessage {
+		t.Errorf("expected maintenance mode with default message, got %v %q", enabled, message)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	store "go-uploader/storage"
+)
+
+// messageField is the form field of the optional note guests leave with
+// their upload, e.g. "Congrats from Table 7!".
+const messageField = "message"
+
+// maxMessageLength limits messages, in characters.
+const maxMessageLength = 500
+
+// sessionMessage is the note left with an upload session.
+type sessionMessage struct {
+	Session   string    `json:"session"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// messageKey has no .json suffix, like consentKey, so it can't collide with
+// the metadata of an uploaded file.
+func messageKey(session string) string {
+	return metaPrefix + session + "/message"
+}
+
+// readMessage reads a message form field, dropping control characters
+// other than line breaks and cutting it to maxMessageLength.
+func readMessage(part io.Reader) string {
+	value, _ := io.ReadAll(io.LimitReader(part, maxMessageLength*4))
+	message := strings.Map(func(r rune) rune {
+		if r == '\r' || (unicode.IsControl(r) && r != '\n') {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(string(value), ""))
+	if runes := []rune(message); len(runes) > maxMessageLength {
+		message = string(runes[:maxMessageLength])
+	}
+	return strings.TrimSpace(message)
+}
+
+// saveMessage stores the message next to the metadata of a session. A later
+// upload to a shared session folder replaces it.
+func saveMessage(r *http.Request, session, message string) error {
+	data, err := json.Marshal(sessionMessage{
+		Session:   session,
+		Time:      time.Now().UTC(),
+		Message:   message,
+		RequestID: requestID(r.Context()),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = storage.SaveFile(messageKey(session), bytes.NewReader(data))
+	return err
+}
+
+// loadMessage returns the message left with a session, if any.
+func loadMessage(session string) (string, error) {
+	f, err := storage.OpenFile(messageKey(session))
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var record sessionMessage
+	if err := json.NewDecoder(f).Decode(&record); err != nil {
+		return "", err
+	}
+	return record.Message, nil
+}
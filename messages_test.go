@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadHandler_Message(t *testing.T) {
+	fakeTurnstile(t)
+	signingSecret = []byte("test-secret")
+	originalStorage := storage
+	storage = &MockStorage{}
+	defer func() { storage = originalStorage }()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "photo.jpg")
+	part.Write([]byte("photo"))
+	// Fields may follow the files
+	writer.WriteField("message", "  Congrats from <b>Table 7</b>!\x1b\r\n  ")
+	writer.Close()
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the upload to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp uploadResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	session := resp.Session
+	message, err := loadMessage(session)
+	if err != nil || message != "Congrats from <b>Table 7</b>!" {
+		t.Fatalf("expected the cleaned up message, got %q, %v", message, err)
+	}
+
+	_, share := createShare(t, `{"path":"`+session+`"}`)
+	page := getShare(share.URL).Body.String()
+	if !strings.Contains(page, "Congrats from &lt;b&gt;Table 7&lt;/b&gt;!") {
+		t.Errorf("expected the escaped message on the share page: %s", page)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	long := strings.Repeat("ä", maxMessageLength+10)
+	if got := readMessage(strings.NewReader(long)); len([]rune(got)) != maxMessageLength {
+		t.Errorf("expected the message to be cut to %d characters, got %d", maxMessageLength, len([]rune(got)))
+	}
+	if got := readMessage(strings.NewReader("line one\nline\ttwo\xff")); got != "line one\nlinetwo" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const metaPrefix = "meta/"
+
+// fileMetadata is stored as a JSON sidecar under the meta/ prefix, keyed by
+// the public path of the file so it stays valid while the file moves between
+// the pending, quarantine and public areas.
+type fileMetadata struct {
+	Path string `json:"path"`
+	// OriginalName is the name the file was uploaded as, if it was
+	// sanitized, made unique, truncated or its extension was corrected
+	OriginalName string `json:"original_name,omitempty"`
+	// ContentType is the type the client gave for the file, if it differs
+	// from the one its extension suggests
+	ContentType string            `json:"content_type,omitempty"`
+	Moderation  *moderationResult `json:"moderation,omitempty"`
+	SHA256      string            `json:"sha256,omitempty"`
+	Image       *imageInfo        `json:"image,omitempty"`
+	// Session and Uploaded are recorded with archiving, which moves
+	// sessions by the time of their last upload
+	Session  string    `json:"session,omitempty"`
+	Uploaded time.Time `json:"uploaded,omitzero"`
+	// Archived is when the file moved to the archive tier
+	Archived time.Time `json:"archived,omitzero"`
+}
+
+// imageInfo describes a stored image, so galleries can lay it out without
+// downloading it.
+type imageInfo struct {
+	// Width and Height are the displayed dimensions, with the EXIF
+	// orientation applied
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Taken is the capture time in the camera's local time, e.g.
+	// "2024-06-01T14:30:00"
+	Taken  string `json:"taken,omitempty"`
+	Camera string `json:"camera,omitempty"`
+}
+
+func metadataKey(name string) string {
+	return metaPrefix + name + ".json"
+}
+
+func saveMetadata(meta *fileMetadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, err = storage.SaveFile(metadataKey(meta.Path), bytes.NewReader(data))
+	return err
+}
+
+func loadMetadata(name string) (*fileMetadata, error) {
+	f, err := storage.OpenFile(metadataKey(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var meta fileMetadata
+	if err := json.NewDecoder(f).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+	return &meta, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	store "go-uploader/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// backendName labels backend metrics, e.g. "local", "s3" or "memory".
+var backendName string
+
+var (
+	uploadSessions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_sessions_total",
+		Help: "Upload requests by result (success, partial, failed).",
+	}, []string{"result"})
+	uploadedFiles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_files_total",
+		Help: "Files in upload requests by result (saved, quarantined, rejected, failed).",
+	}, []string{"result"})
+	fileSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "uploader_file_size_bytes",
+		Help:    "Size of stored files.",
+		Buckets: prometheus.ExponentialBuckets(16*1024, 4, 10), // 16 KB to 4 GB
+	})
+	sessionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "uploader_session_duration_seconds",
+		Help:    "Duration of upload requests from CAPTCHA verification to response.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 12), // 0.25 s to 8.5 min
+	})
+	backendSaveDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "uploader_backend_save_duration_seconds",
+		Help:    "Latency of saving a single file to the storage backend.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14), // 10 ms to 82 s
+	}, []string{"backend"})
+	pipelineStepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "uploader_pipeline_step_duration_seconds",
+		Help:    "Duration of post-upload pipeline steps.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10), // 1 ms to 4.4 min
+	}, []string{"step"})
+	pipelineStepFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_pipeline_step_failures_total",
+		Help: "Failed post-upload pipeline steps.",
+	}, []string{"step"})
+	integrityCheckedFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "uploader_integrity_checked_files_total",
+		Help: "Stored files verified by the integrity check.",
+	})
+	integrityMismatches = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "uploader_integrity_mismatches_total",
+		Help: "Stored files that no longer matched their recorded checksum.",
+	})
+	integrityLastRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "uploader_integrity_last_run_timestamp_seconds",
+		Help: "Time the last integrity check completed.",
+	})
+	liveClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "uploader_live_clients",
+		Help: "Clients connected to the live upload feed.",
+	})
+	backendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "uploader_backend_operation_duration_seconds",
+		Help:    "Latency of storage backend operations by backend and operation (save, open, delete, list).",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10), // 1 ms to 4.4 min
+	}, []string{"backend", "operation"})
+	backendErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_backend_errors_total",
+		Help: "Failed storage backend operations by backend and operation; missing files don't count.",
+	}, []string{"backend", "operation"})
+	geoChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_geoip_checks_total",
+		Help: "Upload requests checked against the GeoIP restrictions by country and result (allowed, blocked).",
+	}, []string{"country", "result"})
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_cache_requests_total",
+		Help: "Lookups in the listing cache by kind (list, metadata) and result (hit, miss).",
+	}, []string{"kind", "result"})
+	spooledFiles = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "uploader_spooled_files",
+		Help: "Files in the local spool waiting to be pushed to the backend.",
+	})
+	outboxFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "uploader_outbox_files_total",
+		Help: "Files the backend failed to store during an upload, kept in the outbox for a retry.",
+	})
+	archivedFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "uploader_archived_files_total",
+		Help: "Files of old sessions moved to the archive tier.",
+	})
+	storageBreakerTrips = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_storage_breaker_trips_total",
+		Help: "Times the circuit breaker of a storage backend opened after repeated failures.",
+	}, []string{"backend"})
+	storageBreakerOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "uploader_storage_breaker_open",
+		Help: "Whether the circuit breaker of a storage backend is open (1) or closed (0).",
+	}, []string{"backend"})
+)
+
+// sessionResult classifies an upload request for the sessions metric.
+func sessionResult(saved, failed int) string {
+	switch {
+	case saved == 0:
+		return "failed"
+	case failed > 0:
+		return "partial"
+	default:
+		return "success"
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// instrumentedBackend records the latency and errors of every operation of a
+// backend, so storage problems show up separately from the HTTP metrics.
+type instrumentedBackend struct {
+	store.Backend
+	name string
+}
+
+func instrumentBackend(name string, b store.Backend) store.Backend {
+	return &instrumentedBackend{Backend: b, name: name}
+}
+
+// observe records an operation that started at start and returned err.
+func (b *instrumentedBackend) observe(operation string, start time.Time, err error) {
+	backendDuration.WithLabelValues(b.name, operation).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		backendErrors.WithLabelValues(b.name, operation).Inc()
+	}
+}
+
+// SaveFile includes the time spent reading data, i.e. receiving the upload.
+func (b *instrumentedBackend) SaveFile(name string, data io.Reader) (store.SaveResult, error) {
+	start := time.Now()
+	result, err := b.Backend.SaveFile(name, data)
+	b.observe("save", start, err)
+	return result, err
+}
+
+func (b *instrumentedBackend) OpenFile(name string) (io.ReadCloser, error) {
+	start := time.Now()
+	f, err := b.Backend.OpenFile(name)
+	b.observe("open", start, err)
+	return f, err
+}
+
+func (b *instrumentedBackend) DeleteFile(name string) error {
+	start := time.Now()
+	err := b.Backend.DeleteFile(name)
+	b.observe("delete", start, err)
+	return err
+}
+
+func (b *instrumentedBackend) ListFiles(prefix string) ([]string, error) {
+	start := time.Now()
+	names, err := b.Backend.ListFiles(prefix)
+	b.observe("list", start, err)
+	return names, err
+}
+
+// unwrapBackend returns the backend behind the instrumentation, the circuit
+// breaker and the cache, for features specific to one backend.
+func unwrapBackend(b store.Backend) store.Backend {
+	for {
+		switch wrapper := b.(type) {
+		case *instrumentedBackend:
+			b = wrapper.Backend
+		case *cachedBackend:
+			b = wrapper.Backend
+		case *breakerBackend:
+			b = wrapper.Backend
+		default:
+			return b
+		}
+	}
+}
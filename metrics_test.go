@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUploadMetrics(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	savedBefore := testutil.ToFloat64(uploadedFiles.WithLabelValues("saved"))
+	successBefore := testutil.ToFloat64(uploadSessions.WithLabelValues("success"))
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "photo.jpg")
+	part.Write(bytes.Repeat([]byte("x"), 20000))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	uploadHandler(httptest.NewRecorder(), req)
+
+	if got := testutil.ToFloat64(uploadedFiles.WithLabelValues("saved")) - savedBefore; got != 1 {
+		t.Errorf("Expected 1 saved file, got %v", got)
+	}
+	if got := testutil.ToFloat64(uploadSessions.WithLabelValues("success")) - successBefore; got != 1 {
+		t.Errorf("Expected 1 successful session, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	for _, name := range []string{"uploader_file_size_bytes_bucket", "uploader_session_duration_seconds_sum", "uploader_backend_save_duration_seconds_count"} {
+		if !strings.Contains(w.Body.String(), name) {
+			t.Errorf("Expected %s in metrics output", name)
+		}
+	}
+}
+
+func TestInstrumentedBackend(t *testing.T) {
+	b := instrumentBackend("test", &MockStorage{files: map[string][]byte{"a.jpg": []byte("a")}})
+	errorsBefore := testutil.ToFloat64(backendErrors.WithLabelValues("test", "open"))
+
+	if _, err := b.OpenFile("a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	b.OpenFile("missing.jpg")
+	if got := testutil.ToFloat64(backendErrors.WithLabelValues("test", "open")) - errorsBefore; got != 0 {
+		t.Errorf("expected missing files not to count as errors, got %v", got)
+	}
+	if got := testutil.CollectAndCount(backendDuration, "uploader_backend_operation_duration_seconds"); got == 0 {
+		t.Error("expected latency to be recorded")
+	}
+
+	failing := instrumentBackend("test", &MockStorage{failOn: "b.jpg", saveErr: errors.New("disk full")})
+	savesBefore := testutil.ToFloat64(backendErrors.WithLabelValues("test", "save"))
+	failing.SaveFile("b.jpg", strings.NewReader("b"))
+	if got := testutil.ToFloat64(backendErrors.WithLabelValues("test", "save")) - savesBefore; got != 1 {
+		t.Errorf("expected 1 save error, got %v", got)
+	}
+	if _, ok := unwrapBackend(b).(*MockStorage); !ok {
+		t.Error("expected unwrapBackend to return the wrapped backend")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// withRequestID tags every request with an ID, taken from the X-Request-ID
+// header set by a proxy or generated otherwise. The ID is returned in the
+// response so an error a guest reports can be matched to the server logs.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID only accepts short IDs of safe characters, so forwarded IDs
+// can't be used to inject content into logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of the request ctx belongs to, if any.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogf logs like log.Printf, prefixed with the request ID of ctx.
+func requestLogf(ctx context.Context, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if id := requestID(ctx); id != "" {
+		message = "[" + id + "] " + message
+	}
+	log.Output(2, message)
+}
+
+// withRecovery turns a panic in a handler into a logged stack trace and a 500
+// response, instead of a dropped connection without any hint for the guest.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tracked := &trackingWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				// Deliberate abort, let net/http close the connection quietly
+				panic(err)
+			}
+			requestLogf(r.Context(), "Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			if !tracked.wroteHeader {
+				http.Error(w, "Internal server error (request ID "+requestID(r.Context())+")", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(tracked, r)
+	})
+}
+
+// trackingWriter records whether a response has been started, so a panic
+// handler doesn't write a second status line.
+type trackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (t *trackingWriter) WriteHeader(code int) {
+	t.wroteHeader = true
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *trackingWriter) Write(b []byte) (int, error) {
+	t.wroteHeader = true
+	return t.ResponseWriter.Write(b)
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer, e.g.
+// for flushing or deadlines.
+func (t *trackingWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// Flush is forwarded since handlers commonly check for http.Flusher.
+func (t *trackingWriter) Flush() {
+	t.wroteHeader = true
+	http.NewResponseController(t.ResponseWriter).Flush()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if seen == "" || w.Header().Get("X-Request-ID") != seen {
+		t.Errorf("expected generated ID in context and response, got %q and %q", seen, w.Header().Get("X-Request-ID"))
+	}
+
+	// IDs from a proxy are kept
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "proxy-123")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if seen != "proxy-123" || w.Header().Get("X-Request-ID") != "proxy-123" {
+		t.Errorf("expected forwarded ID to be used, got %q", seen)
+	}
+
+	// Unsafe IDs are replaced
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "bad\nid")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if seen == "bad\nid" {
+		t.Error("expected unsafe ID to be replaced")
+	}
+}
+
+func TestRequestLogf(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestLogf(r.Context(), "handling %s", r.URL.Path)
+	}))
+	req := httptest.NewRequest("GET", "/upload", nil)
+	req.Header.Set("X-Request-ID", "abc123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.Contains(buf.String(), "[abc123] handling /upload") {
+		t.Errorf("expected log line with request ID, got %q", buf.String())
+	}
+}
+
+func TestWithRecovery(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	handler := withRequestID(withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var parts map[string]string
+		parts["file"] = "boom"
+	})))
+	req := httptest.NewRequest("POST", "/upload", nil)
+	req.Header.Set("X-Request-ID", "abc123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "abc123") {
+		t.Errorf("expected request ID in response, got %q", w.Body.String())
+	}
+	if !strings.Contains(buf.String(), "[abc123] Panic serving POST /upload") || !strings.Contains(buf.String(), "goroutine") {
+		t.Errorf("expected stack trace with request ID in log, got %q", buf.String())
+	}
+}
+
+func TestWithRecovery_AfterResponseStarted(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	handler := withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		panic("late failure")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusCreated || w.Body.Len() != 0 {
+		t.Errorf("expected the started response to be left alone, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	store "go-uploader/storage"
+)
+
+// runMigrateCommand copies all files from one backend to another, e.g. when
+// moving a deployment from local disk to S3. Both backends are configured
+// through the usual environment variables.
+func runMigrateCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := flags.String("from", "", "source backend (local or s3)")
+	to := flags.String("to", "", "target backend (local or s3)")
+	statePath := flags.String("state", "migrate.state", "file recording migrated files, so an interrupted run can be resumed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" || *from == *to {
+		flags.Usage()
+		return errors.New("-from and -to must name two different backends")
+	}
+
+	src, err := newBackend(*from)
+	if err != nil {
+		return fmt.Errorf("setting up %s backend: %w", *from, err)
+	}
+	dst, err := newBackend(*to)
+	if err != nil {
+		return fmt.Errorf("setting up %s backend: %w", *to, err)
+	}
+	return migrateFiles(src, dst, *statePath, stdout)
+}
+
+// migrateFiles copies every file of src to dst and verifies the copy. Files
+// recorded in the state file by an earlier run are skipped.
+func migrateFiles(src, dst store.Backend, statePath string, stdout io.Writer) error {
+	done, err := readMigrateState(statePath)
+	if err != nil {
+		return err
+	}
+	state, err := os.OpenFile(statePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening state file: %w", err)
+	}
+	defer state.Close()
+
+	names, err := src.ListFiles("")
+	if err != nil {
+		return err
+	}
+	copied := 0
+	for i, name := range names {
+		if done[name] {
+			continue
+		}
+		if err := migrateFile(src, dst, name); err != nil {
+			return fmt.Errorf("migrating %s: %w", name, err)
+		}
+		if _, err := fmt.Fprintln(state, name); err != nil {
+			return fmt.Errorf("updating state file: %w", err)
+		}
+		copied++
+		fmt.Fprintf(stdout, "[%d/%d] %s\n", i+1, len(names), name)
+	}
+	fmt.Fprintf(stdout, "Migrated %d file(s), %d already migrated before\n", copied, len(names)-copied)
+	return nil
+}
+
+func readMigrateState(statePath string) (map[string]bool, error) {
+	done := map[string]bool{}
+	f, err := os.Open(statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		done[scanner.Text()] = true
+	}
+	return done, scanner.Err()
+}
+
+// migrateFile copies a file and reads the copy back to compare checksums.
+func migrateFile(src, dst store.Backend, name string) error {
+	f, err := src.OpenFile(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := dst.SaveFile(name, io.TeeReader(f, hash)); err != nil {
+		return err
+	}
+
+	copied, err := dst.OpenFile(name)
+	if err != nil {
+		return fmt.Errorf("reading back copy: %w", err)
+	}
+	defer copied.Close()
+	check := sha256.New()
+	if _, err := io.Copy(check, copied); err != nil {
+		return fmt.Errorf("reading back copy: %w", err)
+	}
+	if !bytes.Equal(hash.Sum(nil), check.Sum(nil)) {
+		return errors.New("copy does not match the original")
+	}
+	return nil
+}
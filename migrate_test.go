@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	store "go-uploader/storage"
+)
+
+func TestMigrateFiles(t *testing.T) {
+	src, _ := store.NewLocalStorage(t.TempDir())
+	dst := &MockStorage{}
+	for name, content := range map[string]string{
+		"session1/photo.jpg":           "a",
+		"session1/videos/clip.mp4":     "b",
+		"meta/session1/photo.jpg.json": "{}",
+	} {
+		src.SaveFile(name, strings.NewReader(content))
+	}
+	statePath := filepath.Join(t.TempDir(), "migrate.state")
+
+	var out strings.Builder
+	if err := migrateFiles(src, dst, statePath, &out); err != nil {
+		t.Fatalf("migrateFiles: %v", err)
+	}
+	if len(dst.files) != 3 || string(dst.files["session1/videos/clip.mp4"]) != "b" {
+		t.Fatalf("unexpected target files: %v", dst.files)
+	}
+	if !strings.Contains(out.String(), "Migrated 3 file(s)") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	// A second run resumes and only copies new files
+	src.SaveFile("session2/new.jpg", strings.NewReader("c"))
+	delete(dst.files, "session1/photo.jpg")
+	out.Reset()
+	if err := migrateFiles(src, dst, statePath, &out); err != nil {
+		t.Fatalf("migrateFiles: %v", err)
+	}
+	if _, ok := dst.files["session1/photo.jpg"]; ok {
+		t.Error("expected already migrated file to be skipped")
+	}
+	if string(dst.files["session2/new.jpg"]) != "c" || !strings.Contains(out.String(), "Migrated 1 file(s), 3 already migrated before") {
+		t.Errorf("unexpected second run: %q", out.String())
+	}
+}
+
+func TestMigrateFiles_VerifiesCopy(t *testing.T) {
+	src, _ := store.NewLocalStorage(t.TempDir())
+	src.SaveFile("session1/photo.jpg", strings.NewReader("a"))
+	dst := &corruptingStorage{MockStorage: &MockStorage{}}
+
+	err := migrateFiles(src, dst, filepath.Join(t.TempDir(), "migrate.state"), &strings.Builder{})
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected verification error, got %v", err)
+	}
+}
+
+// corruptingStorage stores something other than what it was given.
+type corruptingStorage struct {
+	*MockStorage
+}
+
+func (c *corruptingStorage) SaveFile(name string, data io.Reader) (store.SaveResult, error) {
+	io.Copy(io.Discard, data)
+	return c.MockStorage.SaveFile(name, strings.NewReader("corrupt"))
+}
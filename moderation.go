@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	store "go-uploader/storage"
+)
+
+const pendingPrefix = "pending/"
+
+// errFileExists is returned when approving an upload would overwrite a
+// published file that isn't kept as a version.
+var errFileExists = errors.New("file already exists")
+
+// moderationQueue holds new uploads in the pending area until an admin
+// approves them.
+var moderationQueue bool
+
+func setupModeration() {
+	moderationQueue = os.Getenv("MODERATION_QUEUE") == "true"
+	if moderationQueue {
+		log.Println("Moderation queue enabled, new uploads require approval")
+	}
+}
+
+// uploadPath returns where a new upload is stored.
+func uploadPath(name string) string {
+	if moderationQueue {
+		return pendingPrefix + name
+	}
+	return name
+}
+
+func pendingListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	names, err := storage.ListFiles(pendingPrefix)
+	if err != nil {
+		requestLogf(r.Context(), "Error listing pending uploads: %v", err)
+		http.Error(w, "Failed to list pending uploads", http.StatusInternalServerError)
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(names)
+}
+
+func pendingApproveHandler(w http.ResponseWriter, r *http.Request) {
+	pendingAction(w, r, func(name string) error {
+		published := strings.TrimPrefix(name, pendingPrefix)
+		// Without versioning the published file would be lost
+		if !versioning {
+			exists, err := fileExists(published)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return errFileExists
+			}
+		}
+		if _, err := keepVersion(published); err != nil {
+			return err
+		}
+		if err := store.MoveFile(storage, name, published); err != nil {
+			return err
+		}
+		if err := removeThumbnails(published); err != nil {
+			requestLogf(r.Context(), "Error removing thumbnails of %s: %v", logName(published), err)
+		}
+		requestLogf(r.Context(), "Approved pending upload %s", logName(name))
+		recordAudit(r, "pending.approve", name, map[string]any{"published": published})
+		publishLive(published, path.Dir(published), "")
+		return nil
+	})
+}
+
+func pendingRejectHandler(w http.ResponseWriter, r *http.Request) {
+	pendingAction(w, r, func(name string) error {
+		if err := discardFile(name, newTrashBatch()); err != nil {
+			return err
+		}
+		requestLogf(r.Context(), "Rejected pending upload %s", logName(name))
+		recordAudit(r, "pending.reject", name, nil)
+		return nil
+	})
+}
+
+// pendingAction applies action to the pending file named by the "path" query
+// parameter.
+func pendingAction(w http.ResponseWriter, r *http.Request, action func(name string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean(r.URL.Query().Get("path"))
+	if !strings.HasPrefix(name, pendingPrefix) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	err := action(name)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errFileExists) {
+		http.Error(w, "A published file of that name exists, delete it or enable VERSIONING first", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		requestLogf(r.Context(), "Error moderating pending upload %s: %v", logName(name), err)
+		http.Error(w, "Failed to process pending upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
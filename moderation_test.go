@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestModerationQueue(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	moderationQueue = true
+	defer func() { moderationQueue = false }()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, name := range []string{"keep.jpg", "drop.jpg"} {
+		part, _ := writer.CreateFormFile("file", name)
+		part.Write([]byte("\xff\xd8\xff\xe0 " + name))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	pending, _ := mockStorage.ListFiles(pendingPrefix)
+	all, _ := mockStorage.ListFiles("")
+	if len(pending) != 2 || len(all) != 2 {
+		t.Fatalf("Expected both uploads to be pending, got %v", all)
+	}
+
+	moderate := func(handler http.HandlerFunc, name string) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/admin/pending?path="+name, nil))
+		return w.Code
+	}
+	for _, name := range pending {
+		handler := pendingRejectHandler
+		if strings.HasSuffix(name, "keep.jpg") {
+			handler = pendingApproveHandler
+		}
+		if code := moderate(handler, name); code != http.StatusNoContent {
+			t.Errorf("Expected 204 moderating %s, got %d", name, code)
+		}
+	}
+	if code := moderate(pendingApproveHandler, "session/keep.jpg"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for path outside the pending area, got %d", code)
+	}
+
+	all, _ = mockStorage.ListFiles("")
+	audit, _ := mockStorage.ListFiles(auditPrefix)
+	all = slices.DeleteFunc(all, func(name string) bool { return strings.HasPrefix(name, auditPrefix) })
+	if len(all) != 1 || strings.HasPrefix(all[0], pendingPrefix) || !strings.HasSuffix(all[0], "keep.jpg") {
+		t.Errorf("Expected only the approved upload to be public, got %v", all)
+	}
+	var actions []string
+	for _, key := range audit {
+		var event auditEvent
+		json.Unmarshal(mockStorage.files[key], &event)
+		actions = append(actions, event.Action+" "+event.Subject)
+	}
+	slices.Sort(actions)
+	if len(actions) != 2 || !strings.HasPrefix(actions[0], "pending.approve pending/") || !strings.HasPrefix(actions[1], "pending.reject pending/") {
+		t.Errorf("Expected audit events for both decisions, got %v", actions)
+	}
+}
+
+func TestModerationQueue_SharedFolder(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	moderationQueue = true
+	sessionFolder, sharedSessionFolders = "{{date}}", true
+	defer func() {
+		storage = originalStorage
+		moderationQueue = false
+		sessionFolder, sharedSessionFolders = defaultSessionFolder, false
+	}()
+
+	upload := func(content string) string {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "IMG.jpg")
+		part.Write([]byte("\xff\xd8\xff\xe0 " + content))
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		pending, _ := mockStorage.ListFiles(pendingPrefix)
+		for _, name := range pending {
+			if strings.HasSuffix(string(mockStorage.files[name]), content) {
+				return name
+			}
+		}
+		t.Fatalf("Expected %s to be pending, got %v", content, pending)
+		return ""
+	}
+	approve := func(name string) int {
+		w := httptest.NewRecorder()
+		pendingApproveHandler(w, httptest.NewRequest("POST", "/admin/pending/approve?path="+name, nil))
+		return w.Code
+	}
+
+	first := upload("guest A")
+	if code := approve(first); code != http.StatusNoContent {
+		t.Fatalf("Expected 204 approving %s, got %d", first, code)
+	}
+	second := upload("guest B")
+	if second == first {
+		t.Fatalf("Expected the second upload to get another name than the approved %s", first)
+	}
+	if code := approve(second); code != http.StatusNoContent {
+		t.Fatalf("Expected 204 approving %s, got %d", second, code)
+	}
+	if got := string(mockStorage.files[strings.TrimPrefix(first, pendingPrefix)]); !strings.HasSuffix(got, "guest A") {
+		t.Errorf("Expected the first approved upload to be kept, got %q", got)
+	}
+
+	// A pending file taking the name of a published one isn't approved over it
+	mockStorage.SaveFile(first, strings.NewReader("guest C"))
+	if code := approve(first); code != http.StatusConflict {
+		t.Errorf("Expected 409 approving over a published file, got %d", code)
+	}
+	versioning = true
+	defer func() { versioning = false }()
+	if code := approve(first); code != http.StatusNoContent {
+		t.Errorf("Expected 204 approving over a published file with versioning, got %d", code)
+	}
+	if versions, _ := listVersions(strings.TrimPrefix(first, pendingPrefix)); len(versions) != 1 {
+		t.Errorf("Expected the published file to be kept as a version, got %v", versions)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// allowedOrigins are the origins browsers may post uploads from besides the
+// uploader's own, e.g. "https://example.com". Empty allows any.
+var allowedOrigins []string
+
+func setupOriginCheck() error {
+	allowedOrigins = nil
+	for _, value := range splitList(os.Getenv("ALLOWED_ORIGINS")) {
+		origin, ok := parseOrigin(value)
+		if !ok || origin != strings.TrimSuffix(strings.ToLower(value), "/") {
+			return fmt.Errorf("ALLOWED_ORIGINS must list origins like https://example.com, got %q", value)
+		}
+		allowedOrigins = append(allowedOrigins, origin)
+	}
+	if len(allowedOrigins) == 0 {
+		return nil
+	}
+	log.Printf("Accepting uploads from browsers on %s", strings.Join(allowedOrigins, ", "))
+	return nil
+}
+
+// parseOrigin returns the scheme and host of an absolute http(s) URL.
+func parseOrigin(value string) (string, bool) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// checkOrigin rejects requests a browser sent from a page on another
+// origin. Browsers send Origin with every POST, older ones only Referer;
+// clients sending neither, like scripts, aren't browsers and pass. Pages on
+// the host the request was sent to, or on BASE_URL, are the uploader's own.
+// The Origin "null" of sandboxed frames is rejected.
+func checkOrigin(r *http.Request) error {
+	if len(allowedOrigins) == 0 {
+		return nil
+	}
+	value, header := r.Header.Get("Origin"), "Origin"
+	if value == "" {
+		value, header = r.Header.Get("Referer"), "Referer"
+	}
+	if value == "" {
+		return nil
+	}
+	origin, ok := parseOrigin(value)
+	if !ok {
+		return fmt.Errorf("invalid %s %q", header, value)
+	}
+	if base, _ := parseOrigin(publicBaseURL); origin == base || slices.Contains(allowedOrigins, origin) {
+		return nil
+	}
+	if u, _ := url.Parse(origin); strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	return fmt.Errorf("%s %q is not allowed", header, value)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "https://wedding.example.com, https://Photos.example.com/")
+	if err := setupOriginCheck(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { allowedOrigins = nil }()
+
+	for _, test := range []struct {
+		header, value string
+		ok            bool
+	}{
+		{"", "", true},
+		{"Origin", "https://wedding.example.com", true},
+		{"Origin", "https://photos.example.com", true},
+		{"Origin", "http://uploads.example.com:8080", true},
+		{"Origin", "https://evil.example.net", false},
+		{"Origin", "null", false},
+		{"Referer", "https://wedding.example.com/gallery?x=1", true},
+		{"Referer", "https://evil.example.net/page", false},
+	} {
+		req := httptest.NewRequest("POST", "http://uploads.example.com:8080/upload", nil)
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		if err := checkOrigin(req); (err == nil) != test.ok {
+			t.Errorf("%s %q: got %v, want ok %v", test.header, test.value, err, test.ok)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/upload", strings.NewReader(""))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	req.Header.Set("Origin", "https://evil.example.net")
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected cross-site uploads to be rejected, got %d", w.Code)
+	}
+
+	t.Setenv("ALLOWED_ORIGINS", "https://example.com/upload")
+	if err := setupOriginCheck(); err == nil {
+		t.Error("expected an error for a URL with a path")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// pipelineFile is a stored file as seen by the post-upload pipeline. Steps
+// may update it, e.g. record metadata or move it to quarantine.
+type pipelineFile struct {
+	// Name is the public path of the file, Stored where it currently is
+	Name        string
+	Stored      string
+	Session     string
+	RequestID   string
+	Size        int64
+	ContentType string
+	// Ref is the backend's reference to the stored file
+	Ref string
+	// SHA256 is computed while the file is stored
+	SHA256 string
+	// Status is "stored", "pending" or "quarantined"
+	Status string
+	// Replaced is the version the previous content of the path was kept as
+	Replaced string
+	Meta     fileMetadata
+}
+
+// pipelineStep is one step of the post-upload pipeline.
+type pipelineStep interface {
+	Name() string
+	Run(ctx context.Context, file *pipelineFile) error
+}
+
+// failurePolicy decides what happens when a step fails.
+type failurePolicy string
+
+const (
+	// policyContinue logs the failure and runs the remaining steps
+	policyContinue failurePolicy = "continue"
+	// policyStop skips the remaining steps
+	policyStop failurePolicy = "stop"
+	// policyQuarantine moves the file to quarantine and runs the remaining steps
+	policyQuarantine failurePolicy = "quarantine"
+)
+
+type pipelineStage struct {
+	step   pipelineStep
+	policy failurePolicy
+}
+
+// pipelineSteps are the available steps by name.
+var pipelineSteps = map[string]func() (pipelineStep, error){
+	"checksum": func() (pipelineStep, error) { return checksumStep{}, nil },
+	"exec": func() (pipelineStep, error) {
+		if len(fileHookCommand) == 0 {
+			return nil, fmt.Errorf("HOOK_FILE_COMMAND is not set")
+		}
+		return execStep{}, nil
+	},
+	"metadata": func() (pipelineStep, error) { return metadataStep{}, nil },
+	"notify":   func() (pipelineStep, error) { return notifyStep{}, nil },
+}
+
+const defaultPipeline = "metadata,notify"
+
+// uploadPipeline runs on every stored file; setupPipeline replaces it if
+// PIPELINE_STEPS is set.
+var uploadPipeline, _ = parsePipeline(defaultPipeline)
+
+func setupPipeline() error {
+	spec := os.Getenv("PIPELINE_STEPS")
+	if spec == "" {
+		spec = defaultPipeline
+		if len(fileHookCommand) > 0 {
+			spec = "metadata,exec,notify"
+		}
+	}
+	stages, err := parsePipeline(spec)
+	if err != nil {
+		return fmt.Errorf("parsing PIPELINE_STEPS: %w", err)
+	}
+	uploadPipeline = stages
+	log.Printf("Post-upload pipeline: %s", spec)
+	return nil
+}
+
+// parsePipeline parses an ordered list of steps with optional failure
+// policies, e.g. "checksum:quarantine,metadata,notify".
+func parsePipeline(spec string) ([]pipelineStage, error) {
+	var stages []pipelineStage
+	for _, entry := range splitList(spec) {
+		name, policy, _ := strings.Cut(entry, ":")
+		stage := pipelineStage{policy: policyContinue}
+		switch failurePolicy(policy) {
+		case "":
+		case policyContinue, policyStop, policyQuarantine:
+			stage.policy = failurePolicy(policy)
+		default:
+			return nil, fmt.Errorf("unknown failure policy %q for step %s", policy, name)
+		}
+
+		newStep, ok := pipelineSteps[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown step %q", name)
+		}
+		step, err := newStep()
+		if err != nil {
+			return nil, fmt.Errorf("setting up step %s: %w", name, err)
+		}
+		stage.step = step
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// runPipeline runs the steps in order. Failures never undo the upload, the
+// file has already been stored; the stage's policy decides how to go on.
+func runPipeline(ctx context.Context, stages []pipelineStage, file *pipelineFile) {
+	for _, stage := range stages {
+		name := stage.step.Name()
+		start := time.Now()
+		err := stage.step.Run(ctx, file)
+		pipelineStepDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		if err == nil {
+			continue
+		}
+
+		pipelineStepFailures.WithLabelValues(name).Inc()
+		requestLogf(ctx, "Pipeline step %s failed for %s: %v", name, logName(file.Name), err)
+		switch stage.policy {
+		case policyStop:
+			return
+		case policyQuarantine:
+			if file.Status == "quarantined" {
+				continue
+			}
+			reason := fmt.Sprintf("%s step failed: %v", name, err)
+			if err := quarantineStored(file.Stored, file.Name, reason); err != nil {
+				requestLogf(ctx, "Error quarantining %s: %v", logName(file.Name), err)
+				return
+			}
+			file.Stored = quarantinePrefix + file.Name
+			file.Status = "quarantined"
+		}
+	}
+}
+
+// checksumStep reads the stored file back and compares it with the hash
+// computed during the upload, catching truncated or corrupted writes.
+type checksumStep struct{}
+
+func (checksumStep) Name() string { return "checksum" }
+
+func (checksumStep) Run(ctx context.Context, file *pipelineFile) error {
+	f, err := storage.OpenFile(file.Stored)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, contextReader{ctx: ctx, r: f}); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != file.SHA256 {
+		return fmt.Errorf("stored file has checksum %s, expected %s", sum, file.SHA256)
+	}
+	file.Meta.SHA256 = file.SHA256
+	return nil
+}
+
+// metadataStep stores the metadata collected so far as a sidecar, if there
+// is anything to record.
+type metadataStep struct{}
+
+func (metadataStep) Name() string { return "metadata" }
+
+func (metadataStep) Run(_ context.Context, file *pipelineFile) error {
+	if integrityInterval > 0 {
+		// The integrity check needs the digest of every file
+		file.Meta.SHA256 = file.SHA256
+	}
+	if archiveAfter > 0 {
+		// Archiving finds old sessions by their upload times
+		file.Meta.Session = file.Session
+		file.Meta.Uploaded = time.Now().UTC()
+	}
+	if file.Meta.Session == "" && file.Meta.Moderation == nil && file.Meta.SHA256 == "" && file.Meta.Image == nil && file.Meta.OriginalName == "" && file.Meta.ContentType == "" {
+		return nil
+	}
+	return saveMetadata(&file.Meta)
+}
+
+// notifyStep publishes a file.saved event.
+type notifyStep struct{}
+
+func (notifyStep) Name() string { return "notify" }
+
+func (notifyStep) Run(ctx context.Context, file *pipelineFile) error {
+	emitEvent(&uploadEvent{
+		Type:        eventFileSaved,
+		Session:     file.Session,
+		Drop:        dropName(ctx),
+		RequestID:   file.RequestID,
+		Path:        file.Name,
+		Size:        file.Size,
+		ContentType: file.ContentType,
+		Status:      file.Status,
+	})
+	return nil
+}
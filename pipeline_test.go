@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type fakeStep struct {
+	name string
+	err  error
+	runs *[]string
+}
+
+func (f fakeStep) Name() string { return f.name }
+
+func (f fakeStep) Run(_ context.Context, _ *pipelineFile) error {
+	*f.runs = append(*f.runs, f.name)
+	return f.err
+}
+
+func TestParsePipeline(t *testing.T) {
+	stages, err := parsePipeline("checksum:quarantine, metadata,notify:stop")
+	if err != nil {
+		t.Fatalf("parsePipeline() failed: %v", err)
+	}
+	if len(stages) != 3 || stages[0].policy != policyQuarantine || stages[1].policy != policyContinue || stages[2].policy != policyStop {
+		t.Errorf("unexpected stages: %+v", stages)
+	}
+
+	for _, spec := range []string{"checksum,resize", "checksum:ignore"} {
+		if _, err := parsePipeline(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestRunPipeline_FailurePolicies(t *testing.T) {
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+	mockStorage.SaveFile("session1/photo.jpg", strings.NewReader("data"))
+
+	var runs []string
+	failing := errors.New("scanner unavailable")
+	stages := []pipelineStage{
+		{step: fakeStep{name: "scan", err: failing, runs: &runs}, policy: policyQuarantine},
+		{step: fakeStep{name: "thumbnail", err: failing, runs: &runs}, policy: policyStop},
+		{step: fakeStep{name: "notify", runs: &runs}, policy: policyContinue},
+	}
+	file := &pipelineFile{Name: "session1/photo.jpg", Stored: "session1/photo.jpg", Status: "stored"}
+	runPipeline(context.Background(), stages, file)
+
+	if strings.Join(runs, ",") != "scan,thumbnail" {
+		t.Errorf("expected stop policy to skip the remaining steps, ran %v", runs)
+	}
+	if file.Status != "quarantined" || file.Stored != "quarantine/session1/photo.jpg" {
+		t.Errorf("expected file to be quarantined, got %+v", file)
+	}
+	if _, ok := mockStorage.files["quarantine/session1/photo.jpg"]; !ok {
+		t.Error("expected file to be moved to quarantine")
+	}
+	record, err := readQuarantineRecord("quarantine/session1/photo.jpg")
+	if err != nil || !strings.Contains(record.Reason, "scan step failed") {
+		t.Errorf("expected reason record, got %+v, %v", record, err)
+	}
+}
+
+func TestChecksumStep(t *testing.T) {
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+	mockStorage.SaveFile("session1/photo.jpg", bytes.NewReader([]byte("data")))
+
+	// sha256("data")
+	file := &pipelineFile{Stored: "session1/photo.jpg", SHA256: "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"}
+	if err := (checksumStep{}).Run(context.Background(), file); err != nil {
+		t.Fatalf("expected checksum to match: %v", err)
+	}
+	if file.Meta.SHA256 != file.SHA256 {
+		t.Error("expected verified checksum to be recorded in the metadata")
+	}
+
+	mockStorage.SaveFile("session1/photo.jpg", bytes.NewReader([]byte("dat")))
+	if err := (checksumStep{}).Run(context.Background(), file); err == nil {
+		t.Error("expected truncated file to fail the checksum")
+	}
+}
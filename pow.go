@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proof-of-work challenges follow the ALTCHA format: the client searches for
+// the number n in [0, maxNumber] with SHA-256(salt + n) == challenge. The
+// challenge is signed, so the server doesn't have to keep state until a
+// solution is redeemed.
+var powMaxNumber int64 = 100000
+var powExpiry = 10 * time.Minute
+
+// usedChallenges remembers redeemed challenges until they expire, so a
+// solution can't be replayed.
+var usedChallenges = struct {
+	sync.Mutex
+	expires map[string]time.Time
+}{expires: make(map[string]time.Time)}
+
+type powChallenge struct {
+	Algorithm string `json:"algorithm"`
+	Challenge string `json:"challenge"`
+	MaxNumber int64  `json:"maxnumber"`
+	Salt      string `json:"salt"`
+	Signature string `json:"signature"`
+}
+
+type powSolution struct {
+	Algorithm string `json:"algorithm"`
+	Challenge string `json:"challenge"`
+	Number    int64  `json:"number"`
+	Salt      string `json:"salt"`
+	Signature string `json:"signature"`
+}
+
+func setupPoW() error {
+	if value := os.Getenv("POW_MAX_NUMBER"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			return fmt.Errorf("POW_MAX_NUMBER must be a positive number, got %q", value)
+		}
+		powMaxNumber = n
+	}
+	log.Printf("Using proof-of-work challenges with difficulty %d", powMaxNumber)
+	mux.HandleFunc("/captcha/challenge", powChallengeHandler)
+	return nil
+}
+
+func powSignature(challenge string) string {
+	return hex.EncodeToString(tokenMAC("pow", challenge))
+}
+
+func newPoWChallenge() (*powChallenge, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	number, err := rand.Int(rand.Reader, big.NewInt(powMaxNumber+1))
+	if err != nil {
+		return nil, err
+	}
+
+	salt := hex.EncodeToString(random) + "?expires=" + strconv.FormatInt(time.Now().Add(powExpiry).Unix(), 10)
+	sum := sha256.Sum256([]byte(salt + number.String()))
+	challenge := hex.EncodeToString(sum[:])
+	return &powChallenge{
+		Algorithm: "SHA-256",
+		Challenge: challenge,
+		MaxNumber: powMaxNumber,
+		Salt:      salt,
+		Signature: powSignature(challenge),
+	}, nil
+}
+
+func powChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, err := newPoWChallenge()
+	if err != nil {
+		requestLogf(r.Context(), "Error creating proof-of-work challenge: %v", err)
+		http.Error(w, "Failed to create challenge", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(challenge)
+}
+
+// verifyPoW checks a base64 encoded JSON solution as sent by the client.
+func verifyPoW(payload string) error {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return errors.New("invalid solution encoding")
+	}
+	var solution powSolution
+	if err := json.Unmarshal(data, &solution); err != nil {
+		return errors.New("invalid solution")
+	}
+
+	if solution.Algorithm != "SHA-256" || !hmac.Equal([]byte(solution.Signature), []byte(powSignature(solution.Challenge))) {
+		return errors.New("invalid challenge signature")
+	}
+	_, query, _ := strings.Cut(solution.Salt, "?")
+	params, _ := url.ParseQuery(query)
+	expires, err := strconv.ParseInt(params.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return errors.New("challenge expired")
+	}
+	sum := sha256.Sum256([]byte(solution.Salt + strconv.FormatInt(solution.Number, 10)))
+	if hex.EncodeToString(sum[:]) != solution.Challenge {
+		return errors.New("wrong solution")
+	}
+
+	usedChallenges.Lock()
+	defer usedChallenges.Unlock()
+	now := time.Now()
+	for challenge, expiry := range usedChallenges.expires {
+		if now.After(expiry) {
+			delete(usedChallenges.expires, challenge)
+		}
+	}
+	if _, used := usedChallenges.expires[solution.Challenge]; used {
+		return errors.New("challenge already used")
+	}
+	usedChallenges.expires[solution.Challenge] = time.Unix(expires, 0)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func solvePoW(t *testing.T, challenge *powChallenge) string {
+	t.Helper()
+	for n := int64(0); n <= challenge.MaxNumber; n++ {
+		sum := sha256.Sum256([]byte(challenge.Salt + strconv.FormatInt(n, 10)))
+		if hex.EncodeToString(sum[:]) == challenge.Challenge {
+			data, _ := json.Marshal(powSolution{
+				Algorithm: challenge.Algorithm,
+				Challenge: challenge.Challenge,
+				Number:    n,
+				Salt:      challenge.Salt,
+				Signature: challenge.Signature,
+			})
+			return base64.StdEncoding.EncodeToString(data)
+		}
+	}
+	t.Fatal("No solution found")
+	return ""
+}
+
+func TestProofOfWork(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	powMaxNumber = 1000
+	defer func() { powMaxNumber = 100000 }()
+
+	w := httptest.NewRecorder()
+	powChallengeHandler(w, httptest.NewRequest("GET", "/captcha/challenge", nil))
+	var challenge powChallenge
+	if err := json.Unmarshal(w.Body.Bytes(), &challenge); err != nil {
+		t.Fatal(err)
+	}
+
+	solution := solvePoW(t, &challenge)
+	if err := verifyPoW(solution); err != nil {
+		t.Fatalf("Expected valid solution, got %v", err)
+	}
+	if err := verifyPoW(solution); err == nil {
+		t.Error("Expected replayed solution to be rejected")
+	}
+
+	forged := challenge
+	forged.Salt = "00?expires=9999999999"
+	sum := sha256.Sum256([]byte(forged.Salt + "1"))
+	forged.Challenge = hex.EncodeToString(sum[:])
+	if err := verifyPoW(solvePoW(t, &forged)); err == nil {
+		t.Error("Expected unsigned challenge to be rejected")
+	}
+
+	if err := verifyPoW("not base64!"); err == nil {
+		t.Error("Expected malformed payload to be rejected")
+	}
+}
+
+func TestBuildIndexPage_ProofOfWork(t *testing.T) {
+	captchaProvider = "pow"
+	defer func() { captchaProvider = "turnstile" }()
+
+	page, _, err := buildIndexPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(page, "challenges.cloudflare.com") || !strings.Contains(page, "const captchaProvider = 'pow'") {
+		t.Error("Expected index page without Turnstile when using proof-of-work")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+)
+
+// setupPprof starts the profiling endpoints on PPROF_ADDR. They run on their
+// own listener so they are never reachable through the public port, and the
+// address must be a loopback one; use port forwarding to reach it remotely.
+func setupPprof() error {
+	addr := os.Getenv("PPROF_ADDR")
+	if addr == "" {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid PPROF_ADDR %q: %w", addr, err)
+	}
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("PPROF_ADDR must listen on a loopback address, got %q", host)
+		}
+	}
+
+	if checkingConfig {
+		return nil
+	}
+
+	debugMux := http.NewServeMux()
+	debugMux.HandleFunc("/debug/pprof/", pprof.Index)
+	debugMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	debugMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	debugMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	debugMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	log.Printf("pprof endpoints available on http://%s/debug/pprof/", listener.Addr())
+	go func() {
+		err := http.Serve(listener, debugMux)
+		log.Printf("pprof server stopped: %v", err)
+	}()
+	return nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSetupPprof_RejectsPublicAddress(t *testing.T) {
+	defer os.Unsetenv("PPROF_ADDR")
+
+	for _, addr := range []string{":6060", "0.0.0.0:6060", "192.168.1.10:6060", "localhost"} {
+		os.Setenv("PPROF_ADDR", addr)
+		if err := setupPprof(); err == nil {
+			t.Errorf("expected %q to be rejected", addr)
+		}
+	}
+}
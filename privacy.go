@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path"
+)
+
+// privacyMode controls how filenames and client IPs appear in logs: "" logs
+// them as is, "hash" replaces filenames with a keyed hash and "omit" drops
+// them. Client IPs are truncated in both privacy modes.
+var privacyMode string
+
+func setupPrivacy() error {
+	privacyMode = os.Getenv("PRIVACY_MODE")
+	switch privacyMode {
+	case "":
+		return nil
+	case "hash", "omit":
+		log.Printf("Privacy mode %q enabled for logs", privacyMode)
+		return nil
+	default:
+		return fmt.Errorf("PRIVACY_MODE must be hash or omit, got %q", privacyMode)
+	}
+}
+
+// logName returns a file path as it should appear in logs. The folder and
+// extension are kept since they don't identify the uploader but help when
+// debugging.
+func logName(name string) string {
+	if privacyMode == "" {
+		return name
+	}
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	if privacyMode == "omit" {
+		return dir + "[redacted]" + ext
+	}
+	return dir + hex.EncodeToString(tokenMAC("log-name", base)[:6]) + ext
+}
+
+// logIP returns the client address as it should appear in logs. In privacy
+// mode IPv4 addresses are truncated to /24 and IPv6 addresses to /48.
+func logIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if privacyMode == "" {
+		return host
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "[redacted]"
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
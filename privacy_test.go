@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLogNameAndIP(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	defer func() { privacyMode = "" }()
+
+	privacyMode = ""
+	if got := logName("session/images/Anna Schmidt.jpg"); got != "session/images/Anna Schmidt.jpg" {
+		t.Errorf("Expected name unchanged without privacy mode, got %q", got)
+	}
+	if got := logIP("203.0.113.42:5123"); got != "203.0.113.42" {
+		t.Errorf("Expected IP unchanged without privacy mode, got %q", got)
+	}
+
+	privacyMode = "hash"
+	hashed := logName("session/images/Anna Schmidt.jpg")
+	if strings.Contains(hashed, "Anna") || !strings.HasPrefix(hashed, "session/images/") || !strings.HasSuffix(hashed, ".jpg") {
+		t.Errorf("Expected hashed base name, got %q", hashed)
+	}
+	if hashed != logName("session/images/Anna Schmidt.jpg") {
+		t.Error("Expected hashes to be stable so log lines can be correlated")
+	}
+
+	privacyMode = "omit"
+	if got := logName("session/Anna Schmidt.jpg"); got != "session/[redacted].jpg" {
+		t.Errorf("Expected omitted name, got %q", got)
+	}
+
+	tests := map[string]string{
+		"203.0.113.42:5123":         "203.0.113.0",
+		"[2001:db8:abcd:12::1]:443": "2001:db8:abcd::",
+		"not-an-ip":                 "[redacted]",
+	}
+	for input, expected := range tests {
+		if got := logIP(input); got != expected {
+			t.Errorf("logIP(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
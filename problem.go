@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// problemTypeBase prefixes the problem types, e.g.
+// "urn:go-uploader:problem:captcha-failed".
+const problemTypeBase = "urn:go-uploader:problem:"
+
+// Problem types of the upload API. Clients branch on these, so they must not
+// change once released.
+const (
+	problemMethodNotAllowed   = "method-not-allowed"
+	problemInvalidContentType = "invalid-content-type"
+	problemInvalidRequest     = "invalid-request"
+	problemTooLarge           = "too-large"
+	problemUnauthorized       = "unauthorized"
+	problemLocationBlocked    = "location-blocked"
+	problemOriginNotAllowed   = "origin-not-allowed"
+	problemCSRFFailed         = "csrf-failed"
+	problemRateLimited        = "rate-limited"
+	problemQuotaExceeded      = "quota-exceeded"
+	problemServerBusy         = "server-busy"
+	problemStorageUnavailable = "storage-unavailable"
+	problemStorageFull        = "storage-full"
+	problemMaintenance        = "maintenance"
+	problemDropClosed         = "drop-closed"
+	problemCaptchaFailed      = "captcha-failed"
+	problemRequestInProgress  = "request-in-progress"
+	problemConsentRequired    = "consent-required"
+	problemTimeout            = "timeout"
+	problemFilesRejected      = "files-rejected"
+	problemUploadFailed       = "upload-failed"
+	problemNotFound           = "not-found"
+	problemUploadFinished     = "upload-finished"
+	problemCancelled          = "cancelled"
+	problemInternal           = "internal-error"
+)
+
+// problem is an RFC 7807 problem details object. RequestID, SessionID and
+// Errors are extension members.
+type problem struct {
+	Type      string   `json:"type"`
+	Title     string   `json:"title"`
+	Status    int      `json:"status"`
+	Detail    string   `json:"detail,omitempty"`
+	RequestID string   `json:"request_id,omitempty"`
+	SessionID string   `json:"session_id,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// acceptsProblem reports whether the client wants machine-readable errors.
+func acceptsProblem(r *http.Request) bool {
+	return acceptsJSON(r) || strings.Contains(r.Header.Get("Accept"), "application/problem+json")
+}
+
+// writeProblem sends an error as application/problem+json to clients
+// accepting JSON, and as plain text to everyone else.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, problemType, detail string) {
+	sendProblem(w, r, problem{Type: problemType, Status: status, Detail: detail})
+}
+
+// sendProblem is writeProblem for problems with extension members. The plain
+// text response lists the errors below the detail.
+func sendProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	if !acceptsProblem(r) {
+		message := p.Detail
+		if len(p.Errors) > 0 {
+			message += "\n" + strings.Join(p.Errors, "\n")
+		}
+		http.Error(w, message, p.Status)
+		return
+	}
+	p.Type = problemTypeBase + p.Type
+	p.Title = http.StatusText(p.Status)
+	p.RequestID = requestID(r.Context())
+	p.SessionID = w.Header().Get(uploadSessionHeader)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
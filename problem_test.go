@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadProblemDetails(t *testing.T) {
+	req := httptest.NewRequest("POST", "/upload", strings.NewReader("x"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Accept", "application/problem+json")
+	rec := httptest.NewRecorder()
+	withRequestID(http.HandlerFunc(uploadHandler)).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var p problem
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != problemTypeBase+problemInvalidContentType || p.Status != http.StatusBadRequest || p.Title != "Bad Request" || p.RequestID == "" {
+		t.Errorf("unexpected problem %+v", p)
+	}
+
+	// Clients not asking for JSON keep getting plain text
+	req.Header.Del("Accept")
+	rec = httptest.NewRecorder()
+	uploadHandler(rec, req)
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") || strings.TrimSpace(rec.Body.String()) != "Invalid Content-Type" {
+		t.Errorf("got %s %q", rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+}
+
+func TestSendProblemErrors(t *testing.T) {
+	req := httptest.NewRequest("POST", "/upload", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	sendProblem(rec, req, problem{Type: problemFilesRejected, Status: http.StatusUnprocessableEntity, Detail: "No files uploaded", Errors: []string{"a.exe: blocked"}})
+
+	var p problem
+	json.NewDecoder(rec.Body).Decode(&p)
+	if len(p.Errors) != 1 || p.Errors[0] != "a.exe: blocked" {
+		t.Errorf("unexpected errors %v", p.Errors)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Certificate used to serve HTTPS; the server speaks plain HTTP when unset.
+var tlsCertFile string
+var tlsKeyFile string
+
+// clientCAs verify client certificates. When set, uploads and the admin API
+// require a certificate signed by one of them.
+var clientCAs *x509.CertPool
+
+// setupProtocols configures TLS, cleartext HTTP/2 for proxies and the
+// optional HTTP/3 listener for the public server. HTTP/2 over TLS is always
+// enabled when a certificate is configured.
+func setupProtocols(server *http.Server) error {
+	tlsCertFile = os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if tlsCertFile != "" {
+		// Fail on startup rather than on the first connection
+		if _, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile); err != nil {
+			return fmt.Errorf("loading TLS_CERT_FILE and TLS_KEY_FILE: %w", err)
+		}
+	}
+	if err := setupClientCAs(server); err != nil {
+		return err
+	}
+
+	if os.Getenv("HTTP2_CLEARTEXT") == "true" {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetHTTP2(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+		log.Println("Accepting unencrypted HTTP/2 connections")
+	}
+
+	addr := os.Getenv("HTTP3_ADDR")
+	if addr == "" {
+		return nil
+	}
+	if tlsCertFile == "" {
+		return fmt.Errorf("HTTP3_ADDR requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if checkingConfig {
+		return nil
+	}
+	return startHTTP3(server, addr)
+}
+
+// setupClientCAs loads TLS_CLIENT_CA. Certificates are verified when given
+// but only required by the endpoints wrapped in requireClientCert, so the
+// upload page and health checks stay reachable without one.
+func setupClientCAs(server *http.Server) error {
+	clientCAs = nil
+	caFile := os.Getenv("TLS_CLIENT_CA")
+	if caFile == "" {
+		return nil
+	}
+	if tlsCertFile == "" {
+		return fmt.Errorf("TLS_CLIENT_CA requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("reading TLS_CLIENT_CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("TLS_CLIENT_CA %s contains no PEM certificates", caFile)
+	}
+	clientCAs = pool
+	server.TLSConfig = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.VerifyClientCertIfGiven}
+	log.Printf("Requiring client certificates signed by %s for uploads and the admin API", caFile)
+	return nil
+}
+
+// requireClientCert rejects requests without a verified client certificate
+// if TLS_CLIENT_CA is set.
+func requireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if clientCAs != nil && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			requestLogf(r.Context(), "Rejected request from %s without client certificate", logIP(clientIP(r)))
+			writeProblem(w, r, http.StatusUnauthorized, problemUnauthorized, "A client certificate is required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// startHTTP3 serves the handler over QUIC on addr and advertises it to
+// clients of the TCP server with an Alt-Svc header.
+func startHTTP3(server *http.Server, addr string) error {
+	cert, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAs != nil {
+		config.ClientCAs, config.ClientAuth = clientCAs, tls.VerifyClientCertIfGiven
+	}
+	h3 := &http3.Server{
+		Handler:   server.Handler,
+		TLSConfig: http3.ConfigureTLSConfig(config),
+		Port:      conn.LocalAddr().(*net.UDPAddr).Port,
+	}
+	next := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+	server.RegisterOnShutdown(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		h3.Shutdown(ctx)
+	})
+
+	log.Printf("Serving HTTP/3 on %s", conn.LocalAddr())
+	go func() {
+		err := h3.Serve(conn)
+		log.Printf("HTTP/3 server stopped: %v", err)
+	}()
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// writeTestCertificate creates a self-signed certificate for localhost.
+func writeTestCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestSetupProtocols_Validation(t *testing.T) {
+	defer func() {
+		os.Unsetenv("TLS_CERT_FILE")
+		os.Unsetenv("HTTP3_ADDR")
+		tlsCertFile, tlsKeyFile = "", ""
+	}()
+
+	os.Setenv("TLS_CERT_FILE", "cert.pem")
+	if err := setupProtocols(&http.Server{}); err == nil {
+		t.Error("expected an error for a certificate without key")
+	}
+
+	os.Unsetenv("TLS_CERT_FILE")
+	os.Setenv("HTTP3_ADDR", "127.0.0.1:0")
+	if err := setupProtocols(&http.Server{}); err == nil {
+		t.Error("expected HTTP/3 without a certificate to be rejected")
+	}
+}
+
+func TestSetupProtocols_CleartextHTTP2(t *testing.T) {
+	os.Setenv("HTTP2_CLEARTEXT", "true")
+	defer os.Unsetenv("HTTP2_CLEARTEXT")
+
+	server := &http.Server{}
+	if err := setupProtocols(server); err != nil {
+		t.Fatalf("setupProtocols() failed: %v", err)
+	}
+	if server.Protocols == nil || !server.Protocols.UnencryptedHTTP2() || !server.Protocols.HTTP1() {
+		t.Errorf("expected HTTP/1 and unencrypted HTTP/2, got %v", server.Protocols)
+	}
+}
+
+func TestSetupProtocols_HTTP3(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	os.Setenv("TLS_CERT_FILE", certFile)
+	os.Setenv("TLS_KEY_FILE", keyFile)
+	os.Setenv("HTTP3_ADDR", "127.0.0.1:0")
+	defer func() {
+		os.Unsetenv("TLS_CERT_FILE")
+		os.Unsetenv("TLS_KEY_FILE")
+		os.Unsetenv("HTTP3_ADDR")
+		tlsCertFile, tlsKeyFile = "", ""
+	}()
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})}
+	if err := setupProtocols(server); err != nil {
+		t.Fatalf("setupProtocols() failed: %v", err)
+	}
+	defer server.Shutdown(t.Context())
+
+	// The TCP server advertises the QUIC port once the listener is running
+	var altSvc string
+	for deadline := time.Now().Add(time.Second); altSvc == "" && time.Now().Before(deadline); {
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		altSvc = w.Header().Get("Alt-Svc")
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, port, found := strings.Cut(altSvc, `h3=":`)
+	port, _, _ = strings.Cut(port, `"`)
+	if !found || port == "" {
+		t.Fatalf("expected an Alt-Svc header announcing HTTP/3, got %q", altSvc)
+	}
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	resp, err := client.Get("https://127.0.0.1:" + port + "/")
+	if err != nil {
+		t.Fatalf("HTTP/3 request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "HTTP/3.0" {
+		t.Errorf("expected request over HTTP/3, got %q", body)
+	}
+}
+
+func TestSetupProtocols_ClientCertificates(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	// The self-signed certificate doubles as CA and client certificate
+	t.Setenv("TLS_CLIENT_CA", certFile)
+	defer func() {
+		tlsCertFile, tlsKeyFile = "", ""
+		clientCAs = nil
+	}()
+
+	server := &http.Server{Handler: requireClientCert(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("uploaded"))
+	})}
+	if err := setupProtocols(server); err != nil {
+		t.Fatalf("setupProtocols() failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeTLS(listener, tlsCertFile, tlsKeyFile)
+	defer server.Close()
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		certs []tls.Certificate
+		want  int
+	}{
+		{"without certificate", nil, http.StatusUnauthorized},
+		{"with certificate", []tls.Certificate{cert}, http.StatusOK},
+	} {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: tc.certs}}}
+		resp, err := client.Get("https://" + listener.Addr().String() + "/upload")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, resp.StatusCode)
+		}
+	}
+
+	t.Setenv("TLS_CERT_FILE", "")
+	t.Setenv("TLS_KEY_FILE", "")
+	if err := setupProtocols(&http.Server{}); err == nil {
+		t.Error("expected TLS_CLIENT_CA without a server certificate to be rejected")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+const quarantinePrefix = "quarantine/"
+const reasonSuffix = ".reason.json"
+
+// quarantineRecord is stored next to a quarantined file and describes why it
+// was flagged and where it would have been stored.
+type quarantineRecord struct {
+	File   string    `json:"file"`
+	Path   string    `json:"path"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// fileCheck inspects the first bytes of an upload and returns a non-empty
+// reason if the file should be quarantined.
+type fileCheck func(name string, head []byte) string
+
+var fileChecks []fileCheck
+
+func setupValidation() {
+	fileChecks = nil
+	if os.Getenv("VALIDATE_CONTENT_TYPE") == "true" {
+		log.Println("Quarantining files whose content doesn't match their extension")
+		fileChecks = append(fileChecks, checkContentMismatch)
+	}
+}
+
+// flagFile runs all configured checks and returns the first reason found.
+func flagFile(name string, head []byte) string {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	for _, check := range fileChecks {
+		if reason := check(name, head); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// checkContentMismatch flags media files whose content sniffs as a document,
+// archive or markup, e.g. an HTML page renamed to photo.jpg.
+func checkContentMismatch(name string, head []byte) string {
+	declared, _, _ := mime.ParseMediaType(mime.TypeByExtension(strings.ToLower(filepath.Ext(name))))
+	major, _, _ := strings.Cut(declared, "/")
+	if major != "image" && major != "video" && major != "audio" {
+		return ""
+	}
+
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if strings.HasPrefix(sniffed, "text/") ||
+		strings.HasPrefix(sniffed, "application/") && sniffed != "application/octet-stream" && sniffed != "application/ogg" {
+		return fmt.Sprintf("content type mismatch: declared %s, detected %s", declared, sniffed)
+	}
+	return ""
+}
+
+// quarantineFile stores the file under the quarantine prefix together with a
+// reason record instead of its public location.
+func quarantineFile(name string, reason string, data io.Reader) (store.SaveResult, error) {
+	qpath := quarantinePrefix + name
+	log.Printf("Quarantining file %s: %s", logName(name), reason)
+	result, err := storage.SaveFile(qpath, data)
+	if err != nil {
+		return result, err
+	}
+	return result, saveQuarantineRecord(name, reason)
+}
+
+// quarantineStored moves an already stored file into quarantine.
+func quarantineStored(stored, name, reason string) error {
+	log.Printf("Quarantining file %s: %s", logName(name), reason)
+	if err := store.MoveFile(storage, stored, quarantinePrefix+name); err != nil {
+		return err
+	}
+	return saveQuarantineRecord(name, reason)
+}
+
+func saveQuarantineRecord(name, reason string) error {
+	qpath := quarantinePrefix + name
+	record, err := json.Marshal(quarantineRecord{
+		File:   qpath,
+		Path:   name,
+		Reason: reason,
+		Time:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = storage.SaveFile(qpath+reasonSuffix, bytes.NewReader(record))
+	return err
+}
+
+func readQuarantineRecord(qpath string) (*quarantineRecord, error) {
+	f, err := storage.OpenFile(qpath + reasonSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var record quarantineRecord
+	if err := json.NewDecoder(f).Decode(&record); err != nil {
+		return nil, fmt.Errorf("decoding reason record: %w", err)
+	}
+	return &record, nil
+}
+
+func quarantineListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	names, err := storage.ListFiles(quarantinePrefix)
+	if err != nil {
+		requestLogf(r.Context(), "Error listing quarantine: %v", err)
+		http.Error(w, "Failed to list quarantine", http.StatusInternalServerError)
+		return
+	}
+
+	records := []quarantineRecord{}
+	for _, name := range names {
+		qpath, ok := strings.CutSuffix(name, reasonSuffix)
+		if !ok {
+			continue
+		}
+		record, err := readQuarantineRecord(qpath)
+		if err != nil {
+			requestLogf(r.Context(), "Error reading quarantine record %s: %v", logName(name), err)
+			continue
+		}
+		records = append(records, *record)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+func quarantineReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	quarantineAction(w, r, func(record *quarantineRecord) error {
+		// Released files go through moderation like any other upload
+		target := uploadPath(record.Path)
+		if _, err := keepVersion(target); err != nil {
+			return err
+		}
+		if err := store.MoveFile(storage, record.File, target); err != nil {
+			return err
+		}
+		requestLogf(r.Context(), "Released quarantined file %s to %s", logName(record.File), logName(target))
+		if !moderationQueue {
+			if err := removeThumbnails(record.Path); err != nil {
+				requestLogf(r.Context(), "Error removing thumbnails of %s: %v", logName(record.Path), err)
+			}
+			publishLive(record.Path, path.Dir(record.Path), "")
+		}
+		if err := storage.DeleteFile(record.File + reasonSuffix); err != nil {
+			return err
+		}
+		recordAudit(r, "quarantine.release", record.Path, map[string]any{"file": record.File, "reason": record.Reason})
+		return nil
+	})
+}
+
+func quarantinePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	quarantineAction(w, r, func(record *quarantineRecord) error {
+		batch := newTrashBatch()
+		if err := discardFile(record.File, batch); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		requestLogf(r.Context(), "Purged quarantined file %s", logName(record.File))
+		if err := discardFile(record.File+reasonSuffix, batch); err != nil {
+			return err
+		}
+		recordAudit(r, "quarantine.purge", record.Path, map[string]any{"file": record.File, "reason": record.Reason})
+		return nil
+	})
+}
+
+// quarantineAction resolves the quarantined file named by the "path" query
+// parameter and applies action to it.
+func quarantineAction(w http.ResponseWriter, r *http.Request, action func(*quarantineRecord) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	qpath := path.Clean(r.URL.Query().Get("path"))
+	if !strings.HasPrefix(qpath, quarantinePrefix) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	record, err := readQuarantineRecord(qpath)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestLogf(r.Context(), "Error reading quarantine record for %s: %v", logName(qpath), err)
+		http.Error(w, "Failed to read quarantine record", http.StatusInternalServerError)
+		return
+	}
+
+	if err := action(record); err != nil {
+		requestLogf(r.Context(), "Error processing quarantined file %s: %v", logName(qpath), err)
+		http.Error(w, "Failed to process quarantined file", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
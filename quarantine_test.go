@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckContentMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		head    []byte
+		flagged bool
+	}{
+		{"photo.jpg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF"), false},
+		{"photo.heic", []byte("\x00\x00\x00\x18ftypheic"), false},
+		{"photo.jpg", []byte("<html><script>alert(1)</script></html>"), true},
+		{"photo.png", []byte("%PDF-1.7"), true},
+		{"notes.txt", []byte("<html></html>"), false},
+	}
+
+	for _, test := range tests {
+		reason := checkContentMismatch(test.name, test.head)
+		if (reason != "") != test.flagged {
+			t.Errorf("checkContentMismatch(%q, %q) = %q, want flagged %v", test.name, test.head, reason, test.flagged)
+		}
+	}
+}
+
+func TestQuarantine_UploadAndRelease(t *testing.T) {
+	fakeTurnstile(t)
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	fileChecks = []fileCheck{checkContentMismatch}
+	defer func() { fileChecks = nil }()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "photo.jpg")
+	part.Write([]byte("<html><body>not a photo</body></html>"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	names, _ := mockStorage.ListFiles("")
+	if len(names) != 2 || !strings.HasPrefix(names[0], quarantinePrefix) {
+		t.Fatalf("Expected file and reason record in quarantine, got %v", names)
+	}
+	qpath := names[0]
+
+	adminToken = "secret"
+	defer func() { adminToken = "" }()
+
+	list := httptest.NewRequest("GET", "/admin/quarantine", nil)
+	w = httptest.NewRecorder()
+	requireAdmin(quarantineListHandler)(w, list)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", w.Code)
+	}
+
+	list.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	requireAdmin(quarantineListHandler)(w, list)
+	if !strings.Contains(w.Body.String(), "content type mismatch") {
+		t.Errorf("Expected reason in listing, got %s", w.Body.String())
+	}
+
+	release := httptest.NewRequest("POST", "/admin/quarantine/release?path="+qpath, nil)
+	release.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	requireAdmin(quarantineReleaseHandler)(w, release)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	names, _ = mockStorage.ListFiles("")
+	if len(names) != 2 || !strings.HasPrefix(names[1], auditPrefix) || names[0] != strings.TrimPrefix(qpath, quarantinePrefix) {
+		t.Errorf("Expected file released to its original path and an audit event, got %v", names)
+	}
+}
+
+func TestQuarantine_ReleaseIntoModeration(t *testing.T) {
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	moderationQueue, versioning = true, true
+	defer func() {
+		storage = originalStorage
+		moderationQueue, versioning = false, false
+	}()
+
+	mockStorage.SaveFile(pendingPrefix+"photo.jpg", strings.NewReader("earlier"))
+	if _, err := quarantineFile("photo.jpg", "content type mismatch", strings.NewReader("later")); err != nil {
+		t.Fatal(err)
+	}
+
+	release := httptest.NewRequest("POST", "/admin/quarantine/release?path="+quarantinePrefix+"photo.jpg", nil)
+	w := httptest.NewRecorder()
+	quarantineReleaseHandler(w, release)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if got := string(mockStorage.files[pendingPrefix+"photo.jpg"]); got != "later" {
+		t.Errorf("Expected the released file to await moderation, got %q", got)
+	}
+	if _, ok := mockStorage.files["photo.jpg"]; ok {
+		t.Error("Expected the released file not to be published")
+	}
+	versions, _ := mockStorage.ListFiles(versionsPrefix + pendingPrefix + "photo.jpg/")
+	if len(versions) != 1 || string(mockStorage.files[versions[0]]) != "earlier" {
+		t.Errorf("Expected the pending file to be kept as a version, got %v", versions)
+	}
+	if quarantined, _ := mockStorage.ListFiles(quarantinePrefix); len(quarantined) != 0 {
+		t.Errorf("Expected the quarantine to be empty, got %v", quarantined)
+	}
+	if audit, _ := mockStorage.ListFiles(auditPrefix); len(audit) != 1 {
+		t.Errorf("Expected an audit event, got %v", audit)
+	}
+}
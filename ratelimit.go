@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// counterStore keeps counters that reset after a fixed window. It's shared
+// through Redis when several replicas serve the same event.
+type counterStore interface {
+	// Add increments the counter by n and returns the new value and the
+	// time until the window resets.
+	Add(ctx context.Context, key string, n int64, window time.Duration) (int64, time.Duration, error)
+}
+
+var counters counterStore
+
+// Uploads allowed per client within uploadRateWindow; zero disables the limit.
+var uploadRateLimit int64
+var uploadRateWindow = time.Minute
+
+// Files a client may store within fileQuotaWindow; zero disables the quota.
+var fileQuota int64
+var fileQuotaWindow = 24 * time.Hour
+
+func setupRateLimiting() error {
+	var err error
+	uploadRateLimit, uploadRateWindow, err = parseWindowLimit("RATE_LIMIT_UPLOADS", "RATE_LIMIT_WINDOW", time.Minute)
+	if err != nil {
+		return err
+	}
+	fileQuota, fileQuotaWindow, err = parseWindowLimit("QUOTA_FILES", "QUOTA_WINDOW", 24*time.Hour)
+	if err != nil {
+		return err
+	}
+	if uploadRateLimit == 0 && fileQuota == 0 {
+		return nil
+	}
+
+	if url := os.Getenv("RATE_LIMIT_REDIS_URL"); url != "" {
+		options, err := redis.ParseURL(url)
+		if err != nil {
+			return fmt.Errorf("parsing RATE_LIMIT_REDIS_URL: %w", err)
+		}
+		client := redis.NewClient(options)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("connecting to Redis: %w", err)
+		}
+		log.Printf("Sharing rate limits through Redis at %s", options.Addr)
+		counters = &redisCounters{client: client}
+	} else {
+		counters = newMemoryCounters()
+	}
+
+	if uploadRateLimit > 0 {
+		log.Printf("Limiting uploads to %d per client every %s", uploadRateLimit, uploadRateWindow)
+	}
+	if fileQuota > 0 {
+		log.Printf("Limiting each client to %d files every %s", fileQuota, fileQuotaWindow)
+	}
+	return nil
+}
+
+func parseWindowLimit(limitVar, windowVar string, defaultWindow time.Duration) (int64, time.Duration, error) {
+	window := defaultWindow
+	value := os.Getenv(limitVar)
+	if value == "" {
+		return 0, window, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 1 {
+		return 0, window, fmt.Errorf("%s must be a positive number, got %q", limitVar, value)
+	}
+	if value := os.Getenv(windowVar); value != "" {
+		window, err = time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return 0, window, fmt.Errorf("invalid %s %q", windowVar, value)
+		}
+	}
+	return limit, window, nil
+}
+
+// checkRateLimit counts an upload request against the client's rate limit
+// and returns how long to wait if it's exceeded.
+func checkRateLimit(r *http.Request) (time.Duration, bool) {
+	if uploadRateLimit == 0 {
+		return 0, true
+	}
+	count, reset, ok := addCounter(r, "rate:"+clientIP(r), 1, uploadRateWindow)
+	return reset, !ok || count <= uploadRateLimit
+}
+
+// checkQuota reports whether the client may still upload files, and if not,
+// when the quota resets.
+func checkQuota(r *http.Request) (time.Duration, bool) {
+	if fileQuota == 0 {
+		return 0, true
+	}
+	count, reset, ok := addCounter(r, "quota:"+clientIP(r), 0, fileQuotaWindow)
+	return reset, !ok || count < fileQuota
+}
+
+// addToQuota counts stored files against the client's quota.
+func addToQuota(r *http.Request, files int) {
+	if fileQuota == 0 || files == 0 {
+		return
+	}
+	addCounter(r, "quota:"+clientIP(r), int64(files), fileQuotaWindow)
+}
+
+// addCounter adds n to a counter. A failing store is logged and reported as
+// not ok, and callers let the request through, so an unavailable Redis
+// doesn't stop uploads.
+func addCounter(r *http.Request, key string, n int64, window time.Duration) (int64, time.Duration, bool) {
+	count, reset, err := counters.Add(r.Context(), key, n, window)
+	if err != nil {
+		requestLogf(r.Context(), "Error updating rate limit counter: %v", err)
+		return 0, 0, false
+	}
+	return count, reset, true
+}
+
+// retryAfter formats a wait time for the Retry-After header.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int((wait + time.Second - 1) / time.Second))
+}
+
+type windowCounter struct {
+	count int64
+	reset time.Time
+}
+
+// memoryCounters keeps the counters in process, for single instances.
+type memoryCounters struct {
+	mu        sync.Mutex
+	counters  map[string]*windowCounter
+	lastSweep time.Time
+}
+
+func newMemoryCounters() *memoryCounters {
+	return &memoryCounters{counters: make(map[string]*windowCounter), lastSweep: time.Now()}
+}
+
+func (m *memoryCounters) Add(_ context.Context, key string, n int64, window time.Duration) (int64, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, c := range m.counters {
+			if now.After(c.reset) {
+				delete(m.counters, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	c, ok := m.counters[key]
+	if !ok || now.After(c.reset) {
+		c = &windowCounter{reset: now.Add(window)}
+		m.counters[key] = c
+	}
+	c.count += n
+	return c.count, c.reset.Sub(now), nil
+}
+
+// redisCounters shares the counters between replicas. The increment and the
+// expiry are applied atomically by a script.
+type redisCounters struct {
+	client *redis.Client
+}
+
+var addCounterScript = redis.NewScript(`
+local count = redis.call('INCRBY', KEYS[1], ARGV[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	ttl = tonumber(ARGV[2])
+end
+return {count, ttl}
+`)
+
+func (c *redisCounters) Add(ctx context.Context, key string, n int64, window time.Duration) (int64, time.Duration, error) {
+	result, err := addCounterScript.Run(ctx, c.client, []string{"go-uploader:" + key}, n, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return result[0], time.Duration(result[1]) * time.Millisecond, nil
+}
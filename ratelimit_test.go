@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func testCounterStore(t *testing.T, store counterStore) {
+	ctx := context.Background()
+	for i := int64(1); i <= 3; i++ {
+		count, reset, err := store.Add(ctx, "rate:192.0.2.1", 1, time.Minute)
+		if err != nil {
+			t.Fatalf("Add() failed: %v", err)
+		}
+		if count != i {
+			t.Errorf("expected count %d, got %d", i, count)
+		}
+		if reset <= 0 || reset > time.Minute {
+			t.Errorf("unexpected reset time %s", reset)
+		}
+	}
+	if count, _, _ := store.Add(ctx, "rate:192.0.2.2", 0, time.Minute); count != 0 {
+		t.Errorf("expected counters to be separate per key, got %d", count)
+	}
+}
+
+func TestMemoryCounters(t *testing.T) {
+	testCounterStore(t, newMemoryCounters())
+
+	store := newMemoryCounters()
+	store.Add(context.Background(), "key", 5, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if count, _, _ := store.Add(context.Background(), "key", 1, time.Minute); count != 1 {
+		t.Errorf("expected counter to reset after the window, got %d", count)
+	}
+}
+
+func TestRedisCounters(t *testing.T) {
+	server := miniredis.RunT(t)
+	os.Setenv("RATE_LIMIT_UPLOADS", "2")
+	os.Setenv("RATE_LIMIT_REDIS_URL", "redis://"+server.Addr())
+	defer func() {
+		os.Unsetenv("RATE_LIMIT_UPLOADS")
+		os.Unsetenv("RATE_LIMIT_REDIS_URL")
+		uploadRateLimit = 0
+		counters = nil
+	}()
+	if err := setupRateLimiting(); err != nil {
+		t.Fatalf("setupRateLimiting() failed: %v", err)
+	}
+	testCounterStore(t, counters)
+
+	if ttl := server.TTL("go-uploader:rate:192.0.2.1"); ttl != time.Minute {
+		t.Errorf("expected key to expire with the window, got %s", ttl)
+	}
+}
+
+func TestUploadHandler_RateLimited(t *testing.T) {
+	fakeTurnstile(t)
+	uploadRateLimit = 1
+	counters = newMemoryCounters()
+	defer func() {
+		uploadRateLimit = 0
+		counters = nil
+	}()
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/upload", nil)
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+		return req
+	}
+
+	// The first request passes the limit and fails later on the empty body
+	w := httptest.NewRecorder()
+	uploadHandler(w, newRequest())
+	if w.Code == http.StatusTooManyRequests {
+		t.Fatal("expected first request to pass the rate limit")
+	}
+
+	w = httptest.NewRecorder()
+	uploadHandler(w, newRequest())
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	fileQuota = 3
+	counters = newMemoryCounters()
+	defer func() {
+		fileQuota = 0
+		counters = nil
+	}()
+
+	r := httptest.NewRequest("POST", "/upload", nil)
+	if _, ok := checkQuota(r); !ok {
+		t.Fatal("expected quota to be available")
+	}
+	addToQuota(r, 2)
+	if _, ok := checkQuota(r); !ok {
+		t.Fatal("expected quota to be available after 2 of 3 files")
+	}
+	addToQuota(r, 1)
+	if wait, ok := checkQuota(r); ok || wait <= 0 {
+		t.Errorf("expected quota to be exhausted, got %v %s", ok, wait)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const receiptPurpose = "receipt"
+
+// receiptTemplate replaces the built-in receipt page if RECEIPT_TEMPLATE is
+// set. It is executed with the uploadResponse.
+var receiptTemplate *template.Template
+
+func setupReceiptPage() error {
+	file := os.Getenv("RECEIPT_TEMPLATE")
+	if file == "" {
+		return nil
+	}
+	t, err := template.ParseFiles(file)
+	if err != nil {
+		return fmt.Errorf("parsing RECEIPT_TEMPLATE: %w", err)
+	}
+	receiptTemplate = t
+	log.Printf("Rendering upload receipts with %s", file)
+	return nil
+}
+
+// uploadReceipt lists what the server accepted in an upload session. It is
+// signed and handed to the guest, who can later prove that the files were
+// received unmodified.
+type uploadReceipt struct {
+	Session string        `json:"session"`
+	Time    time.Time     `json:"time"`
+	Files   []receiptFile `json:"files"`
+}
+
+type receiptFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// uploadResponse is sent instead of plain text to clients accepting JSON.
+type uploadResponse struct {
+	Message string `json:"message"`
+	Session string `json:"session"`
+	// SessionID identifies the upload, see uploadSessionHeader
+	SessionID string `json:"session_id,omitempty"`
+	Saved     int    `json:"saved"`
+	Failed    int    `json:"failed"`
+	Receipt   string `json:"receipt,omitempty"`
+	// Files are the accepted files, with where and how big they were stored
+	Files []uploadedFile `json:"files,omitempty"`
+}
+
+type uploadedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Ref    string `json:"ref,omitempty"`
+	Status string `json:"status"`
+}
+
+// DisplaySize formats the size for the receipt page.
+func (f uploadedFile) DisplaySize() string {
+	return formatSize(f.Size)
+}
+
+// newReceipt signs a receipt over the accepted files of a session, including
+// files held in quarantine or for moderation.
+func newReceipt(session string, files []*pipelineFile) (string, error) {
+	receipt := uploadReceipt{Session: session, Time: time.Now().UTC(), Files: []receiptFile{}}
+	for _, file := range files {
+		receipt.Files = append(receipt.Files, receiptFile{
+			Path:   filepath.ToSlash(file.Name),
+			Size:   file.Size,
+			SHA256: file.SHA256,
+		})
+	}
+	return signToken(receiptPurpose, receipt)
+}
+
+func acceptsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// acceptsHTML reports whether r comes from a browser submitting a form.
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// writeUploadResult sends the result of a successful or partially successful
+// upload. Clients accepting JSON also receive the signed receipt, browsers
+// posting a form get it on a receipt page.
+func writeUploadResult(w http.ResponseWriter, r *http.Request, code int, resp uploadResponse, files []*pipelineFile) {
+	wantJSON := acceptsJSON(r)
+	if !wantJSON && !acceptsHTML(r) {
+		w.WriteHeader(code)
+		w.Write([]byte(resp.Message))
+		return
+	}
+
+	receipt, err := newReceipt(resp.Session, files)
+	if err != nil {
+		requestLogf(r.Context(), "Error signing receipt for session %s: %v", resp.Session, err)
+	}
+	resp.Receipt = receipt
+	for _, file := range files {
+		resp.Files = append(resp.Files, uploadedFile{
+			Path:   filepath.ToSlash(file.Name),
+			Size:   file.Size,
+			Ref:    file.Ref,
+			Status: file.Status,
+		})
+	}
+	if !wantJSON {
+		writeReceiptPage(w, r, code, resp)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeReceiptPage renders the receipt page, falling back to the plain
+// message if the template fails.
+func writeReceiptPage(w http.ResponseWriter, r *http.Request, code int, resp uploadResponse) {
+	var buf bytes.Buffer
+	var err error
+	if receiptTemplate != nil {
+		err = receiptTemplate.Execute(&buf, resp)
+	} else {
+		err = pageTemplates().ExecuteTemplate(&buf, "receipt.html", resp)
+	}
+	if err != nil {
+		requestLogf(r.Context(), "Error rendering receipt page: %v", err)
+		w.WriteHeader(code)
+		w.Write([]byte(resp.Message))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// receiptVerifyHandler checks a receipt posted as the request body and
+// returns its contents if the signature is valid.
+func receiptVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Receipt too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var receipt uploadReceipt
+	if err := parseToken(receiptPurpose, strings.TrimSpace(string(body)), &receipt); err != nil {
+		http.Error(w, "Invalid receipt", http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receipt)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadHandler_Receipt(t *testing.T) {
+	fakeTurnstile(t)
+	originalStorage := storage
+	storage = &MockStorage{}
+	defer func() { storage = originalStorage }()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "photo.jpg")
+	part.Write([]byte("photo"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var resp uploadResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if resp.Saved != 1 || resp.Receipt == "" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if len(resp.Files) != 1 || resp.Files[0].Size != 5 || resp.Files[0].Ref != "mock://"+resp.Files[0].Path {
+		t.Errorf("unexpected files: %+v", resp.Files)
+	}
+
+	verify := httptest.NewRecorder()
+	receiptVerifyHandler(verify, httptest.NewRequest("POST", "/receipts/verify", strings.NewReader(resp.Receipt)))
+	if verify.Code != http.StatusOK {
+		t.Fatalf("expected valid receipt, got %d: %s", verify.Code, verify.Body.String())
+	}
+	var receipt uploadReceipt
+	json.NewDecoder(verify.Body).Decode(&receipt)
+	sum := sha256.Sum256([]byte("photo"))
+	if receipt.Session != resp.Session || len(receipt.Files) != 1 ||
+		receipt.Files[0].Path != resp.Session+"/photo.jpg" || receipt.Files[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+
+	tampered := strings.Replace(resp.Receipt, resp.Receipt[:4], "AAAA", 1)
+	verify = httptest.NewRecorder()
+	receiptVerifyHandler(verify, httptest.NewRequest("POST", "/receipts/verify", strings.NewReader(tampered)))
+	if verify.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected tampered receipt to be rejected, got %d", verify.Code)
+	}
+}
+
+func TestUploadHandler_PlainTextWithoutReceipt(t *testing.T) {
+	fakeTurnstile(t)
+	originalStorage := storage
+	storage = &MockStorage{}
+	defer func() { storage = originalStorage }()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "photo.jpg")
+	part.Write([]byte("photo"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+
+	if w.Body.String() != "Uploaded 1 file(s)" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestUploadHandler_ReceiptPage(t *testing.T) {
+	fakeTurnstile(t)
+	originalStorage := storage
+	storage = &MockStorage{}
+	defer func() {
+		storage = originalStorage
+		receiptTemplate = nil
+	}()
+
+	upload := func() *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "photo.jpg")
+		part.Write(bytes.Repeat([]byte("x"), 2048))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w
+	}
+
+	w := upload()
+	page := w.Body.String()
+	if w.Code != http.StatusCreated || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("expected a receipt page, got %d: %s", w.Code, page)
+	}
+	if !strings.Contains(page, "/photo.jpg") || !strings.Contains(page, "2.0 KB") || !strings.Contains(page, "<textarea") {
+		t.Errorf("expected the file, its size and the receipt on the page: %s", page)
+	}
+
+	custom := filepath.Join(t.TempDir(), "receipt.html")
+	os.WriteFile(custom, []byte(`Session {{.Session}}{{range .Files}} {{.Path}}{{end}}`), 0644)
+	t.Setenv("RECEIPT_TEMPLATE", custom)
+	if err := setupReceiptPage(); err != nil {
+		t.Fatal(err)
+	}
+	if page := upload().Body.String(); !strings.HasPrefix(page, "Session ") || !strings.HasSuffix(page, "/photo.jpg") {
+		t.Errorf("expected the custom template to be used, got %q", page)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/time/rate"
+)
+
+// configLock guards the settings that can be replaced by reloadConfig while
+// uploads are running. Readers only hold it for short lookups.
+var configLock sync.RWMutex
+
+// indexPage is the rendered upload page, rebuilt on reload so changes to the
+// page settings apply without a restart.
+var indexPage string
+
+// reloadSteps re-run the setup of everything that can change at runtime.
+// Storage, signing, the CAPTCHA provider and the listeners need a restart.
+var reloadSteps = []func() error{
+	setupFileCountLimit,
+	setupConcurrencyLimit,
+	setupThrottling,
+	setupTypeRouting,
+	setupHashBlocklist,
+	func() error {
+		setupExtensionBlocklist()
+		setupValidation()
+		setupTurnstile()
+		return nil
+	},
+	func() error {
+		page, _, err := buildIndexPage()
+		indexPage = page
+		return err
+	},
+}
+
+// runtimeConfig is a snapshot of the reloadable settings, used to roll back a
+// reload that fails halfway.
+type runtimeConfig struct {
+	maxFiles           int
+	uploadSlots        chan struct{}
+	connection         int64
+	global             *rate.Limiter
+	downloadConnection int64
+	downloadGlobal     *rate.Limiter
+	typeRoutes         []typeRoute
+	blockedExtensions  []string
+	fileChecks         []fileCheck
+	blockedHashes      map[string]bool
+	hashAction         string
+	turnstileAction    string
+	turnstileHostnames []string
+	indexPage          string
+}
+
+func currentConfig() runtimeConfig {
+	return runtimeConfig{
+		maxFiles:           maxFilesPerRequest,
+		uploadSlots:        uploadSlots,
+		connection:         connectionBandwidth,
+		global:             globalLimiter,
+		downloadConnection: downloadConnectionBandwidth,
+		downloadGlobal:     downloadGlobalLimiter,
+		typeRoutes:         typeRoutes,
+		blockedExtensions:  blockedExtensions,
+		fileChecks:         fileChecks,
+		blockedHashes:      blockedHashes,
+		hashAction:         hashBlocklistAction,
+		turnstileAction:    turnstileAction,
+		turnstileHostnames: turnstileHostnames,
+		indexPage:          indexPage,
+	}
+}
+
+func (c runtimeConfig) apply() {
+	maxFilesPerRequest = c.maxFiles
+	uploadSlots = c.uploadSlots
+	connectionBandwidth = c.connection
+	globalLimiter = c.global
+	downloadConnectionBandwidth = c.downloadConnection
+	downloadGlobalLimiter = c.downloadGlobal
+	typeRoutes = c.typeRoutes
+	blockedExtensions = c.blockedExtensions
+	fileChecks = c.fileChecks
+	blockedHashes = c.blockedHashes
+	hashBlocklistAction = c.hashAction
+	turnstileAction = c.turnstileAction
+	turnstileHostnames = c.turnstileHostnames
+	indexPage = c.indexPage
+}
+
+// setupReload reloads the configuration whenever the process receives SIGHUP.
+func setupReload() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Println("Received SIGHUP, reloading configuration")
+			if err := reloadConfig(); err != nil {
+				log.Printf("Failed to reload configuration, keeping the previous one: %v", err)
+				continue
+			}
+			log.Println("Configuration reloaded")
+		}
+	}()
+}
+
+// reloadConfig re-reads the .env file and applies the reloadable settings.
+// Uploads in progress keep the limits they started with. If any setting is
+// invalid, the previous configuration stays in effect.
+func reloadConfig() error {
+	err := godotenv.Overload()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	previous := currentConfig()
+	for _, step := range reloadSteps {
+		if err := step(); err != nil {
+			previous.apply()
+			return err
+		}
+	}
+	return nil
+}
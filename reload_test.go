@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	os.Setenv("TURNSTILE_SITEKEY", "test-sitekey")
+	os.Setenv("MAX_FILES_PER_REQUEST", "5")
+	os.Setenv("BLOCKED_EXTENSIONS", ".exe")
+	defer func() {
+		os.Unsetenv("TURNSTILE_SITEKEY")
+		os.Unsetenv("MAX_FILES_PER_REQUEST")
+		os.Unsetenv("BLOCKED_EXTENSIONS")
+		maxFilesPerRequest = 0
+		blockedExtensions = nil
+		indexPage = ""
+	}()
+
+	if err := reloadConfig(); err != nil {
+		t.Fatalf("reloadConfig() failed: %v", err)
+	}
+	if maxFilesPerRequest != 5 {
+		t.Errorf("expected file limit 5, got %d", maxFilesPerRequest)
+	}
+	if checkExtension("setup.exe") == "" || checkExtension("script.js") != "" {
+		t.Errorf("expected only .exe to be blocked, got %v", blockedExtensions)
+	}
+	if indexPage == "" {
+		t.Error("expected index page to be rebuilt")
+	}
+
+	// An invalid value keeps the previous configuration in place
+	os.Setenv("MAX_FILES_PER_REQUEST", "5")
+	os.Setenv("BLOCKED_EXTENSIONS", ".js")
+	os.Setenv("UPLOAD_BANDWIDTH_TOTAL", "fast")
+	defer os.Unsetenv("UPLOAD_BANDWIDTH_TOTAL")
+	if err := reloadConfig(); err == nil {
+		t.Fatal("expected reload with invalid bandwidth to fail")
+	}
+	if maxFilesPerRequest != 5 || checkExtension("setup.exe") == "" {
+		t.Errorf("expected previous configuration to be restored")
+	}
+}
+
+func TestReloadConfig_ReleasesOldSlots(t *testing.T) {
+	os.Setenv("TURNSTILE_SITEKEY", "test-sitekey")
+	os.Setenv("MAX_CONCURRENT_UPLOADS", "1")
+	defer func() {
+		os.Unsetenv("TURNSTILE_SITEKEY")
+		os.Unsetenv("MAX_CONCURRENT_UPLOADS")
+		uploadSlots = nil
+		indexPage = ""
+	}()
+
+	if err := reloadConfig(); err != nil {
+		t.Fatalf("reloadConfig() failed: %v", err)
+	}
+	release, ok := acquireUploadSlot()
+	if !ok {
+		t.Fatal("expected a free slot")
+	}
+	old := uploadSlots
+
+	os.Setenv("MAX_CONCURRENT_UPLOADS", "2")
+	if err := reloadConfig(); err != nil {
+		t.Fatalf("reloadConfig() failed: %v", err)
+	}
+	release()
+	if len(old) != 0 {
+		t.Error("expected the slot to be returned to the limit it was taken from")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultTypePrefixes is used when TYPE_ROUTING is enabled without an
+// explicit TYPE_PREFIXES mapping.
+const defaultTypePrefixes = "images=image/*;videos=video/*;documents=application/pdf,text/*,.doc,.docx,.odt,.xls,.xlsx,.ods,.ppt,.pptx,.odp"
+
+// typeRoute sends files matching any of its patterns into Prefix within the
+// session folder. Patterns are extensions (".pdf"), MIME wildcards
+// ("image/*") or exact media types ("application/pdf").
+type typeRoute struct {
+	Prefix   string
+	Patterns []string
+}
+
+var typeRoutes []typeRoute
+
+func setupTypeRouting() error {
+	typeRoutes = nil
+	spec := os.Getenv("TYPE_PREFIXES")
+	if spec == "" {
+		if os.Getenv("TYPE_ROUTING") != "true" {
+			return nil
+		}
+		spec = defaultTypePrefixes
+	}
+
+	routes, err := parseTypeRoutes(spec)
+	if err != nil {
+		return fmt.Errorf("parsing TYPE_PREFIXES: %w", err)
+	}
+	for _, route := range routes {
+		log.Printf("Routing %s into %s/", strings.Join(route.Patterns, ", "), route.Prefix)
+	}
+	typeRoutes = routes
+	return nil
+}
+
+// parseTypeRoutes parses a mapping of the form
+// "images=image/*,.heic;documents=application/pdf".
+func parseTypeRoutes(spec string) ([]typeRoute, error) {
+	var routes []typeRoute
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, patterns, ok := strings.Cut(entry, "=")
+		prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+		if !ok || prefix == "" || strings.Contains(prefix, "..") {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+		route := typeRoute{Prefix: prefix, Patterns: splitList(patterns)}
+		if len(route.Patterns) == 0 {
+			return nil, fmt.Errorf("no patterns for prefix %q", prefix)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// typePrefix returns the prefix the file should be stored under, or an empty
+// string if no route matches. The declared content type is preferred and the
+// extension is used when the client didn't send a useful one.
+func typePrefix(filename, contentType string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(mime.TypeByExtension(ext))
+	}
+
+	configLock.RLock()
+	defer configLock.RUnlock()
+	for _, route := range typeRoutes {
+		for _, pattern := range route.Patterns {
+			if matchesType(pattern, ext, mediaType) {
+				return route.Prefix
+			}
+		}
+	}
+	return ""
+}
+
+func matchesType(pattern, ext, mediaType string) bool {
+	switch {
+	case strings.HasPrefix(pattern, "."):
+		return pattern == ext
+	case strings.HasSuffix(pattern, "/*"):
+		return mediaType != "" && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == mediaType
+	}
+}
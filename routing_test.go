@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestTypePrefix(t *testing.T) {
+	routes, err := parseTypeRoutes("raw=.cr2,.nef;" + defaultTypePrefixes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeRoutes = routes
+	defer func() { typeRoutes = nil }()
+
+	tests := []struct {
+		filename    string
+		contentType string
+		expected    string
+	}{
+		{"photo.jpg", "image/jpeg", "images"},
+		{"photo.jpg", "", "images"},
+		{"clip.mp4", "application/octet-stream", "videos"},
+		{"invoice.pdf", "application/pdf", "documents"},
+		{"notes.docx", "", "documents"},
+		{"IMG_0001.CR2", "", "raw"},
+		{"archive.zip", "application/zip", ""},
+	}
+
+	for _, test := range tests {
+		result := typePrefix(test.filename, test.contentType)
+		if result != test.expected {
+			t.Errorf("typePrefix(%q, %q) = %q, want %q", test.filename, test.contentType, result, test.expected)
+		}
+	}
+}
+
+func TestParseTypeRoutes_Invalid(t *testing.T) {
+	for _, spec := range []string{"images", "=image/*", "../up=image/*", "images="} {
+		if _, err := parseTypeRoutes(spec); err == nil {
+			t.Errorf("parseTypeRoutes(%q) expected error", spec)
+		}
+	}
+}
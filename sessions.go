@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+const defaultSessionFolder = "{{timestamp}}"
+
+// sessionFolder is the template for the folder of an upload request, e.g.
+// "{{date}}/{{uploader}}/{{uuid}}".
+var sessionFolder = defaultSessionFolder
+
+// sessionLocation is the time zone session folders are named in. It is also
+// the default for the upload windows of drops.
+var sessionLocation = time.Local
+
+// sharedSessionFolders is set when several requests can end up in the same
+// folder, so file names have to be made unique.
+var sharedSessionFolders bool
+
+// captureDateFolders renders the date placeholders of the session folder
+// from the capture time of each image instead of the upload time.
+var captureDateFolders bool
+
+// datePlaceholders are rendered from the capture time with
+// captureDateFolders.
+var datePlaceholders = map[string]bool{"date": true, "year": true, "month": true, "day": true, "hour": true}
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*(\w*)\s*\}\}`)
+
+// sessionPlaceholders render the parts of a session folder template.
+var sessionPlaceholders = map[string]func(r *http.Request, now time.Time) string{
+	"timestamp": func(_ *http.Request, now time.Time) string { return now.Format("2006-01-02_15-04-05.000") },
+	// ISO 8601 in the basic format, which needs no colons
+	"iso8601":  func(_ *http.Request, now time.Time) string { return now.Format("20060102T150405.000-0700") },
+	"date":     func(_ *http.Request, now time.Time) string { return now.Format("2006-01-02") },
+	"year":     func(_ *http.Request, now time.Time) string { return now.Format("2006") },
+	"month":    func(_ *http.Request, now time.Time) string { return now.Format("01") },
+	"day":      func(_ *http.Request, now time.Time) string { return now.Format("02") },
+	"hour":     func(_ *http.Request, now time.Time) string { return now.Format("15") },
+	"uploader": uploaderID,
+	"uuid":     func(*http.Request, time.Time) string { return newUUID() },
+}
+
+func setupSessionFolders() error {
+	spec := os.Getenv("SESSION_FOLDER")
+	if spec == "" {
+		spec = defaultSessionFolder
+	}
+	if strings.Contains(spec, "..") || strings.Trim(spec, "/") != spec {
+		return fmt.Errorf("SESSION_FOLDER must be a relative path, got %q", spec)
+	}
+	shared := true
+	dated := false
+	for _, match := range placeholderPattern.FindAllStringSubmatch(spec, -1) {
+		if _, ok := sessionPlaceholders[match[1]]; !ok {
+			return fmt.Errorf("unknown placeholder %q in SESSION_FOLDER", match[0])
+		}
+		if match[1] == "timestamp" || match[1] == "iso8601" || match[1] == "uuid" {
+			shared = false
+		}
+		dated = dated || datePlaceholders[match[1]]
+	}
+	location := time.Local
+	if zone := os.Getenv("TIMEZONE"); zone != "" {
+		var err error
+		location, err = time.LoadLocation(zone)
+		if err != nil {
+			return fmt.Errorf("invalid TIMEZONE: %w", err)
+		}
+	}
+	capture := os.Getenv("CAPTURE_DATE_FOLDERS") == "true"
+	if capture && !dated {
+		return fmt.Errorf("CAPTURE_DATE_FOLDERS needs a date placeholder in SESSION_FOLDER, got %q", spec)
+	}
+	if isInternalPath(placeholderPattern.ReplaceAllString(spec, "x")) {
+		return fmt.Errorf("SESSION_FOLDER must not start with an internal prefix, got %q", spec)
+	}
+
+	sessionFolder = spec
+	sharedSessionFolders = shared
+	captureDateFolders = capture
+	sessionLocation = location
+	if spec != defaultSessionFolder {
+		log.Printf("Storing uploads in session folders %s", spec)
+	}
+	if location != time.Local {
+		log.Printf("Naming session folders in time zone %s", location)
+	}
+	if capture {
+		log.Printf("Sorting images into folders by their capture date")
+	}
+	return nil
+}
+
+// newSessionFolder renders the folder for an upload request.
+func newSessionFolder(r *http.Request, now time.Time) string {
+	return newSessionFolders(r, now).Session
+}
+
+// sessionFolders places the files of an upload request. Session is the
+// folder of the request; with captureDateFolders images move to the folder
+// of their capture date, which otherwise renders the same.
+type sessionFolders struct {
+	Session string
+	// template has everything but the date placeholders rendered, so
+	// placeholders like {{uuid}} stay the same for all files
+	template string
+}
+
+func newSessionFolders(r *http.Request, now time.Time) sessionFolders {
+	now = now.In(sessionLocation)
+	template := placeholderPattern.ReplaceAllStringFunc(sessionFolder, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		if captureDateFolders && datePlaceholders[name] {
+			return "{{" + name + "}}"
+		}
+		return sanitizeFilename(sessionPlaceholders[name](r, now))
+	})
+	if link := requestUploadLink(r.Context()); link != nil && link.Album != "" {
+		template = link.Album + "/" + template
+	}
+	if d := requestDrop(r.Context()); d != nil {
+		template = d.Prefix + "/" + template
+	}
+	folders := sessionFolders{template: template}
+	folders.Session = folders.render(now)
+	return folders
+}
+
+// render fills in the date placeholders left in the template.
+func (f sessionFolders) render(date time.Time) string {
+	folder := placeholderPattern.ReplaceAllStringFunc(f.template, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		return sessionPlaceholders[name](nil, date)
+	})
+	return strings.Trim(path.Clean(folder), "/")
+}
+
+// forFile returns the folder for a file along with a reader for all of it.
+// Images without a capture time stay in the session folder.
+func (f sessionFolders) forFile(data io.Reader) (string, io.Reader) {
+	if !captureDateFolders {
+		return f.Session, data
+	}
+	// The EXIF segment comes first and is limited to 64KB
+	reader := bufio.NewReaderSize(data, 64<<10+512)
+	head, _ := reader.Peek(64<<10 + 512)
+	if exif := parseJPEGExif(head); exif != nil {
+		if taken, ok := exif.captureTime(); ok {
+			return f.render(taken), reader
+		}
+	}
+	return f.Session, reader
+}
+
+// uploaderID identifies the client without revealing its address. It is
+// stable until the signing secret changes.
+func uploaderID(r *http.Request, _ time.Time) string {
+	host, _, err := net.SplitHostPort(clientIP(r))
+	if err != nil {
+		host = clientIP(r)
+	}
+	return hostUploaderID(host)
+}
+
+func hostUploaderID(host string) string {
+	return hex.EncodeToString(tokenMAC("uploader", host)[:6])
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// fileExists reports whether a file is stored under name.
+func fileExists(name string) (bool, error) {
+	f, err := storage.OpenFile(name)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	f.Close()
+	return true, nil
+}
+
+// uniqueFileName appends a short random suffix to name if a file of that
+// name already exists in a shared session folder, so guests uploading
+// IMG_0001.jpg on the same day don't overwrite each other. Files awaiting
+// moderation or in quarantine take their name once published, so they
+// count as well.
+func uniqueFileName(name string) (string, error) {
+	if !sharedSessionFolders {
+		return name, nil
+	}
+	taken := false
+	for _, key := range []string{name, pendingPrefix + name, quarantinePrefix + name} {
+		exists, err := fileExists(key)
+		if err != nil {
+			return name, err
+		}
+		taken = taken || exists
+	}
+	if !taken {
+		return name, nil
+	}
+	return withRandomSuffix(name), nil
+}
+
+// withRandomSuffix appends a short random suffix to the base name of name,
+// keeping its extension.
+func withRandomSuffix(name string) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + hex.EncodeToString(suffix) + ext
+}
+
+// sessionPrefixes returns every storage prefix that can hold data belonging
+// to a session. Deleted files in the trash are below a batch folder, see
+// sessionFiles.
+func sessionPrefixes(session string) []string {
+	return []string{
+		session + "/",
+		pendingPrefix + session + "/",
+		quarantinePrefix + session + "/",
+		metaPrefix + session + "/",
+		versionsPrefix + session + "/",
+		versionsPrefix + pendingPrefix + session + "/",
+		thumbnailsPrefix + session + "/",
+	}
+}
+
+// sessionFiles returns every stored file belonging to a session, including
+// copies deleted earlier that are still in the trash.
+func sessionFiles(session string) ([]string, error) {
+	prefixes := sessionPrefixes(session)
+	var files []string
+	for _, prefix := range prefixes {
+		names, err := storage.ListFiles(prefix)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, names...)
+	}
+	trashed, err := storage.ListFiles(trashPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range trashed {
+		entry, ok := parseTrashEntry(file)
+		if ok && slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(entry.Path, prefix) }) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// deleteSession erases all files of a session and returns how many were
+// deleted. Data deletion requests have to be final, so the files bypass the
+// trash and copies already in it are purged.
+func deleteSession(session string) (int, error) {
+	files, err := sessionFiles(session)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, name := range files {
+		if err := storage.DeleteFile(name); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return deleted, err
+		}
+		removeSlides(name)
+		deleted++
+	}
+	return deleted, nil
+}
+
+// uploaderPattern matches the part of a path naming a session of the
+// uploader, i.e. SESSION_FOLDER with the uploader filled in, below the
+// folders of drops and albums.
+func uploaderPattern(id string) *regexp.Regexp {
+	var pattern strings.Builder
+	pattern.WriteString(`^(?:[^/]+/)*?`)
+	last := 0
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(sessionFolder, -1) {
+		pattern.WriteString(regexp.QuoteMeta(sessionFolder[last:match[0]]))
+		if sessionFolder[match[2]:match[3]] == "uploader" {
+			pattern.WriteString(regexp.QuoteMeta(id))
+		} else {
+			pattern.WriteString(`[^/]+`)
+		}
+		last = match[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(sessionFolder[last:]) + "/")
+	return regexp.MustCompile(pattern.String())
+}
+
+// uploaderSessions returns the session folders holding uploads of an
+// uploader, found by the {{uploader}} placeholder in their path.
+func uploaderSessions(id string) ([]string, error) {
+	names, err := storage.ListFiles("")
+	if err != nil {
+		return nil, err
+	}
+	pattern := uploaderPattern(id)
+	areas := []string{versionsPrefix + pendingPrefix, pendingPrefix, quarantinePrefix, metaPrefix, versionsPrefix, thumbnailsPrefix}
+	var sessions []string
+	for _, name := range names {
+		if entry, ok := parseTrashEntry(name); ok {
+			name = entry.Path
+		}
+		for _, area := range areas {
+			if rest, ok := strings.CutPrefix(name, area); ok {
+				name = rest
+				break
+			}
+		}
+		if match := pattern.FindString(name); match != "" && !isInternalPath(match) {
+			sessions = append(sessions, strings.TrimSuffix(match, "/"))
+		}
+	}
+	slices.Sort(sessions)
+	return slices.Compact(sessions), nil
+}
+
+// uploaderDeleteHandler fulfills data deletion requests for everything an
+// uploader sent, given by its uploader ID or IP address. Uploads can only be
+// attributed with {{uploader}} in SESSION_FOLDER.
+func uploaderDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	attributable := slices.ContainsFunc(placeholderPattern.FindAllStringSubmatch(sessionFolder, -1), func(match []string) bool {
+		return match[1] == "uploader"
+	})
+	if !attributable {
+		http.Error(w, "Uploads can only be attributed to uploaders with {{uploader}} in SESSION_FOLDER", http.StatusConflict)
+		return
+	}
+	id := r.PathValue("id")
+	if ip := net.ParseIP(id); ip != nil {
+		id = hostUploaderID(ip.String())
+	}
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 12 {
+		http.Error(w, "Invalid uploader, expected an uploader ID or IP address", http.StatusBadRequest)
+		return
+	}
+
+	deleted := 0
+	sessions, err := uploaderSessions(id)
+	for i := 0; err == nil && i < len(sessions); i++ {
+		var n int
+		n, err = deleteSession(sessions[i])
+		deleted += n
+	}
+	if err != nil {
+		requestLogf(r.Context(), "Error deleting uploads of uploader %s after %d file(s): %v", id, deleted, err)
+		recordAudit(r, "uploader.delete.failed", id, map[string]any{"deleted": deleted, "error": err.Error()})
+		http.Error(w, "Failed to delete uploads", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, "uploader.delete", id, map[string]any{"sessions": sessions, "deleted": deleted})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"uploader": id,
+		"sessions": sessions,
+		"deleted":  deleted,
+	})
+}
+
+// sessionDeleteHandler fulfills data deletion requests by removing every
+// file, sidecar and quarantined copy of a session.
+func sessionDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := cleanStoragePath(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Invalid session", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := deleteSession(session)
+	if err != nil {
+		requestLogf(r.Context(), "Error deleting session %s after %d file(s): %v", session, deleted, err)
+		recordAudit(r, "session.delete.failed", session, map[string]any{"deleted": deleted, "error": err.Error()})
+		http.Error(w, "Failed to delete session", http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, "session.delete", session, map[string]any{"deleted": deleted})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"session": session,
+		"deleted": deleted,
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionDeleteHandler(t *testing.T) {
+	mockStorage := &MockStorage{files: map[string][]byte{
+		"session1/photo.jpg":                       []byte("a"),
+		"session1/images/b.jpg":                    []byte("b"),
+		"pending/session1/c.jpg":                   []byte("c"),
+		"quarantine/session1/d.jpg":                []byte("d"),
+		"quarantine/session1/d.jpg" + reasonSuffix: []byte("{}"),
+		"meta/session1/photo.jpg.json":             []byte("{}"),
+		"session10/other.jpg":                      []byte("keep"),
+	}}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /admin/sessions/{id...}", sessionDeleteHandler)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/sessions/session1", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deleted":6`) {
+		t.Fatalf("Expected 6 deleted files, got %d %s", w.Code, w.Body.String())
+	}
+
+	names, _ := mockStorage.ListFiles("")
+	var audit int
+	for _, name := range names {
+		switch {
+		case strings.HasPrefix(name, auditPrefix):
+			audit++
+		case name != "session10/other.jpg":
+			t.Errorf("Expected %s to be deleted", name)
+		}
+	}
+	if audit != 1 {
+		t.Errorf("Expected one audit record, got %d", audit)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/sessions/session1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for already deleted session, got %d", w.Code)
+	}
+}
+
+func TestSetupSessionFolders(t *testing.T) {
+	defer func() {
+		sessionFolder = defaultSessionFolder
+		sharedSessionFolders = false
+	}()
+
+	for spec, shared := range map[string]bool{
+		"":                                  false,
+		"{{date}}/{{uploader}}/{{uuid}}":    false,
+		"{{ date }}/{{hour}}":               true,
+		"events/{{year}}/{{month}}-{{day}}": true,
+	} {
+		t.Setenv("SESSION_FOLDER", spec)
+		if err := setupSessionFolders(); err != nil {
+			t.Errorf("%q: unexpected error %v", spec, err)
+		}
+		if sharedSessionFolders != shared {
+			t.Errorf("%q: expected shared=%v", spec, shared)
+		}
+	}
+
+	for _, spec := range []string{"{{nope}}", "../{{date}}", "/{{date}}", "meta/{{date}}"} {
+		t.Setenv("SESSION_FOLDER", spec)
+		if err := setupSessionFolders(); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestNewSessionFolder(t *testing.T) {
+	defer func() { sessionFolder = defaultSessionFolder }()
+	now := time.Date(2024, 6, 1, 14, 3, 22, 123000000, time.UTC)
+	r := httptest.NewRequest("POST", "/upload", nil)
+
+	if got := newSessionFolder(r, now); got != "2024-06-01_14-03-22.123" {
+		t.Errorf("default folder = %q", got)
+	}
+
+	sessionFolder = "{{date}}/{{uploader}}/{{uuid}}"
+	parts := strings.Split(newSessionFolder(r, now), "/")
+	if len(parts) != 3 || parts[0] != "2024-06-01" || len(parts[1]) != 12 || len(parts[2]) != 36 {
+		t.Errorf("unexpected folder %v", parts)
+	}
+	other := httptest.NewRequest("POST", "/upload", nil)
+	other.RemoteAddr = "198.51.100.7:1234"
+	if strings.Split(newSessionFolder(other, now), "/")[1] == parts[1] {
+		t.Error("expected different uploaders to get different folders")
+	}
+}
+
+func TestSessionFolderTimezone(t *testing.T) {
+	t.Setenv("SESSION_FOLDER", "{{hour}}/{{iso8601}}")
+	t.Setenv("TIMEZONE", "Europe/Berlin")
+	if err := setupSessionFolders(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		sessionFolder = defaultSessionFolder
+		sessionLocation = time.Local
+	}()
+	if sharedSessionFolders {
+		t.Error("expected {{iso8601}} to make folders unique")
+	}
+
+	now := time.Date(2024, 6, 1, 14, 3, 22, 123000000, time.UTC)
+	if got := newSessionFolder(httptest.NewRequest("POST", "/upload", nil), now); got != "16/20240601T160322.123+0200" {
+		t.Errorf("folder = %q", got)
+	}
+
+	t.Setenv("TIMEZONE", "Mars/Olympus_Mons")
+	if err := setupSessionFolders(); err == nil {
+		t.Error("expected error for unknown time zone")
+	}
+}
+
+func TestSessionFoldersCaptureDate(t *testing.T) {
+	sessionFolder = "{{year}}/{{month}}/{{uuid}}"
+	captureDateFolders = true
+	defer func() {
+		sessionFolder = defaultSessionFolder
+		captureDateFolders = false
+	}()
+	now := time.Date(2024, 6, 1, 14, 3, 22, 0, time.UTC)
+	folders := newSessionFolders(httptest.NewRequest("POST", "/upload", nil), now)
+
+	// IFD0 with a DateTime string right after it
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x32\x00\x02\x00\x00\x00\x14\x00\x00\x00\x1a\x00\x00\x00\x00")
+	tiff = append(tiff, "2023:12:24 18:00:00\x00"...)
+	photo := insertExif(jpegWithOrientation(t, 8, 8, 1), tiff)
+
+	folder, body := folders.forFile(bytes.NewReader(photo))
+	uuid := strings.Split(folders.Session, "/")[2]
+	if folder != "2023/12/"+uuid || !strings.HasPrefix(folders.Session, "2024/06/") {
+		t.Errorf("folder = %q, session %q", folder, folders.Session)
+	}
+	if data, _ := io.ReadAll(body); !bytes.Equal(data, photo) {
+		t.Error("expected the whole file to be readable after peeking")
+	}
+
+	if folder, _ := folders.forFile(strings.NewReader("no exif")); folder != folders.Session {
+		t.Errorf("expected files without capture time in %q, got %q", folders.Session, folder)
+	}
+
+	t.Setenv("CAPTURE_DATE_FOLDERS", "true")
+	t.Setenv("SESSION_FOLDER", "{{uploader}}/{{uuid}}")
+	if err := setupSessionFolders(); err == nil {
+		t.Error("expected error without a date placeholder")
+	}
+}
+
+func TestUniqueFileName(t *testing.T) {
+	mockStorage := &MockStorage{files: map[string][]byte{"2024-06-01/IMG_0001.jpg": []byte("a")}}
+	originalStorage := storage
+	storage = mockStorage
+	sharedSessionFolders = true
+	defer func() {
+		storage = originalStorage
+		sharedSessionFolders = false
+	}()
+
+	if name, _ := uniqueFileName("2024-06-01/IMG_0002.jpg"); name != "2024-06-01/IMG_0002.jpg" {
+		t.Errorf("expected new name to be kept, got %q", name)
+	}
+	name, _ := uniqueFileName("2024-06-01/IMG_0001.jpg")
+	if !strings.HasPrefix(name, "2024-06-01/IMG_0001-") || !strings.HasSuffix(name, ".jpg") {
+		t.Errorf("expected a suffixed name, got %q", name)
+	}
+}
+
+func TestUploaderDeleteHandler(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	id := hostUploaderID("192.0.2.1")
+	other := hostUploaderID("192.0.2.2")
+	mockStorage := &MockStorage{files: map[string][]byte{
+		"2024-06-01/" + id + "/a/photo.jpg":                              []byte("a"),
+		"wedding/2024-06-02/" + id + "/b/photo.jpg":                      []byte("b"),
+		"pending/2024-06-01/" + id + "/c/photo.jpg":                      []byte("c"),
+		"meta/2024-06-01/" + id + "/a/photo.jpg.json":                    []byte("{}"),
+		"trash/20240601T120000.000000000Z/2024-06-01/" + id + "/d/x.jpg": []byte("d"),
+		"2024-06-01/" + other + "/e/photo.jpg":                           []byte("keep"),
+	}}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() {
+		storage = originalStorage
+		sessionFolder = defaultSessionFolder
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /admin/uploaders/{id}", uploaderDeleteHandler)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/uploaders/"+id, nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 without {{uploader}} in SESSION_FOLDER, got %d", w.Code)
+	}
+
+	sessionFolder = "{{date}}/{{uploader}}/{{uuid}}"
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/uploaders/192.0.2.1", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deleted":5`) {
+		t.Fatalf("Expected 5 deleted files, got %d %s", w.Code, w.Body.String())
+	}
+	names, _ := mockStorage.ListFiles("")
+	for _, name := range names {
+		if !strings.HasPrefix(name, auditPrefix) && name != "2024-06-01/"+other+"/e/photo.jpg" {
+			t.Errorf("Expected %s to be deleted", name)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/uploaders/not-an-id", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid uploader, got %d", w.Code)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+// uploadSessionHeader carries the ID of an upload, under which its progress
+// can be looked up, e.g. after a mobile browser lost the response when the
+// tab was switched. Clients may pick it, otherwise the server generates one
+// and returns it with every response to the upload.
+const uploadSessionHeader = "X-Upload-Session"
+
+// minUploadSessionIDLength keeps IDs unguessable, since anyone knowing one
+// can see the names of the files.
+const minUploadSessionIDLength = 16
+
+// sessionStatusRetention is how long the status of a finished upload can be
+// looked up.
+const sessionStatusRetention = time.Hour
+
+// Upload states reported by the status endpoint.
+const (
+	sessionUploading = "uploading"
+	sessionFinished  = "finished"
+	sessionCancelled = "cancelled"
+)
+
+// errUploadCancelled is the cause of the context of a cancelled upload.
+var errUploadCancelled = errors.New("upload cancelled")
+
+// sessionStatus is the progress of an upload request.
+type sessionStatus struct {
+	State      string              `json:"state"`
+	Session    string              `json:"session"`
+	Saved      int                 `json:"saved"`
+	Failed     int                 `json:"failed"`
+	InProgress int                 `json:"in_progress"`
+	Files      []sessionStatusFile `json:"files"`
+	Updated    time.Time           `json:"updated"`
+	// cancel stops the upload while it is in progress
+	cancel context.CancelCauseFunc
+}
+
+// sessionStatusFile is a file as named by the client, with status
+// "receiving", "failed", "rejected" or the status of an accepted file.
+type sessionStatusFile struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// sessionStatuses are kept in process, like the idempotency results.
+var sessionStatuses = struct {
+	sync.Mutex
+	statuses map[string]*sessionStatus
+}{statuses: make(map[string]*sessionStatus)}
+
+// sessionTracker records the progress of one upload. A nil tracker records
+// nothing.
+type sessionTracker struct {
+	status *sessionStatus
+}
+
+func setupSessionStatus() {
+	mux.HandleFunc("GET /api/sessions/{id}/status", sessionStatusHandler)
+	mux.HandleFunc("POST /api/sessions/{id}/cancel", sessionCancelHandler)
+}
+
+// uploadSessionID returns the ID sent with r, or a new one, and whether
+// the ID sent is acceptable.
+func uploadSessionID(r *http.Request) (string, bool) {
+	id := r.Header.Get(uploadSessionHeader)
+	if id == "" {
+		return newUploadSessionID(), true
+	}
+	return id, len(id) >= minUploadSessionIDLength && validRequestID(id)
+}
+
+func newUploadSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// trackSession starts recording the progress of the upload with ID id into
+// session, replacing an earlier upload with the same ID. Cancelling the
+// upload calls cancel with errUploadCancelled.
+func trackSession(id, session string, cancel context.CancelCauseFunc) *sessionTracker {
+	if id == "" {
+		return nil
+	}
+	now := time.Now()
+	status := &sessionStatus{State: sessionUploading, Session: session, Files: []sessionStatusFile{}, Updated: now, cancel: cancel}
+
+	sessionStatuses.Lock()
+	defer sessionStatuses.Unlock()
+	for key, s := range sessionStatuses.statuses {
+		if s.State != sessionUploading && now.Sub(s.Updated) > sessionStatusRetention {
+			delete(sessionStatuses.statuses, key)
+		}
+	}
+	sessionStatuses.statuses[id] = status
+	return &sessionTracker{status: status}
+}
+
+// receiving records that the file name is being received.
+func (t *sessionTracker) receiving(name string) {
+	if t == nil {
+		return
+	}
+	sessionStatuses.Lock()
+	defer sessionStatuses.Unlock()
+	t.status.Files = append(t.status.Files, sessionStatusFile{Name: name, Status: "receiving"})
+	t.status.InProgress = 1
+	t.status.Updated = time.Now()
+}
+
+// received records the outcome of the file being received, along with the
+// counts of the upload so far.
+func (t *sessionTracker) received(status string, saved, failed int) {
+	if t == nil {
+		return
+	}
+	sessionStatuses.Lock()
+	defer sessionStatuses.Unlock()
+	if n := len(t.status.Files); n > 0 {
+		t.status.Files[n-1].Status = status
+	}
+	t.status.Saved, t.status.Failed, t.status.InProgress = saved, failed, 0
+	t.status.Updated = time.Now()
+}
+
+// finish records the final counts of the upload, and whether its files were
+// discarded because it was cancelled. Cancelling too late, when all files
+// were received, has no effect.
+func (t *sessionTracker) finish(saved, failed int, cancelled bool) {
+	if t == nil {
+		return
+	}
+	sessionStatuses.Lock()
+	defer sessionStatuses.Unlock()
+	for i, file := range t.status.Files {
+		if file.Status == "receiving" || cancelled {
+			t.status.Files[i].Status = "failed"
+		}
+	}
+	t.status.State = sessionFinished
+	if cancelled {
+		t.status.State = sessionCancelled
+	}
+	t.status.cancel = nil
+	t.status.Saved, t.status.Failed, t.status.InProgress = saved, failed, 0
+	t.status.Updated = time.Now()
+}
+
+// sessionStatusHandler reports the progress of the upload with the ID in
+// the path.
+func sessionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	sessionStatuses.Lock()
+	status, ok := sessionStatuses.statuses[r.PathValue("id")]
+	if ok && status.State != sessionUploading && time.Since(status.Updated) > sessionStatusRetention {
+		ok = false
+	}
+	var body []byte
+	var err error
+	if ok {
+		body, err = json.Marshal(status)
+	}
+	sessionStatuses.Unlock()
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "No upload with this ID")
+		return
+	}
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, problemInternal, fmt.Sprintf("Encoding status: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(append(body, '\n'))
+}
+
+// sessionCancelHandler stops the upload with the ID in the path. Knowing
+// the ID authorizes it, like looking up the status. The upload discards its
+// files once it notices, the status shows when it is done.
+func sessionCancelHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sessionStatuses.Lock()
+	status, ok := sessionStatuses.statuses[id]
+	uploading := ok && status.State == sessionUploading && status.cancel != nil
+	if uploading {
+		status.State = sessionCancelled
+		status.Updated = time.Now()
+		status.cancel(errUploadCancelled)
+	}
+	sessionStatuses.Unlock()
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, problemNotFound, "No upload with this ID")
+		return
+	}
+	if !uploading {
+		writeProblem(w, r, http.StatusConflict, problemUploadFinished, "The upload is no longer in progress")
+		return
+	}
+	requestLogf(r.Context(), "Cancelling upload %s from %s", id, logIP(clientIP(r)))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// removeUpload deletes the files of a cancelled upload along with their
+// metadata, thumbnails and quarantine records, restores the files they
+// replaced, and returns how many it removed.
+func removeUpload(ctx context.Context, files []*pipelineFile) int {
+	removed := 0
+	for _, file := range files {
+		if err := storage.DeleteFile(file.Stored); err != nil && !errors.Is(err, store.ErrNotFound) {
+			requestLogf(ctx, "Error removing %s of cancelled upload: %v", logName(file.Stored), err)
+			continue
+		}
+		removed++
+		if file.Status == "quarantined" {
+			if err := storage.DeleteFile(file.Stored + reasonSuffix); err != nil && !errors.Is(err, store.ErrNotFound) {
+				requestLogf(ctx, "Error removing quarantine record of %s: %v", logName(file.Name), err)
+			}
+		}
+		if file.Replaced != "" {
+			// The version was taken from where the upload was saved
+			if err := store.MoveFile(storage, file.Replaced, uploadPath(file.Name)); err != nil {
+				requestLogf(ctx, "Error restoring the previous version of %s: %v", logName(file.Name), err)
+				removeSlides(file.Name)
+			}
+		} else {
+			removeSlides(file.Name)
+		}
+		if err := storage.DeleteFile(metadataKey(file.Name)); err != nil && !errors.Is(err, store.ErrNotFound) {
+			requestLogf(ctx, "Error removing metadata of %s: %v", logName(file.Name), err)
+		}
+		if err := removeThumbnails(file.Name); err != nil {
+			requestLogf(ctx, "Error removing thumbnails of %s: %v", logName(file.Name), err)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getSessionStatus(t *testing.T, id string) (int, sessionStatus) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/sessions/"+id+"/status", nil)
+	req.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	sessionStatusHandler(w, req)
+	var status sessionStatus
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, status
+}
+
+func TestSessionStatus(t *testing.T) {
+	fakeTurnstile(t)
+	originalStorage, originalBlocked := storage, blockedExtensions
+	storage, blockedExtensions = &MockStorage{}, []string{".exe"}
+	defer func() { storage, blockedExtensions = originalStorage, originalBlocked }()
+
+	upload := func(id string) int {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for _, name := range []string{"photo.jpg", "script.exe"} {
+			part, _ := writer.CreateFormFile("file", name)
+			part.Write([]byte("data"))
+		}
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set(uploadSessionHeader, id)
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w.Code
+	}
+
+	if code := upload("too-short"); code != http.StatusBadRequest {
+		t.Errorf("expected a short ID to be rejected, got %d", code)
+	}
+	if code, _ := getSessionStatus(t, "too-short"); code != http.StatusNotFound {
+		t.Errorf("expected unknown IDs to be not found, got %d", code)
+	}
+
+	id := "3b0c2a4e-5f1d-4c8e-9a7b-0d6e1f2a3b4c"
+	upload(id)
+	code, status := getSessionStatus(t, id)
+	if code != http.StatusOK {
+		t.Fatalf("expected the status, got %d", code)
+	}
+	if status.State != sessionFinished || status.Saved != 1 || status.Failed != 1 || status.InProgress != 0 || len(status.Files) != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if status.Files[0] != (sessionStatusFile{"photo.jpg", "stored"}) || status.Files[1] != (sessionStatusFile{"script.exe", "rejected"}) {
+		t.Errorf("unexpected files %+v", status.Files)
+	}
+
+	tracker := trackSession("in-progress-upload-0001", "session", func(error) {})
+	tracker.receiving("video.mp4")
+	if _, status := getSessionStatus(t, "in-progress-upload-0001"); status.State != sessionUploading || status.InProgress != 1 {
+		t.Errorf("expected an upload in progress, got %+v", status)
+	}
+	tracker.finish(0, 1, false)
+	if _, status := getSessionStatus(t, "in-progress-upload-0001"); status.Files[0].Status != "failed" {
+		t.Errorf("expected the interrupted file to be failed, got %+v", status)
+	}
+}
+
+func TestUploadHandler_SessionID(t *testing.T) {
+	fakeTurnstile(t)
+	originalStorage, originalBlocked := storage, blockedExtensions
+	storage, blockedExtensions = &MockStorage{}, []string{".exe"}
+	defer func() { storage, blockedExtensions = originalStorage, originalBlocked }()
+
+	upload := func(files ...string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for _, name := range files {
+			part, _ := writer.CreateFormFile("file", name)
+			part.Write([]byte("data"))
+		}
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w
+	}
+
+	w := upload("photo.jpg", "script.exe")
+	var resp uploadResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	id := w.Header().Get(uploadSessionHeader)
+	if w.Code != http.StatusPartialContent || len(id) != 32 || resp.SessionID != id {
+		t.Fatalf("expected the generated ID in header and body, got %d, %q and %+v", w.Code, id, resp)
+	}
+	if code, status := getSessionStatus(t, id); code != http.StatusOK || status.Session != resp.Session {
+		t.Errorf("expected the status under the generated ID, got %d %+v", code, status)
+	}
+
+	w = upload("script.exe")
+	var p problem
+	json.NewDecoder(w.Body).Decode(&p)
+	if w.Code != http.StatusUnprocessableEntity || p.SessionID == "" || p.SessionID != w.Header().Get(uploadSessionHeader) {
+		t.Errorf("expected the ID with the problem, got %d %+v", w.Code, p)
+	}
+}
+
+func TestSessionCancel(t *testing.T) {
+	fakeTurnstile(t)
+	originalStorage := storage
+	mock := &MockStorage{}
+	storage = mock
+	defer func() { storage = originalStorage }()
+
+	id := "cancel-test-upload-0001"
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	next := make(chan struct{})
+	go func() {
+		part, _ := writer.CreateFormFile("file", "first.jpg")
+		part.Write([]byte("first"))
+		// The first file ends with the boundary of the second
+		part, _ = writer.CreateFormFile("file", "second.jpg")
+		part.Write([]byte("sec"))
+		<-next
+		part.Write([]byte("ond"))
+		writer.Close()
+		pw.Close()
+	}()
+
+	req := httptest.NewRequest("POST", "/upload", pr)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set(uploadSessionHeader, id)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		uploadHandler(w, req)
+		pr.Close()
+		close(done)
+	}()
+
+	cancel := func() int {
+		req := httptest.NewRequest("POST", "/api/sessions/"+id+"/cancel", nil)
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		sessionCancelHandler(w, req)
+		return w.Code
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, status := getSessionStatus(t, id); status.Saved == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first file was not stored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := cancel(); code != http.StatusAccepted {
+		t.Fatalf("expected the cancellation to be accepted, got %d", code)
+	}
+	close(next)
+	<-done
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected the upload to be cancelled, got %d: %s", w.Code, w.Body.String())
+	}
+	for name := range mock.files {
+		if !strings.HasPrefix(name, auditPrefix) {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	if _, status := getSessionStatus(t, id); status.State != sessionCancelled || status.Saved != 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if code := cancel(); code != http.StatusConflict {
+		t.Errorf("expected a finished upload not to be cancellable, got %d", code)
+	}
+}
+
+func TestRemoveUpload_RestoresReplacedFiles(t *testing.T) {
+	mock := &MockStorage{files: map[string][]byte{"event/photo.jpg": []byte("earlier")}}
+	originalStorage := storage
+	storage = mock
+	versioning = true
+	defer func() {
+		storage = originalStorage
+		versioning = false
+	}()
+
+	replaced, err := keepVersion("event/photo.jpg")
+	if err != nil || replaced == "" {
+		t.Fatalf("keepVersion = %q, %v", replaced, err)
+	}
+	mock.SaveFile("event/photo.jpg", strings.NewReader("later"))
+	if _, err := quarantineFile("event/notes.jpg", "content type mismatch", strings.NewReader("<html>")); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []*pipelineFile{
+		{Name: "event/photo.jpg", Stored: "event/photo.jpg", Status: "stored", Replaced: replaced},
+		{Name: "event/notes.jpg", Stored: quarantinePrefix + "event/notes.jpg", Status: "quarantined"},
+	}
+	if removed := removeUpload(context.Background(), files); removed != 2 {
+		t.Errorf("removed %d files, want 2", removed)
+	}
+	if got := string(mock.files["event/photo.jpg"]); got != "earlier" {
+		t.Errorf("expected the replaced file to be restored, got %q", got)
+	}
+	if len(mock.files) != 1 {
+		t.Errorf("expected only the restored file to be left, got %v", mock.files)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+const sharePurpose = "share"
+
+var shareExpiry = 24 * time.Hour
+
+const shareCookie = "share_auth"
+
+// shareClaims is the signed payload of a share link. Session links grant
+// access to every file below Path. Password protected links carry a salted,
+// keyed hash of the password, so it can't be brute forced from the link.
+type shareClaims struct {
+	Path         string `json:"p"`
+	Session      bool   `json:"s,omitempty"`
+	Expires      int64  `json:"e"`
+	Salt         string `json:"ps,omitempty"`
+	PasswordHash string `json:"ph,omitempty"`
+}
+
+type shareRequest struct {
+	Path      string `json:"path"`
+	ExpiresIn string `json:"expires_in"`
+	Password  string `json:"password"`
+}
+
+type shareResponse struct {
+	Token   string    `json:"token"`
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+func setupShares() error {
+	if value := os.Getenv("SHARE_EXPIRY"); value != "" {
+		expiry, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		shareExpiry = expiry
+	}
+	mux.HandleFunc("/s/", shareHandler)
+	setupGallery()
+	return nil
+}
+
+// cleanStoragePath normalizes a user supplied path and rejects paths that
+// escape the storage root or point into internal areas.
+func cleanStoragePath(p string) (string, bool) {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" || isInternalPath(p) {
+		return "", false
+	}
+	return p, true
+}
+
+// isInternalPath reports whether p lies in an area that must not be exposed
+// publicly, like quarantined or not yet approved uploads.
+func isInternalPath(p string) bool {
+	for _, prefix := range []string{quarantinePrefix, pendingPrefix, metaPrefix, auditPrefix, versionsPrefix, trashPrefix, thumbnailsPrefix, archivePrefix} {
+		if strings.HasPrefix(p+"/", prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// shareCreateHandler mints a share link for a file or a whole session folder.
+func shareCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	name, ok := cleanStoragePath(req.Path)
+	if !ok {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	expiry := shareExpiry
+	if req.ExpiresIn != "" {
+		var err error
+		expiry, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || expiry <= 0 {
+			http.Error(w, "Invalid expires_in", http.StatusBadRequest)
+			return
+		}
+	}
+
+	claims := shareClaims{Path: name, Expires: time.Now().Add(expiry).Unix()}
+	f, err := storage.OpenFile(name)
+	if err == nil {
+		f.Close()
+	} else if errors.Is(err, store.ErrNotFound) {
+		files, err := storage.ListFiles(name + "/")
+		if err != nil || len(files) == 0 {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		claims.Session = true
+	} else {
+		requestLogf(r.Context(), "Error checking share path %s: %v", logName(name), err)
+		http.Error(w, "Failed to create share", http.StatusInternalServerError)
+		return
+	}
+
+	if req.Password != "" {
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		claims.Salt = base64.RawURLEncoding.EncodeToString(salt)
+		claims.PasswordHash = hashSharePassword(claims.Salt, req.Password)
+	}
+
+	token, err := signToken(sharePurpose, claims)
+	if err != nil {
+		requestLogf(r.Context(), "Error signing share token: %v", err)
+		http.Error(w, "Failed to create share", http.StatusInternalServerError)
+		return
+	}
+	requestLogf(r.Context(), "Created share link for %s valid for %s", logName(name), expiry)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(shareResponse{
+		Token:   token,
+		URL:     publicURL("/s/" + token),
+		Expires: time.Unix(claims.Expires, 0),
+	})
+}
+
+// shareHandler serves /s/<token> for files and /s/<token>/<name> for files
+// within a shared session.
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	token, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/s/"), "/")
+	var claims shareClaims
+	if err := parseToken(sharePurpose, token, &claims); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if time.Now().Unix() > claims.Expires {
+		http.Error(w, "Share link expired", http.StatusGone)
+		return
+	}
+
+	if claims.PasswordHash != "" && r.Method == http.MethodPost {
+		unlockShare(w, r, token, &claims)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if claims.PasswordHash != "" && !shareUnlocked(r, token) {
+		renderSharePassword(w, http.StatusOK, "")
+		return
+	}
+
+	if !claims.Session {
+		if rest != "" {
+			http.NotFound(w, r)
+			return
+		}
+		serveStoredFile(w, r, claims.Path)
+		return
+	}
+
+	if rest == "" {
+		renderShareListing(w, r, token, &claims)
+		return
+	}
+	name := path.Join(claims.Path, rest)
+	if !strings.HasPrefix(name, claims.Path+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	serveStoredFile(w, r, name)
+}
+
+// sharedFile is an entry of the share listing. Images are shown as
+// thumbnails, loaded through the gallery cookie or the image service.
+type sharedFile struct {
+	Name      string
+	Image     bool
+	Thumbnail string
+}
+
+func renderShareListing(w http.ResponseWriter, r *http.Request, token string, claims *shareClaims) {
+	names, err := storage.ListFiles(claims.Path + "/")
+	if err != nil {
+		log.Printf("Error listing shared session %s: %v", claims.Path, err)
+		http.Error(w, "Failed to list files", http.StatusInternalServerError)
+		return
+	}
+	files := make([]sharedFile, 0, len(names))
+	images := false
+	for _, name := range names {
+		file := sharedFile{Name: strings.TrimPrefix(name, claims.Path+"/"), Image: isDisplayableImage(name)}
+		if file.Image {
+			file.Thumbnail = imageServiceURL(name, galleryThumbnailSize)
+			if file.Thumbnail == "" {
+				file.Thumbnail = galleryURL(claims.Path) + (&url.URL{Path: "/" + file.Name}).EscapedPath() + "?thumbnail"
+			}
+		}
+		images = images || file.Image
+		files = append(files, file)
+	}
+	if images {
+		if err := grantGalleryAccess(w, r, claims.Path, time.Unix(claims.Expires, 0)); err != nil {
+			requestLogf(r.Context(), "Error signing gallery cookie for %s: %v", logName(claims.Path), err)
+		}
+	}
+
+	message, err := loadMessage(claims.Path)
+	if err != nil {
+		requestLogf(r.Context(), "Error reading message of %s: %v", logName(claims.Path), err)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = pageTemplates().ExecuteTemplate(w, "share.html", map[string]any{
+		"Base":    publicURL("/s/" + token),
+		"Files":   files,
+		"Expires": time.Unix(claims.Expires, 0),
+		"Message": message,
+	})
+	if err != nil {
+		log.Printf("Error rendering share listing: %v", err)
+	}
+}
+
+func hashSharePassword(salt, password string) string {
+	return base64.RawURLEncoding.EncodeToString(tokenMAC("share-password", salt+"."+password))
+}
+
+// shareUnlockValue is the cookie value proving the password of the share
+// link identified by token was entered.
+func shareUnlockValue(token string) string {
+	return base64.RawURLEncoding.EncodeToString(tokenMAC("share-unlock", token))
+}
+
+func shareUnlocked(r *http.Request, token string) bool {
+	cookie, err := r.Cookie(shareCookie)
+	return err == nil && hmac.Equal([]byte(cookie.Value), []byte(shareUnlockValue(token)))
+}
+
+// unlockShare checks the submitted password and sets a cookie scoped to the
+// share link so subsequent downloads don't ask again.
+func unlockShare(w http.ResponseWriter, r *http.Request, token string, claims *shareClaims) {
+	password := r.PostFormValue("password")
+	if !hmac.Equal([]byte(hashSharePassword(claims.Salt, password)), []byte(claims.PasswordHash)) {
+		requestLogf(r.Context(), "Wrong password for share link to %s", logName(claims.Path))
+		renderSharePassword(w, http.StatusUnauthorized, "Wrong password, please try again.")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookie,
+		Value:    shareUnlockValue(token),
+		Path:     publicBasePath + "/s/" + token,
+		Expires:  time.Unix(claims.Expires, 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, publicURL(r.URL.Path), http.StatusSeeOther)
+}
+
+func renderSharePassword(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := pageTemplates().ExecuteTemplate(w, "share_password.html", map[string]string{"Error": message}); err != nil {
+		log.Printf("Error rendering share password page: %v", err)
+	}
+}
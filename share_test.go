@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+func createShare(t *testing.T, body string) (int, shareResponse) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/admin/shares", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	shareCreateHandler(w, req)
+
+	var resp shareResponse
+	if w.Code == http.StatusCreated {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, resp
+}
+
+func getShare(url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	shareHandler(w, httptest.NewRequest("GET", url, nil))
+	return w
+}
+
+func TestShareLinks(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	mockStorage := &MockStorage{files: map[string][]byte{
+		"session1/photo.jpg":       []byte("jpeg data"),
+		"session1/images/b.png":    []byte("png data"),
+		"session2/other.jpg":       []byte("other data"),
+		"quarantine/session3/x.js": []byte("alert(1)"),
+	}}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	t.Run("File", func(t *testing.T) {
+		code, share := createShare(t, `{"path":"session1/photo.jpg"}`)
+		if code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d", code)
+		}
+		w := getShare(share.URL)
+		if w.Code != http.StatusOK || w.Body.String() != "jpeg data" {
+			t.Errorf("Expected file content, got %d %q", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != "image/jpeg" {
+			t.Errorf("Expected image/jpeg, got %q", w.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("Session", func(t *testing.T) {
+		code, share := createShare(t, `{"path":"session1","expires_in":"1h"}`)
+		if code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d", code)
+		}
+		w := getShare(share.URL)
+		if !strings.Contains(w.Body.String(), "images/b.png") {
+			t.Errorf("Expected listing to contain images/b.png, got %s", w.Body.String())
+		}
+		if w := getShare(share.URL + "/images/b.png"); w.Body.String() != "png data" {
+			t.Errorf("Expected file content, got %q", w.Body.String())
+		}
+		if w := getShare(share.URL + "/../session2/other.jpg"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for path outside the session, got %d", w.Code)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token, _ := signToken(sharePurpose, shareClaims{Path: "session1/photo.jpg", Expires: time.Now().Add(-time.Minute).Unix()})
+		if w := getShare("/s/" + token); w.Code != http.StatusGone {
+			t.Errorf("Expected 410, got %d", w.Code)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		_, share := createShare(t, `{"path":"session1/photo.jpg"}`)
+		other, _ := signToken(sharePurpose, shareClaims{Path: "session2/other.jpg", Expires: time.Now().Add(time.Hour).Unix()})
+		payload, _, _ := strings.Cut(other, ".")
+		_, signature, _ := strings.Cut(share.Token, ".")
+		if w := getShare("/s/" + payload + "." + signature); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for tampered token, got %d", w.Code)
+		}
+	})
+
+	t.Run("InvalidPaths", func(t *testing.T) {
+		for _, body := range []string{`{"path":"quarantine/session3/x.js"}`, `{"path":"../etc"}`, `{"path":"missing"}`} {
+			if code, _ := createShare(t, body); code == http.StatusCreated {
+				t.Errorf("Expected share creation to fail for %s", body)
+			}
+		}
+	})
+}
+
+func TestShareLinks_Password(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	mockStorage := &MockStorage{files: map[string][]byte{"session1/contract.pdf": []byte("pdf data")}}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	code, share := createShare(t, `{"path":"session1/contract.pdf","password":"hunter2"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", code)
+	}
+	if strings.Contains(share.Token, "hunter2") {
+		t.Error("Token must not contain the plain password")
+	}
+
+	w := getShare(share.URL)
+	if !strings.Contains(w.Body.String(), "Password required") {
+		t.Fatalf("Expected password prompt, got %q", w.Body.String())
+	}
+
+	post := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", share.URL, strings.NewReader("password="+password))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		shareHandler(w, req)
+		return w
+	}
+
+	if w := post("wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for wrong password, got %d", w.Code)
+	}
+
+	w = post("hunter2")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect after correct password, got %d", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected unlock cookie, got %v", cookies)
+	}
+
+	req := httptest.NewRequest("GET", share.URL, nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	shareHandler(w, req)
+	if w.Body.String() != "pdf data" {
+		t.Errorf("Expected file after unlocking, got %q", w.Body.String())
+	}
+}
+
+func TestShareLinks_BaseURL(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	originalStorage := storage
+	storage = &MockStorage{files: map[string][]byte{"session1/photo.jpg": []byte("jpeg data")}}
+	t.Setenv("BASE_URL", "https://example.com/photos/")
+	defer func() {
+		storage = originalStorage
+		publicBaseURL, publicBasePath = "", ""
+	}()
+	if err := setupBaseURL(); err != nil {
+		t.Fatal(err)
+	}
+
+	code, share := createShare(t, `{"path":"session1/photo.jpg","password":"hunter2"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", code)
+	}
+	if !strings.HasPrefix(share.URL, "https://example.com/photos/s/") {
+		t.Fatalf("Expected link below BASE_URL, got %s", share.URL)
+	}
+
+	// The proxy strips the path prefix before forwarding
+	req := httptest.NewRequest("POST", "/s/"+share.Token, strings.NewReader("password=hunter2"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	shareHandler(w, req)
+	if location := w.Header().Get("Location"); location != share.URL {
+		t.Errorf("Expected redirect to %s, got %s", share.URL, location)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Path != "/photos/s/"+share.Token {
+		t.Errorf("Expected cookie scoped below the base path, got %v", cookies)
+	}
+
+	t.Setenv("BASE_URL", "example.com")
+	if err := setupBaseURL(); err == nil {
+		t.Error("Expected error for a URL without scheme")
+	}
+}
+
+func TestShareLinks_LocalStorage(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	local, err := store.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.SaveFile("session1/photo.jpg", strings.NewReader("jpeg data"))
+	originalStorage := storage
+	storage = local
+	defer func() { storage = originalStorage }()
+
+	// The session folder is a directory on disk, not a file
+	code, share := createShare(t, `{"path":"session1"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", code)
+	}
+	w := getShare(share.URL)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "photo.jpg") {
+		t.Errorf("Expected a session listing, got %d %q", w.Code, w.Body.String())
+	}
+	if w := getShare(share.URL + "/photo.jpg"); w.Body.String() != "jpeg data" {
+		t.Errorf("Expected file content, got %q", w.Body.String())
+	}
+}
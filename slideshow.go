@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// slideshowSize is how many of the most recent images the slideshow cycles
+// through.
+var slideshowSize = 50
+
+// slideshowInterval is how long each image is shown, slideshowRefresh how
+// often the page fetches the list of images again.
+var (
+	slideshowInterval = 8 * time.Second
+	slideshowRefresh  = 30 * time.Second
+)
+
+// slides holds the paths of the most recent public images, oldest first.
+var slides struct {
+	sync.Mutex
+	paths []string
+}
+
+// slide is an image of the slideshow as returned by the JSON API.
+type slide struct {
+	Path         string `json:"path"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+type slideshowConfig struct {
+	Interval  int64  `json:"interval_ms"`
+	Refresh   int64  `json:"refresh_ms"`
+	ImagesURL string `json:"images_url"`
+}
+
+// setupSlideshow serves the slideshow along with the live feed, protected by
+// the same token.
+func setupSlideshow() error {
+	if value := os.Getenv("SLIDESHOW_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 {
+			return fmt.Errorf("SLIDESHOW_SIZE must be a positive number, got %q", value)
+		}
+		slideshowSize = size
+	}
+	for _, setting := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"SLIDESHOW_INTERVAL", &slideshowInterval},
+		{"SLIDESHOW_REFRESH", &slideshowRefresh},
+	} {
+		value := os.Getenv(setting.name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%s must be a positive duration, got %q", setting.name, value)
+		}
+		*setting.value = d
+	}
+
+	// Listing a large bucket takes a while, the slideshow fills up meanwhile
+	if !checkingConfig {
+		go seedSlides()
+	}
+
+	mux.HandleFunc("GET /slideshow", requireLiveToken(slideshowPageHandler))
+	mux.HandleFunc("GET /slideshow/images", requireLiveToken(slideshowImagesHandler))
+	log.Printf("Serving a slideshow of the latest %d images at /slideshow", slideshowSize)
+	return nil
+}
+
+// seedSlides fills the slideshow after a restart with the last public images
+// in path order, which is upload order for timestamped session folders.
+// Pending and quarantined files are internal and never listed.
+func seedSlides() {
+	names, err := storage.ListFiles("")
+	if err != nil {
+		log.Printf("Error listing images for the slideshow: %v", err)
+		return
+	}
+	var seeded []string
+	for _, name := range names {
+		if !isInternalPath(name) && isDisplayableImage(name) {
+			seeded = append(seeded, name)
+		}
+	}
+	slices.Sort(seeded)
+
+	slides.Lock()
+	defer slides.Unlock()
+	// Images published while listing are newer than everything listed
+	for _, name := range slides.paths {
+		seeded = slices.DeleteFunc(seeded, func(s string) bool { return s == name })
+	}
+	slides.paths = append(seeded, slides.paths...)
+	if len(slides.paths) > slideshowSize {
+		slides.paths = slides.paths[len(slides.paths)-slideshowSize:]
+	}
+}
+
+// addSlide adds a newly public image to the slideshow.
+func addSlide(name string) {
+	slides.Lock()
+	defer slides.Unlock()
+	slides.paths = slices.DeleteFunc(slides.paths, func(s string) bool { return s == name })
+	slides.paths = append(slides.paths, name)
+	if len(slides.paths) > slideshowSize {
+		slides.paths = slices.Delete(slides.paths, 0, len(slides.paths)-slideshowSize)
+	}
+}
+
+// removeSlides takes deleted files out of the slideshow.
+func removeSlides(names ...string) {
+	slides.Lock()
+	defer slides.Unlock()
+	slides.paths = slices.DeleteFunc(slides.paths, func(s string) bool { return slices.Contains(names, s) })
+}
+
+func slideshowPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := pageTemplates().ExecuteTemplate(w, "slideshow.html", slideshowConfig{
+		Interval:  slideshowInterval.Milliseconds(),
+		Refresh:   slideshowRefresh.Milliseconds(),
+		ImagesURL: publicURL("/slideshow/images"),
+	}); err != nil {
+		requestLogf(r.Context(), "Error rendering slideshow: %v", err)
+	}
+}
+
+// slideshowImagesHandler returns the images of the slideshow, newest first,
+// with freshly signed thumbnail links.
+func slideshowImagesHandler(w http.ResponseWriter, r *http.Request) {
+	slides.Lock()
+	paths := slices.Clone(slides.paths)
+	slides.Unlock()
+
+	images := []slide{}
+	for i := len(paths) - 1; i >= 0; i-- {
+		thumbnail, err := liveThumbnailURL(paths[i])
+		if err != nil {
+			requestLogf(r.Context(), "Error signing thumbnail link for %s: %v", logName(paths[i]), err)
+			continue
+		}
+		images = append(images, slide{Path: paths[i], ThumbnailURL: thumbnail})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(images)
+}
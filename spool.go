@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+// spoolRetryDelay is the wait after the first failed attempt to push a
+// spooled file. It doubles with every attempt, up to spoolMaxBackoff.
+var spoolRetryDelay = time.Second
+
+const spoolMaxBackoff = 5 * time.Minute
+
+// uploadsSpooled is set if uploads to BACKEND go through the spool or the
+// outbox, so they succeed while the backend is down.
+var uploadsSpooled bool
+
+func setupSpool() error {
+	dir, outbox := os.Getenv("SPOOL_DIR"), os.Getenv("OUTBOX_DIR")
+	if dir != "" && outbox != "" {
+		return fmt.Errorf("SPOOL_DIR and OUTBOX_DIR can't be combined, the spool already retries failed saves")
+	}
+	if outbox != "" {
+		dir = outbox
+	}
+	if dir == "" {
+		return nil
+	}
+	workers := 4
+	if value := os.Getenv("SPOOL_WORKERS"); value != "" {
+		var err error
+		workers, err = strconv.Atoi(value)
+		if err != nil || workers < 1 {
+			return fmt.Errorf("SPOOL_WORKERS must be a positive number, got %q", value)
+		}
+	}
+	local, err := store.NewLocalStorage(dir)
+	if err != nil {
+		return fmt.Errorf("creating spool directory: %w", err)
+	}
+	spool := newSpooledBackend(storage, local)
+	spool.direct = outbox != ""
+	// Files left over from the last run haven't reached the backend yet
+	leftover, err := local.ListFiles("")
+	if err != nil {
+		return fmt.Errorf("reading spool directory: %w", err)
+	}
+	for _, name := range leftover {
+		spool.enqueue(name)
+	}
+	if !checkingConfig {
+		spool.start(workers)
+	}
+	storage = spool
+	uploadsSpooled = true
+	if spool.direct {
+		log.Printf("Keeping files the %s backend fails to store in the outbox %s, %d worker(s) retry them", backendName, dir, workers)
+	} else {
+		log.Printf("Spooling uploads in %s, %d worker(s) push them to the %s backend", dir, workers, backendName)
+	}
+	if len(leftover) > 0 {
+		log.Printf("Resuming %d spooled file(s) from the last run", len(leftover))
+	}
+	return nil
+}
+
+// spooledBackend saves files to a local spool and returns right away, so
+// guests don't wait for a slow backend, e.g. S3 during a spike. Workers push
+// the spooled files to the backend in the background, retrying until they
+// get through, and remove them from the spool once stored. Until then, reads
+// are served from the spool.
+type spooledBackend struct {
+	store.Backend
+	spool store.Backend
+	// direct saves push to the backend right away and only leave the files
+	// it fails to store in the spool, which then serves as an outbox
+	direct bool
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// pending maps the spooled names to a generation, which changes when
+	// a file is saved again while a worker pushes the previous content
+	pending    map[string]uint64
+	queue      []string
+	generation uint64
+	// pushing holds the names workers are pushing, which no other worker
+	// may pick up until they are done
+	pushing map[string]bool
+}
+
+func newSpooledBackend(backend, spool store.Backend) *spooledBackend {
+	b := &spooledBackend{Backend: backend, spool: spool, pending: make(map[string]uint64), pushing: make(map[string]bool)}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *spooledBackend) start(workers int) {
+	for range workers {
+		go b.work()
+	}
+}
+
+// enqueue queues name for pushing, unless it already waits in the queue.
+func (b *spooledBackend) enqueue(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.generation++
+	if !slices.Contains(b.queue, name) {
+		b.queue = append(b.queue, name)
+	}
+	b.pending[name] = b.generation
+	spooledFiles.Set(float64(len(b.pending)))
+	b.cond.Signal()
+}
+
+func (b *spooledBackend) isPending(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.pending[name]
+	return ok
+}
+
+func (b *spooledBackend) SaveFile(name string, data io.Reader) (store.SaveResult, error) {
+	result, err := b.spool.SaveFile(name, data)
+	if err != nil {
+		return result, err
+	}
+	// An earlier version still waiting has to be pushed first
+	if b.direct && !b.isPending(name) {
+		remote, err := b.pushOnce(name)
+		if err == nil {
+			if err := b.spool.DeleteFile(name); err != nil {
+				log.Printf("Error removing %s from the outbox: %v", logName(name), err)
+			}
+			return remote, nil
+		}
+		log.Printf("Error saving %s, keeping it in the outbox for a retry: %v", logName(name), err)
+		outboxFiles.Inc()
+	}
+	b.enqueue(name)
+	// The spooled file is gone once pushed, and the backend's reference
+	// isn't known before
+	return store.SaveResult{Size: result.Size}, nil
+}
+
+func (b *spooledBackend) OpenFile(name string) (io.ReadCloser, error) {
+	if b.isPending(name) {
+		f, err := b.spool.OpenFile(name)
+		// A worker may have pushed it in the meantime
+		if !errors.Is(err, store.ErrNotFound) {
+			return f, err
+		}
+	}
+	return b.Backend.OpenFile(name)
+}
+
+// DeleteFile removes the file from the spool and the backend, which may
+// hold an earlier version.
+func (b *spooledBackend) DeleteFile(name string) error {
+	b.mu.Lock()
+	_, spooled := b.pending[name]
+	if spooled {
+		delete(b.pending, name)
+		spooledFiles.Set(float64(len(b.pending)))
+	}
+	b.mu.Unlock()
+	if spooled {
+		if err := b.spool.DeleteFile(name); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+	err := b.Backend.DeleteFile(name)
+	if spooled && errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// ListFiles includes the files still in the spool.
+func (b *spooledBackend) ListFiles(prefix string) ([]string, error) {
+	names, err := b.Backend.ListFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	for name := range b.pending {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	b.mu.Unlock()
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// work pushes queued files until the process exits.
+func (b *spooledBackend) work() {
+	for {
+		b.mu.Lock()
+		i := b.next()
+		for i < 0 {
+			b.cond.Wait()
+			i = b.next()
+		}
+		name := b.queue[i]
+		b.queue = slices.Delete(b.queue, i, i+1)
+		generation, ok := b.pending[name]
+		b.pushing[name] = true
+		b.mu.Unlock()
+		if ok {
+			b.push(name, generation)
+		}
+		b.mu.Lock()
+		delete(b.pushing, name)
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	}
+}
+
+// next returns the index of the first queued file no worker is pushing, or
+// -1. It must be called with mu held.
+func (b *spooledBackend) next() int {
+	return slices.IndexFunc(b.queue, func(name string) bool { return !b.pushing[name] })
+}
+
+// push stores a spooled file in the backend, retrying with backoff, and
+// removes it from the spool unless it was saved again in the meantime.
+func (b *spooledBackend) push(name string, generation uint64) {
+	for attempt := 1; ; attempt++ {
+		_, err := b.pushOnce(name)
+		if err == nil || errors.Is(err, store.ErrNotFound) {
+			break
+		}
+		backoff := min(spoolRetryDelay<<min(attempt-1, 10), spoolMaxBackoff)
+		log.Printf("Error pushing spooled file %s (attempt %d), retrying in %s: %v", logName(name), attempt, backoff, err)
+		time.Sleep(backoff)
+		if !b.isPending(name) {
+			// Deleted while waiting
+			return
+		}
+	}
+
+	b.mu.Lock()
+	current, ok := b.pending[name]
+	if ok && current == generation {
+		delete(b.pending, name)
+		spooledFiles.Set(float64(len(b.pending)))
+		if err := b.spool.DeleteFile(name); err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Error removing %s from the spool: %v", logName(name), err)
+		}
+	}
+	b.mu.Unlock()
+	if !ok {
+		// Deleted while it was pushed
+		if err := b.Backend.DeleteFile(name); err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Error removing deleted file %s from the backend: %v", logName(name), err)
+		}
+	}
+}
+
+func (b *spooledBackend) pushOnce(name string) (store.SaveResult, error) {
+	f, err := b.spool.OpenFile(name)
+	if err != nil {
+		return store.SaveResult{}, err
+	}
+	defer f.Close()
+	return b.Backend.SaveFile(name, f)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+// flakyStorage fails the first saves, like S3 during an outage.
+type flakyStorage struct {
+	MockStorage
+	mu    sync.Mutex
+	fails int
+}
+
+func (f *flakyStorage) SaveFile(name string, data io.Reader) (store.SaveResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fails > 0 {
+		f.fails--
+		return store.SaveResult{}, errors.New("service unavailable")
+	}
+	return f.MockStorage.SaveFile(name, data)
+}
+
+func (f *flakyStorage) OpenFile(name string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.MockStorage.OpenFile(name)
+}
+
+func waitForSpool(t *testing.T, b *spooledBackend) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		pending := len(b.pending)
+		b.mu.Unlock()
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("spooled files were not pushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSpooledBackend(t *testing.T) {
+	originalDelay := spoolRetryDelay
+	spoolRetryDelay = time.Millisecond
+	defer func() { spoolRetryDelay = originalDelay }()
+
+	dir := t.TempDir()
+	local, _ := store.NewLocalStorage(dir)
+	remote := &flakyStorage{fails: 2}
+	spool := newSpooledBackend(remote, local)
+
+	result, err := spool.SaveFile("session/photo.jpg", strings.NewReader("photo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Size != 5 || result.Ref != "" {
+		t.Errorf("expected the size without a reference to the spool, got %+v", result)
+	}
+	f, err := spool.OpenFile("session/photo.jpg")
+	if err != nil {
+		t.Fatalf("expected the spooled file to be readable, got %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if names, _ := spool.ListFiles("session/"); string(data) != "photo" || len(names) != 1 {
+		t.Errorf("expected the spooled file, got %q and %v", data, names)
+	}
+
+	spool.start(2)
+	waitForSpool(t, spool)
+	f, err = remote.OpenFile("session/photo.jpg")
+	if err != nil {
+		t.Fatalf("expected the file to be pushed after the failures, got %v", err)
+	}
+	f.Close()
+	if names, _ := local.ListFiles(""); len(names) != 0 {
+		t.Errorf("expected the spool to be empty, got %v", names)
+	}
+
+	spool.SaveFile("session/other.jpg", strings.NewReader("other"))
+	if err := spool.DeleteFile("session/other.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	waitForSpool(t, spool)
+	if _, err := spool.OpenFile("session/other.jpg"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected the deleted file to be gone, got %v", err)
+	}
+}
+
+func TestSetupSpool_ResumesLeftovers(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "session"), 0o755)
+	os.WriteFile(filepath.Join(dir, "session", "photo.jpg"), []byte("photo"), 0o644)
+	t.Setenv("SPOOL_DIR", dir)
+
+	originalStorage := storage
+	remote := &MockStorage{}
+	storage = remote
+	defer func() { storage = originalStorage }()
+
+	if err := setupSpool(); err != nil {
+		t.Fatal(err)
+	}
+	waitForSpool(t, storage.(*spooledBackend))
+	if string(remote.files["session/photo.jpg"]) != "photo" {
+		t.Errorf("expected the leftover file to be pushed, got %v", remote.files)
+	}
+}
+
+func TestSpooledBackend_Outbox(t *testing.T) {
+	originalDelay := spoolRetryDelay
+	spoolRetryDelay = time.Millisecond
+	defer func() { spoolRetryDelay = originalDelay }()
+
+	local, _ := store.NewLocalStorage(t.TempDir())
+	remote := &flakyStorage{}
+	outbox := newSpooledBackend(remote, local)
+	outbox.direct = true
+
+	if _, err := outbox.SaveFile("session/first.jpg", strings.NewReader("first")); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := local.ListFiles(""); len(names) != 0 || len(remote.files) != 1 {
+		t.Errorf("expected the file to be saved right away, got %v in the outbox", names)
+	}
+
+	remote.fails = 3
+	result, err := outbox.SaveFile("session/second.jpg", strings.NewReader("second"))
+	if err != nil || result.Ref != "" {
+		t.Fatalf("expected the failed save to be kept in the outbox, got %+v and %v", result, err)
+	}
+	outbox.start(1)
+	waitForSpool(t, outbox)
+	if f, err := remote.OpenFile("session/second.jpg"); err != nil {
+		t.Errorf("expected the file to be retried, got %v", err)
+	} else {
+		f.Close()
+	}
+}
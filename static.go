@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// assetModTime is reported as Last-Modified for the embedded files, which
+// carry no modification time of their own.
+var assetModTime = time.Now()
+
+// assetHashes maps the embedded static files to a hash of their content,
+// used as ETag and as version parameter in asset URLs.
+var assetHashes = sync.OnceValues(func() (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := fs.WalkDir(staticFiles, "public", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := staticFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		hashes[strings.TrimPrefix(name, "public/")] = hex.EncodeToString(sum[:8])
+		return nil
+	})
+	return hashes, err
+})
+
+// assetURL returns the URL of an embedded file with its content hash
+// appended, so it can be cached forever and still updates on a new release.
+func assetURL(name string) string {
+	hashes, _ := assetHashes()
+	if hash, ok := hashes[name]; ok {
+		return name + "?v=" + hash
+	}
+	return name
+}
+
+// staticHandler serves the embedded files with an ETag. Requests carrying
+// the current version parameter are cached for a year, anything else has to
+// be revalidated, which is cheap thanks to the ETag.
+func staticHandler(files fs.FS) http.Handler {
+	fileServer := http.FileServer(http.FS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		hashes, _ := assetHashes()
+		hash, ok := hashes[name]
+		if devMode {
+			// Files may differ from the embedded ones the hashes are of
+			w.Header().Set("Cache-Control", "no-store")
+			ok = false
+		}
+		if !ok {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		file, err := files.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+
+		w.Header().Set("ETag", `"`+hash+`"`)
+		if r.URL.Query().Get("v") == hash {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "public, no-cache")
+		}
+		http.ServeContent(w, r, name, assetModTime, file.(io.ReadSeeker))
+	})
+}
+
+// serveIndexPage serves the rendered upload page. It always has to be
+// revalidated since it's rebuilt on configuration reloads, and it carries
+// the CSRF token of the browser.
+func serveIndexPage(w http.ResponseWriter, r *http.Request, page string) {
+	page = strings.Replace(page, csrfTokenPlaceholder, csrfToken(w, r), 1)
+	sum := sha256.Sum256([]byte(page))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "index.html", time.Time{}, strings.NewReader(page))
+}
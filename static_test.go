@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStaticHandler_Caching(t *testing.T) {
+	files, err := fs.Sub(staticFiles, "public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := staticHandler(files)
+
+	versioned := "/" + assetURL("robots.txt")
+	if !strings.Contains(versioned, "?v=") {
+		t.Fatalf("expected a version parameter, got %s", versioned)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", versioned, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("expected versioned asset to be cached, got %q", w.Header().Get("Cache-Control"))
+	}
+	if w.Header().Get("Last-Modified") == "" {
+		t.Error("expected a Last-Modified header")
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag")
+	}
+
+	// Unversioned or outdated URLs must be revalidated
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/robots.txt?v=old", nil))
+	if w.Header().Get("Cache-Control") != "public, no-cache" {
+		t.Errorf("expected revalidation for outdated version, got %q", w.Header().Get("Cache-Control"))
+	}
+
+	req := httptest.NewRequest("GET", "/robots.txt", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for matching ETag, got %d", w.Code)
+	}
+}
+
+func TestBuildIndexPage_VersionedAssets(t *testing.T) {
+	os.Setenv("TURNSTILE_SITEKEY", "test-sitekey")
+	defer os.Unsetenv("TURNSTILE_SITEKEY")
+
+	page, _, err := buildIndexPage()
+	if err != nil {
+		t.Fatalf("buildIndexPage() failed: %v", err)
+	}
+	if !strings.Contains(page, assetURL("assets/background-picture.png")) {
+		t.Error("expected background image URL to carry a version parameter")
+	}
+
+	w := httptest.NewRecorder()
+	serveIndexPage(w, httptest.NewRequest("GET", "/", nil), page)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	// The page carries the CSRF token of the browser's cookie
+	req.AddCookie(w.Result().Cookies()[0])
+	w = httptest.NewRecorder()
+	serveIndexPage(w, req, page)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for unchanged index page, got %d", w.Code)
+	}
+}
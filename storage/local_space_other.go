@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package storage
+
+import "errors"
+
+// FreeSpace is not available on this platform.
+func (l *LocalStorage) FreeSpace() (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import "syscall"
+
+// FreeSpace returns the bytes available to the process on the file system
+// holding BasePath.
+func (l *LocalStorage) FreeSpace() (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(l.BasePath, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
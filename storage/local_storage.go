@@ -1,14 +1,26 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
 )
 
 type LocalStorage struct {
 	BasePath string
+	// FileMode and DirMode are the permissions of new files and directories,
+	// applied regardless of the umask. Zero keeps 0666 and 0755 minus the
+	// umask.
+	FileMode fs.FileMode
+	DirMode  fs.FileMode
+	// UID and GID own new files and directories if non-zero, which needs
+	// the privilege to change ownership.
+	UID int
+	GID int
 }
 
 func NewLocalStorage(path string) (*LocalStorage, error) {
@@ -18,17 +30,130 @@ func NewLocalStorage(path string) (*LocalStorage, error) {
 	return &LocalStorage{BasePath: path}, nil
 }
 
-func (l *LocalStorage) SaveFile(name string, data io.Reader) error {
-	err := os.MkdirAll(filepath.Dir(filepath.Join(l.BasePath, name)), 0755)
-	if err != nil {
-		return fmt.Errorf("creating directories: %w", err)
-	}
+func (l *LocalStorage) SaveFile(name string, data io.Reader) (SaveResult, error) {
 	fullPath := filepath.Join(l.BasePath, name)
+	if err := l.mkdirs(filepath.Dir(fullPath)); err != nil {
+		return SaveResult{}, fmt.Errorf("creating directories: %w", err)
+	}
 	f, err := os.Create(fullPath)
 	if err != nil {
-		return fmt.Errorf("creating file: %w", err)
+		return SaveResult{}, fmt.Errorf("creating file: %w", err)
+	}
+	var n int64
+	err = l.setOwnership(fullPath, l.FileMode)
+	if err == nil {
+		n, err = io.Copy(f, data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Don't leave truncated files behind for failed or cancelled uploads
+		os.Remove(fullPath)
+		return SaveResult{}, err
+	}
+	abs, err := filepath.Abs(fullPath)
+	if err != nil {
+		abs = fullPath
+	}
+	ref := url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+	return SaveResult{Size: n, Ref: ref.String()}, nil
+}
+
+// mkdirs creates dir and its missing parents below BasePath, applying the
+// configured mode and owner to the ones it creates.
+func (l *LocalStorage) mkdirs(dir string) error {
+	base := filepath.Clean(l.BasePath)
+	var missing []string
+	for d := dir; len(d) > len(base); d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+	}
+	mode := l.DirMode
+	if mode == 0 {
+		mode = 0755
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := l.setOwnership(missing[i], l.DirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setOwnership applies mode, unless it is zero, and the configured owner.
+func (l *LocalStorage) setOwnership(path string, mode fs.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	if l.UID == 0 && l.GID == 0 {
+		return nil
+	}
+	uid, gid := l.UID, l.GID
+	if uid == 0 {
+		uid = -1
+	}
+	if gid == 0 {
+		gid = -1
+	}
+	return os.Chown(path, uid, gid)
+}
+
+func (l *LocalStorage) OpenFile(name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(l.BasePath, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Directories are prefixes like in the other backends, not files
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNotFound
+	}
+	return f, nil
+}
+
+func (l *LocalStorage) DeleteFile(name string) error {
+	err := os.Remove(filepath.Join(l.BasePath, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
 	}
-	defer f.Close()
-	_, err = io.Copy(f, data)
 	return err
 }
+
+func (l *LocalStorage) ListFiles(prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(filepath.Join(l.BasePath, prefix), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.BasePath, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing files: %w", err)
+	}
+	return names, nil
+}
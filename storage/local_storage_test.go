@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorage_SaveFileRemovesPartialFile(t *testing.T) {
+	dir := t.TempDir()
+	local, err := NewLocalStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := io.MultiReader(strings.NewReader("partial content"), errReader{io.ErrUnexpectedEOF})
+	if _, err := local.SaveFile("session/photo.jpg", data); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected unexpected EOF, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "session/photo.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected partial file to be removed, stat returned %v", err)
+	}
+}
+
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
+
+func TestLocalStorage_SaveResult(t *testing.T) {
+	dir := t.TempDir()
+	local, err := NewLocalStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := local.SaveFile("session/photo.jpg", strings.NewReader("photo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "file://" + filepath.ToSlash(filepath.Join(dir, "session", "photo.jpg"))
+	if result.Size != 5 || result.Ref != want {
+		t.Errorf("got %+v, want size 5 and ref %s", result, want)
+	}
+}
+
+func TestLocalStorage_Modes(t *testing.T) {
+	dir := t.TempDir()
+	local, err := NewLocalStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.FileMode, local.DirMode = 0640, 0750
+
+	if _, err := local.SaveFile("2024/session/photo.jpg", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{
+		"2024":                   0750 | os.ModeDir,
+		"2024/session":           0750 | os.ModeDir,
+		"2024/session/photo.jpg": 0640,
+	} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != want {
+			t.Errorf("%s: mode %v, want %v", name, info.Mode(), want)
+		}
+	}
+	if info, _ := os.Stat(dir); info.Mode().Perm() == 0750 {
+		t.Error("expected the base directory to be left alone")
+	}
+}
+
+func TestLocalStorage_FreeSpace(t *testing.T) {
+	local, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	free, err := local.FreeSpace()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("free space is not available on this platform")
+	}
+	if err != nil || free <= 0 {
+		t.Errorf("FreeSpace = %d, %v", free, err)
+	}
+}
+
+func TestLocalStorage_OpenDirectory(t *testing.T) {
+	local, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.SaveFile("session/photo.jpg", strings.NewReader("x"))
+	if _, err := local.OpenFile("session"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("OpenFile of a directory = %v, want ErrNotFound", err)
+	}
+}
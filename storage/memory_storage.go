@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps files in memory, for demos, tests and throwaway drop
+// boxes. Files are lost on restart.
+type MemoryStorage struct {
+	// MaxSize is the total size of all files. The oldest files are evicted
+	// to make room for new ones.
+	MaxSize int64
+	// TTL removes files this long after they were saved if non-zero.
+	TTL time.Duration
+
+	mu    sync.Mutex
+	files map[string]memoryFile
+	size  int64
+}
+
+type memoryFile struct {
+	data  []byte
+	saved time.Time
+}
+
+func NewMemoryStorage(maxSize int64, ttl time.Duration) *MemoryStorage {
+	return &MemoryStorage{MaxSize: maxSize, TTL: ttl, files: make(map[string]memoryFile)}
+}
+
+func (m *MemoryStorage) SaveFile(name string, data io.Reader) (SaveResult, error) {
+	// Read outside the lock, uploads can be slow
+	content, err := io.ReadAll(io.LimitReader(data, m.MaxSize+1))
+	if err != nil {
+		return SaveResult{}, err
+	}
+	if int64(len(content)) > m.MaxSize {
+		return SaveResult{}, fmt.Errorf("file exceeds the memory storage size of %d bytes", m.MaxSize)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.expire(now)
+	m.remove(name)
+	for m.size+int64(len(content)) > m.MaxSize {
+		m.evictOldest()
+	}
+	m.files[name] = memoryFile{data: content, saved: now}
+	m.size += int64(len(content))
+	return SaveResult{Size: int64(len(content)), Ref: "memory://" + name}, nil
+}
+
+func (m *MemoryStorage) OpenFile(name string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	f, ok := m.files[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// Files are replaced rather than modified, so readers can share data
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (m *MemoryStorage) DeleteFile(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	if !m.remove(name) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *MemoryStorage) ListFiles(prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	var names []string
+	for name := range m.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Size returns the total size of the stored files.
+func (m *MemoryStorage) Size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	return m.size
+}
+
+// expire removes files older than the TTL. The caller holds the lock.
+func (m *MemoryStorage) expire(now time.Time) {
+	if m.TTL <= 0 {
+		return
+	}
+	for name, f := range m.files {
+		if now.Sub(f.saved) >= m.TTL {
+			m.remove(name)
+		}
+	}
+}
+
+// evictOldest removes the file saved first. The caller holds the lock.
+func (m *MemoryStorage) evictOldest() {
+	var oldest string
+	var saved time.Time
+	for name, f := range m.files {
+		if oldest == "" || f.saved.Before(saved) {
+			oldest, saved = name, f.saved
+		}
+	}
+	m.remove(oldest)
+}
+
+// remove deletes a file and reports whether it existed. The caller holds
+// the lock.
+func (m *MemoryStorage) remove(name string) bool {
+	f, ok := m.files[name]
+	if ok {
+		delete(m.files, name)
+		m.size -= int64(len(f.data))
+	}
+	return ok
+}
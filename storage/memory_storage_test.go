@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	memory := NewMemoryStorage(10, 0)
+
+	result, err := memory.SaveFile("session/a.jpg", strings.NewReader("aaaa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Size != 4 || result.Ref != "memory://session/a.jpg" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	memory.SaveFile("session/b.jpg", strings.NewReader("bbbb"))
+	f, err := memory.OpenFile("session/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	if string(data) != "aaaa" {
+		t.Errorf("got %q", data)
+	}
+
+	// The oldest file makes room
+	memory.SaveFile("other/c.jpg", strings.NewReader("cccc"))
+	if _, err := memory.OpenFile("session/a.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the oldest file to be evicted, got %v", err)
+	}
+	names, _ := memory.ListFiles("session/")
+	if len(names) != 1 || names[0] != "session/b.jpg" || memory.Size() != 8 {
+		t.Errorf("got %v with %d bytes", names, memory.Size())
+	}
+
+	if _, err := memory.SaveFile("large.jpg", strings.NewReader("01234567890")); err == nil {
+		t.Error("expected files larger than the storage to be rejected")
+	}
+	if err := memory.DeleteFile("other/c.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.DeleteFile("other/c.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestMemoryStorage_TTL(t *testing.T) {
+	memory := NewMemoryStorage(100, time.Millisecond)
+	memory.SaveFile("a.jpg", strings.NewReader("a"))
+	time.Sleep(5 * time.Millisecond)
+	if names, _ := memory.ListFiles(""); len(names) != 0 || memory.Size() != 0 {
+		t.Errorf("expected the file to expire, got %v", names)
+	}
+}
@@ -2,20 +2,49 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	s3lib "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
 )
 
 type S3Storage struct {
 	Client     *s3lib.Client
 	BucketName string
 	Prefix     string
+	// PartSize and Concurrency bound the memory used per upload: the uploader
+	// buffers at most PartSize * (Concurrency + 1) bytes.
+	PartSize    int64
+	Concurrency int
+	// KMSKeyID enables SSE-KMS encryption with the given key when set.
+	KMSKeyID string
+	// LockMode and LockRetention place every stored object under Object Lock
+	// retention until LockRetention after the upload. The bucket must have
+	// Object Lock enabled.
+	LockMode      types.ObjectLockMode
+	LockRetention time.Duration
+	// AttemptTimeout limits every attempt of a request storing data, so a
+	// hung connection is retried instead of stalling the upload.
+	// OperationTimeout limits the other requests including their retries;
+	// for OpenFile it only covers the time until the response starts. Zero
+	// disables them.
+	AttemptTimeout   time.Duration
+	OperationTimeout time.Duration
+	// ChecksumAlgorithm has S3 verify every upload with the given checksum
+	// and store it with the object, where HeadObject returns it.
+	ChecksumAlgorithm types.ChecksumAlgorithm
+	// RequesterPays acknowledges the charges of a requester-pays bucket on
+	// every object request.
+	RequesterPays bool
 }
 
 func NewS3Storage(bucket string, prefix string) (*S3Storage, error) {
@@ -26,25 +55,279 @@ func NewS3Storage(bucket string, prefix string) (*S3Storage, error) {
 	client := s3lib.NewFromConfig(cfg)
 
 	return &S3Storage{
-		Client:     client,
-		BucketName: bucket,
-		Prefix:     prefix,
+		Client:      client,
+		BucketName:  bucket,
+		Prefix:      prefix,
+		PartSize:    8 * 1024 * 1024,
+		Concurrency: 3,
 	}, nil
 }
 
-func (s *S3Storage) SaveFile(name string, data io.Reader) error {
-	key := strings.TrimPrefix(s.Prefix+"/"+name, "/")
+// VerifyBucket checks that the bucket exists and is writable by storing and
+// removing a small probe object. A missing bucket is created if create is set.
+func (s *S3Storage) VerifyBucket(create bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
+	_, err := s.Client.HeadBucket(ctx, &s3lib.HeadBucketInput{Bucket: aws.String(s.BucketName)})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NotFound", "NoSuchBucket":
+			if !create {
+				return fmt.Errorf("bucket %q does not exist; create it or enable automatic creation", s.BucketName)
+			}
+			if err := s.createBucket(ctx); err != nil {
+				return err
+			}
+			err = nil
+		case "Forbidden", "AccessDenied":
+			return fmt.Errorf("access to bucket %q denied; check the credentials and bucket policy: %w", s.BucketName, err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("checking bucket %q: %w", s.BucketName, err)
+	}
+
+	if s.LockMode != "" {
+		if err := s.verifyObjectLock(ctx); err != nil {
+			return err
+		}
+	}
+
+	// The probe is not locked, so it doesn't linger in the bucket
+	probe := fmt.Sprintf(".write-probe-%d", time.Now().UnixNano())
+	if err := s.putObject(s.key(probe), strings.NewReader("probe"), false); err != nil {
+		return fmt.Errorf("bucket %q is not writable; check s3:PutObject permissions: %w", s.BucketName, err)
+	}
+	if err := s.DeleteFile(probe); err != nil {
+		return fmt.Errorf("removing write probe from bucket %q; check s3:DeleteObject permissions: %w", s.BucketName, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) createBucket(ctx context.Context) error {
+	input := &s3lib.CreateBucketInput{Bucket: aws.String(s.BucketName)}
+	if s.LockMode != "" {
+		input.ObjectLockEnabledForBucket = aws.Bool(true)
+	}
+	// us-east-1 is the default location and must not be passed explicitly
+	if region := s.Client.Options().Region; region != "" && region != "us-east-1" {
+		input.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraint(region),
+		}
+	}
+	if _, err := s.Client.CreateBucket(ctx, input); err != nil {
+		return fmt.Errorf("creating bucket %q: %w", s.BucketName, err)
+	}
+	return nil
+}
+
+func (s *S3Storage) verifyObjectLock(ctx context.Context) error {
+	out, err := s.Client.GetObjectLockConfiguration(ctx, &s3lib.GetObjectLockConfigurationInput{Bucket: aws.String(s.BucketName)})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "ObjectLockConfigurationNotFoundError" {
+		return fmt.Errorf("bucket %q does not have Object Lock enabled", s.BucketName)
+	}
+	if err != nil {
+		return fmt.Errorf("checking Object Lock configuration of bucket %q: %w", s.BucketName, err)
+	}
+	if out.ObjectLockConfiguration == nil || out.ObjectLockConfiguration.ObjectLockEnabled != types.ObjectLockEnabledEnabled {
+		return fmt.Errorf("bucket %q does not have Object Lock enabled", s.BucketName)
+	}
+	return nil
+}
+
+// UseAccelerate switches the client to the Transfer Acceleration endpoint.
+// The bucket must have acceleration enabled and a name without dots.
+func (s *S3Storage) UseAccelerate() {
+	s.Client = s3lib.New(s.Client.Options(), func(o *s3lib.Options) {
+		o.UseAccelerate = true
+	})
+}
+
+func (s *S3Storage) requestPayer() types.RequestPayer {
+	if s.RequesterPays {
+		return types.RequestPayerRequester
+	}
+	return ""
+}
+
+func (s *S3Storage) key(name string) string {
+	return strings.TrimPrefix(s.Prefix+"/"+name, "/")
+}
+
+func (s *S3Storage) SaveFile(name string, data io.Reader) (SaveResult, error) {
+	key := s.key(name)
+	counter := &countingReader{Reader: data}
+	if err := s.putObject(key, counter, true); err != nil {
+		return SaveResult{}, err
+	}
+	return SaveResult{Size: counter.n, Ref: "s3://" + s.BucketName + "/" + key}, nil
+}
+
+// countingReader counts the bytes read through it. Seeking is not passed
+// on, so retried parts are not counted twice: the uploader buffers them.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (s *S3Storage) putObject(key string, data io.Reader, lock bool) error {
 	uploader := manager.NewUploader(s.Client, func(u *manager.Uploader) {
-		u.PartSize = 8 * 1024 * 1024
-		u.Concurrency = 3
+		u.PartSize = s.PartSize
+		u.Concurrency = s.Concurrency
+		// Abort the multipart upload if reading the body fails, so no
+		// orphaned parts or half-written objects remain in the bucket
+		u.LeavePartsOnError = false
+		if s.AttemptTimeout > 0 {
+			u.ClientOptions = append(u.ClientOptions, func(o *s3lib.Options) {
+				o.APIOptions = append(o.APIOptions, attemptTimeout(s.AttemptTimeout))
+			})
+		}
 	})
 
-	_, err := uploader.Upload(context.TODO(), &s3lib.PutObjectInput{
-		Bucket: aws.String(s.BucketName),
-		Key:    aws.String(key),
-		Body:   data,
+	// The uploader passes RequestPayer on to the multipart requests
+	input := &s3lib.PutObjectInput{
+		Bucket:       aws.String(s.BucketName),
+		Key:          aws.String(key),
+		Body:         data,
+		RequestPayer: s.requestPayer(),
+	}
+	if s.KMSKeyID != "" {
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = aws.String(s.KMSKeyID)
+	}
+	input.ChecksumAlgorithm = s.ChecksumAlgorithm
+	if lock && s.LockMode != "" {
+		// Object Lock requires an integrity checksum on the request
+		if input.ChecksumAlgorithm == "" {
+			input.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32
+		}
+		input.ObjectLockMode = s.LockMode
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(s.LockRetention))
+	}
+
+	_, err := uploader.Upload(context.TODO(), input)
+
+	return err
+}
+
+// attemptTimeout cancels single attempts of a request after d. The retryer
+// never retries cancelled requests, so the timeout is reported as a
+// retryable error unless the whole operation was cancelled.
+func attemptTimeout(d time.Duration) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		// Added after the retry middleware, so it wraps every attempt
+		return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("AttemptTimeout",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				attemptCtx, cancel := context.WithTimeout(ctx, d)
+				defer cancel()
+				out, metadata, err := next.HandleFinalize(attemptCtx, in)
+				if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+					err = &attemptTimeoutError{timeout: d}
+				}
+				return out, metadata, err
+			}), middleware.After)
+	}
+}
+
+// attemptTimeoutError doesn't unwrap to the cancellation, which would stop
+// the retries.
+type attemptTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *attemptTimeoutError) Error() string {
+	return fmt.Sprintf("attempt timed out after %s", e.timeout)
+}
+
+func (e *attemptTimeoutError) RetryableError() bool {
+	return true
+}
+
+// operationContext applies OperationTimeout to a request.
+func (s *S3Storage) operationContext() (context.Context, context.CancelFunc) {
+	if s.OperationTimeout > 0 {
+		return context.WithTimeout(context.Background(), s.OperationTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (s *S3Storage) OpenFile(name string) (io.ReadCloser, error) {
+	// The body is read long after the response started, e.g. by slow
+	// downloads, so the timeout is stopped once it did
+	ctx, cancel := context.WithCancel(context.Background())
+	if s.OperationTimeout > 0 {
+		timer := time.AfterFunc(s.OperationTimeout, cancel)
+		defer timer.Stop()
+	}
+	out, err := s.Client.GetObject(ctx, &s3lib.GetObjectInput{
+		Bucket:       aws.String(s.BucketName),
+		Key:          aws.String(s.key(name)),
+		RequestPayer: s.requestPayer(),
 	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		cancel()
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &cancelOnClose{ReadCloser: out.Body, cancel: cancel}, nil
+}
+
+// cancelOnClose releases the context of a request with its body.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
 
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
 	return err
 }
+
+func (s *S3Storage) DeleteFile(name string) error {
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	_, err := s.Client.DeleteObject(ctx, &s3lib.DeleteObjectInput{
+		Bucket:       aws.String(s.BucketName),
+		Key:          aws.String(s.key(name)),
+		RequestPayer: s.requestPayer(),
+	})
+	return err
+}
+
+func (s *S3Storage) ListFiles(prefix string) ([]string, error) {
+	base := s.key("")
+	paginator := s3lib.NewListObjectsV2Paginator(s.Client, &s3lib.ListObjectsV2Input{
+		Bucket:       aws.String(s.BucketName),
+		Prefix:       aws.String(s.key(prefix)),
+		RequestPayer: s.requestPayer(),
+	})
+
+	var names []string
+	for paginator.HasMorePages() {
+		// Per page, large buckets take many pages
+		ctx, cancel := s.operationContext()
+		page, err := paginator.NextPage(ctx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("listing objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			names = append(names, strings.TrimPrefix(aws.ToString(obj.Key), base))
+		}
+	}
+	return names, nil
+}
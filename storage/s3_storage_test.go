@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	s3lib "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// newTestS3Storage returns storage talking to a fake S3 endpoint.
+func newTestS3Storage(t *testing.T, handler http.HandlerFunc) *S3Storage {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := s3lib.New(s3lib.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})
+	return &S3Storage{Client: client, BucketName: "bucket", Prefix: "uploads", PartSize: 5 << 20, Concurrency: 1}
+}
+
+func TestS3Storage_AttemptTimeoutRetries(t *testing.T) {
+	var attempts atomic.Int32
+	s := newTestS3Storage(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if attempts.Add(1) == 1 {
+			// Hang until the client gives up
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	s.AttemptTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	result, err := s.SaveFile("session/photo.jpg", strings.NewReader("photo"))
+	if err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	if result.Size != 5 || result.Ref != "s3://bucket/uploads/session/photo.jpg" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts.Load())
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("took %s", time.Since(start))
+	}
+}
+
+func TestS3Storage_OperationTimeout(t *testing.T) {
+	s := newTestS3Storage(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	s.OperationTimeout = 200 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() { done <- s.DeleteFile("session/photo.jpg") }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected a timeout error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delete did not time out")
+	}
+}
+
+func TestS3Storage_RequesterPays(t *testing.T) {
+	var missing atomic.Int32
+	s := newTestS3Storage(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.Header.Get("X-Amz-Request-Payer") != "requester" {
+			missing.Add(1)
+		}
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+			w.Write([]byte(`<ListBucketResult></ListBucketResult>`))
+		}
+	})
+	s.RequesterPays = true
+
+	s.SaveFile("a.jpg", strings.NewReader("a"))
+	if f, err := s.OpenFile("a.jpg"); err == nil {
+		f.Close()
+	}
+	s.DeleteFile("a.jpg")
+	s.ListFiles("")
+	if missing.Load() != 0 {
+		t.Errorf("%d request(s) without the requester-pays header", missing.Load())
+	}
+}
+
+func TestS3Storage_ChecksumAlgorithm(t *testing.T) {
+	var algorithm string
+	s := newTestS3Storage(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		algorithm = r.Header.Get("X-Amz-Sdk-Checksum-Algorithm")
+	})
+	s.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+
+	if _, err := s.SaveFile("a.jpg", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if algorithm != "SHA256" {
+		t.Errorf("expected a SHA256 checksum, got %q", algorithm)
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+)
+
+// SizeRouter stores files up to Threshold bytes in Small and larger ones in
+// Large, e.g. photos and metadata on local disk and videos in S3. Files are
+// buffered in memory up to the threshold to find out where they belong.
+type SizeRouter struct {
+	Small     Backend
+	Large     Backend
+	Threshold int64
+}
+
+// SaveFile removes an earlier version of the file from the other backend,
+// in case it changed sides.
+func (s *SizeRouter) SaveFile(name string, data io.Reader) (SaveResult, error) {
+	head, err := io.ReadAll(io.LimitReader(data, s.Threshold+1))
+	if err != nil {
+		return SaveResult{}, err
+	}
+	target, other := s.Small, s.Large
+	body := io.Reader(bytes.NewReader(head))
+	if int64(len(head)) > s.Threshold {
+		target, other = s.Large, s.Small
+		body = io.MultiReader(body, data)
+	}
+	result, err := target.SaveFile(name, body)
+	if err != nil {
+		return result, err
+	}
+	if err := other.DeleteFile(name); err != nil && !errors.Is(err, ErrNotFound) {
+		return result, err
+	}
+	return result, nil
+}
+
+func (s *SizeRouter) OpenFile(name string) (io.ReadCloser, error) {
+	f, err := s.Small.OpenFile(name)
+	if errors.Is(err, ErrNotFound) {
+		return s.Large.OpenFile(name)
+	}
+	return f, err
+}
+
+// DeleteFile removes the file from whichever backend holds it.
+func (s *SizeRouter) DeleteFile(name string) error {
+	smallErr := s.Small.DeleteFile(name)
+	if smallErr != nil && !errors.Is(smallErr, ErrNotFound) {
+		return smallErr
+	}
+	largeErr := s.Large.DeleteFile(name)
+	if largeErr != nil && !errors.Is(largeErr, ErrNotFound) {
+		return largeErr
+	}
+	if smallErr != nil && largeErr != nil {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListFiles merges the listings of both backends.
+func (s *SizeRouter) ListFiles(prefix string) ([]string, error) {
+	names, err := s.Small.ListFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+	large, err := s.Large.ListFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, large...)
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSizeRouter(t *testing.T) {
+	small, large := NewMemoryStorage(100, 0), NewMemoryStorage(100, 0)
+	router := &SizeRouter{Small: small, Large: large, Threshold: 4}
+
+	router.SaveFile("session/photo.jpg", strings.NewReader("1234"))
+	router.SaveFile("session/video.mp4", strings.NewReader("123456789"))
+	if small.Size() != 4 || large.Size() != 9 {
+		t.Fatalf("expected the files to be split by size, got %d and %d bytes", small.Size(), large.Size())
+	}
+	f, err := router.OpenFile("session/video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	if string(data) != "123456789" {
+		t.Errorf("got %q", data)
+	}
+	if names, _ := router.ListFiles("session/"); len(names) != 2 {
+		t.Errorf("expected both files listed, got %v", names)
+	}
+
+	// A file growing past the threshold moves to the large backend
+	router.SaveFile("session/photo.jpg", strings.NewReader("12345"))
+	if _, err := small.OpenFile("session/photo.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the old version to be removed, got %v", err)
+	}
+
+	if err := router.DeleteFile("session/video.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.DeleteFile("session/video.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
@@ -1,10 +1,44 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"io"
 )
 
+// ErrNotFound is returned when a requested file does not exist in the backend.
+var ErrNotFound = errors.New("file not found")
+
 // Backend defines a common interface for saving files.
 type Backend interface {
-	SaveFile(name string, data io.Reader) error
+	// SaveFile stores data under name, replacing an existing file.
+	SaveFile(name string, data io.Reader) (SaveResult, error)
+	OpenFile(name string) (io.ReadCloser, error)
+	DeleteFile(name string) error
+	// ListFiles returns the names of all files below prefix.
+	ListFiles(prefix string) ([]string, error)
+}
+
+// SaveResult describes a stored file.
+type SaveResult struct {
+	// Size is the number of bytes written.
+	Size int64
+	// Ref is the canonical reference of the stored object, e.g.
+	// "s3://bucket/uploads/session/photo.jpg" or
+	// "file:///var/uploads/session/photo.jpg".
+	Ref string
+}
+
+// MoveFile copies a file to a new name and removes the original.
+func MoveFile(b Backend, from, to string) error {
+	src, err := b.OpenFile(from)
+	if err != nil {
+		return err
+	}
+	_, err = b.SaveFile(to, src)
+	src.Close()
+	if err != nil {
+		return fmt.Errorf("copying file: %w", err)
+	}
+	return b.DeleteFile(from)
 }
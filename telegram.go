@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telegramAPI is the Bot API endpoint. Tests replace it.
+var telegramAPI = "https://api.telegram.org"
+
+// telegramBot receives media sent to a Telegram bot. Token is empty when
+// the bot is off.
+var telegramBot struct {
+	Token string
+	// Drop receives the files, the main upload page if nil
+	Drop *drop
+	// Chats limits the chats the bot accepts files from, if set
+	Chats []int64
+}
+
+// telegramMaxSize is the largest file bots can download through the Bot API.
+const telegramMaxSize = 20 << 20
+
+var telegramClient = &http.Client{Timeout: 90 * time.Second}
+
+// setupTelegram starts polling the bot of TELEGRAM_BOT_TOKEN, so guests can
+// send photos in a chat instead of opening the upload page. It runs after
+// the drops are set up to resolve TELEGRAM_DROP.
+func setupTelegram() error {
+	telegramBot.Token = os.Getenv("TELEGRAM_BOT_TOKEN")
+	if telegramBot.Token == "" {
+		return nil
+	}
+	if name := os.Getenv("TELEGRAM_DROP"); name != "" {
+		d, ok := drops[name]
+		if !ok {
+			return fmt.Errorf("TELEGRAM_DROP %q is not listed in DROPS", name)
+		}
+		telegramBot.Drop = d
+	}
+	for _, item := range splitList(os.Getenv("TELEGRAM_ALLOWED_CHATS")) {
+		id, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			return fmt.Errorf("TELEGRAM_ALLOWED_CHATS must list chat IDs, got %q", item)
+		}
+		telegramBot.Chats = append(telegramBot.Chats, id)
+	}
+	var me telegramUser
+	if err := telegramCall(context.Background(), "getMe", nil, &me); err != nil {
+		return fmt.Errorf("connecting to the Telegram bot: %w", err)
+	}
+	if checkingConfig {
+		// Polling would take the updates from the running server
+		return nil
+	}
+	go pollTelegram()
+	log.Printf("Receiving files sent to the Telegram bot @%s", me.Username)
+	return nil
+}
+
+type telegramUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+}
+
+type telegramFile struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	FileSize int64  `json:"file_size"`
+}
+
+type telegramMessage struct {
+	MessageID    int64         `json:"message_id"`
+	MediaGroupID string        `json:"media_group_id"`
+	From         *telegramUser `json:"from"`
+	Chat         struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Caption  string         `json:"caption"`
+	Photo    []telegramFile `json:"photo"`
+	Document *telegramFile  `json:"document"`
+	Video    *telegramFile  `json:"video"`
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+// telegramCall calls a Bot API method and decodes its result into result.
+func telegramCall(ctx context.Context, method string, params url.Values, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPI+"/bot"+telegramBot.Token+"/"+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := telegramClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling %s: %w", method, redactTelegramError(err))
+	}
+	defer resp.Body.Close()
+	var body struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("decoding %s response: %w", method, err)
+	}
+	if !body.OK {
+		return fmt.Errorf("%s failed: %s", method, body.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(body.Result, result)
+}
+
+// pollTelegram fetches updates with long polling until the process exits.
+func pollTelegram() {
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		params := url.Values{"offset": {strconv.FormatInt(offset, 10)}, "timeout": {"60"}, "allowed_updates": {`["message"]`}}
+		if err := telegramCall(context.Background(), "getUpdates", params, &updates); err != nil {
+			log.Printf("Error polling Telegram: %v", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		var messages []*telegramMessage
+		for _, update := range updates {
+			offset = max(offset, update.UpdateID+1)
+			if update.Message != nil {
+				messages = append(messages, update.Message)
+			}
+		}
+		receiveTelegramMessages(messages)
+	}
+}
+
+// receiveTelegramMessages stores the media of the messages, one session per
+// message or album, and answers in the chat.
+func receiveTelegramMessages(messages []*telegramMessage) {
+	var groups [][]*telegramMessage
+	albums := make(map[string]int)
+	for _, msg := range messages {
+		if msg.MediaGroupID == "" {
+			groups = append(groups, []*telegramMessage{msg})
+			continue
+		}
+		key := strconv.FormatInt(msg.Chat.ID, 10) + "/" + msg.MediaGroupID
+		if i, ok := albums[key]; ok {
+			groups[i] = append(groups[i], msg)
+			continue
+		}
+		albums[key] = len(groups)
+		groups = append(groups, []*telegramMessage{msg})
+	}
+	for _, group := range groups {
+		if reply := receiveTelegramGroup(group); reply != "" {
+			sendTelegramReply(group[0], reply)
+		}
+	}
+}
+
+// telegramMedia returns the files of a message. Photos come in several
+// sizes, of which the largest is kept.
+func telegramMedia(msg *telegramMessage) []telegramFile {
+	var files []telegramFile
+	if len(msg.Photo) > 0 {
+		photo := slices.MaxFunc(msg.Photo, func(a, b telegramFile) int { return int(a.FileSize - b.FileSize) })
+		photo.FileName = fmt.Sprintf("telegram-%d.jpg", msg.MessageID)
+		photo.MimeType = "image/jpeg"
+		files = append(files, photo)
+	}
+	for _, file := range []*telegramFile{msg.Document, msg.Video} {
+		if file != nil {
+			if file.FileName == "" {
+				file.FileName = fmt.Sprintf("telegram-%d", msg.MessageID)
+			}
+			files = append(files, *file)
+		}
+	}
+	return files
+}
+
+// receiveTelegramGroup stores the media of a message or album as an upload
+// session and returns the reply for the chat.
+func receiveTelegramGroup(group []*telegramMessage) string {
+	first := group[0]
+	if len(telegramBot.Chats) > 0 && !slices.Contains(telegramBot.Chats, first.Chat.ID) {
+		log.Printf("Ignoring Telegram message from chat %d, which is not allowed", first.Chat.ID)
+		return ""
+	}
+	var files []telegramFile
+	caption := ""
+	for _, msg := range group {
+		files = append(files, telegramMedia(msg)...)
+		if caption == "" {
+			caption = msg.Caption
+		}
+	}
+	if len(files) == 0 {
+		return "Send photos or videos to add them to the collection."
+	}
+	if paused, message := maintenanceMode(); paused {
+		return message
+	}
+	if d := telegramBot.Drop; d != nil {
+		if message, closed := d.closedMessage(time.Now()); closed {
+			return message
+		}
+	}
+	release, ok := acquireUploadSlot()
+	if !ok {
+		return "Too many uploads right now, please send the files again in a minute."
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), requestIDKey{}, newRequestID()), 4*time.Minute)
+	defer cancel()
+	if telegramBot.Drop != nil {
+		ctx = context.WithValue(ctx, dropKey{}, telegramBot.Drop)
+	}
+	// Session placeholders and audit events read the client from a request,
+	// the chat stands in for the address
+	r := (&http.Request{RemoteAddr: fmt.Sprintf("telegram-%d", first.Chat.ID), Header: http.Header{}}).WithContext(ctx)
+	folders := newSessionFolders(r, time.Now())
+	session := folders.Session
+	sender := telegramSender(first)
+	requestLogf(ctx, "Receiving %d file(s) from Telegram user %s into session %s", len(files), logName(sender), session)
+
+	var saved, failed int
+	var stored []*pipelineFile
+	for _, file := range files {
+		result := receiveTelegramFile(ctx, folders, file)
+		auditBlockedHash(r, result)
+		switch result.outcome {
+		case outcomeRejected, outcomeFailed:
+			failed++
+			if result.outcome == outcomeRejected {
+				uploadedFiles.WithLabelValues("rejected").Inc()
+			} else {
+				uploadedFiles.WithLabelValues("failed").Inc()
+			}
+		case outcomeQuarantined:
+			saved++
+			uploadedFiles.WithLabelValues("quarantined").Inc()
+		default:
+			saved++
+			uploadedFiles.WithLabelValues("saved").Inc()
+			stored = append(stored, result.file)
+		}
+	}
+
+	requestLogf(ctx, "Telegram upload into session %s: %d saved, %d failed", session, saved, failed)
+	uploadSessions.WithLabelValues(sessionResult(saved, failed)).Inc()
+	if saved == 0 {
+		return "Sorry, the files could not be saved."
+	}
+	message := "Sent via Telegram by " + sender
+	if caption != "" {
+		message += ": " + caption
+	}
+	message = readMessage(strings.NewReader(message))
+	if err := saveMessage(r, session, message); err != nil {
+		requestLogf(ctx, "Error saving message for session %s: %v", session, err)
+	}
+	recordAudit(r, "upload.telegram", session, map[string]any{"chat": first.Chat.ID, "from": sender, "saved": saved, "failed": failed})
+	writeSessionChecksums(ctx, stored)
+	emitEvent(&uploadEvent{Type: eventSessionCompleted, Session: session, Drop: dropName(ctx), RequestID: requestID(ctx), Saved: saved, Failed: failed})
+	runSessionHook(ctx, session, saved, failed, message)
+	if failed > 0 {
+		return fmt.Sprintf("Saved %d file(s), %d could not be saved.", saved, failed)
+	}
+	return fmt.Sprintf("Thank you! Saved %d file(s).", saved)
+}
+
+func telegramSender(msg *telegramMessage) string {
+	switch {
+	case msg.From == nil:
+		return strconv.FormatInt(msg.Chat.ID, 10)
+	case msg.From.Username != "":
+		return "@" + msg.From.Username
+	case msg.From.FirstName != "":
+		return msg.From.FirstName
+	}
+	return strconv.FormatInt(msg.From.ID, 10)
+}
+
+// receiveTelegramFile downloads a file through the Bot API and stores it in
+// the session.
+func receiveTelegramFile(ctx context.Context, folders sessionFolders, file telegramFile) fileResult {
+	if file.FileSize > telegramMaxSize {
+		return fileResult{outcome: outcomeRejected, message: fmt.Sprintf("larger than %d bytes", telegramMaxSize)}
+	}
+	var info struct {
+		FilePath string `json:"file_path"`
+	}
+	if err := telegramCall(ctx, "getFile", url.Values{"file_id": {file.FileID}}, &info); err != nil {
+		requestLogf(ctx, "Error looking up Telegram file %s: %v", file.FileID, err)
+		return fileResult{outcome: outcomeFailed, message: "could not be downloaded", err: err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, telegramAPI+"/file/bot"+telegramBot.Token+"/"+info.FilePath, nil)
+	if err != nil {
+		return fileResult{outcome: outcomeFailed, message: "could not be downloaded", err: err}
+	}
+	resp, err := telegramClient.Do(req)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("server returned %s", resp.Status)
+	}
+	if err != nil {
+		err = redactTelegramError(err)
+		requestLogf(ctx, "Error downloading Telegram file %s: %v", file.FileID, err)
+		return fileResult{outcome: outcomeFailed, message: "could not be downloaded", err: err}
+	}
+	defer resp.Body.Close()
+	folder, data := folders.forFile(http.MaxBytesReader(nil, resp.Body, telegramMaxSize))
+	return storeUpload(ctx, folder, "", file.FileName, file.MimeType, data)
+}
+
+func sendTelegramReply(msg *telegramMessage, text string) {
+	params := url.Values{
+		"chat_id":          {strconv.FormatInt(msg.Chat.ID, 10)},
+		"text":             {text},
+		"reply_parameters": {fmt.Sprintf(`{"message_id":%d}`, msg.MessageID)},
+	}
+	if err := telegramCall(context.Background(), "sendMessage", params, nil); err != nil {
+		log.Printf("Error replying in Telegram chat %d: %v", msg.Chat.ID, err)
+	}
+}
+
+// redactTelegramError drops the URL, which includes the bot token, from
+// errors of the HTTP client.
+func redactTelegramError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTelegramBot(t *testing.T) {
+	var mu sync.Mutex
+	var replies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bottest-token/getFile":
+			r.ParseForm()
+			w.Write([]byte(`{"ok":true,"result":{"file_path":"photos/` + r.Form.Get("file_id") + `"}}`))
+		case "/file/bottest-token/photos/large", "/file/bottest-token/photos/video":
+			w.Write([]byte("media " + strings.TrimPrefix(r.URL.Path, "/file/bottest-token/photos/")))
+		case "/bottest-token/sendMessage":
+			r.ParseForm()
+			mu.Lock()
+			replies = append(replies, r.Form.Get("text"))
+			mu.Unlock()
+			w.Write([]byte(`{"ok":true,"result":{}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	originalAPI, originalStorage := telegramAPI, storage
+	mock := &MockStorage{}
+	telegramAPI, storage = server.URL, mock
+	telegramBot.Token, telegramBot.Chats = "test-token", []int64{42}
+	defer func() {
+		telegramAPI, storage = originalAPI, originalStorage
+		telegramBot.Token, telegramBot.Chats = "", nil
+	}()
+
+	album := func(id int64, chat int64, media string) *telegramMessage {
+		msg := &telegramMessage{MessageID: id, MediaGroupID: "album", From: &telegramUser{Username: "grandma"}}
+		msg.Chat.ID = chat
+		if media == "photo" {
+			msg.Caption = "From the party"
+			msg.Photo = []telegramFile{{FileID: "small", FileSize: 10}, {FileID: "large", FileSize: 100}}
+		} else {
+			msg.Video = &telegramFile{FileID: "video", FileName: "clip.mp4", MimeType: "video/mp4"}
+		}
+		return msg
+	}
+	receiveTelegramMessages([]*telegramMessage{album(1, 42, "photo"), album(2, 42, "video"), album(3, 7, "photo")})
+
+	var files []string
+	sessions := map[string]bool{}
+	for name, content := range mock.files {
+		if isInternalPath(name) {
+			continue
+		}
+		files = append(files, name+"="+string(content))
+		sessions[name[:strings.LastIndex(name, "/")]] = true
+	}
+	if len(files) != 2 || len(sessions) != 1 {
+		t.Errorf("expected the album in one session, got %v", files)
+	}
+	if len(replies) != 1 || !strings.Contains(replies[0], "Saved 2 file(s)") {
+		t.Errorf("expected one reply for the allowed chat, got %q", replies)
+	}
+}
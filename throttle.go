@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// throttleChunk bounds how much is read before waiting on the limiters, so
+// the bandwidth is shaped smoothly instead of in large bursts.
+const throttleChunk = 32 * 1024
+
+// Bandwidth limits in bytes per second for reading uploads and sending
+// downloads; zero disables the limit.
+var connectionBandwidth int64
+var globalLimiter *rate.Limiter
+var downloadConnectionBandwidth int64
+var downloadGlobalLimiter *rate.Limiter
+
+func setupThrottling() error {
+	for _, limits := range []struct {
+		direction  string
+		connection *int64
+		global     **rate.Limiter
+	}{
+		{"upload", &connectionBandwidth, &globalLimiter},
+		{"download", &downloadConnectionBandwidth, &downloadGlobalLimiter},
+	} {
+		*limits.connection, *limits.global = 0, nil
+		prefix := strings.ToUpper(limits.direction) + "_BANDWIDTH_"
+		if value := os.Getenv(prefix + "PER_CONNECTION"); value != "" {
+			limit, err := parseSize(value)
+			if err != nil {
+				return fmt.Errorf("parsing %sPER_CONNECTION: %w", prefix, err)
+			}
+			*limits.connection = limit
+			log.Printf("Limiting %s bandwidth to %d bytes/s per connection", limits.direction, limit)
+		}
+		if value := os.Getenv(prefix + "TOTAL"); value != "" {
+			limit, err := parseSize(value)
+			if err != nil {
+				return fmt.Errorf("parsing %sTOTAL: %w", prefix, err)
+			}
+			if limit > 0 {
+				*limits.global = newBandwidthLimiter(limit)
+				log.Printf("Limiting total %s bandwidth to %d bytes/s", limits.direction, limit)
+			}
+		}
+	}
+	return nil
+}
+
+func newBandwidthLimiter(bytesPerSecond int64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), max(int(bytesPerSecond), throttleChunk))
+}
+
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rate.Limiter
+}
+
+// throttleUpload wraps an upload body with the per-connection and global
+// bandwidth limits. The body is returned unchanged if no limit is set.
+func throttleUpload(ctx context.Context, r io.Reader) io.Reader {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return throttle(ctx, r, connectionBandwidth, globalLimiter)
+}
+
+// throttleDownload is throttleUpload for files sent to clients, so browsing
+// guests don't use up the uplink needed by uploads.
+func throttleDownload(ctx context.Context, r io.Reader) io.Reader {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return throttle(ctx, r, downloadConnectionBandwidth, downloadGlobalLimiter)
+}
+
+func throttle(ctx context.Context, r io.Reader, connection int64, global *rate.Limiter) io.Reader {
+	var limiters []*rate.Limiter
+	if connection > 0 {
+		limiters = append(limiters, newBandwidthLimiter(connection))
+	}
+	if global != nil {
+		limiters = append(limiters, global)
+	}
+	if len(limiters) == 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiters: limiters}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	for _, limiter := range t.limiters {
+		if waitErr := limiter.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
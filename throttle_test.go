@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThrottleUpload(t *testing.T) {
+	connectionBandwidth = 100 * 1024
+	defer func() { connectionBandwidth = 0 }()
+
+	data := bytes.Repeat([]byte("x"), 150*1024)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, throttleUpload(context.Background(), bytes.NewReader(data)))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("Copy returned %d, %v", n, err)
+	}
+
+	// The first 100KB are covered by the burst, the remaining 50KB take ~0.5s.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected throttled read to take at least 400ms, took %v", elapsed)
+	}
+}
+
+func TestThrottleUpload_Cancelled(t *testing.T) {
+	connectionBandwidth = 32 * 1024
+	defer func() { connectionBandwidth = 0 }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := io.Copy(io.Discard, throttleUpload(ctx, bytes.NewReader(make([]byte, 128*1024))))
+	if err == nil {
+		t.Error("Expected error reading with a cancelled context")
+	}
+}
+
+func TestThrottleDownload(t *testing.T) {
+	t.Setenv("DOWNLOAD_BANDWIDTH_TOTAL", "100KB")
+	defer func() { downloadGlobalLimiter = nil }()
+	if err := setupThrottling(); err != nil {
+		t.Fatal(err)
+	}
+	if connectionBandwidth != 0 || globalLimiter != nil {
+		t.Fatal("expected uploads to stay unlimited")
+	}
+
+	data := bytes.Repeat([]byte("x"), 150*1024)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, throttleDownload(context.Background(), bytes.NewReader(data)))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("Copy returned %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected throttled download to take at least 400ms, took %v", elapsed)
+	}
+}
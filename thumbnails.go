@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+
+	store "go-uploader/storage"
+)
+
+// thumbnailsPrefix holds generated thumbnails, so they are only rendered
+// once per image and size, also across instances sharing a bucket.
+const thumbnailsPrefix = "thumbnails/"
+
+// thumbnailWidths are the sizes /thumb/ renders. Requested widths are
+// rounded up to one of them, so the cache can't be filled with every size.
+var thumbnailWidths = []int{160, galleryThumbnailSize, 640, 1280}
+
+// setupThumbnails serves thumbnails of any stored image on demand, so
+// galleries also work for sessions uploaded before thumbnails existed.
+func setupThumbnails() error {
+	thumbnailWidths = []int{160, galleryThumbnailSize, 640, 1280}
+	if value := os.Getenv("THUMBNAIL_WIDTHS"); value != "" {
+		var widths []int
+		for _, item := range splitList(value) {
+			width, err := strconv.Atoi(item)
+			if err != nil || width < 1 || width > 1<<16 {
+				return fmt.Errorf("THUMBNAIL_WIDTHS must list sizes between 1 and %d, got %q", 1<<16, value)
+			}
+			widths = append(widths, width)
+		}
+		if len(widths) == 0 {
+			return fmt.Errorf("THUMBNAIL_WIDTHS must list at least one size, got %q", value)
+		}
+		slices.Sort(widths)
+		thumbnailWidths = slices.Compact(widths)
+	}
+	mux.HandleFunc("GET /thumb/{path...}", thumbHandler)
+	return nil
+}
+
+// thumbnailWidth returns the smallest configured width of at least
+// requested, or the largest one.
+func thumbnailWidth(requested int) int {
+	for _, width := range thumbnailWidths {
+		if width >= requested {
+			return width
+		}
+	}
+	return thumbnailWidths[len(thumbnailWidths)-1]
+}
+
+// thumbHandler serves /thumb/<path>?w=<size> to admins and holders of the
+// session's gallery cookie. The thumbnail fits into a w by w square.
+func thumbHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := cleanStoragePath(r.PathValue("path"))
+	if !ok || !isDisplayableImage(path.Base(name)) {
+		http.NotFound(w, r)
+		return
+	}
+	if !galleryAccess(r, name) && !adminAuthorized(r) {
+		http.NotFound(w, r)
+		return
+	}
+	width := galleryThumbnailSize
+	if value := r.URL.Query().Get("w"); value != "" {
+		var err error
+		width, err = strconv.Atoi(value)
+		if err != nil || width < 1 {
+			http.Error(w, "Invalid width", http.StatusBadRequest)
+			return
+		}
+	}
+	serveThumbnail(w, r, name, thumbnailWidth(width))
+}
+
+// errNoThumbnail is returned for files that can't be decoded as an image.
+var errNoThumbnail = errors.New("file is not a supported image")
+
+// thumbnailKey is where the thumbnail of name with the given size is stored.
+// All sizes of a file share a folder, so they can be removed together.
+func thumbnailKey(name string, size int) string {
+	return thumbnailsPrefix + name + "/" + strconv.Itoa(size) + ".jpg"
+}
+
+// loadThumbnail returns the stored thumbnail of name, rendering and storing
+// it on a miss.
+func loadThumbnail(name string, size int) ([]byte, error) {
+	key := thumbnailKey(name, size)
+	if f, err := storage.OpenFile(key); err == nil {
+		defer f.Close()
+		return io.ReadAll(f)
+	} else if !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Error opening thumbnail %s, rendering it again: %v", logName(key), err)
+	}
+
+	f, err := storage.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := renderThumbnail(f, size)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := storage.SaveFile(key, bytes.NewReader(thumbnail)); err != nil {
+		// Serve it anyway, it is rendered again on the next request
+		log.Printf("Error storing thumbnail %s: %v", logName(key), err)
+	}
+	return thumbnail, nil
+}
+
+// serveThumbnail sends the thumbnail of name. Images that can't be decoded
+// are served as they are.
+func serveThumbnail(w http.ResponseWriter, r *http.Request, name string, size int) {
+	thumbnail, err := loadThumbnail(name, size)
+	if errors.Is(err, errNoThumbnail) {
+		serveStoredFile(w, r, name)
+		return
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		// Deleted or moved back into moderation since it was linked
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		requestLogf(r.Context(), "Error loading thumbnail of %s: %v", logName(name), err)
+		http.Error(w, "Failed to load thumbnail", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(thumbnail)
+}
+
+// removeThumbnails deletes all thumbnails of name, e.g. when it is replaced.
+func removeThumbnails(name string) error {
+	keys, err := storage.ListFiles(thumbnailsPrefix + name + "/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := storage.DeleteFile(key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderThumbnail scales a JPEG or PNG image to fit into a size by size
+// square, applying its EXIF orientation. Images over IMAGE_MAX_PIXELS are
+// not decoded.
+func renderThumbnail(data io.Reader, size int) ([]byte, error) {
+	// The header read by DecodeConfig holds the EXIF data and is replayed
+	// for decoding
+	var head bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(data, &head))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoThumbnail, err)
+	}
+	if !decodable(cfg) {
+		return nil, fmt.Errorf("%w: %dx%d pixels exceed IMAGE_MAX_PIXELS", errNoThumbnail, cfg.Width, cfg.Height)
+	}
+	defer acquireImageSlot()()
+	img, _, err := image.Decode(io.MultiReader(bytes.NewReader(head.Bytes()), data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoThumbnail, err)
+	}
+	orientation := 1
+	if exif := parseJPEGExif(head.Bytes()); exif != nil {
+		orientation = exif.Orientation
+	}
+	bounds := img.Bounds()
+	width, height := fitDimensions(bounds.Dx(), bounds.Dy(), orientation, size, size)
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, orient(downscale(img, width, height), orientation), &jpeg.Options{Quality: resizeQuality}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThumbnailCache(t *testing.T) {
+	mockStorage := &MockStorage{files: map[string][]byte{
+		"session1/photo.jpg": jpegWithOrientation(t, 200, 100, 1),
+		"session1/notes.txt": []byte("text"),
+		"session1/bomb.png":  pngDeclaring(t, 60000, 60000),
+	}}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	thumbnail, err := loadThumbnail("session1/photo.jpg", 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := thumbnailKey("session1/photo.jpg", 50)
+	if string(mockStorage.files[key]) != string(thumbnail) {
+		t.Fatalf("expected the thumbnail to be stored at %s", key)
+	}
+
+	// Served from the cache from now on
+	mockStorage.files[key] = []byte("cached")
+	if thumbnail, _ := loadThumbnail("session1/photo.jpg", 50); string(thumbnail) != "cached" {
+		t.Errorf("expected the cached thumbnail, got %d bytes", len(thumbnail))
+	}
+
+	if _, err := loadThumbnail("session1/notes.txt", 50); !errors.Is(err, errNoThumbnail) {
+		t.Errorf("expected errNoThumbnail, got %v", err)
+	}
+	// Images over the pixel limit are never decoded
+	if _, err := loadThumbnail("session1/bomb.png", 50); !errors.Is(err, errNoThumbnail) {
+		t.Errorf("expected errNoThumbnail for a decompression bomb, got %v", err)
+	}
+
+	if err := removeThumbnails("session1/photo.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mockStorage.files[key]; ok {
+		t.Error("expected the thumbnail to be removed")
+	}
+}
+
+func TestThumbHandler(t *testing.T) {
+	signingSecret = []byte("test-secret")
+	mockStorage := &MockStorage{files: map[string][]byte{
+		"session1/photo.jpg": jpegWithOrientation(t, 200, 100, 1),
+		"session2/photo.jpg": jpegWithOrientation(t, 200, 100, 1),
+	}}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	grant := httptest.NewRecorder()
+	if err := grantGalleryAccess(grant, httptest.NewRequest("GET", "/s/token", nil), "session1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	cookie := grant.Result().Cookies()[1]
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.SetPathValue("path", strings.TrimPrefix(strings.SplitN(target, "?", 2)[0], "/thumb/"))
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		thumbHandler(w, req)
+		return w
+	}
+	if w := get("/thumb/session1/photo.jpg?w=100"); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("expected a thumbnail, got %d", w.Code)
+	}
+	// Rounded up to a configured width
+	if _, ok := mockStorage.files[thumbnailKey("session1/photo.jpg", 160)]; !ok {
+		t.Errorf("expected the 160 pixel thumbnail to be cached, got %v", mockStorage.files)
+	}
+	for _, target := range []string{"/thumb/session2/photo.jpg", "/thumb/session1/photo.jpg?w=x"} {
+		if w := get(target); w.Code == http.StatusOK {
+			t.Errorf("%s: expected an error, got %d", target, w.Code)
+		}
+	}
+}
+
+func TestThumbnailsRemovedOnReplace(t *testing.T) {
+	mockStorage := &MockStorage{files: map[string][]byte{
+		"session1/a.jpg":                    []byte("old a"),
+		"pending/session1/a.jpg":            []byte("new a"),
+		thumbnailKey("session1/a.jpg", 160): []byte("thumbnail of old a"),
+		thumbnailKey("session1/b.jpg", 160): []byte("thumbnail of old b"),
+	}}
+	originalStorage := storage
+	storage = mockStorage
+	versioning = true
+	defer func() {
+		storage = originalStorage
+		versioning = false
+	}()
+
+	w := httptest.NewRecorder()
+	pendingApproveHandler(w, httptest.NewRequest("POST", "/admin/pending/approve?path=pending/session1/a.jpg", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("approve = %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := mockStorage.files[thumbnailKey("session1/a.jpg", 160)]; ok {
+		t.Error("expected the thumbnail of the replaced file to be removed on approval")
+	}
+
+	if _, err := quarantineFile("session1/b.jpg", "content type mismatch", strings.NewReader("new b")); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	quarantineReleaseHandler(w, httptest.NewRequest("POST", "/admin/quarantine/release?path=quarantine/session1/b.jpg", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("release = %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := mockStorage.files[thumbnailKey("session1/b.jpg", 160)]; ok {
+		t.Error("expected the thumbnail of the replaced file to be removed on release")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Timeouts of the public server. Headers and idle connections are limited
+// for every connection, the time to send a response per route: the server's
+// WriteTimeout would count from the end of the headers, cutting off the
+// response to long uploads as well as downloads and streams.
+const (
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 60 * time.Second
+	// uploadReadTimeout bounds reading the body of any request
+	uploadReadTimeout = 5 * time.Minute
+)
+
+var (
+	// responseTimeout bounds writing a response after the request was read
+	responseTimeout = 30 * time.Second
+	// stallTimeout is how long a download may make no progress
+	stallTimeout = 30 * time.Second
+)
+
+// withResponseDeadline gives handlers responseTimeout to respond. Routes
+// reading large bodies or streaming move the deadline themselves.
+func withResponseDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setWriteDeadline(w, r, time.Now().Add(responseTimeout))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withUploadDeadline lets upload routes read their body for the full
+// uploadReadTimeout and still answer afterwards.
+func withUploadDeadline(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setWriteDeadline(w, r, time.Now().Add(uploadReadTimeout+responseTimeout))
+		next(w, r)
+	}
+}
+
+// setWriteDeadline sets the write deadline of the connection, or clears it
+// for a zero deadline. Writers without deadlines, e.g. in tests, are fine.
+func setWriteDeadline(w http.ResponseWriter, r *http.Request, deadline time.Time) {
+	err := http.NewResponseController(w).SetWriteDeadline(deadline)
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		requestLogf(r.Context(), "Error setting write deadline: %v", err)
+	}
+}
+
+// stallWriter moves the write deadline forward with every write, so
+// downloads take as long as they need while clients that stop reading are
+// dropped.
+type stallWriter struct {
+	http.ResponseWriter
+	r *http.Request
+}
+
+func (s stallWriter) Write(b []byte) (int, error) {
+	setWriteDeadline(s.ResponseWriter, s.r, time.Now().Add(stallTimeout))
+	return s.ResponseWriter.Write(b)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStallWriter(t *testing.T) {
+	originalResponse, originalStall := responseTimeout, stallTimeout
+	responseTimeout, stallTimeout = 100*time.Millisecond, 100*time.Millisecond
+	defer func() { responseTimeout, stallTimeout = originalResponse, originalStall }()
+
+	server := httptest.NewServer(withResponseDeadline(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var out io.Writer = w
+		if r.URL.Path == "/download" {
+			out = stallWriter{w, r}
+		}
+		for range 5 {
+			time.Sleep(40 * time.Millisecond)
+			out.Write([]byte("chunk"))
+			http.NewResponseController(w).Flush()
+		}
+	})))
+	defer server.Close()
+
+	get := func(path string) (string, error) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// Takes longer than the response deadline, but keeps making progress
+	if body, err := get("/download"); err != nil || body != strings.Repeat("chunk", 5) {
+		t.Errorf("expected the full download, got %q, %v", body, err)
+	}
+	if body, err := get("/page"); err == nil && body == strings.Repeat("chunk", 5) {
+		t.Error("expected other responses to be cut off at the deadline")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+const trashPrefix = "trash/"
+
+// trashRetention is how long deleted files are kept in the trash before they
+// are purged. Files are deleted right away when it is zero.
+var trashRetention time.Duration
+
+const trashSweepInterval = time.Hour
+
+func setupTrash() error {
+	trashRetention = 0
+	value := os.Getenv("TRASH_RETENTION")
+	if value == "" {
+		return nil
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		return fmt.Errorf("TRASH_RETENTION must be a positive duration, got %q", value)
+	}
+	trashRetention = retention
+	log.Printf("Keeping deleted files in the trash for %s", trashRetention)
+	if checkingConfig {
+		return nil
+	}
+
+	go func() {
+		for {
+			purgeExpiredTrash(time.Now())
+			time.Sleep(trashSweepInterval)
+		}
+	}()
+	return nil
+}
+
+// trashEntry is a deleted file as listed by the admin API. Entries are stored
+// as trash/<batch>/<original path>; the batch is the deletion time and groups
+// the files removed together, e.g. by a session deletion.
+type trashEntry struct {
+	File    string    `json:"file"`
+	Path    string    `json:"path"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// newTrashBatch returns the batch for files deleted by one admin action.
+func newTrashBatch() string {
+	return time.Now().UTC().Format(versionIDFormat)
+}
+
+// discardFile deletes name, or moves it to the trash batch if the trash is
+// enabled.
+func discardFile(name, batch string) error {
+	var err error
+	if trashRetention == 0 {
+		err = storage.DeleteFile(name)
+	} else {
+		err = store.MoveFile(storage, name, trashPrefix+batch+"/"+name)
+	}
+	if err == nil {
+		removeSlides(name)
+	}
+	return err
+}
+
+func parseTrashEntry(file string) (trashEntry, bool) {
+	batch, name, ok := strings.Cut(strings.TrimPrefix(file, trashPrefix), "/")
+	if !ok || !strings.HasPrefix(file, trashPrefix) {
+		return trashEntry{}, false
+	}
+	deleted, err := time.Parse(versionIDFormat, batch)
+	if err != nil {
+		return trashEntry{}, false
+	}
+	return trashEntry{File: file, Path: name, Deleted: deleted}, true
+}
+
+func listTrash() ([]trashEntry, error) {
+	files, err := storage.ListFiles(trashPrefix)
+	if err != nil {
+		return nil, err
+	}
+	entries := []trashEntry{}
+	for _, file := range files {
+		if entry, ok := parseTrashEntry(file); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// purgeExpiredTrash removes trashed files whose grace period has passed.
+func purgeExpiredTrash(now time.Time) {
+	entries, err := listTrash()
+	if err != nil {
+		log.Printf("Error listing trash: %v", err)
+		return
+	}
+	purged := 0
+	for _, entry := range entries {
+		if now.Sub(entry.Deleted) < trashRetention {
+			continue
+		}
+		if err := storage.DeleteFile(entry.File); err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Error purging %s from trash: %v", logName(entry.File), err)
+			continue
+		}
+		purged++
+	}
+	if purged > 0 {
+		log.Printf("Purged %d expired file(s) from the trash", purged)
+	}
+}
+
+func trashListHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := listTrash()
+	if err != nil {
+		requestLogf(r.Context(), "Error listing trash: %v", err)
+		http.Error(w, "Failed to list trash", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func trashRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	trashAction(w, r, "trash.restore", func(entry trashEntry) error {
+		if _, err := keepVersion(entry.Path); err != nil {
+			return err
+		}
+		if err := store.MoveFile(storage, entry.File, entry.Path); err != nil {
+			return err
+		}
+		if err := removeThumbnails(entry.Path); err != nil {
+			requestLogf(r.Context(), "Error removing thumbnails of %s: %v", logName(entry.Path), err)
+		}
+		requestLogf(r.Context(), "Restored %s from trash", logName(entry.Path))
+		return nil
+	})
+}
+
+func trashPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	trashAction(w, r, "trash.purge", func(entry trashEntry) error {
+		if err := storage.DeleteFile(entry.File); err != nil {
+			return err
+		}
+		requestLogf(r.Context(), "Purged %s from trash", logName(entry.File))
+		return nil
+	})
+}
+
+// trashAction applies action to the trashed file named by the "path" query
+// parameter.
+func trashAction(w http.ResponseWriter, r *http.Request, audit string, action func(trashEntry) error) {
+	entry, ok := parseTrashEntry(path.Clean(r.URL.Query().Get("path")))
+	if !ok {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	err := action(entry)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestLogf(r.Context(), "Error processing trashed file %s: %v", logName(entry.File), err)
+		http.Error(w, "Failed to process trashed file", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, audit, entry.Path, map[string]any{"file": entry.File})
+	w.WriteHeader(http.StatusNoContent)
+}
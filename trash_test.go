@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrash_DeleteAndRestore(t *testing.T) {
+	mockStorage := &MockStorage{files: map[string][]byte{
+		"session1/photo.jpg":     []byte("a"),
+		"pending/session1/b.jpg": []byte("b"),
+	}}
+	originalStorage := storage
+	storage = mockStorage
+	trashRetention = time.Hour
+	defer func() {
+		storage = originalStorage
+		trashRetention = 0
+	}()
+
+	batch := newTrashBatch()
+	for _, name := range []string{"session1/photo.jpg", "pending/session1/b.jpg"} {
+		if err := discardFile(name, batch); err != nil {
+			t.Fatalf("discardFile(%s): %v", name, err)
+		}
+	}
+	if _, ok := mockStorage.files["session1/photo.jpg"]; ok {
+		t.Fatal("expected file to be removed from its location")
+	}
+
+	w := httptest.NewRecorder()
+	trashListHandler(w, httptest.NewRequest("GET", "/admin/trash", nil))
+	var entries []trashEntry
+	json.NewDecoder(w.Body).Decode(&entries)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 trashed files, got %+v", entries)
+	}
+
+	var photo trashEntry
+	for _, entry := range entries {
+		if entry.Path == "session1/photo.jpg" {
+			photo = entry
+		}
+	}
+	w = httptest.NewRecorder()
+	trashRestoreHandler(w, httptest.NewRequest("POST", "/admin/trash/restore?path="+photo.File, nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	if string(mockStorage.files["session1/photo.jpg"]) != "a" {
+		t.Error("expected file to be restored")
+	}
+
+	purgeExpiredTrash(time.Now().Add(2 * time.Hour))
+	if entries, _ := listTrash(); len(entries) != 0 {
+		t.Errorf("expected expired files to be purged, got %+v", entries)
+	}
+}
+
+func TestTrash_Disabled(t *testing.T) {
+	mockStorage := &MockStorage{files: map[string][]byte{"session1/photo.jpg": []byte("a")}}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	if err := discardFile("session1/photo.jpg", newTrashBatch()); err != nil {
+		t.Fatalf("discardFile: %v", err)
+	}
+	if len(mockStorage.files) != 0 {
+		t.Errorf("expected file to be deleted, got %v", mockStorage.files)
+	}
+}
+
+func TestTrashAction_InvalidPath(t *testing.T) {
+	for _, p := range []string{"session1/photo.jpg", "trash/notabatch/photo.jpg", "trash/20240601T120000.000000000Z"} {
+		w := httptest.NewRecorder()
+		trashPurgeHandler(w, httptest.NewRequest("POST", "/admin/trash/purge?path="+p, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", p, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestTrash_SessionDeletionBypassesTrash(t *testing.T) {
+	mockStorage := &MockStorage{files: map[string][]byte{
+		"session1/photo.jpg": []byte("a"),
+		"trash/20240601T120000.000000000Z/session1/old.jpg":         []byte("b"),
+		"trash/20240601T120000.000000000Z/pending/session1/new.jpg": []byte("c"),
+		"trash/20240601T120000.000000000Z/session2/other.jpg":       []byte("d"),
+	}}
+	originalStorage := storage
+	storage = mockStorage
+	trashRetention = time.Hour
+	defer func() {
+		storage = originalStorage
+		trashRetention = 0
+	}()
+
+	if deleted, err := deleteSession("session1"); err != nil || deleted != 3 {
+		t.Fatalf("deleteSession = %d, %v", deleted, err)
+	}
+	if len(mockStorage.files) != 1 || mockStorage.files["trash/20240601T120000.000000000Z/session2/other.jpg"] == nil {
+		t.Errorf("expected only the other session's trash to remain, got %v", mockStorage.files)
+	}
+}
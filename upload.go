@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"path"
+	"path/filepath"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+type uploadOutcome int
+
+const (
+	outcomeSaved uploadOutcome = iota
+	outcomeQuarantined
+	outcomeRejected
+	outcomeFailed
+)
+
+// fileResult is the outcome of storing a single file of an upload request.
+type fileResult struct {
+	outcome uploadOutcome
+	// message explains a rejected or failed file to the client
+	message string
+	err     error
+	// file is the stored file, for saved and quarantined files
+	file *pipelineFile
+	// blocked is set if the file matched the hash blocklist
+	blocked *blockedFile
+}
+
+// storeUpload validates, processes and stores one file of an upload session
+// and runs the post-upload pipeline on it. The name and content type are as
+// given by the client.
+func storeUpload(ctx context.Context, session, dir, uploadName, contentType string, body io.Reader) fileResult {
+	if reason := checkExtension(uploadName); reason != "" {
+		requestLogf(ctx, "Rejected file %q in session %s: %s", logName(uploadName), session, reason)
+		return fileResult{outcome: outcomeRejected, message: reason}
+	}
+
+	reader := bufio.NewReader(contextReader{ctx: ctx, r: body})
+	head, err := reader.Peek(512)
+	if rejectEmptyFiles && len(head) == 0 && err == io.EOF {
+		requestLogf(ctx, "Rejected file %q in session %s: file is empty", logName(uploadName), session)
+		return fileResult{outcome: outcomeRejected, message: "file is empty"}
+	}
+
+	name := sanitizeFilename(uploadName)
+	// Downloads are offered under the name as uploaded
+	var originalName string
+	if name != uploadName {
+		originalName = uploadName
+	}
+	if fixExtensions {
+		if corrected, detected, ok := correctExtension(name, head); ok {
+			requestLogf(ctx, "Correcting extension of %s to %s", logName(name), path.Ext(corrected))
+			if originalName == "" {
+				originalName = name
+			}
+			name, contentType = corrected, detected
+		}
+	}
+	if truncated := truncateFilename(name); truncated != name {
+		requestLogf(ctx, "Truncating filename %s to %d bytes", logName(name), len(truncated))
+		if originalName == "" {
+			originalName = name
+		}
+		name = truncated
+	}
+	filename := filepath.Join(session, typePrefix(name, contentType), dir, name)
+	if filename == path.Join(session, checksumsFile) {
+		// The name of the manifest written with SESSION_CHECKSUMS
+		filename = withRandomSuffix(filename)
+	}
+	filename, err = uniqueFileName(filename)
+	if err != nil {
+		requestLogf(ctx, "Error checking for existing file %s in session %s: %v", logName(name), session, err)
+		return fileResult{outcome: outcomeFailed, message: "could not be saved", err: err}
+	}
+	if originalName == "" && path.Base(filename) != name {
+		originalName = name
+	}
+	requestLogf(ctx, "Saving file: %s", logName(filename))
+
+	reason := flagFile(filename, head)
+	original := &headBuffer{}
+	// Blocklists list the files as uploaded, before resizing
+	originalHash := sha256.New()
+	var data io.Reader = io.TeeReader(reader, io.MultiWriter(original, originalHash))
+	var moderation *moderationResult
+	if reason == "" {
+		var err error
+		data, err = resizeUpload(filename, data)
+		if err != nil {
+			requestLogf(ctx, "Error resizing file %s in session %s: %v", logName(filename), session, err)
+			return fileResult{outcome: outcomeFailed, message: "could not be processed", err: err}
+		}
+		data, moderation, reason = moderateUpload(ctx, filename, contentType, data)
+	}
+
+	hash := sha256.New()
+	stored := &headBuffer{}
+	counter := &countingReader{r: io.TeeReader(data, io.MultiWriter(hash, stored))}
+	file := &pipelineFile{
+		Name:        filename,
+		Stored:      uploadPath(filename),
+		Session:     session,
+		RequestID:   requestID(ctx),
+		ContentType: contentType,
+		Status:      "stored",
+		Meta:        fileMetadata{Path: filename, OriginalName: originalName, ContentType: uploadedType(filename, contentType), Moderation: moderation},
+	}
+	if moderationQueue {
+		file.Status = "pending"
+	}
+
+	start := time.Now()
+	var result store.SaveResult
+	if reason != "" {
+		file.Stored = quarantinePrefix + filename
+		file.Status = "quarantined"
+		result, err = quarantineFile(filename, reason, counter)
+	} else if file.Replaced, err = keepVersion(file.Stored); err == nil {
+		result, err = storage.SaveFile(file.Stored, counter)
+	}
+	backendSaveDuration.WithLabelValues(backendName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		requestLogf(ctx, "Error saving file %s in session %s: %v", logName(filename), session, err)
+		return fileResult{outcome: outcomeFailed, message: "could not be saved", err: err}
+	}
+	fileSize.Observe(float64(counter.n))
+	requestLogf(ctx, "Successfully saved file: %s", logName(filename))
+	if file.Stored == filename {
+		// Thumbnails of content the upload replaced
+		if err := removeThumbnails(filename); err != nil {
+			requestLogf(ctx, "Error removing thumbnails of %s: %v", logName(filename), err)
+		}
+	}
+
+	// The backend's count is what ended up stored
+	file.Size = result.Size
+	file.Ref = result.Ref
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	var blocked *blockedFile
+	if sum, action := checkHashBlocklist(hex.EncodeToString(originalHash.Sum(nil)), file.SHA256); sum != "" {
+		blocked = &blockedFile{Name: filename, SHA256: sum, Action: action}
+		requestLogf(ctx, "File %s in session %s matches blocked hash %s", logName(filename), session, sum)
+		if blocked.Action == "reject" {
+			removeBlocked(ctx, file)
+			return fileResult{outcome: outcomeRejected, message: "file is not allowed", blocked: blocked}
+		}
+		if file.Status != "quarantined" {
+			if err := quarantineStored(file.Stored, filename, "matches blocked hash "+sum); err != nil {
+				requestLogf(ctx, "Error quarantining %s: %v", logName(filename), err)
+				removeBlocked(ctx, file)
+				return fileResult{outcome: outcomeRejected, message: "file is not allowed", blocked: blocked}
+			}
+			file.Stored = quarantinePrefix + filename
+			file.Status = "quarantined"
+		}
+	}
+	file.Meta.Image = describeImage(original.Bytes(), stored.Bytes())
+	stages := uploadPipeline
+	if d := requestDrop(ctx); d != nil && d.Pipeline != nil {
+		stages = d.Pipeline
+	}
+	runPipeline(ctx, stages, file)
+	if file.Status == "stored" {
+		publishLive(file.Name, file.Session, dropName(ctx))
+	}
+	if file.Status == "quarantined" {
+		return fileResult{outcome: outcomeQuarantined, file: file, blocked: blocked}
+	}
+	return fileResult{outcome: outcomeSaved, file: file}
+}
+
+// removeBlocked deletes a stored file that matched the hash blocklist, along
+// with its quarantine record if it was quarantined.
+func removeBlocked(ctx context.Context, file *pipelineFile) {
+	if err := storage.DeleteFile(file.Stored); err != nil {
+		requestLogf(ctx, "Error removing blocked file %s: %v", logName(file.Name), err)
+	}
+	if file.Status == "quarantined" {
+		if err := storage.DeleteFile(file.Stored + reasonSuffix); err != nil {
+			requestLogf(ctx, "Error removing quarantine record of %s: %v", logName(file.Name), err)
+		}
+	}
+}
+
+// headBufferSize covers the EXIF segment, which is limited to 64 KB, and the
+// headers of common image formats.
+const headBufferSize = 128 << 10
+
+// headBuffer keeps the first bytes written to it and discards the rest.
+type headBuffer struct {
+	bytes.Buffer
+}
+
+func (h *headBuffer) Write(p []byte) (int, error) {
+	if room := headBufferSize - h.Len(); room > 0 {
+		h.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// uploadedType returns the content type given for a file if downloads
+// would be served with another one, guessed from its name, or "".
+func uploadedType(name, contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		return ""
+	}
+	guessed, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name)))
+	if mediaType == guessed {
+		return ""
+	}
+	return contentType
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const uploadLinkPurpose = "upload"
+
+// uploadTokenHeader carries the token of a signed upload link. The upload
+// page forwards the upload_token parameter of its URL in it.
+const uploadTokenHeader = "X-Upload-Token"
+
+// uploadLinkExpiry is the validity of upload links minted without one.
+const uploadLinkExpiry = 30 * 24 * time.Hour
+
+// uploadLinkClaims is the signed payload of an upload link. Uploads with a
+// valid link skip the CAPTCHA, e.g. from personalized invitations or kiosk
+// devices, and are limited to the drop and album it names.
+type uploadLinkClaims struct {
+	Drop string `json:"d,omitempty"`
+	// Album is a folder the sessions are stored in, within the drop
+	Album   string `json:"a,omitempty"`
+	Expires int64  `json:"e"`
+	// MaxBytes limits each upload request made with the link
+	MaxBytes int64 `json:"b,omitempty"`
+}
+
+type uploadLinkRequest struct {
+	Drop      string `json:"drop"`
+	Album     string `json:"album"`
+	ExpiresIn string `json:"expires_in"`
+	MaxBytes  int64  `json:"max_bytes"`
+}
+
+type uploadLinkResponse struct {
+	Token   string    `json:"token"`
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+type uploadLinkKey struct{}
+
+// uploadLinkCreateHandler mints a signed upload link.
+func uploadLinkCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var req uploadLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	page := "/"
+	if req.Drop != "" {
+		if _, ok := drops[req.Drop]; !ok {
+			http.Error(w, "Unknown drop", http.StatusBadRequest)
+			return
+		}
+		page = "/d/" + req.Drop
+	}
+	if req.Album != "" {
+		album := sanitizeFilename(req.Album)
+		if album == "" || album != req.Album {
+			http.Error(w, "Invalid album, use a single folder name", http.StatusBadRequest)
+			return
+		}
+		// Uploads would land in e.g. the audit log or the moderation queue
+		if isInternalPath(album) {
+			http.Error(w, "Invalid album, the name is reserved", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.MaxBytes < 0 {
+		http.Error(w, "Invalid max_bytes", http.StatusBadRequest)
+		return
+	}
+	expiry := uploadLinkExpiry
+	if req.ExpiresIn != "" {
+		var err error
+		expiry, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || expiry <= 0 {
+			http.Error(w, "Invalid expires_in", http.StatusBadRequest)
+			return
+		}
+	}
+
+	claims := uploadLinkClaims{Drop: req.Drop, Album: req.Album, Expires: time.Now().Add(expiry).Unix(), MaxBytes: req.MaxBytes}
+	token, err := signToken(uploadLinkPurpose, claims)
+	if err != nil {
+		requestLogf(r.Context(), "Error signing upload link: %v", err)
+		http.Error(w, "Failed to create upload link", http.StatusInternalServerError)
+		return
+	}
+	requestLogf(r.Context(), "Created upload link for drop %q, album %q valid for %s", req.Drop, req.Album, expiry)
+	recordAudit(r, "upload_link.create", page, map[string]any{"album": req.Album, "expires": time.Unix(claims.Expires, 0), "max_bytes": req.MaxBytes})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(uploadLinkResponse{
+		Token:   token,
+		URL:     publicURL(page + "?" + url.Values{"upload_token": {token}}.Encode()),
+		Expires: time.Unix(claims.Expires, 0),
+	})
+}
+
+// parseUploadLink returns the claims of the upload link sent with r, nil if
+// there is none, and whether the link is valid for the drop of the request.
+func parseUploadLink(r *http.Request) (*uploadLinkClaims, bool) {
+	token := r.Header.Get(uploadTokenHeader)
+	if token == "" {
+		token = r.URL.Query().Get("upload_token")
+	}
+	if token == "" {
+		return nil, true
+	}
+	var claims uploadLinkClaims
+	if err := parseToken(uploadLinkPurpose, token, &claims); err != nil {
+		return nil, false
+	}
+	if time.Now().Unix() > claims.Expires || claims.Drop != dropName(r.Context()) {
+		return nil, false
+	}
+	return &claims, true
+}
+
+// requestUploadLink returns the upload link a request was made with, if any.
+func requestUploadLink(ctx context.Context) *uploadLinkClaims {
+	link, _ := ctx.Value(uploadLinkKey{}).(*uploadLinkClaims)
+	return link
+}
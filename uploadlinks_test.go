@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadLinks(t *testing.T) {
+	// Every CAPTCHA fails, so only uploads with a link pass
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success":false}`))
+	}))
+	defer server.Close()
+	originalURL, originalStorage := turnstileURL, storage
+	turnstileURL = server.URL
+	mock := &MockStorage{}
+	storage = mock
+	defer func() { turnstileURL, storage = originalURL, originalStorage }()
+
+	create := func(body string) (int, uploadLinkResponse) {
+		req := httptest.NewRequest("POST", "/admin/upload-links", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		uploadLinkCreateHandler(w, req)
+		var resp uploadLinkResponse
+		if w.Code == http.StatusCreated {
+			json.NewDecoder(w.Body).Decode(&resp)
+		}
+		return w.Code, resp
+	}
+	for _, body := range []string{`{"drop":"unknown"}`, `{"album":"../etc"}`, `{"album":"pending"}`, `{"album":"audit"}`, `{"expires_in":"-1h"}`, `{"max_bytes":-1}`} {
+		if code, _ := create(body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, code)
+		}
+	}
+	code, link := create(`{"album":"smith-family","max_bytes":1000}`)
+	if code != http.StatusCreated || !strings.Contains(link.URL, "/?upload_token="+link.Token) {
+		t.Fatalf("expected an upload link, got %d %+v", code, link)
+	}
+
+	upload := func(token string, size int) int {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "photo.jpg")
+		part.Write(bytes.Repeat([]byte("x"), size))
+		writer.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if token != "" {
+			req.Header.Set(uploadTokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		uploadHandler(w, req)
+		return w.Code
+	}
+
+	if code := upload("", 10); code != http.StatusForbidden {
+		t.Errorf("expected the CAPTCHA to be required without a link, got %d", code)
+	}
+	if code := upload(link.Token, 10); code != http.StatusCreated {
+		t.Fatalf("expected the link to replace the CAPTCHA, got %d", code)
+	}
+	for name := range mock.files {
+		if !strings.HasPrefix(name, "smith-family/") && !strings.HasPrefix(name, auditPrefix) {
+			t.Errorf("expected %s to be stored in the album", name)
+		}
+	}
+	if code := upload(link.Token, 2000); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected the size limit of the link, got %d", code)
+	}
+
+	expired, _ := signToken(uploadLinkPurpose, uploadLinkClaims{Expires: time.Now().Add(-time.Minute).Unix()})
+	otherDrop, _ := signToken(uploadLinkPurpose, uploadLinkClaims{Drop: "party", Expires: time.Now().Add(time.Hour).Unix()})
+	for _, token := range []string{expired, otherDrop, link.Token + "x"} {
+		if code := upload(token, 10); code != http.StatusUnauthorized {
+			t.Errorf("expected an invalid link to be rejected, got %d", code)
+		}
+	}
+}
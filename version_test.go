@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestVersionHandler(t *testing.T) {
+	defer func(v, c string) { version, commit = v, c }(version, commit)
+	version = "v1.2.3"
+	commit = "abc123"
+
+	w := httptest.NewRecorder()
+	versionHandler(w, httptest.NewRequest("GET", "/version", nil))
+
+	var info versionInfo
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if info.Version != "v1.2.3" || info.Commit != "abc123" {
+		t.Errorf("unexpected build info: %+v", info)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("expected Go version %s, got %s", runtime.Version(), info.GoVersion)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	store "go-uploader/storage"
+)
+
+const versionsPrefix = "versions/"
+
+// versionIDFormat sorts chronologically as a string.
+const versionIDFormat = "20060102T150405.000000000Z"
+
+// versioning keeps the previous content of a path under the versions/ prefix
+// instead of overwriting it.
+var versioning bool
+
+func setupVersioning() {
+	versioning = os.Getenv("VERSIONING") == "true"
+	if versioning {
+		log.Println("Keeping previous versions of overwritten files")
+	}
+}
+
+// fileVersion is a prior version of a file as listed by the admin API.
+type fileVersion struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+func versionKey(name, version string) string {
+	return versionsPrefix + name + "/" + version
+}
+
+// keepVersion moves the file at name aside if it exists, so a following save
+// doesn't overwrite it, and returns the key of the version it was kept as.
+func keepVersion(name string) (string, error) {
+	if !versioning {
+		return "", nil
+	}
+	exists, err := fileExists(name)
+	if err != nil || !exists {
+		return "", err
+	}
+	key := versionKey(name, time.Now().UTC().Format(versionIDFormat))
+	return key, store.MoveFile(storage, name, key)
+}
+
+// listVersions returns the prior versions of name, newest first.
+func listVersions(name string) ([]fileVersion, error) {
+	prefix := versionsPrefix + name + "/"
+	keys, err := storage.ListFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+	versions := []fileVersion{}
+	for _, key := range keys {
+		id := strings.TrimPrefix(key, prefix)
+		if strings.Contains(id, "/") {
+			// A version of a file further down, e.g. when name is a folder
+			continue
+		}
+		versions = append(versions, fileVersion{Path: name, Version: id})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+	return versions, nil
+}
+
+// versionedPath resolves the "path" query parameter to a public or pending
+// file.
+func versionedPath(r *http.Request) (string, bool) {
+	name := strings.Trim(path.Clean("/"+r.URL.Query().Get("path")), "/")
+	if name == "" || isInternalPath(name) && !strings.HasPrefix(name, pendingPrefix) {
+		return "", false
+	}
+	return name, true
+}
+
+func versionListHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := versionedPath(r)
+	if !ok {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	versions, err := listVersions(name)
+	if err != nil {
+		requestLogf(r.Context(), "Error listing versions of %s: %v", logName(name), err)
+		http.Error(w, "Failed to list versions", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// versionRestoreHandler makes a prior version current again. The content it
+// replaces is kept as a new version, so a restore can be undone.
+func versionRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := versionedPath(r)
+	version := r.URL.Query().Get("version")
+	if !ok || version == "" || strings.Contains(version, "/") {
+		http.Error(w, "Invalid path or version", http.StatusBadRequest)
+		return
+	}
+
+	src, err := storage.OpenFile(versionKey(name, version))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestLogf(r.Context(), "Error opening version %s of %s: %v", version, logName(name), err)
+		http.Error(w, "Failed to restore version", http.StatusInternalServerError)
+		return
+	}
+	defer src.Close()
+
+	if _, err := keepVersion(name); err != nil {
+		requestLogf(r.Context(), "Error keeping current version of %s: %v", logName(name), err)
+		http.Error(w, "Failed to restore version", http.StatusInternalServerError)
+		return
+	}
+	if _, err := storage.SaveFile(name, src); err != nil {
+		requestLogf(r.Context(), "Error restoring version %s of %s: %v", version, logName(name), err)
+		http.Error(w, "Failed to restore version", http.StatusInternalServerError)
+		return
+	}
+	if err := removeThumbnails(name); err != nil {
+		requestLogf(r.Context(), "Error removing thumbnails of %s: %v", logName(name), err)
+	}
+	requestLogf(r.Context(), "Restored version %s of %s", version, logName(name))
+	recordAudit(r, "version.restore", name, map[string]any{"version": version})
+	w.WriteHeader(http.StatusNoContent)
+}
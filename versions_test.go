@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVersioning_KeepAndRestore(t *testing.T) {
+	mockStorage := &MockStorage{}
+	originalStorage := storage
+	storage = mockStorage
+	versioning = true
+	defer func() {
+		storage = originalStorage
+		versioning = false
+	}()
+
+	name := "2024-06-01_12-00-00.000/photo.jpg"
+	for _, content := range []string{"first", "second"} {
+		if _, err := keepVersion(name); err != nil {
+			t.Fatalf("keepVersion: %v", err)
+		}
+		storage.SaveFile(name, strings.NewReader(content))
+	}
+
+	w := httptest.NewRecorder()
+	versionListHandler(w, httptest.NewRequest("GET", "/admin/versions?path="+name, nil))
+	var versions []fileVersion
+	json.NewDecoder(w.Body).Decode(&versions)
+	if len(versions) != 1 || string(mockStorage.files[versionKey(name, versions[0].Version)]) != "first" {
+		t.Fatalf("expected the first upload as prior version, got %+v", versions)
+	}
+
+	w = httptest.NewRecorder()
+	versionRestoreHandler(w, httptest.NewRequest("POST", "/admin/versions/restore?path="+name+"&version="+versions[0].Version, nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	if string(mockStorage.files[name]) != "first" {
+		t.Errorf("expected restored content, got %q", mockStorage.files[name])
+	}
+
+	versions, _ = listVersions(name)
+	if len(versions) != 2 || string(mockStorage.files[versionKey(name, versions[0].Version)]) != "second" {
+		t.Errorf("expected the replaced content to be kept as newest version, got %+v", versions)
+	}
+}
+
+func TestVersioning_Disabled(t *testing.T) {
+	mockStorage := &MockStorage{files: map[string][]byte{"a/photo.jpg": []byte("old")}}
+	originalStorage := storage
+	storage = mockStorage
+	defer func() { storage = originalStorage }()
+
+	if _, err := keepVersion("a/photo.jpg"); err != nil {
+		t.Fatalf("keepVersion: %v", err)
+	}
+	if len(mockStorage.files) != 1 {
+		t.Errorf("expected no version to be kept, got %v", mockStorage.files)
+	}
+}
+
+func TestVersionRestoreHandler_RejectsInternalPaths(t *testing.T) {
+	w := httptest.NewRecorder()
+	versionRestoreHandler(w, httptest.NewRequest("POST", "/admin/versions/restore?path=audit/x.json&version=1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}